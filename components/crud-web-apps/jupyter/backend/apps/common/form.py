@@ -2,7 +2,7 @@ import json
 
 from werkzeug.exceptions import BadRequest
 
-from kubeflow.kubeflow.crud_backend import logging
+from kubeflow.kubeflow.crud_backend import authn, logging
 
 from . import utils
 
@@ -11,6 +11,7 @@ log = logging.getLogger(__name__)
 SERVER_TYPE_ANNOTATION = "notebooks.kubeflow.org/server-type"
 HEADERS_ANNOTATION = "notebooks.kubeflow.org/http-headers-request-set"
 URI_REWRITE_ANNOTATION = "notebooks.kubeflow.org/http-rewrite-uri"
+CREATOR_ANNOTATION = "notebooks.kubeflow.org/creator"
 
 
 def get_form_value(body, defaults, body_field, defaults_field=None,
@@ -113,6 +114,19 @@ def set_server_type(notebook, body, defaults):
         notebook_annotations[HEADERS_ANNOTATION] = rstudio_header
 
 
+def set_notebook_creator(notebook):
+    """
+    The Notebook is created by the app's ServiceAccount, so the webhook of the
+    notebook-controller can't tell the user who created it. The app is trusted
+    to set it to the authenticated user.
+    """
+    user = authn.get_username()
+    if user is None:
+        return
+
+    notebook["metadata"]["annotations"][CREATOR_ANNOTATION] = user
+
+
 def set_notebook_cpu(notebook, body, defaults):
     container = notebook["spec"]["template"]["spec"]["containers"][0]
 
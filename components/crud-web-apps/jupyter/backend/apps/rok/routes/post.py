@@ -28,6 +28,7 @@ def post_notebook(namespace):
     form.set_notebook_image(notebook, body, defaults)
     form.set_notebook_image_pull_policy(notebook, body, defaults)
     form.set_server_type(notebook, body, defaults)
+    form.set_notebook_creator(notebook)
     form.set_notebook_cpu(notebook, body, defaults)
     form.set_notebook_memory(notebook, body, defaults)
     form.set_notebook_gpus(notebook, body, defaults)
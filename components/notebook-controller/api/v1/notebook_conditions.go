@@ -0,0 +1,99 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Phases of a Notebook
+const (
+	NotebookPhasePending  = "Pending"
+	NotebookPhaseStarting = "Starting"
+	NotebookPhaseRunning  = "Running"
+	NotebookPhaseStopping = "Stopping"
+	NotebookPhaseStopped  = "Stopped"
+	NotebookPhaseCulled   = "Culled"
+	NotebookPhaseFailed   = "Failed"
+)
+
+// Types of the conditions of a Notebook
+const (
+	NotebookConditionReady        = "Ready"
+	NotebookConditionScheduled    = "Scheduled"
+	NotebookConditionImagePulled  = "ImagePulled"
+	NotebookConditionRouted       = "Routed"
+	NotebookConditionWithinLimits = "WithinLimits"
+)
+
+// Statuses of a NotebookCondition
+const (
+	ConditionTrue    = "True"
+	ConditionFalse   = "False"
+	ConditionUnknown = "Unknown"
+)
+
+// Reasons of a NotebookCondition. The failures of the Notebook's Pod are
+// classified into the reasons below, so that they can be shown to the users
+// as they are.
+const (
+	NotebookReasonReady                = "Ready"
+	NotebookReasonStarting             = "Starting"
+	NotebookReasonStopped              = "Stopped"
+	NotebookReasonPodPending           = "PodPending"
+	NotebookReasonScheduled            = "Scheduled"
+	NotebookReasonUnschedulable        = "Unschedulable"
+	NotebookReasonImagePulled          = "ImagePulled"
+	NotebookReasonImagePulling         = "ImagePulling"
+	NotebookReasonImagePullFailed      = "ImagePullFailed"
+	NotebookReasonCrashLoopBackOff     = "CrashLoopBackOff"
+	NotebookReasonOutOfMemory          = "OutOfMemory"
+	NotebookReasonInvalidConfiguration = "InvalidConfiguration"
+	NotebookReasonRoutedToNotebook     = "RoutedToNotebook"
+	NotebookReasonRoutedToActivator    = "RoutedToActivator"
+	NotebookReasonWithinLimits         = "WithinLimits"
+	NotebookReasonLimitExceeded        = "LimitExceeded"
+)
+
+// GetCondition returns the condition of the given type, or nil.
+func (s *NotebookStatus) GetCondition(conditionType string) *NotebookCondition {
+	for i := range s.Conditions {
+		if s.Conditions[i].Type == conditionType {
+			return &s.Conditions[i]
+		}
+	}
+	return nil
+}
+
+// SetCondition adds or updates the condition of the same type. The
+// LastTransitionTime is only set to now when the status of the condition
+// changes, so that it doesn't churn when the condition is set again on every
+// reconciliation.
+func (s *NotebookStatus) SetCondition(condition NotebookCondition, now metav1.Time) {
+	existing := s.GetCondition(condition.Type)
+	if existing == nil {
+		condition.LastTransitionTime = now
+		s.Conditions = append(s.Conditions, condition)
+		return
+	}
+
+	if existing.Status == condition.Status && !existing.LastTransitionTime.IsZero() {
+		condition.LastTransitionTime = existing.LastTransitionTime
+	} else {
+		condition.LastTransitionTime = now
+	}
+	*existing = condition
+}
@@ -24,18 +24,62 @@ import (
 // ConvertTo converts this Notebook to the Hub version (v1beta1).
 func (src *Notebook) ConvertTo(dstRaw conversion.Hub) error {
 	dst := dstRaw.(*nbv1beta1.Notebook)
+	src.ObjectMeta.DeepCopyInto(&dst.ObjectMeta)
 	dst.Spec.Template.Spec = src.Spec.Template.Spec
+	dst.Spec.Culling = (*nbv1beta1.CullingPolicy)(src.Spec.Culling)
+	dst.Spec.Schedule = (*nbv1beta1.NotebookSchedule)(src.Spec.Schedule)
+	if src.Spec.Workspace != nil {
+		dst.Spec.Workspace = &nbv1beta1.NotebookWorkspace{
+			Size:                src.Spec.Workspace.Size,
+			StorageClassName:    src.Spec.Workspace.StorageClassName,
+			AccessModes:         src.Spec.Workspace.AccessModes,
+			MountPath:           src.Spec.Workspace.MountPath,
+			RetentionPolicy:     src.Spec.Workspace.RetentionPolicy,
+			Snapshots:           (*nbv1beta1.WorkspaceSnapshots)(src.Spec.Workspace.Snapshots),
+			RestoreFromSnapshot: src.Spec.Workspace.RestoreFromSnapshot,
+		}
+	}
+	for _, e := range src.Spec.Endpoints {
+		dst.Spec.Endpoints = append(dst.Spec.Endpoints, nbv1beta1.NotebookEndpoint(e))
+	}
 	dst.Status.ReadyReplicas = src.Status.ReadyReplicas
 	dst.Status.ContainerState = src.Status.ContainerState
-	conditions := []nbv1beta1.NotebookCondition{}
-	for _, c := range src.Status.Conditions {
-		newc := nbv1beta1.NotebookCondition{
-			Type:          c.Type,
-			LastProbeTime: c.LastProbeTime,
-			Reason:        c.Reason,
-			Message:       c.Message,
+	dst.Status.Culling = (*nbv1beta1.CullingStatus)(src.Status.Culling)
+	dst.Status.Schedule = (*nbv1beta1.ScheduleStatus)(src.Status.Schedule)
+	dst.Status.Workspace = (*nbv1beta1.WorkspaceStatus)(src.Status.Workspace)
+	dst.Status.Restart = (*nbv1beta1.RestartStatus)(src.Status.Restart)
+	dst.Status.Image = (*nbv1beta1.ImageStatus)(src.Status.Image)
+	if src.Status.Routing != nil {
+		routing := &nbv1beta1.RoutingStatus{
+			Backend:     src.Status.Routing.Backend,
+			URL:         src.Status.Routing.URL,
+			URLs:        src.Status.Routing.URLs,
+			InternalURL: src.Status.Routing.InternalURL,
+		}
+		for _, e := range src.Status.Routing.Endpoints {
+			routing.Endpoints = append(routing.Endpoints, nbv1beta1.EndpointStatus(e))
 		}
-		conditions = append(conditions, newc)
+		dst.Status.Routing = routing
+	}
+	if src.Status.Lifecycle != nil {
+		lifecycle := &nbv1beta1.LifecycleStatus{
+			Stopped:            src.Status.Lifecycle.Stopped,
+			Reason:             src.Status.Lifecycle.Reason,
+			Actor:              src.Status.Lifecycle.Actor,
+			LastTransitionTime: src.Status.Lifecycle.LastTransitionTime,
+		}
+		for _, t := range src.Status.Lifecycle.History {
+			lifecycle.History = append(lifecycle.History, nbv1beta1.LifecycleTransition(t))
+		}
+		dst.Status.Lifecycle = lifecycle
+	}
+	dst.Status.Phase = src.Status.Phase
+	var conditions []nbv1beta1.NotebookCondition
+	if src.Status.Conditions != nil {
+		conditions = []nbv1beta1.NotebookCondition{}
+	}
+	for _, c := range src.Status.Conditions {
+		conditions = append(conditions, nbv1beta1.NotebookCondition(c))
 	}
 	dst.Status.Conditions = conditions
 
@@ -50,18 +94,62 @@ Most of the conversion is straightforward copying, except for converting our cha
 // ConvertFrom converts from the Hub version (v1beta1) to this version.
 func (dst *Notebook) ConvertFrom(srcRaw conversion.Hub) error {
 	src := srcRaw.(*nbv1beta1.Notebook)
+	src.ObjectMeta.DeepCopyInto(&dst.ObjectMeta)
 	dst.Spec.Template.Spec = src.Spec.Template.Spec
+	dst.Spec.Culling = (*CullingPolicy)(src.Spec.Culling)
+	dst.Spec.Schedule = (*NotebookSchedule)(src.Spec.Schedule)
+	if src.Spec.Workspace != nil {
+		dst.Spec.Workspace = &NotebookWorkspace{
+			Size:                src.Spec.Workspace.Size,
+			StorageClassName:    src.Spec.Workspace.StorageClassName,
+			AccessModes:         src.Spec.Workspace.AccessModes,
+			MountPath:           src.Spec.Workspace.MountPath,
+			RetentionPolicy:     src.Spec.Workspace.RetentionPolicy,
+			Snapshots:           (*WorkspaceSnapshots)(src.Spec.Workspace.Snapshots),
+			RestoreFromSnapshot: src.Spec.Workspace.RestoreFromSnapshot,
+		}
+	}
+	for _, e := range src.Spec.Endpoints {
+		dst.Spec.Endpoints = append(dst.Spec.Endpoints, NotebookEndpoint(e))
+	}
 	dst.Status.ReadyReplicas = src.Status.ReadyReplicas
 	dst.Status.ContainerState = src.Status.ContainerState
-	conditions := []NotebookCondition{}
-	for _, c := range src.Status.Conditions {
-		newc := NotebookCondition{
-			Type:          c.Type,
-			LastProbeTime: c.LastProbeTime,
-			Reason:        c.Reason,
-			Message:       c.Message,
+	dst.Status.Culling = (*CullingStatus)(src.Status.Culling)
+	dst.Status.Schedule = (*ScheduleStatus)(src.Status.Schedule)
+	dst.Status.Workspace = (*WorkspaceStatus)(src.Status.Workspace)
+	dst.Status.Restart = (*RestartStatus)(src.Status.Restart)
+	dst.Status.Image = (*ImageStatus)(src.Status.Image)
+	if src.Status.Routing != nil {
+		routing := &RoutingStatus{
+			Backend:     src.Status.Routing.Backend,
+			URL:         src.Status.Routing.URL,
+			URLs:        src.Status.Routing.URLs,
+			InternalURL: src.Status.Routing.InternalURL,
+		}
+		for _, e := range src.Status.Routing.Endpoints {
+			routing.Endpoints = append(routing.Endpoints, EndpointStatus(e))
 		}
-		conditions = append(conditions, newc)
+		dst.Status.Routing = routing
+	}
+	if src.Status.Lifecycle != nil {
+		lifecycle := &LifecycleStatus{
+			Stopped:            src.Status.Lifecycle.Stopped,
+			Reason:             src.Status.Lifecycle.Reason,
+			Actor:              src.Status.Lifecycle.Actor,
+			LastTransitionTime: src.Status.Lifecycle.LastTransitionTime,
+		}
+		for _, t := range src.Status.Lifecycle.History {
+			lifecycle.History = append(lifecycle.History, LifecycleTransition(t))
+		}
+		dst.Status.Lifecycle = lifecycle
+	}
+	dst.Status.Phase = src.Status.Phase
+	var conditions []NotebookCondition
+	if src.Status.Conditions != nil {
+		conditions = []NotebookCondition{}
+	}
+	for _, c := range src.Status.Conditions {
+		conditions = append(conditions, NotebookCondition(c))
 	}
 	dst.Status.Conditions = conditions
 
@@ -0,0 +1,71 @@
+package v1
+
+import (
+	"math/rand"
+	"testing"
+
+	fuzz "github.com/google/gofuzz"
+	nbv1beta1 "github.com/kubeflow/kubeflow/components/notebook-controller/api/v1beta1"
+	"k8s.io/apimachinery/pkg/api/apitesting/fuzzer"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	metafuzzer "k8s.io/apimachinery/pkg/apis/meta/fuzzer"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/apimachinery/pkg/util/diff"
+)
+
+const fuzzIterations = 100
+
+func newFuzzer(t *testing.T) *fuzz.Fuzzer {
+	scheme := runtime.NewScheme()
+	if err := AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := nbv1beta1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	return fuzzer.FuzzerFor(metafuzzer.Funcs, rand.NewSource(rand.Int63()), serializer.NewCodecFactory(scheme))
+}
+
+func TestHubRoundTrip(t *testing.T) {
+	f := newFuzzer(t)
+	for i := 0; i < fuzzIterations; i++ {
+		hub := &nbv1beta1.Notebook{}
+		f.Fuzz(hub)
+		hub.TypeMeta = metav1.TypeMeta{}
+
+		spoke := &Notebook{}
+		if err := spoke.ConvertFrom(hub.DeepCopy()); err != nil {
+			t.Fatalf("Unable to convert from the hub: %v", err)
+		}
+		got := &nbv1beta1.Notebook{}
+		if err := spoke.ConvertTo(got); err != nil {
+			t.Fatalf("Unable to convert to the hub: %v", err)
+		}
+		if !apiequality.Semantic.DeepEqual(hub, got) {
+			t.Fatalf("Hub changed after round trip:\n%s", diff.ObjectReflectDiff(hub, got))
+		}
+	}
+}
+
+func TestSpokeRoundTrip(t *testing.T) {
+	f := newFuzzer(t)
+	for i := 0; i < fuzzIterations; i++ {
+		spoke := &Notebook{}
+		f.Fuzz(spoke)
+		spoke.TypeMeta = metav1.TypeMeta{}
+
+		hub := &nbv1beta1.Notebook{}
+		if err := spoke.DeepCopy().ConvertTo(hub); err != nil {
+			t.Fatalf("Unable to convert to the hub: %v", err)
+		}
+		got := &Notebook{}
+		if err := got.ConvertFrom(hub); err != nil {
+			t.Fatalf("Unable to convert from the hub: %v", err)
+		}
+		if !apiequality.Semantic.DeepEqual(spoke, got) {
+			t.Fatalf("Notebook changed after round trip:\n%s", diff.ObjectReflectDiff(spoke, got))
+		}
+	}
+}
@@ -0,0 +1,78 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Actions of a LifecycleTransition
+const (
+	LifecycleActionStart = "Start"
+	LifecycleActionStop  = "Stop"
+)
+
+// Reasons of a LifecycleTransition
+const (
+	LifecycleReasonCreated                    = "Created"
+	LifecycleReasonCulled                     = "Culled"
+	LifecycleReasonManual                     = "Manual"
+	LifecycleReasonScheduled                  = "Scheduled"
+	LifecycleReasonReconciliationLock         = "ReconciliationLock"
+	LifecycleReasonReconciliationLockReleased = "ReconciliationLockReleased"
+	LifecycleReasonWakeOnRequest              = "WakeOnRequest"
+	LifecycleReasonLimitExceeded              = "LimitExceeded"
+)
+
+// Reasons of a RestartStatus
+const (
+	RestartReasonRequested    = "Requested"
+	RestartReasonImageUpdated = "ImageUpdated"
+)
+
+// MaxLifecycleHistory is the number of transitions kept in the history.
+const MaxLifecycleHistory = 10
+
+// RecordLifecycleTransition records that the Notebook was stopped or started.
+// It is a no-op if the Notebook is already in that state, so that the
+// components observing the same transition don't record it twice. It returns
+// true if the status changed.
+func (s *NotebookStatus) RecordLifecycleTransition(action, reason, actor string, t metav1.Time) bool {
+	stopped := action == LifecycleActionStop
+	if s.Lifecycle != nil && s.Lifecycle.Stopped == stopped {
+		return false
+	}
+	if s.Lifecycle == nil {
+		s.Lifecycle = &LifecycleStatus{}
+	}
+
+	s.Lifecycle.Stopped = stopped
+	s.Lifecycle.Reason = reason
+	s.Lifecycle.Actor = actor
+	s.Lifecycle.LastTransitionTime = t
+
+	history := append([]LifecycleTransition{{
+		Action: action,
+		Reason: reason,
+		Actor:  actor,
+		Time:   t,
+	}}, s.Lifecycle.History...)
+	if len(history) > MaxLifecycleHistory {
+		history = history[:MaxLifecycleHistory]
+	}
+	s.Lifecycle.History = history
+	return true
+}
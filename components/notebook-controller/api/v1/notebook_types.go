@@ -17,6 +17,7 @@ package v1
 
 import (
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -27,6 +28,21 @@ import (
 type NotebookSpec struct {
 	// Template describes the notebooks that will be created.
 	Template NotebookTemplateSpec `json:"template,omitempty"`
+	// Culling overrides the namespace and controller culling policy for this Notebook.
+	// +optional
+	Culling *CullingPolicy `json:"culling,omitempty"`
+	// Schedule stops and starts the Notebook at fixed times, regardless of its activity.
+	// +optional
+	Schedule *NotebookSchedule `json:"schedule,omitempty"`
+	// Workspace is a volume managed by the controller and mounted in the
+	// Notebook's container.
+	// +optional
+	Workspace *NotebookWorkspace `json:"workspace,omitempty"`
+	// Endpoints are the applications served by the Notebook's Pod on other
+	// ports than the Notebook server, e.g. a TensorBoard or a Streamlit app.
+	// They are exposed under the prefix of the Notebook.
+	// +optional
+	Endpoints []NotebookEndpoint `json:"endpoints,omitempty"`
 }
 
 type NotebookTemplateSpec struct {
@@ -35,16 +51,294 @@ type NotebookTemplateSpec struct {
 
 // NotebookStatus defines the observed state of Notebook
 type NotebookStatus struct {
+	// Phase is a summary of the state of the Notebook. One of Pending,
+	// Starting, Running, Stopping, Stopped, Culled or Failed.
+	// +optional
+	Phase string `json:"phase,omitempty"`
 	// Conditions is an array of current conditions
 	Conditions []NotebookCondition `json:"conditions"`
 	// ReadyReplicas is the number of Pods created by the StatefulSet controller that have a Ready Condition.
 	ReadyReplicas int32 `json:"readyReplicas"`
 	// ContainerState is the state of underlying container.
 	ContainerState corev1.ContainerState `json:"containerState"`
+	// Culling is the effective culling policy of the Notebook.
+	// +optional
+	Culling *CullingStatus `json:"culling,omitempty"`
+	// Schedule is the next scheduled action of the Notebook.
+	// +optional
+	Schedule *ScheduleStatus `json:"schedule,omitempty"`
+	// Lifecycle tracks why and by whom the Notebook was stopped or started.
+	// +optional
+	Lifecycle *LifecycleStatus `json:"lifecycle,omitempty"`
+	// Workspace is the state of the workspace volume.
+	// +optional
+	Workspace *WorkspaceStatus `json:"workspace,omitempty"`
+	// Restart is the most recent restart of the Notebook's Pod by the
+	// controller.
+	// +optional
+	Restart *RestartStatus `json:"restart,omitempty"`
+	// Image is the digest the image of the Notebook resolves to, when its
+	// image update policy is Digest.
+	// +optional
+	Image *ImageStatus `json:"image,omitempty"`
+	// Routing is where the Notebook is reached, as exposed by the objects
+	// that route to it.
+	// +optional
+	Routing *RoutingStatus `json:"routing,omitempty"`
+}
+
+// CullingPolicy configures when an idle Notebook is stopped. Unset fields are
+// inherited from the namespace defaults and then from the controller defaults.
+type CullingPolicy struct {
+	// Enabled turns culling of the idle Notebook on or off.
+	// +optional
+	Enabled *bool `json:"enabled,omitempty"`
+	// IdleTime is how long the Notebook can be idle before it is culled.
+	// +optional
+	IdleTime *metav1.Duration `json:"idleTime,omitempty"`
+	// CheckPeriod is how often the activity of the Notebook is checked.
+	// +optional
+	CheckPeriod *metav1.Duration `json:"checkPeriod,omitempty"`
+	// WarningPeriod is how long before its culling an idle Notebook is
+	// annotated and a warning event is emitted. Zero disables the warning.
+	// +optional
+	WarningPeriod *metav1.Duration `json:"warningPeriod,omitempty"`
+}
+
+// CullingStatus is the culling policy in effect after resolving the Notebook,
+// namespace and controller settings.
+type CullingStatus struct {
+	// Enabled is true if the Notebook is culled when idle.
+	Enabled bool `json:"enabled"`
+	// IdleTime is how long the Notebook can be idle before it is culled.
+	IdleTime metav1.Duration `json:"idleTime"`
+	// CheckPeriod is how often the activity of the Notebook is checked.
+	CheckPeriod metav1.Duration `json:"checkPeriod"`
+	// WarningPeriod is how long before its culling an idle Notebook is warned.
+	WarningPeriod metav1.Duration `json:"warningPeriod"`
+}
+
+// NotebookSchedule configures when the Notebook is stopped and started. The
+// cron expressions use the standard five fields, or descriptors like @daily.
+type NotebookSchedule struct {
+	// Stop is the cron expression of the times to stop the Notebook at.
+	// +optional
+	Stop string `json:"stop,omitempty"`
+	// Start is the cron expression of the times to start the Notebook at.
+	// +optional
+	Start string `json:"start,omitempty"`
+	// TimeZone is the IANA name of the time zone of the cron expressions,
+	// e.g. Europe/Berlin. Defaults to UTC.
+	// +optional
+	TimeZone string `json:"timeZone,omitempty"`
+}
+
+// ScheduleStatus is the next action of the Notebook's schedule.
+type ScheduleStatus struct {
+	// NextAction is the next scheduled action. Can be Start or Stop.
+	NextAction string `json:"nextAction"`
+	// NextActionTime is when the next action is scheduled.
+	NextActionTime metav1.Time `json:"nextActionTime"`
+}
+
+// LifecycleStatus is the current stop state of the Notebook, along with the
+// most recent transitions between stopped and started.
+type LifecycleStatus struct {
+	// Stopped is true if the Notebook is stopped.
+	Stopped bool `json:"stopped"`
+	// Reason is why the Notebook was last stopped or started. Can be
+	// Created, Culled, Manual, Scheduled, ReconciliationLock,
+	// ReconciliationLockReleased or LimitExceeded.
+	// +optional
+	Reason string `json:"reason,omitempty"`
+	// Actor is the component or field manager that last stopped or started
+	// the Notebook, if known.
+	// +optional
+	Actor string `json:"actor,omitempty"`
+	// LastTransitionTime is when the Notebook was last stopped or started.
+	// +optional
+	LastTransitionTime metav1.Time `json:"lastTransitionTime,omitempty"`
+	// History holds the most recent transitions, newest first.
+	// +optional
+	History []LifecycleTransition `json:"history,omitempty"`
+}
+
+// LifecycleTransition is a past stop or start of the Notebook.
+type LifecycleTransition struct {
+	// Action is Start or Stop.
+	Action string `json:"action"`
+	// Reason is why the Notebook was stopped or started.
+	// +optional
+	Reason string `json:"reason,omitempty"`
+	// Actor is the component or field manager that stopped or started the
+	// Notebook, if known.
+	// +optional
+	Actor string `json:"actor,omitempty"`
+	// Time is when the transition happened.
+	Time metav1.Time `json:"time"`
+}
+
+// NotebookWorkspace configures the PersistentVolumeClaim of the Notebook's
+// workspace. The claim is named <notebook>-workspace.
+type NotebookWorkspace struct {
+	// Size is the requested size of the volume. It can be increased later if
+	// the StorageClass allows volume expansion, but not decreased.
+	Size resource.Quantity `json:"size"`
+	// StorageClassName is the StorageClass of the volume. Defaults to the
+	// default StorageClass of the cluster. Can't be changed once the claim
+	// is created.
+	// +optional
+	StorageClassName *string `json:"storageClassName,omitempty"`
+	// AccessModes of the volume. Defaults to ReadWriteOnce. Can't be changed
+	// once the claim is created.
+	// +optional
+	AccessModes []corev1.PersistentVolumeAccessMode `json:"accessModes,omitempty"`
+	// MountPath is where the volume is mounted in the Notebook's container.
+	// Defaults to /home/jovyan.
+	// +optional
+	MountPath string `json:"mountPath,omitempty"`
+	// RetentionPolicy is what happens to the claim when the Notebook is
+	// deleted. Can be Delete or Retain. Defaults to Delete.
+	// +kubebuilder:validation:Enum=Delete;Retain
+	// +optional
+	RetentionPolicy string `json:"retentionPolicy,omitempty"`
+	// Snapshots turns on taking a VolumeSnapshot of the workspace each time
+	// the Notebook is stopped.
+	// +optional
+	Snapshots *WorkspaceSnapshots `json:"snapshots,omitempty"`
+	// RestoreFromSnapshot is the name of a VolumeSnapshot, in the namespace
+	// of the Notebook, to populate the workspace with. It is only used when
+	// the claim is created.
+	// +optional
+	RestoreFromSnapshot string `json:"restoreFromSnapshot,omitempty"`
+}
+
+// WorkspaceSnapshots configures the VolumeSnapshots of the workspace taken
+// when the Notebook is stopped.
+type WorkspaceSnapshots struct {
+	// VolumeSnapshotClassName is the VolumeSnapshotClass of the snapshots.
+	// Defaults to the default VolumeSnapshotClass of the cluster.
+	// +optional
+	VolumeSnapshotClassName *string `json:"volumeSnapshotClassName,omitempty"`
+	// Retain is the number of snapshots kept. The oldest snapshots are
+	// deleted. Defaults to 3.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	Retain *int32 `json:"retain,omitempty"`
+}
+
+// WorkspaceStatus is the state of the PersistentVolumeClaim of the
+// Notebook's workspace.
+type WorkspaceStatus struct {
+	// ClaimName is the name of the PersistentVolumeClaim.
+	ClaimName string `json:"claimName"`
+	// Phase is the phase of the claim. Can be Pending, Bound or Lost.
+	// +optional
+	Phase corev1.PersistentVolumeClaimPhase `json:"phase,omitempty"`
+	// VolumeName is the PersistentVolume bound to the claim.
+	// +optional
+	VolumeName string `json:"volumeName,omitempty"`
+	// Capacity is the actual size of the bound volume. It is smaller than
+	// the requested size while the volume is being expanded.
+	// +optional
+	Capacity *resource.Quantity `json:"capacity,omitempty"`
+	// LastSnapshot is the name of the most recent VolumeSnapshot taken by
+	// the controller.
+	// +optional
+	LastSnapshot string `json:"lastSnapshot,omitempty"`
+}
+
+// NotebookEndpoint is an application served by the Notebook's Pod, exposed
+// on its own port of the Notebook's Service and under its own path of the
+// Notebook's prefix.
+type NotebookEndpoint struct {
+	// Name of the endpoint. It must be a DNS-1035 label, unique within the
+	// Notebook, and names the port of the Service.
+	Name string `json:"name"`
+	// Port is the container port the endpoint is served on. It is also the
+	// port of the Service.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	Port int32 `json:"port"`
+	// Path is appended to the prefix of the Notebook to expose the endpoint,
+	// e.g. "tensorboard/" for /notebook/<namespace>/<name>/tensorboard/. It
+	// defaults to the name of the endpoint followed by a slash.
+	// +optional
+	Path string `json:"path,omitempty"`
+	// Rewrite is the path the prefix of the endpoint is rewritten to, e.g. "/"
+	// for the applications that are served at the root. The prefix is kept
+	// if it is empty.
+	// +optional
+	Rewrite string `json:"rewrite,omitempty"`
+}
+
+// RestartStatus is a restart of the Notebook's Pod, requested through the
+// restart-requested-at annotation or caused by a new digest of its image.
+type RestartStatus struct {
+	// RequestedAt is the value of the restart-requested-at annotation that
+	// was last honored.
+	// +optional
+	RequestedAt string `json:"requestedAt,omitempty"`
+	// Reason is either Requested or ImageUpdated.
+	Reason string `json:"reason"`
+	// LastRestartTime is when the controller rolled the Pod.
+	LastRestartTime metav1.Time `json:"lastRestartTime"`
+}
+
+// ImageStatus is the digest of the image of the Notebook's first container.
+type ImageStatus struct {
+	// Image is the image that was resolved, as written in the Notebook.
+	Image string `json:"image"`
+	// Digest is the digest of the manifest the image resolved to.
+	// +optional
+	Digest string `json:"digest,omitempty"`
+	// LastResolveTime is when the image was last resolved.
+	// +optional
+	LastResolveTime metav1.Time `json:"lastResolveTime,omitempty"`
+	// Message is the error of the last resolution, if it failed.
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+// RoutingStatus is where the clients reach the Notebook, so that they don't
+// rebuild its URL from its name.
+type RoutingStatus struct {
+	// Backend is what routes the external requests to the Notebook: istio,
+	// ingress, gateway, route for the OpenShift Routes, or none.
+	Backend string `json:"backend"`
+	// URL is the external URL of the Notebook. It is only a path when the
+	// host isn't known, e.g. for the routes matching any host of a gateway
+	// without an external URL in the configuration, and it is empty while
+	// the route isn't admitted.
+	// +optional
+	URL string `json:"url,omitempty"`
+	// URLs are all the external URLs of the Notebook, starting with URL,
+	// when it is reached on several hosts.
+	// +optional
+	URLs []string `json:"urls,omitempty"`
+	// InternalURL is the URL of the Notebook's Service within the cluster,
+	// with the path its server is served on.
+	// +optional
+	InternalURL string `json:"internalURL,omitempty"`
+	// Endpoints are where the endpoints of the Notebook are reached, in the
+	// order of the spec.
+	// +optional
+	Endpoints []EndpointStatus `json:"endpoints,omitempty"`
+}
+
+// EndpointStatus is where an endpoint of the Notebook is reached.
+type EndpointStatus struct {
+	// Name is the name of the endpoint in the spec.
+	Name string `json:"name"`
+	// URL is the external URL of the endpoint, like the URL of the Notebook.
+	// +optional
+	URL string `json:"url,omitempty"`
+	// InternalURL is the URL of the endpoint on the Notebook's Service.
+	InternalURL string `json:"internalURL"`
 }
 
 type NotebookCondition struct {
-	// Type is the type of the condition. Possible values are Running|Waiting|Terminated
+	// Type is the type of the condition. Possible values are Ready|Scheduled|ImagePulled|Routed|WithinLimits
 	Type string `json:"type"`
 	// Status is the status of the condition. Can be True, False, Unknown.
 	Status string `json:"status"`
@@ -60,6 +354,10 @@ type NotebookCondition struct {
 	// Message regarding why the container is in the current state.
 	// +optional
 	Message string `json:"message,omitempty"`
+	// ObservedGeneration is the generation of the Notebook the condition
+	// was set for.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
 }
 
 // +kubebuilder:object:root=true
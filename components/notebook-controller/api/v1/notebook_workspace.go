@@ -0,0 +1,30 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+// Retention policies of a NotebookWorkspace
+const (
+	WorkspaceRetentionDelete = "Delete"
+	WorkspaceRetentionRetain = "Retain"
+)
+
+// DefaultWorkspaceMountPath is where the workspace is mounted if its
+// MountPath is empty.
+const DefaultWorkspaceMountPath = "/home/jovyan"
+
+// DefaultWorkspaceSnapshotRetention is the number of snapshots kept if the
+// Retain field of the WorkspaceSnapshots is unset.
+const DefaultWorkspaceSnapshotRetention = 3
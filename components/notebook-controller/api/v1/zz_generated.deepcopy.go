@@ -21,9 +21,134 @@ limitations under the License.
 package v1
 
 import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CullingPolicy) DeepCopyInto(out *CullingPolicy) {
+	*out = *in
+	if in.Enabled != nil {
+		in, out := &in.Enabled, &out.Enabled
+		*out = new(bool)
+		**out = **in
+	}
+	if in.IdleTime != nil {
+		in, out := &in.IdleTime, &out.IdleTime
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	if in.CheckPeriod != nil {
+		in, out := &in.CheckPeriod, &out.CheckPeriod
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	if in.WarningPeriod != nil {
+		in, out := &in.WarningPeriod, &out.WarningPeriod
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CullingPolicy.
+func (in *CullingPolicy) DeepCopy() *CullingPolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(CullingPolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CullingStatus) DeepCopyInto(out *CullingStatus) {
+	*out = *in
+	out.IdleTime = in.IdleTime
+	out.CheckPeriod = in.CheckPeriod
+	out.WarningPeriod = in.WarningPeriod
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CullingStatus.
+func (in *CullingStatus) DeepCopy() *CullingStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(CullingStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *EndpointStatus) DeepCopyInto(out *EndpointStatus) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EndpointStatus.
+func (in *EndpointStatus) DeepCopy() *EndpointStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(EndpointStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ImageStatus) DeepCopyInto(out *ImageStatus) {
+	*out = *in
+	in.LastResolveTime.DeepCopyInto(&out.LastResolveTime)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ImageStatus.
+func (in *ImageStatus) DeepCopy() *ImageStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ImageStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *LifecycleStatus) DeepCopyInto(out *LifecycleStatus) {
+	*out = *in
+	in.LastTransitionTime.DeepCopyInto(&out.LastTransitionTime)
+	if in.History != nil {
+		in, out := &in.History, &out.History
+		*out = make([]LifecycleTransition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LifecycleStatus.
+func (in *LifecycleStatus) DeepCopy() *LifecycleStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(LifecycleStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *LifecycleTransition) DeepCopyInto(out *LifecycleTransition) {
+	*out = *in
+	in.Time.DeepCopyInto(&out.Time)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LifecycleTransition.
+func (in *LifecycleTransition) DeepCopy() *LifecycleTransition {
+	if in == nil {
+		return nil
+	}
+	out := new(LifecycleTransition)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Notebook) DeepCopyInto(out *Notebook) {
 	*out = *in
@@ -68,6 +193,21 @@ func (in *NotebookCondition) DeepCopy() *NotebookCondition {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NotebookEndpoint) DeepCopyInto(out *NotebookEndpoint) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NotebookEndpoint.
+func (in *NotebookEndpoint) DeepCopy() *NotebookEndpoint {
+	if in == nil {
+		return nil
+	}
+	out := new(NotebookEndpoint)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *NotebookList) DeepCopyInto(out *NotebookList) {
 	*out = *in
@@ -100,10 +240,45 @@ func (in *NotebookList) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NotebookSchedule) DeepCopyInto(out *NotebookSchedule) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NotebookSchedule.
+func (in *NotebookSchedule) DeepCopy() *NotebookSchedule {
+	if in == nil {
+		return nil
+	}
+	out := new(NotebookSchedule)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *NotebookSpec) DeepCopyInto(out *NotebookSpec) {
 	*out = *in
 	in.Template.DeepCopyInto(&out.Template)
+	if in.Culling != nil {
+		in, out := &in.Culling, &out.Culling
+		*out = new(CullingPolicy)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Schedule != nil {
+		in, out := &in.Schedule, &out.Schedule
+		*out = new(NotebookSchedule)
+		**out = **in
+	}
+	if in.Workspace != nil {
+		in, out := &in.Workspace, &out.Workspace
+		*out = new(NotebookWorkspace)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Endpoints != nil {
+		in, out := &in.Endpoints, &out.Endpoints
+		*out = make([]NotebookEndpoint, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NotebookSpec.
@@ -127,6 +302,41 @@ func (in *NotebookStatus) DeepCopyInto(out *NotebookStatus) {
 		}
 	}
 	in.ContainerState.DeepCopyInto(&out.ContainerState)
+	if in.Culling != nil {
+		in, out := &in.Culling, &out.Culling
+		*out = new(CullingStatus)
+		**out = **in
+	}
+	if in.Schedule != nil {
+		in, out := &in.Schedule, &out.Schedule
+		*out = new(ScheduleStatus)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Lifecycle != nil {
+		in, out := &in.Lifecycle, &out.Lifecycle
+		*out = new(LifecycleStatus)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Workspace != nil {
+		in, out := &in.Workspace, &out.Workspace
+		*out = new(WorkspaceStatus)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Restart != nil {
+		in, out := &in.Restart, &out.Restart
+		*out = new(RestartStatus)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Image != nil {
+		in, out := &in.Image, &out.Image
+		*out = new(ImageStatus)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Routing != nil {
+		in, out := &in.Routing, &out.Routing
+		*out = new(RoutingStatus)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NotebookStatus.
@@ -154,3 +364,136 @@ func (in *NotebookTemplateSpec) DeepCopy() *NotebookTemplateSpec {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NotebookWorkspace) DeepCopyInto(out *NotebookWorkspace) {
+	*out = *in
+	out.Size = in.Size.DeepCopy()
+	if in.StorageClassName != nil {
+		in, out := &in.StorageClassName, &out.StorageClassName
+		*out = new(string)
+		**out = **in
+	}
+	if in.AccessModes != nil {
+		in, out := &in.AccessModes, &out.AccessModes
+		*out = make([]corev1.PersistentVolumeAccessMode, len(*in))
+		copy(*out, *in)
+	}
+	if in.Snapshots != nil {
+		in, out := &in.Snapshots, &out.Snapshots
+		*out = new(WorkspaceSnapshots)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NotebookWorkspace.
+func (in *NotebookWorkspace) DeepCopy() *NotebookWorkspace {
+	if in == nil {
+		return nil
+	}
+	out := new(NotebookWorkspace)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RestartStatus) DeepCopyInto(out *RestartStatus) {
+	*out = *in
+	in.LastRestartTime.DeepCopyInto(&out.LastRestartTime)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RestartStatus.
+func (in *RestartStatus) DeepCopy() *RestartStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(RestartStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RoutingStatus) DeepCopyInto(out *RoutingStatus) {
+	*out = *in
+	if in.URLs != nil {
+		in, out := &in.URLs, &out.URLs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Endpoints != nil {
+		in, out := &in.Endpoints, &out.Endpoints
+		*out = make([]EndpointStatus, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RoutingStatus.
+func (in *RoutingStatus) DeepCopy() *RoutingStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(RoutingStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ScheduleStatus) DeepCopyInto(out *ScheduleStatus) {
+	*out = *in
+	in.NextActionTime.DeepCopyInto(&out.NextActionTime)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ScheduleStatus.
+func (in *ScheduleStatus) DeepCopy() *ScheduleStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ScheduleStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WorkspaceSnapshots) DeepCopyInto(out *WorkspaceSnapshots) {
+	*out = *in
+	if in.VolumeSnapshotClassName != nil {
+		in, out := &in.VolumeSnapshotClassName, &out.VolumeSnapshotClassName
+		*out = new(string)
+		**out = **in
+	}
+	if in.Retain != nil {
+		in, out := &in.Retain, &out.Retain
+		*out = new(int32)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WorkspaceSnapshots.
+func (in *WorkspaceSnapshots) DeepCopy() *WorkspaceSnapshots {
+	if in == nil {
+		return nil
+	}
+	out := new(WorkspaceSnapshots)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WorkspaceStatus) DeepCopyInto(out *WorkspaceStatus) {
+	*out = *in
+	if in.Capacity != nil {
+		in, out := &in.Capacity, &out.Capacity
+		x := (*in).DeepCopy()
+		*out = &x
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WorkspaceStatus.
+func (in *WorkspaceStatus) DeepCopy() *WorkspaceStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(WorkspaceStatus)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -16,27 +16,75 @@ limitations under the License.
 package v1alpha1
 
 import (
+	"encoding/json"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"sigs.k8s.io/controller-runtime/pkg/conversion"
 
 	nbv1beta1 "github.com/kubeflow/kubeflow/components/notebook-controller/api/v1beta1"
 )
 
+// ConversionDataAnnotation holds the fields of the Hub version (v1beta1) that
+// this version cannot hold, so that converting to this version and back is
+// lossless.
+const ConversionDataAnnotation = "notebooks.kubeflow.org/conversion-data"
+
+// conversionData is the content of the ConversionDataAnnotation. The fields
+// that this version holds are left empty.
+type conversionData struct {
+	Spec   nbv1beta1.NotebookSpec   `json:"spec"`
+	Status nbv1beta1.NotebookStatus `json:"status"`
+	// ConditionGenerations are the ObservedGenerations of the conditions, in
+	// the same order.
+	ConditionGenerations []int64 `json:"conditionGenerations,omitempty"`
+}
+
 // ConvertTo converts this Notebook to the Hub version (v1beta1).
 func (src *Notebook) ConvertTo(dstRaw conversion.Hub) error {
 	dst := dstRaw.(*nbv1beta1.Notebook)
+	src.ObjectMeta.DeepCopyInto(&dst.ObjectMeta)
+
+	// Restore the fields that this version cannot hold. An invalid annotation
+	// is ignored, since it can be edited by the clients of this version.
+	data := conversionData{}
+	if raw, ok := src.Annotations[ConversionDataAnnotation]; ok {
+		if err := json.Unmarshal([]byte(raw), &data); err != nil {
+			data = conversionData{}
+		}
+		delete(dst.Annotations, ConversionDataAnnotation)
+		if len(dst.Annotations) == 0 {
+			dst.Annotations = nil
+		}
+	}
+	dst.Spec = data.Spec
+	dst.Status = data.Status
+
 	dst.Spec.Template.Spec = src.Spec.Template.Spec
 	dst.Status.ReadyReplicas = src.Status.ReadyReplicas
 	dst.Status.ContainerState = src.Status.ContainerState
-	conditions := []nbv1beta1.NotebookCondition{}
+	var conditions []nbv1beta1.NotebookCondition
+	if src.Status.Conditions != nil {
+		conditions = []nbv1beta1.NotebookCondition{}
+	}
 	for _, c := range src.Status.Conditions {
 		newc := nbv1beta1.NotebookCondition{
-			Type:          c.Type,
-			LastProbeTime: c.LastProbeTime,
-			Reason:        c.Reason,
-			Message:       c.Message,
+			Type:               c.Type,
+			Status:             c.Status,
+			LastProbeTime:      c.LastProbeTime,
+			LastTransitionTime: c.LastTransitionTime,
+			Reason:             c.Reason,
+			Message:            c.Message,
 		}
 		conditions = append(conditions, newc)
 	}
+	// The generations are only restored if the conditions weren't added or
+	// removed in this version
+	if len(data.ConditionGenerations) == len(conditions) {
+		for i := range conditions {
+			conditions[i].ObservedGeneration = data.ConditionGenerations[i]
+		}
+	}
 	dst.Status.Conditions = conditions
 
 	return nil
@@ -44,26 +92,60 @@ func (src *Notebook) ConvertTo(dstRaw conversion.Hub) error {
 
 /*
 ConvertFrom is expected to modify its receiver to contain the converted object.
-Most of the conversion is straightforward copying, except for converting our changed field.
+The fields that this version cannot hold are stored in an annotation.
 */
 
 // ConvertFrom converts from the Hub version (v1beta1) to this version.
 func (dst *Notebook) ConvertFrom(srcRaw conversion.Hub) error {
 	src := srcRaw.(*nbv1beta1.Notebook)
+	src.ObjectMeta.DeepCopyInto(&dst.ObjectMeta)
 	dst.Spec.Template.Spec = src.Spec.Template.Spec
 	dst.Status.ReadyReplicas = src.Status.ReadyReplicas
 	dst.Status.ContainerState = src.Status.ContainerState
-	conditions := []NotebookCondition{}
+	var conditions []NotebookCondition
+	if src.Status.Conditions != nil {
+		conditions = []NotebookCondition{}
+	}
+	generations := []int64{}
+	observed := false
 	for _, c := range src.Status.Conditions {
 		newc := NotebookCondition{
-			Type:          c.Type,
-			LastProbeTime: c.LastProbeTime,
-			Reason:        c.Reason,
-			Message:       c.Message,
+			Type:               c.Type,
+			Status:             c.Status,
+			LastProbeTime:      c.LastProbeTime,
+			LastTransitionTime: c.LastTransitionTime,
+			Reason:             c.Reason,
+			Message:            c.Message,
 		}
 		conditions = append(conditions, newc)
+		generations = append(generations, c.ObservedGeneration)
+		observed = observed || c.ObservedGeneration != 0
 	}
 	dst.Status.Conditions = conditions
 
+	// Store the fields that this version cannot hold
+	data := conversionData{
+		Spec:   *src.Spec.DeepCopy(),
+		Status: *src.Status.DeepCopy(),
+	}
+	data.Spec.Template = nbv1beta1.NotebookTemplateSpec{}
+	data.Status.Conditions = nil
+	data.Status.ReadyReplicas = 0
+	data.Status.ContainerState = corev1.ContainerState{}
+	if observed {
+		data.ConditionGenerations = generations
+	}
+	if equality.Semantic.DeepEqual(data, conversionData{}) {
+		return nil
+	}
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if dst.Annotations == nil {
+		dst.Annotations = map[string]string{}
+	}
+	dst.Annotations[ConversionDataAnnotation] = string(raw)
+
 	return nil
 }
@@ -0,0 +1,109 @@
+package v1alpha1
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	fuzz "github.com/google/gofuzz"
+	nbv1beta1 "github.com/kubeflow/kubeflow/components/notebook-controller/api/v1beta1"
+	"k8s.io/apimachinery/pkg/api/apitesting/fuzzer"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	metafuzzer "k8s.io/apimachinery/pkg/apis/meta/fuzzer"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/apimachinery/pkg/util/diff"
+)
+
+const fuzzIterations = 100
+
+func newFuzzer(t *testing.T) *fuzz.Fuzzer {
+	scheme := runtime.NewScheme()
+	if err := AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := nbv1beta1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	funcs := fuzzer.MergeFuzzerFuncs(metafuzzer.Funcs, func(serializer.CodecFactory) []interface{} {
+		return []interface{}{
+			// Durations are stored as strings in the conversion annotation
+			func(d *metav1.Duration, c fuzz.Continue) {
+				d.Duration = time.Duration(c.Int63n(int64(1000 * time.Hour)))
+			},
+		}
+	})
+	return fuzzer.FuzzerFor(funcs, rand.NewSource(rand.Int63()), serializer.NewCodecFactory(scheme))
+}
+
+func TestHubRoundTrip(t *testing.T) {
+	f := newFuzzer(t)
+	for i := 0; i < fuzzIterations; i++ {
+		hub := &nbv1beta1.Notebook{}
+		f.Fuzz(hub)
+		hub.TypeMeta = metav1.TypeMeta{}
+
+		spoke := &Notebook{}
+		if err := spoke.ConvertFrom(hub.DeepCopy()); err != nil {
+			t.Fatalf("Unable to convert from the hub: %v", err)
+		}
+		got := &nbv1beta1.Notebook{}
+		if err := spoke.ConvertTo(got); err != nil {
+			t.Fatalf("Unable to convert to the hub: %v", err)
+		}
+		if !apiequality.Semantic.DeepEqual(hub, got) {
+			t.Fatalf("Hub changed after round trip:\n%s", diff.ObjectReflectDiff(hub, got))
+		}
+	}
+}
+
+func TestSpokeRoundTrip(t *testing.T) {
+	f := newFuzzer(t)
+	for i := 0; i < fuzzIterations; i++ {
+		spoke := &Notebook{}
+		f.Fuzz(spoke)
+		spoke.TypeMeta = metav1.TypeMeta{}
+
+		hub := &nbv1beta1.Notebook{}
+		if err := spoke.DeepCopy().ConvertTo(hub); err != nil {
+			t.Fatalf("Unable to convert to the hub: %v", err)
+		}
+		got := &Notebook{}
+		if err := got.ConvertFrom(hub); err != nil {
+			t.Fatalf("Unable to convert from the hub: %v", err)
+		}
+		if !apiequality.Semantic.DeepEqual(spoke, got) {
+			t.Fatalf("Notebook changed after round trip:\n%s", diff.ObjectReflectDiff(spoke, got))
+		}
+	}
+}
+
+func TestConvertFromStoresConversionData(t *testing.T) {
+	hub := &nbv1beta1.Notebook{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"},
+		Status: nbv1beta1.NotebookStatus{
+			Phase: nbv1beta1.NotebookPhaseRunning,
+		},
+	}
+	spoke := &Notebook{}
+	if err := spoke.ConvertFrom(hub); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := spoke.Annotations[ConversionDataAnnotation]; !ok {
+		t.Errorf("Expected the phase to be stored in the %s annotation", ConversionDataAnnotation)
+	}
+	if hub.Annotations != nil {
+		t.Errorf("Expected the annotations of the hub to be left unchanged, got %v", hub.Annotations)
+	}
+
+	// The clients of this version may break the annotation
+	spoke.Annotations[ConversionDataAnnotation] = "{"
+	got := &nbv1beta1.Notebook{}
+	if err := spoke.ConvertTo(got); err != nil {
+		t.Fatalf("Expected an invalid annotation to be ignored, got %v", err)
+	}
+	if got.Annotations != nil || got.Status.Phase != "" || got.Name != "test" {
+		t.Errorf("Got unexpected Notebook %+v", got)
+	}
+}
@@ -15,18 +15,16 @@ limitations under the License.
 
 package v1beta1
 
-import (
-	ctrl "sigs.k8s.io/controller-runtime"
-	logf "sigs.k8s.io/controller-runtime/pkg/log"
+// Retention policies of a NotebookWorkspace
+const (
+	WorkspaceRetentionDelete = "Delete"
+	WorkspaceRetentionRetain = "Retain"
 )
 
-// log is for logging in this package.
-var notebooklog = logf.Log.WithName("notebook-resource")
+// DefaultWorkspaceMountPath is where the workspace is mounted if its
+// MountPath is empty.
+const DefaultWorkspaceMountPath = "/home/jovyan"
 
-func (r *Notebook) SetupWebhookWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewWebhookManagedBy(mgr).
-		For(r).
-		Complete()
-}
-
-// EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
+// DefaultWorkspaceSnapshotRetention is the number of snapshots kept if the
+// Retain field of the WorkspaceSnapshots is unset.
+const DefaultWorkspaceSnapshotRetention = 3
@@ -0,0 +1,349 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/kubeflow/kubeflow/components/notebook-controller/api/v1beta1"
+	"github.com/kubeflow/kubeflow/components/notebook-controller/pkg/config"
+	"github.com/kubeflow/kubeflow/components/notebook-controller/pkg/culler"
+	appsv1 "k8s.io/api/apps/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// A Notebook with this annotation set to "true" is started again when its URL
+// is visited while it is stopped.
+const AnnotationWakeOnRequest = "notebooks.kubeflow.org/wake-on-request"
+
+// ActivatorNotebookHeader tells the activator which Notebook a request is for,
+// as "<namespace>/<name>". It is set by the Notebook's VirtualService.
+const ActivatorNotebookHeader = "X-Kubeflow-Notebook"
+
+// DefaultActivatorPort is the port of the activator's Service.
+const DefaultActivatorPort = 80
+
+// activatorRetryAfter is the delay, in seconds, after which the clients are
+// asked to retry while the Notebook is starting.
+const activatorRetryAfter = 5
+
+// activatorPollInterval is how often the activator checks whether a Notebook
+// it holds requests for is ready.
+var activatorPollInterval = time.Second
+
+// wakeOnRequest returns true if the Notebook is started when its URL is
+// visited while it is stopped.
+func wakeOnRequest(meta metav1.ObjectMeta) bool {
+	return meta.GetAnnotations()[AnnotationWakeOnRequest] == "true"
+}
+
+// activatorService returns the host of the activator's Service, or an empty
+// string if waking Notebooks on request is disabled.
+func activatorService() string {
+	return config.Get().Routing.ActivatorService
+}
+
+// routeToActivator returns true if the requests for the Notebook should be
+// sent to the activator instead of the Notebook's Service, i.e. if the
+// Notebook is stopped or not ready yet and wakes on request.
+func routeToActivator(instance *v1beta1.Notebook, sts *appsv1.StatefulSet) bool {
+	if activatorService() == "" || !wakeOnRequest(instance.ObjectMeta) {
+		return false
+	}
+	return culler.StopAnnotationIsSet(instance.ObjectMeta) || sts.Status.ReadyReplicas == 0
+}
+
+// NotebookAuthorizer tells whether a user may start a Notebook.
+type NotebookAuthorizer interface {
+	CanStart(ctx context.Context, user string, key types.NamespacedName) (bool, error)
+}
+
+// subjectAccessReviewer allows the users who may patch the Notebook, as
+// starting it removes its STOP_ANNOTATION.
+type subjectAccessReviewer struct {
+	client client.Client
+}
+
+// +kubebuilder:rbac:groups=authorization.k8s.io,resources=subjectaccessreviews,verbs=create
+
+func (s subjectAccessReviewer) CanStart(ctx context.Context, user string, key types.NamespacedName) (bool, error) {
+	review := &authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			User: user,
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Namespace: key.Namespace,
+				Verb:      "patch",
+				Group:     v1beta1.GroupVersion.Group,
+				Resource:  "notebooks",
+				Name:      key.Name,
+			},
+		},
+	}
+	if err := s.client.Create(ctx, review); err != nil {
+		return false, err
+	}
+	return review.Status.Allowed, nil
+}
+
+// Activator serves the requests for the stopped Notebooks that wake on
+// request. It starts the Notebook by removing its STOP_ANNOTATION, holds the
+// request until the Notebook is ready and then redirects the client to the
+// original URL, which the VirtualService routes to the Notebook again. If the
+// Notebook takes longer than HoldTimeout to start, the client is asked to
+// retry.
+//
+// The activator trusts the ActivatorNotebookHeader and the user header set
+// by the gateway, so only the gateway must be able to reach it. It only
+// starts the Notebooks that the user may patch.
+type Activator struct {
+	Client        client.Client
+	Log           logr.Logger
+	EventRecorder record.EventRecorder
+	// Authorizer checks that the user of a request may start the Notebook.
+	// It defaults to a SubjectAccessReview.
+	Authorizer NotebookAuthorizer
+
+	// Addr is the address the activator binds to.
+	Addr string
+	// HoldTimeout is how long a request is held while the Notebook starts.
+	HoldTimeout time.Duration
+}
+
+// Start runs the activator's HTTP server until the context is done.
+func (a *Activator) Start(ctx context.Context) error {
+	srv := &http.Server{Addr: a.Addr, Handler: a}
+
+	errc := make(chan error, 1)
+	go func() {
+		a.Log.Info("Starting the activator", "addr", a.Addr)
+		errc <- srv.ListenAndServe()
+	}()
+
+	select {
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), a.HoldTimeout+time.Second)
+		defer cancel()
+		return srv.Shutdown(shutdownCtx)
+	case err := <-errc:
+		return err
+	}
+}
+
+// NeedLeaderElection returns false, so that every replica of the controller
+// serves the requests it gets.
+func (a *Activator) NeedLeaderElection() bool {
+	return false
+}
+
+func (a *Activator) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+
+	key, ok := activatorNotebookKey(req.Header.Get(ActivatorNotebookHeader))
+	if !ok {
+		http.Error(w, "Unknown Notebook", http.StatusBadRequest)
+		return
+	}
+	log := a.Log.WithValues("notebook", key.String())
+
+	nb := &v1beta1.Notebook{}
+	if err := a.Client.Get(ctx, key, nb); err != nil {
+		if apierrs.IsNotFound(err) {
+			http.Error(w, "Notebook not found", http.StatusNotFound)
+			return
+		}
+		log.Error(err, "unable to get the Notebook")
+		http.Error(w, "Unable to get the Notebook", http.StatusInternalServerError)
+		return
+	}
+
+	if value, stopped := nb.GetAnnotations()[culler.STOP_ANNOTATION]; stopped {
+		// Only wake the Notebook for the pages visited in a browser, so that
+		// the API calls of a tab left open don't undo the culling
+		if !wakeOnRequest(nb.ObjectMeta) || value == reconciliationLockValue || !isNavigation(req) {
+			writeNotebookStopped(w, req, key)
+			return
+		}
+
+		allowed, err := a.authorize(ctx, req, key)
+		if err != nil {
+			log.Error(err, "unable to authorize the request")
+			http.Error(w, "Unable to authorize the request", http.StatusInternalServerError)
+			return
+		}
+		if !allowed {
+			writeNotebookForbidden(w, req, key)
+			return
+		}
+
+		log.Info("Starting the Notebook on request")
+		if err := a.wake(ctx, nb); err != nil {
+			log.Error(err, "unable to start the Notebook")
+			http.Error(w, "Unable to start the Notebook", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if a.waitUntilReady(ctx, key) {
+		http.Redirect(w, req, originalURI(req, key), http.StatusTemporaryRedirect)
+		return
+	}
+	writeNotebookStarting(w, req, key)
+}
+
+// authorize returns true if the user of the request may start the Notebook.
+// The requests without a user are denied, unless the user header is disabled
+// in the configuration.
+func (a *Activator) authorize(ctx context.Context, req *http.Request, key types.NamespacedName) (bool, error) {
+	routing := config.Get().Routing
+	if routing.ActivatorUserIDHeader == "" {
+		return true, nil
+	}
+	user := strings.TrimPrefix(req.Header.Get(routing.ActivatorUserIDHeader), routing.ActivatorUserIDPrefix)
+	if user == "" {
+		return false, nil
+	}
+
+	authorizer := a.Authorizer
+	if authorizer == nil {
+		authorizer = subjectAccessReviewer{client: a.Client}
+	}
+	return authorizer.CanStart(ctx, user, key)
+}
+
+// wake starts the Notebook and records it in its lifecycle.
+func (a *Activator) wake(ctx context.Context, nb *v1beta1.Notebook) error {
+	patch := client.MergeFrom(nb.DeepCopy())
+	delete(nb.Annotations, culler.STOP_ANNOTATION)
+	if err := a.Client.Patch(ctx, nb, patch); err != nil {
+		return err
+	}
+	a.EventRecorder.Event(nb, corev1.EventTypeNormal, "WokenOnRequest",
+		"Starting the Notebook on request")
+
+	key := types.NamespacedName{Name: nb.Name, Namespace: nb.Namespace}
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		if err := a.Client.Get(ctx, key, nb); err != nil {
+			return err
+		}
+		if !nb.Status.RecordLifecycleTransition(v1beta1.LifecycleActionStart,
+			v1beta1.LifecycleReasonWakeOnRequest, lifecycleActor, metav1.Now()) {
+			return nil
+		}
+		return a.Client.Status().Update(ctx, nb)
+	})
+}
+
+// waitUntilReady polls the Notebook until it has a ready replica, for at most
+// HoldTimeout. It returns true if the Notebook is ready.
+func (a *Activator) waitUntilReady(ctx context.Context, key types.NamespacedName) bool {
+	ctx, cancel := context.WithTimeout(ctx, a.HoldTimeout)
+	defer cancel()
+
+	ticker := time.NewTicker(activatorPollInterval)
+	defer ticker.Stop()
+	for {
+		nb := &v1beta1.Notebook{}
+		if err := a.Client.Get(ctx, key, nb); err == nil &&
+			!culler.StopAnnotationIsSet(nb.ObjectMeta) && nb.Status.ReadyReplicas > 0 {
+			return true
+		}
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+		}
+	}
+}
+
+// activatorNotebookKey parses the ActivatorNotebookHeader.
+func activatorNotebookKey(header string) (types.NamespacedName, bool) {
+	parts := strings.Split(header, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return types.NamespacedName{}, false
+	}
+	return types.NamespacedName{Namespace: parts[0], Name: parts[1]}, true
+}
+
+// isNavigation returns true if the request is a browser loading a page.
+func isNavigation(req *http.Request) bool {
+	return req.Method == http.MethodGet && strings.Contains(req.Header.Get("Accept"), "text/html")
+}
+
+// originalURI returns the URI the client requested, before the VirtualService
+// rewrote it.
+func originalURI(req *http.Request, key types.NamespacedName) string {
+	uri := req.Header.Get("X-Envoy-Original-Path")
+	if uri == "" {
+		uri = req.URL.RequestURI()
+	}
+	// Only redirect within the gateway's host
+	if !strings.HasPrefix(uri, "/") || strings.HasPrefix(uri, "//") {
+		uri = fmt.Sprintf("/notebook/%s/%s/", key.Namespace, key.Name)
+	}
+	return uri
+}
+
+// writeNotebookStopped answers that the Notebook is stopped and won't be
+// started by this request.
+func writeNotebookStopped(w http.ResponseWriter, req *http.Request, key types.NamespacedName) {
+	w.Header().Set("Retry-After", fmt.Sprint(activatorRetryAfter))
+	writeNotebookPage(w, req, http.StatusServiceUnavailable,
+		fmt.Sprintf("The Notebook %s is stopped.", key), false)
+}
+
+// writeNotebookForbidden answers that the user may not start the Notebook.
+func writeNotebookForbidden(w http.ResponseWriter, req *http.Request, key types.NamespacedName) {
+	writeNotebookPage(w, req, http.StatusForbidden,
+		fmt.Sprintf("The Notebook %s is stopped, and you are not allowed to start it.", key), false)
+}
+
+// writeNotebookStarting asks the client to retry while the Notebook starts.
+// Browsers reload the page by themselves.
+func writeNotebookStarting(w http.ResponseWriter, req *http.Request, key types.NamespacedName) {
+	w.Header().Set("Retry-After", fmt.Sprint(activatorRetryAfter))
+	writeNotebookPage(w, req, http.StatusServiceUnavailable,
+		fmt.Sprintf("The Notebook %s is starting. This page reloads when it is ready.", key), true)
+}
+
+func writeNotebookPage(w http.ResponseWriter, req *http.Request, code int, message string, refresh bool) {
+	if !isNavigation(req) {
+		http.Error(w, message, code)
+		return
+	}
+
+	if refresh {
+		w.Header().Set("Refresh", fmt.Sprint(activatorRetryAfter))
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	fmt.Fprintf(w, "<!DOCTYPE html>\n<html><head><title>Notebook</title></head><body><p>%s</p></body></html>\n",
+		html.EscapeString(message))
+}
@@ -0,0 +1,247 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	nbv1beta1 "github.com/kubeflow/kubeflow/components/notebook-controller/api/v1beta1"
+	"github.com/kubeflow/kubeflow/components/notebook-controller/pkg/culler"
+)
+
+// fakeAuthorizer allows the users of the set.
+type fakeAuthorizer map[string]bool
+
+func (f fakeAuthorizer) CanStart(ctx context.Context, user string, key types.NamespacedName) (bool, error) {
+	return f[user], nil
+}
+
+func TestActivator(t *testing.T) {
+	activatorPollInterval = 10 * time.Millisecond
+	browser := "text/html,application/xhtml+xml"
+
+	tests := []struct {
+		name     string
+		header   string
+		user     string
+		accept   string
+		stop     string
+		wake     bool
+		ready    bool
+		code     int
+		location string
+		stopped  bool
+	}{
+		{
+			name: "unknown Notebook",
+			code: http.StatusBadRequest,
+		},
+		{
+			name:   "Notebook not found",
+			header: "default/other",
+			code:   http.StatusNotFound,
+		},
+		{
+			name:    "stopped Notebook that doesn't wake on request",
+			header:  "default/test",
+			accept:  browser,
+			stop:    "2022-08-30T16:37:36Z",
+			code:    http.StatusServiceUnavailable,
+			stopped: true,
+		},
+		{
+			name:    "API call doesn't wake the Notebook",
+			header:  "default/test",
+			accept:  "application/json",
+			stop:    "2022-08-30T16:37:36Z",
+			wake:    true,
+			code:    http.StatusServiceUnavailable,
+			stopped: true,
+		},
+		{
+			name:    "reconciliation lock is kept",
+			header:  "default/test",
+			accept:  browser,
+			stop:    reconciliationLockValue,
+			wake:    true,
+			code:    http.StatusServiceUnavailable,
+			stopped: true,
+		},
+		{
+			name:    "anonymous page visit doesn't wake the Notebook",
+			header:  "default/test",
+			accept:  browser,
+			stop:    "2022-08-30T16:37:36Z",
+			wake:    true,
+			code:    http.StatusForbidden,
+			stopped: true,
+		},
+		{
+			name:    "unauthorized page visit doesn't wake the Notebook",
+			header:  "default/test",
+			user:    "mallory@example.com",
+			accept:  browser,
+			stop:    "2022-08-30T16:37:36Z",
+			wake:    true,
+			code:    http.StatusForbidden,
+			stopped: true,
+		},
+		{
+			name:   "page visit wakes the Notebook",
+			header: "default/test",
+			user:   "alice@example.com",
+			accept: browser,
+			stop:   "2022-08-30T16:37:36Z",
+			wake:   true,
+			code:   http.StatusServiceUnavailable,
+		},
+		{
+			name:     "ready Notebook is redirected to",
+			header:   "default/test",
+			accept:   browser,
+			wake:     true,
+			ready:    true,
+			code:     http.StatusTemporaryRedirect,
+			location: "/notebook/default/test/lab",
+		},
+	}
+
+	scheme := runtime.NewScheme()
+	_ = nbv1beta1.AddToScheme(scheme)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			nb := &nbv1beta1.Notebook{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "test",
+					Namespace:   "default",
+					Annotations: map[string]string{},
+				},
+			}
+			if test.stop != "" {
+				nb.Annotations[culler.STOP_ANNOTATION] = test.stop
+			}
+			if test.wake {
+				nb.Annotations[AnnotationWakeOnRequest] = "true"
+			}
+			if test.ready {
+				nb.Status.ReadyReplicas = 1
+			}
+
+			c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(nb).Build()
+			a := &Activator{
+				Client:        c,
+				Log:           logr.Discard(),
+				EventRecorder: record.NewFakeRecorder(10),
+				Authorizer:    fakeAuthorizer{"alice@example.com": true},
+				HoldTimeout:   50 * time.Millisecond,
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/notebook/default/test/lab", nil)
+			req.Header.Set("Accept", test.accept)
+			if test.header != "" {
+				req.Header.Set(ActivatorNotebookHeader, test.header)
+			}
+			if test.user != "" {
+				req.Header.Set("kubeflow-userid", test.user)
+			}
+			rec := httptest.NewRecorder()
+			a.ServeHTTP(rec, req)
+
+			if rec.Code != test.code {
+				t.Errorf("Got status %d, expected %d", rec.Code, test.code)
+			}
+			if location := rec.Header().Get("Location"); location != test.location {
+				t.Errorf("Got location %q, expected %q", location, test.location)
+			}
+			if test.code == http.StatusServiceUnavailable && rec.Header().Get("Retry-After") == "" {
+				t.Errorf("Expected a Retry-After header")
+			}
+
+			found := &nbv1beta1.Notebook{}
+			if err := c.Get(req.Context(), types.NamespacedName{Name: "test", Namespace: "default"}, found); err != nil {
+				return
+			}
+			if stopped := culler.StopAnnotationIsSet(found.ObjectMeta); stopped != (test.stop != "" && test.stopped) {
+				t.Errorf("Got stopped %t, expected %t", stopped, test.stopped)
+			}
+			if test.stop != "" && !test.stopped {
+				lifecycle := found.Status.Lifecycle
+				if lifecycle == nil || lifecycle.Reason != nbv1beta1.LifecycleReasonWakeOnRequest {
+					t.Errorf("Expected the wake to be recorded, got %+v", lifecycle)
+				}
+			}
+		})
+	}
+}
+
+func TestOriginalURI(t *testing.T) {
+	key := types.NamespacedName{Name: "test", Namespace: "default"}
+
+	req := httptest.NewRequest(http.MethodGet, "/lab?reset", nil)
+	if uri := originalURI(req, key); uri != "/lab?reset" {
+		t.Errorf("Got %q, expected the request URI", uri)
+	}
+	req.Header.Set("X-Envoy-Original-Path", "/notebook/default/test/tree")
+	if uri := originalURI(req, key); uri != "/notebook/default/test/tree" {
+		t.Errorf("Got %q, expected the original path", uri)
+	}
+	req.Header.Set("X-Envoy-Original-Path", "//example.com/")
+	if uri := originalURI(req, key); uri != "/notebook/default/test/" {
+		t.Errorf("Got %q, expected the Notebook's prefix", uri)
+	}
+}
+
+func TestRouteToActivator(t *testing.T) {
+	t.Setenv("ACTIVATOR_SERVICE", "notebook-controller-activator.kubeflow.svc.cluster.local")
+
+	nb := &nbv1beta1.Notebook{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "test",
+			Namespace:   "default",
+			Annotations: map[string]string{AnnotationWakeOnRequest: "true"},
+		},
+	}
+	ready := &appsv1.StatefulSet{Status: appsv1.StatefulSetStatus{ReadyReplicas: 1}}
+
+	if routeToActivator(nb, ready) {
+		t.Errorf("Expected a ready Notebook to be routed to its Service")
+	}
+	if !routeToActivator(nb, &appsv1.StatefulSet{}) {
+		t.Errorf("Expected a starting Notebook to be routed to the activator")
+	}
+	culler.SetStopAnnotation(&nb.ObjectMeta, nil)
+	if !routeToActivator(nb, ready) {
+		t.Errorf("Expected a stopped Notebook to be routed to the activator")
+	}
+	delete(nb.Annotations, AnnotationWakeOnRequest)
+	if routeToActivator(nb, ready) {
+		t.Errorf("Expected a Notebook that doesn't wake on request to be routed to its Service")
+	}
+
+	vsvc, err := generateVirtualService(nb, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	http, _, _ := unstructured.NestedSlice(vsvc.Object, "spec", "http")
+	route := http[0].(map[string]interface{})
+	destination := route["route"].([]interface{})[0].(map[string]interface{})
+	host, _, _ := unstructured.NestedString(destination, "destination", "host")
+	if host != "notebook-controller-activator.kubeflow.svc.cluster.local" {
+		t.Errorf("Got host %q, expected the activator", host)
+	}
+	header, _, _ := unstructured.NestedString(route, "headers", "request", "set", ActivatorNotebookHeader)
+	if header != "default/test" {
+		t.Errorf("Got %s header %q, expected default/test", ActivatorNotebookHeader, header)
+	}
+}
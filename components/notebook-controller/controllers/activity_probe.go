@@ -0,0 +1,118 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/kubeflow/kubeflow/components/notebook-controller/api/v1beta1"
+	"github.com/kubeflow/kubeflow/components/notebook-controller/pkg/culler"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// probeTokens remembers the token of the activity probe of each Notebook,
+// along with the name of the Secret it was read from. Its zero value is ready
+// to use.
+type probeTokens struct {
+	mu     sync.Mutex
+	tokens map[types.NamespacedName]probeToken
+}
+
+type probeToken struct {
+	secret string
+	token  string
+}
+
+// get returns the token of the Notebook if it was read from the Secret.
+func (p *probeTokens) get(key types.NamespacedName, secret string) (string, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	cached, ok := p.tokens[key]
+	if !ok || cached.secret != secret {
+		return "", false
+	}
+	return cached.token, true
+}
+
+func (p *probeTokens) set(key types.NamespacedName, secret, token string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.tokens == nil {
+		p.tokens = map[types.NamespacedName]probeToken{}
+	}
+	p.tokens[key] = probeToken{secret: secret, token: token}
+}
+
+func (p *probeTokens) forget(key types.NamespacedName) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.tokens, key)
+}
+
+// probeEndpoint returns how the culler reaches the Notebook server, along
+// with the Jupyter token of the Secret named by the Notebook's
+// ACTIVITY_PROBE_TOKEN_SECRET_ANNOTATION. The Secret is only read when the
+// Notebook is first probed after it started, or when the annotation changes.
+func (r *NotebookReconciler) probeEndpoint(ctx context.Context, nb *v1beta1.Notebook) culler.ProbeEndpoint {
+	endpoint := culler.GetProbeEndpoint(nb.ObjectMeta)
+
+	key := types.NamespacedName{Name: nb.Name, Namespace: nb.Namespace}
+	name := culler.ProbeTokenSecretName(nb.ObjectMeta)
+	if name == "" {
+		r.probeTokens.forget(key)
+		return endpoint
+	}
+	if token, ok := r.probeTokens.get(key, name); ok {
+		endpoint.Token = token
+		return endpoint
+	}
+
+	token, err := r.probeToken(ctx, types.NamespacedName{Name: name, Namespace: nb.Namespace})
+	if err != nil {
+		// Probe without the token, so that the failures of the probe show up
+		// in the metrics
+		r.Log.Error(err, "unable to get the token of the activity probe",
+			"notebook", nb.Namespace+"/"+nb.Name)
+		r.EventRecorder.Eventf(nb, corev1.EventTypeWarning, "ProbeTokenUnavailable",
+			"Unable to get the token of the activity probe: %v", err)
+		return endpoint
+	}
+	r.probeTokens.set(key, name, token)
+	endpoint.Token = token
+	return endpoint
+}
+
+// probeToken reads the token from the Secret. The Secret is read from the API
+// server rather than the cache, so that the controller doesn't have to watch
+// all the Secrets of the cluster.
+func (r *NotebookReconciler) probeToken(ctx context.Context, key types.NamespacedName) (string, error) {
+	secret := &corev1.Secret{}
+	if err := r.APIReader.Get(ctx, key, secret); err != nil {
+		return "", err
+	}
+
+	token, ok := secret.Data[culler.PROBE_TOKEN_SECRET_KEY]
+	if !ok || len(token) == 0 {
+		return "", fmt.Errorf("Secret %s has no %q key", key.Name, culler.PROBE_TOKEN_SECRET_KEY)
+	}
+	return strings.TrimSpace(string(token)), nil
+}
@@ -0,0 +1,177 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	nbv1beta1 "github.com/kubeflow/kubeflow/components/notebook-controller/api/v1beta1"
+	"github.com/kubeflow/kubeflow/components/notebook-controller/pkg/culler"
+)
+
+func TestProbeEndpoint(t *testing.T) {
+	tests := []struct {
+		name   string
+		secret string
+		token  string
+		event  bool
+	}{
+		{
+			name: "no token Secret",
+		},
+		{
+			name:   "token Secret",
+			secret: "jupyter-token",
+			token:  "secret",
+		},
+		{
+			name:   "missing Secret",
+			secret: "other",
+			event:  true,
+		},
+		{
+			name:   "Secret without a token",
+			secret: "empty",
+			event:  true,
+		},
+	}
+
+	scheme := runtime.NewScheme()
+	_ = corev1.AddToScheme(scheme)
+	secrets := []runtime.Object{
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "jupyter-token", Namespace: "default"},
+			Data:       map[string][]byte{culler.PROBE_TOKEN_SECRET_KEY: []byte("secret\n")},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "empty", Namespace: "default"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			nb := &nbv1beta1.Notebook{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "test",
+					Namespace: "default",
+					Annotations: map[string]string{
+						culler.ACTIVITY_PROBE_SCHEME_ANNOTATION: "https",
+					},
+				},
+			}
+			if test.secret != "" {
+				nb.Annotations[culler.ACTIVITY_PROBE_TOKEN_SECRET_ANNOTATION] = test.secret
+			}
+
+			recorder := record.NewFakeRecorder(10)
+			r := &NotebookReconciler{
+				APIReader:     fake.NewClientBuilder().WithScheme(scheme).WithRuntimeObjects(secrets...).Build(),
+				Log:           logr.Discard(),
+				EventRecorder: recorder,
+			}
+			endpoint := r.probeEndpoint(context.TODO(), nb)
+
+			if endpoint.Scheme != "https" {
+				t.Errorf("Expected the endpoint of the annotations, got %+v", endpoint)
+			}
+			if endpoint.Token != test.token {
+				t.Errorf("Got token %q, expected %q", endpoint.Token, test.token)
+			}
+			if event := len(recorder.Events) > 0; event != test.event {
+				t.Errorf("Got event %t, expected %t", event, test.event)
+			}
+		})
+	}
+}
+
+// countingReader counts the Gets of the objects.
+type countingReader struct {
+	client.Reader
+	gets int
+}
+
+func (c *countingReader) Get(ctx context.Context, key client.ObjectKey, obj client.Object) error {
+	c.gets++
+	return c.Reader.Get(ctx, key, obj)
+}
+
+func TestProbeEndpointCachesToken(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = corev1.AddToScheme(scheme)
+	reader := &countingReader{Reader: fake.NewClientBuilder().WithScheme(scheme).WithRuntimeObjects(
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "jupyter-token", Namespace: "default"},
+			Data:       map[string][]byte{culler.PROBE_TOKEN_SECRET_KEY: []byte("secret")},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "rotated-token", Namespace: "default"},
+			Data:       map[string][]byte{culler.PROBE_TOKEN_SECRET_KEY: []byte("rotated")},
+		},
+	).Build()}
+	r := &NotebookReconciler{
+		APIReader:     reader,
+		Log:           logr.Discard(),
+		EventRecorder: record.NewFakeRecorder(10),
+	}
+	nb := &nbv1beta1.Notebook{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "test",
+			Namespace:   "default",
+			Annotations: map[string]string{culler.ACTIVITY_PROBE_TOKEN_SECRET_ANNOTATION: "jupyter-token"},
+		},
+	}
+
+	steps := []struct {
+		name   string
+		update func()
+		token  string
+		gets   int
+	}{
+		{
+			name:  "first probe reads the Secret",
+			token: "secret",
+			gets:  1,
+		},
+		{
+			name:  "next probes use the cached token",
+			token: "secret",
+			gets:  1,
+		},
+		{
+			name: "changed annotation reads the new Secret",
+			update: func() {
+				nb.Annotations[culler.ACTIVITY_PROBE_TOKEN_SECRET_ANNOTATION] = "rotated-token"
+			},
+			token: "rotated",
+			gets:  2,
+		},
+		{
+			name: "restarted Notebook reads the Secret again",
+			update: func() {
+				r.probeTokens.forget(types.NamespacedName{Name: "test", Namespace: "default"})
+			},
+			token: "rotated",
+			gets:  3,
+		},
+	}
+	for _, step := range steps {
+		if step.update != nil {
+			step.update()
+		}
+		endpoint := r.probeEndpoint(context.TODO(), nb)
+		if endpoint.Token != step.token {
+			t.Errorf("%s: got token %q, expected %q", step.name, endpoint.Token, step.token)
+		}
+		if reader.gets != step.gets {
+			t.Errorf("%s: got %d reads of the Secret, expected %d", step.name, reader.gets, step.gets)
+		}
+	}
+}
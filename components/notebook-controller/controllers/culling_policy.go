@@ -0,0 +1,181 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/kubeflow/kubeflow/components/notebook-controller/api/v1beta1"
+	"github.com/kubeflow/kubeflow/components/notebook-controller/pkg/culler"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// ConfigMaps with this label hold the default culling policy of the
+// Notebooks in their namespace, in the "enabled", "idleTime", "checkPeriod"
+// and "warningPeriod" keys.
+const CullingPolicyLabel = "notebooks.kubeflow.org/culling-policy"
+
+// NewCullingPolicyCache returns a cache of the ConfigMaps holding the culling
+// policies, added to the manager. It is separate from the cache of the
+// manager, so that the reads of the other ConfigMaps aren't filtered by the
+// label, and the other ConfigMaps aren't cached.
+func NewCullingPolicyCache(mgr ctrl.Manager) (cache.Cache, error) {
+	policies, err := cache.New(mgr.GetConfig(), cache.Options{
+		Scheme: mgr.GetScheme(),
+		Mapper: mgr.GetRESTMapper(),
+		SelectorsByObject: cache.SelectorsByObject{
+			&corev1.ConfigMap{}: {
+				Label: labels.SelectorFromSet(labels.Set{CullingPolicyLabel: "true"}),
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return policies, mgr.Add(policies)
+}
+
+// namespaceCullingPolicy reads the culling policy of a namespace from its
+// labelled ConfigMap. If there are more than one, the first by name is used.
+func namespaceCullingPolicy(ctx context.Context, c client.Reader, namespace string) (*v1beta1.CullingPolicy, error) {
+	cms := &corev1.ConfigMapList{}
+	err := c.List(ctx, cms, client.InNamespace(namespace),
+		client.MatchingLabels{CullingPolicyLabel: "true"})
+	if err != nil {
+		return nil, err
+	}
+	if len(cms.Items) == 0 {
+		return nil, nil
+	}
+
+	sort.Slice(cms.Items, func(i, j int) bool {
+		return cms.Items[i].Name < cms.Items[j].Name
+	})
+	return parseCullingPolicy(cms.Items[0].Data)
+}
+
+// parseCullingPolicy converts the data of a culling policy ConfigMap.
+func parseCullingPolicy(data map[string]string) (*v1beta1.CullingPolicy, error) {
+	policy := &v1beta1.CullingPolicy{}
+
+	if v, ok := data["enabled"]; ok {
+		enabled, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid enabled value %q: %v", v, err)
+		}
+		policy.Enabled = &enabled
+	}
+
+	durations := map[string]**metav1.Duration{
+		"idleTime":      &policy.IdleTime,
+		"checkPeriod":   &policy.CheckPeriod,
+		"warningPeriod": &policy.WarningPeriod,
+	}
+	for key, field := range durations {
+		v, ok := data[key]
+		if !ok {
+			continue
+		}
+		d, err := time.ParseDuration(v)
+		// Only the warning can be disabled with a zero duration
+		if err != nil || d < 0 || (d == 0 && key != "warningPeriod") {
+			return nil, fmt.Errorf("invalid %s value %q", key, v)
+		}
+		*field = &metav1.Duration{Duration: d}
+	}
+
+	return policy, nil
+}
+
+// mergeCullingPolicy overrides the fields of the policy that are set in the
+// given CullingPolicy.
+func mergeCullingPolicy(policy culler.Policy, override *v1beta1.CullingPolicy) culler.Policy {
+	if override == nil {
+		return policy
+	}
+	if override.Enabled != nil {
+		policy.Enabled = *override.Enabled
+	}
+	if override.IdleTime != nil && override.IdleTime.Duration > 0 {
+		policy.IdleTime = override.IdleTime.Duration
+	}
+	if override.CheckPeriod != nil && override.CheckPeriod.Duration > 0 {
+		policy.CheckPeriod = override.CheckPeriod.Duration
+	}
+	if override.WarningPeriod != nil && override.WarningPeriod.Duration >= 0 {
+		policy.WarningPeriod = override.WarningPeriod.Duration
+	}
+	return policy
+}
+
+// cullingPolicy resolves the culling policy of a Notebook. Its own policy
+// takes precedence over the namespace defaults, which take precedence over
+// the controller defaults.
+func (r *NotebookReconciler) cullingPolicy(ctx context.Context, nb *v1beta1.Notebook) culler.Policy {
+	log := r.Log.WithValues("notebook", nb.Namespace+"/"+nb.Name)
+
+	policy := culler.DefaultPolicy()
+
+	var reader client.Reader = r.Client
+	if r.CullingPolicies != nil {
+		reader = r.CullingPolicies
+	}
+	nsPolicy, err := namespaceCullingPolicy(ctx, reader, nb.Namespace)
+	if err != nil {
+		log.Error(err, "unable to read the namespace culling policy. Ignoring it")
+	}
+	policy = mergeCullingPolicy(policy, nsPolicy)
+
+	return mergeCullingPolicy(policy, nb.Spec.Culling)
+}
+
+// mapCullingPolicyToNotebooks reconciles the Notebooks of the namespace of a
+// culling policy ConfigMap, so that they apply its changes.
+func (r *NotebookReconciler) mapCullingPolicyToNotebooks(object client.Object) []reconcile.Request {
+	notebooks := &v1beta1.NotebookList{}
+	if err := r.List(context.TODO(), notebooks, client.InNamespace(object.GetNamespace())); err != nil {
+		r.Log.Error(err, "unable to list the Notebooks of the culling policy", "namespace", object.GetNamespace())
+		return nil
+	}
+	requests := []reconcile.Request{}
+	for _, nb := range notebooks.Items {
+		requests = append(requests, reconcile.Request{
+			NamespacedName: types.NamespacedName{Name: nb.Name, Namespace: nb.Namespace},
+		})
+	}
+	return requests
+}
+
+// cullingStatus reports a culling policy in the Notebook's status.
+func cullingStatus(policy culler.Policy) *v1beta1.CullingStatus {
+	return &v1beta1.CullingStatus{
+		Enabled:       policy.Enabled,
+		IdleTime:      metav1.Duration{Duration: policy.IdleTime},
+		CheckPeriod:   metav1.Duration{Duration: policy.CheckPeriod},
+		WarningPeriod: metav1.Duration{Duration: policy.WarningPeriod},
+	}
+}
@@ -0,0 +1,220 @@
+package controllers
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	nbv1beta1 "github.com/kubeflow/kubeflow/components/notebook-controller/api/v1beta1"
+	"github.com/kubeflow/kubeflow/components/notebook-controller/pkg/culler"
+)
+
+func TestParseCullingPolicy(t *testing.T) {
+	enabled := true
+
+	tests := []struct {
+		name     string
+		data     map[string]string
+		expected *nbv1beta1.CullingPolicy
+		err      bool
+	}{
+		{
+			name:     "empty",
+			data:     map[string]string{},
+			expected: &nbv1beta1.CullingPolicy{},
+		},
+		{
+			name: "all fields",
+			data: map[string]string{
+				"enabled":       "true",
+				"idleTime":      "2h",
+				"checkPeriod":   "5m",
+				"warningPeriod": "15m",
+			},
+			expected: &nbv1beta1.CullingPolicy{
+				Enabled:       &enabled,
+				IdleTime:      &metav1.Duration{Duration: 2 * time.Hour},
+				CheckPeriod:   &metav1.Duration{Duration: 5 * time.Minute},
+				WarningPeriod: &metav1.Duration{Duration: 15 * time.Minute},
+			},
+		},
+		{
+			name: "disabled warning",
+			data: map[string]string{"warningPeriod": "0s"},
+			expected: &nbv1beta1.CullingPolicy{
+				WarningPeriod: &metav1.Duration{},
+			},
+		},
+		{
+			name: "zero idle time",
+			data: map[string]string{"idleTime": "0s"},
+			err:  true,
+		},
+		{
+			name: "invalid enabled",
+			data: map[string]string{"enabled": "maybe"},
+			err:  true,
+		},
+		{
+			name: "invalid duration",
+			data: map[string]string{"idleTime": "1440"},
+			err:  true,
+		},
+		{
+			name: "negative duration",
+			data: map[string]string{"checkPeriod": "-1m"},
+			err:  true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			policy, err := parseCullingPolicy(test.data)
+			if (err != nil) != test.err {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(policy, test.expected) {
+				t.Errorf("Got %+v, Expected %+v", policy, test.expected)
+			}
+		})
+	}
+}
+
+func TestCullingPolicy(t *testing.T) {
+	disabled := false
+	enabled := true
+
+	os.Setenv("ENABLE_CULLING", "true")
+	os.Setenv("CULL_IDLE_TIME", "60")
+	os.Setenv("IDLENESS_CHECK_PERIOD", "1")
+	defer func() {
+		os.Unsetenv("ENABLE_CULLING")
+		os.Unsetenv("CULL_IDLE_TIME")
+		os.Unsetenv("IDLENESS_CHECK_PERIOD")
+	}()
+
+	nsPolicy := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "culling",
+			Namespace: "with-policy",
+			Labels:    map[string]string{CullingPolicyLabel: "true"},
+		},
+		Data: map[string]string{
+			"enabled":  "false",
+			"idleTime": "30m",
+		},
+	}
+	unlabelled := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "other",
+			Namespace: "without-policy",
+		},
+		Data: map[string]string{
+			"enabled": "false",
+		},
+	}
+
+	tests := []struct {
+		name     string
+		notebook *nbv1beta1.Notebook
+		expected culler.Policy
+	}{
+		{
+			name: "controller defaults",
+			notebook: &nbv1beta1.Notebook{
+				ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "without-policy"},
+			},
+			expected: culler.Policy{
+				Enabled:     true,
+				IdleTime:    time.Hour,
+				CheckPeriod: time.Minute,
+			},
+		},
+		{
+			name: "namespace defaults",
+			notebook: &nbv1beta1.Notebook{
+				ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "with-policy"},
+			},
+			expected: culler.Policy{
+				Enabled:     false,
+				IdleTime:    30 * time.Minute,
+				CheckPeriod: time.Minute,
+			},
+		},
+		{
+			name: "notebook policy",
+			notebook: &nbv1beta1.Notebook{
+				ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "with-policy"},
+				Spec: nbv1beta1.NotebookSpec{
+					Culling: &nbv1beta1.CullingPolicy{
+						Enabled:     &enabled,
+						CheckPeriod: &metav1.Duration{Duration: 10 * time.Minute},
+					},
+				},
+			},
+			expected: culler.Policy{
+				Enabled:     true,
+				IdleTime:    30 * time.Minute,
+				CheckPeriod: 10 * time.Minute,
+			},
+		},
+		{
+			name: "notebook disables culling",
+			notebook: &nbv1beta1.Notebook{
+				ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "without-policy"},
+				Spec: nbv1beta1.NotebookSpec{
+					Culling: &nbv1beta1.CullingPolicy{Enabled: &disabled},
+				},
+			},
+			expected: culler.Policy{
+				Enabled:     false,
+				IdleTime:    time.Hour,
+				CheckPeriod: time.Minute,
+			},
+		},
+	}
+
+	objects := []runtime.Object{nsPolicy, unlabelled}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := &NotebookReconciler{
+				Client: fake.NewFakeClientWithScheme(scheme.Scheme, objects...),
+				Log:    logr.Discard(),
+			}
+			policy := r.cullingPolicy(context.TODO(), test.notebook)
+			if !reflect.DeepEqual(policy, test.expected) {
+				t.Errorf("Got %+v, Expected %+v", policy, test.expected)
+			}
+		})
+	}
+}
+
+func TestMapCullingPolicyToNotebooks(t *testing.T) {
+	notebooks := []runtime.Object{
+		&nbv1beta1.Notebook{ObjectMeta: metav1.ObjectMeta{Name: "a", Namespace: "with-policy"}},
+		&nbv1beta1.Notebook{ObjectMeta: metav1.ObjectMeta{Name: "b", Namespace: "with-policy"}},
+		&nbv1beta1.Notebook{ObjectMeta: metav1.ObjectMeta{Name: "c", Namespace: "other"}},
+	}
+	r := &NotebookReconciler{
+		Client: fake.NewClientBuilder().WithScheme(limitsScheme()).WithRuntimeObjects(notebooks...).Build(),
+		Log:    logr.Discard(),
+	}
+
+	policy := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "culling", Namespace: "with-policy"}}
+	names := []string{}
+	for _, request := range r.mapCullingPolicyToNotebooks(policy) {
+		names = append(names, request.String())
+	}
+	if expected := []string{"with-policy/a", "with-policy/b"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("Got %v, expected the Notebooks of the namespace %v", names, expected)
+	}
+}
@@ -0,0 +1,271 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/kubeflow/kubeflow/components/notebook-controller/api/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+const (
+	// eventAggregationWindow is how long the repetitions of a mirrored
+	// event are aggregated before being mirrored again.
+	eventAggregationWindow = time.Minute
+	// eventRateLimit is how many events are mirrored on a Notebook per
+	// eventRateWindow.
+	eventRateLimit  = 10
+	eventRateWindow = time.Minute
+	// eventMirrorTTL is how long the mirrored events are remembered. It is
+	// the default TTL of the Events of the API server.
+	eventMirrorTTL = time.Hour
+)
+
+// NotebookEventReconciler re-emits the events of the Pods and StatefulSets of
+// the Notebooks on the Notebooks, so that the users see why their Notebook
+// doesn't start without reading the events of the objects it owns.
+//
+// The repetitions of an event, which the API server counts in the same Event,
+// are mirrored at most once per eventAggregationWindow, and no more than
+// eventRateLimit events are mirrored per Notebook per eventRateWindow.
+type NotebookEventReconciler struct {
+	client.Client
+	Log           logr.Logger
+	EventRecorder record.EventRecorder
+
+	mirror eventMirror
+}
+
+// +kubebuilder:rbac:groups=core,resources=events,verbs=get;list;watch;create;patch
+// +kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch
+
+func (r *NotebookEventReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := r.Log.WithValues("event", req.NamespacedName)
+
+	event := &corev1.Event{}
+	if err := r.Get(ctx, req.NamespacedName, event); err != nil {
+		return ctrl.Result{}, ignoreNotFound(err)
+	}
+
+	// Find the Notebook that corresponds to the event
+	nbName, err := nbNameFromInvolvedObject(r.Client, &event.InvolvedObject)
+	if err != nil {
+		// The event isn't related to a Notebook
+		return ctrl.Result{}, nil
+	}
+	notebook := &v1beta1.Notebook{}
+	key := types.NamespacedName{Name: nbName, Namespace: req.Namespace}
+	if err := r.Get(ctx, key, notebook); err != nil {
+		if apierrs.IsNotFound(err) {
+			r.mirror.forget(key)
+		}
+		return ctrl.Result{}, ignoreNotFound(err)
+	}
+
+	occurrences, retryAfter := r.mirror.record(key, event, time.Now())
+	if occurrences == 0 {
+		return ctrl.Result{RequeueAfter: retryAfter}, nil
+	}
+
+	message := fmt.Sprintf("Reissued from %s/%s: %s",
+		strings.ToLower(event.InvolvedObject.Kind), event.InvolvedObject.Name, event.Message)
+	if occurrences > 1 {
+		message = fmt.Sprintf("%s (%d times)", message, occurrences)
+	}
+	log.V(1).Info("Re-emitting the event on the Notebook", "notebook", key)
+	r.EventRecorder.Event(notebook, event.Type, event.Reason, message)
+	return ctrl.Result{}, nil
+}
+
+// eventCount returns how many times the event occurred.
+func eventCount(event *corev1.Event) int32 {
+	if event.Series != nil && event.Series.Count > 0 {
+		return event.Series.Count
+	}
+	if event.Count > 0 {
+		return event.Count
+	}
+	return 1
+}
+
+// mirroredEvent is an Event that was mirrored on a Notebook.
+type mirroredEvent struct {
+	count    int32
+	mirrored time.Time
+}
+
+// notebookEvents are the events mirrored on a Notebook.
+type notebookEvents struct {
+	events map[types.UID]mirroredEvent
+
+	windowStart time.Time
+	inWindow    int
+}
+
+// eventMirror remembers the events mirrored on each Notebook, to aggregate
+// their repetitions and rate-limit them. Its zero value is ready to use.
+type eventMirror struct {
+	mu        sync.Mutex
+	notebooks map[types.NamespacedName]*notebookEvents
+	pruned    time.Time
+}
+
+// record returns how many occurrences of the event should be mirrored on the
+// Notebook now, or 0 and when to check the event again if its occurrences are
+// aggregated or rate-limited. The returned occurrences are recorded as
+// mirrored.
+func (m *eventMirror) record(key types.NamespacedName, event *corev1.Event, now time.Time) (int32, time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.notebooks == nil {
+		m.notebooks = map[types.NamespacedName]*notebookEvents{}
+	}
+	if now.Sub(m.pruned) > eventMirrorTTL {
+		m.prune(now)
+	}
+	nb, ok := m.notebooks[key]
+	if !ok {
+		nb = &notebookEvents{events: map[types.UID]mirroredEvent{}}
+		m.notebooks[key] = nb
+	}
+
+	count := eventCount(event)
+	previous, ok := nb.events[event.UID]
+	if ok && count <= previous.count {
+		// Nothing happened since the event was mirrored
+		return 0, 0
+	}
+	if ok && now.Sub(previous.mirrored) < eventAggregationWindow {
+		return 0, previous.mirrored.Add(eventAggregationWindow).Sub(now)
+	}
+
+	if now.Sub(nb.windowStart) >= eventRateWindow {
+		nb.windowStart, nb.inWindow = now, 0
+	}
+	if nb.inWindow >= eventRateLimit {
+		return 0, nb.windowStart.Add(eventRateWindow).Sub(now)
+	}
+	nb.inWindow++
+
+	nb.events[event.UID] = mirroredEvent{count: count, mirrored: now}
+	return count - previous.count, 0
+}
+
+// prune forgets the events that were mirrored more than eventMirrorTTL ago,
+// which the API server deleted.
+func (m *eventMirror) prune(now time.Time) {
+	for key, nb := range m.notebooks {
+		for uid, event := range nb.events {
+			if now.Sub(event.mirrored) > eventMirrorTTL {
+				delete(nb.events, uid)
+			}
+		}
+		if len(nb.events) == 0 {
+			delete(m.notebooks, key)
+		}
+	}
+	m.pruned = now
+}
+
+func (m *eventMirror) forget(key types.NamespacedName) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.notebooks, key)
+}
+
+func isStsOrPodEvent(event *corev1.Event) bool {
+	return event.InvolvedObject.Kind == "Pod" || event.InvolvedObject.Kind == "StatefulSet"
+}
+
+// nbNameFromInvolvedObject returns the name of the Notebook of the Pod or
+// StatefulSet involved in an event. The Pods are read from the cache, and the
+// name of a Pod that is already gone is derived from the name of its
+// StatefulSet.
+func nbNameFromInvolvedObject(c client.Client, object *corev1.ObjectReference) (string, error) {
+	name, namespace := object.Name, object.Namespace
+
+	if object.Kind == "StatefulSet" {
+		return name, nil
+	}
+	if object.Kind == "Pod" {
+		pod := &corev1.Pod{}
+		err := c.Get(
+			context.TODO(),
+			types.NamespacedName{
+				Namespace: namespace,
+				Name:      name,
+			},
+			pod,
+		)
+		if apierrs.IsNotFound(err) {
+			// The Pods of a StatefulSet are named after it, followed by
+			// their ordinal
+			if i := strings.LastIndex(name, "-"); i > 0 {
+				if _, err := strconv.Atoi(name[i+1:]); err == nil {
+					return name[:i], nil
+				}
+			}
+		}
+		if err != nil {
+			return "", err
+		}
+		if nbName, ok := pod.Labels["notebook-name"]; ok {
+			return nbName, nil
+		}
+	}
+	return "", fmt.Errorf("object isn't related to a Notebook")
+}
+
+// predNBEvents filters the events not coming from Pods or StatefulSets. It
+// doesn't read any object, the events are mapped to their Notebook by the
+// reconciler.
+func predNBEvents() predicate.Funcs {
+	predicates := predicate.NewPredicateFuncs(func(object client.Object) bool {
+		event, ok := object.(*corev1.Event)
+		return ok && isStsOrPodEvent(event)
+	})
+
+	// Do not reconcile when an event gets deleted
+	predicates.DeleteFunc = func(e event.DeleteEvent) bool {
+		return false
+	}
+
+	return predicates
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *NotebookEventReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("notebook-event").
+		For(&corev1.Event{}, builder.WithPredicates(predNBEvents())).
+		Complete(r)
+}
@@ -0,0 +1,162 @@
+package controllers
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+
+	nbv1beta1 "github.com/kubeflow/kubeflow/components/notebook-controller/api/v1beta1"
+)
+
+func podEvent(uid, pod string, count int32) *corev1.Event {
+	return &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{Name: uid, Namespace: "default", UID: types.UID(uid)},
+		InvolvedObject: corev1.ObjectReference{
+			Kind:      "Pod",
+			Name:      pod,
+			Namespace: "default",
+		},
+		Type:    corev1.EventTypeWarning,
+		Reason:  "BackOff",
+		Message: "Back-off pulling image",
+		Count:   count,
+	}
+}
+
+func TestEventMirrorRecord(t *testing.T) {
+	key := types.NamespacedName{Name: "test", Namespace: "default"}
+	now := time.Now()
+	m := &eventMirror{}
+
+	if n, _ := m.record(key, podEvent("a", "test-0", 2), now); n != 2 {
+		t.Errorf("Expected the first occurrences to be mirrored, got %d", n)
+	}
+	if n, _ := m.record(key, podEvent("a", "test-0", 2), now.Add(2*eventAggregationWindow)); n != 0 {
+		t.Errorf("Expected an unchanged event not to be mirrored, got %d", n)
+	}
+
+	// The repetitions are aggregated until the end of the window
+	n, retryAfter := m.record(key, podEvent("a", "test-0", 3), now.Add(10*time.Second))
+	if n != 0 || retryAfter != eventAggregationWindow-10*time.Second {
+		t.Errorf("Expected the repetition to be aggregated, got %d occurrences, retry after %s", n, retryAfter)
+	}
+	if n, _ := m.record(key, podEvent("a", "test-0", 5), now.Add(eventAggregationWindow)); n != 3 {
+		t.Errorf("Expected the aggregated repetitions to be mirrored, got %d", n)
+	}
+}
+
+func TestEventMirrorRateLimit(t *testing.T) {
+	key := types.NamespacedName{Name: "test", Namespace: "default"}
+	other := types.NamespacedName{Name: "other", Namespace: "default"}
+	now := time.Now()
+	m := &eventMirror{}
+
+	for i := 0; i < eventRateLimit; i++ {
+		if n, _ := m.record(key, podEvent(string(rune('a'+i)), "test-0", 1), now); n != 1 {
+			t.Fatalf("Expected event %d to be mirrored", i)
+		}
+	}
+	n, retryAfter := m.record(key, podEvent("z", "test-0", 1), now.Add(time.Second))
+	if n != 0 || retryAfter != eventRateWindow-time.Second {
+		t.Errorf("Expected the event to be rate-limited, got %d occurrences, retry after %s", n, retryAfter)
+	}
+	if n, _ := m.record(other, podEvent("z", "other-0", 1), now.Add(time.Second)); n != 1 {
+		t.Errorf("Expected the limit to be per Notebook")
+	}
+	if n, _ := m.record(key, podEvent("z", "test-0", 1), now.Add(eventRateWindow)); n != 1 {
+		t.Errorf("Expected the event to be mirrored in the next window")
+	}
+
+	m.prune(now.Add(eventRateWindow + eventMirrorTTL + time.Second))
+	if len(m.notebooks) != 0 {
+		t.Errorf("Expected the old events to be pruned, got %d Notebooks", len(m.notebooks))
+	}
+}
+
+func TestNotebookEventReconciler(t *testing.T) {
+	nb := &nbv1beta1.Notebook{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"}}
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Name:      "test-0",
+		Namespace: "default",
+		Labels:    map[string]string{"notebook-name": "test"},
+	}}
+	unrelated := podEvent("unrelated", "other", 1)
+	deletedPod := podEvent("deleted", "test-1", 1)
+	repeated := podEvent("repeated", "test-0", 4)
+
+	recorder := record.NewFakeRecorder(10)
+	r := &NotebookEventReconciler{
+		Client: fake.NewClientBuilder().WithScheme(shutdownHookScheme()).
+			WithObjects(nb, pod, unrelated, deletedPod, repeated).Build(),
+		Log:           logr.Discard(),
+		EventRecorder: recorder,
+	}
+	reconcile := func(event *corev1.Event) {
+		key := types.NamespacedName{Name: event.Name, Namespace: event.Namespace}
+		if _, err := r.Reconcile(context.TODO(), ctrl.Request{NamespacedName: key}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reconcile(unrelated)
+	reconcile(deletedPod)
+	reconcile(repeated)
+	reconcile(repeated)
+
+	expected := []string{
+		"Warning BackOff Reissued from pod/test-1: Back-off pulling image",
+		"Warning BackOff Reissued from pod/test-0: Back-off pulling image (4 times)",
+	}
+	for _, e := range expected {
+		select {
+		case got := <-recorder.Events:
+			if got != e {
+				t.Errorf("Expected event %q, got %q", e, got)
+			}
+		default:
+			t.Errorf("Expected event %q", e)
+		}
+	}
+	if len(recorder.Events) != 0 {
+		t.Errorf("Expected the events to be mirrored once, got %q", <-recorder.Events)
+	}
+}
+
+func TestPredNBEvents(t *testing.T) {
+	pred := predNBEvents()
+	if !pred.Create(event.CreateEvent{Object: podEvent("a", "test-0", 1)}) {
+		t.Errorf("Expected the Pod events to be mirrored")
+	}
+
+	nodeEvent := podEvent("b", "node", 1)
+	nodeEvent.InvolvedObject.Kind = "Node"
+	if pred.Create(event.CreateEvent{Object: nodeEvent}) {
+		t.Errorf("Expected the Node events to be ignored")
+	}
+	if pred.Delete(event.DeleteEvent{Object: podEvent("a", "test-0", 1)}) {
+		t.Errorf("Expected the deleted events to be ignored")
+	}
+}
+
+func TestNbNameFromDeletedPod(t *testing.T) {
+	c := fake.NewClientBuilder().WithScheme(shutdownHookScheme()).Build()
+	name, err := nbNameFromInvolvedObject(c, &podEvent("a", "test-notebook-0", 1).InvolvedObject)
+	if err != nil || name != "test-notebook" {
+		t.Errorf("Expected the name of the StatefulSet, got %q, %v", name, err)
+	}
+
+	if _, err := nbNameFromInvolvedObject(c, &podEvent("a", "standalone", 1).InvolvedObject); err == nil ||
+		!strings.Contains(err.Error(), "not found") {
+		t.Errorf("Expected a NotFound error for a Pod not named after a StatefulSet, got %v", err)
+	}
+}
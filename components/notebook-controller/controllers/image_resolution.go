@@ -0,0 +1,183 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/kubeflow/kubeflow/components/notebook-controller/api/v1beta1"
+	"github.com/kubeflow/kubeflow/components/notebook-controller/pkg/config"
+	"github.com/kubeflow/kubeflow/components/notebook-controller/pkg/registry"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+// imageResolveTimeout bounds the registry calls of a resolution.
+const imageResolveTimeout = 30 * time.Second
+
+// imageResolveWorkers is the number of resolutions run at the same time.
+const imageResolveWorkers = 2
+
+// imageResolution is a resolution of the image of a Notebook, along with its
+// result once it is done.
+type imageResolution struct {
+	image string
+	creds registry.Credentials
+
+	done   bool
+	digest string
+	err    error
+	time   metav1.Time
+}
+
+// ImageResolutions resolves the images of the Notebooks with the Digest image
+// update policy in the background, so that the reconcile loop doesn't block
+// on the registries. It runs as a manager Runnable, and triggers the
+// reconciliation of a Notebook once its image is resolved, which records the
+// result in the status of the Notebook.
+type ImageResolutions struct {
+	// Resolver defaults to the registry API, restricted to the registries
+	// of the configuration.
+	Resolver ImageResolver
+
+	queue  workqueue.Interface
+	events chan event.GenericEvent
+
+	mu          sync.Mutex
+	resolutions map[types.NamespacedName]*imageResolution
+}
+
+// NewImageResolutions creates an ImageResolutions.
+func NewImageResolutions() *ImageResolutions {
+	return &ImageResolutions{
+		queue:       workqueue.NewNamed("images"),
+		events:      make(chan event.GenericEvent, 100),
+		resolutions: map[types.NamespacedName]*imageResolution{},
+	}
+}
+
+func (i *ImageResolutions) resolver() ImageResolver {
+	if i.Resolver != nil {
+		return i.Resolver
+	}
+	imageUpdate := config.Get().ImageUpdate
+	return &registry.Resolver{
+		Registries:  imageUpdate.Registries,
+		Insecure:    imageUpdate.InsecureRegistries,
+		TokenRealms: imageUpdate.TokenRealms,
+	}
+}
+
+// request starts resolving the image of the Notebook, unless it is already
+// being resolved.
+func (i *ImageResolutions) request(key types.NamespacedName, image string, creds registry.Credentials) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if resolution, ok := i.resolutions[key]; ok && resolution.image == image {
+		return
+	}
+	i.resolutions[key] = &imageResolution{image: image, creds: creds}
+	i.queue.Add(key)
+}
+
+// result returns the resolution of the image of the Notebook once it is
+// done. It is only returned once.
+func (i *ImageResolutions) result(key types.NamespacedName, image string) (imageResolution, bool) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	resolution, ok := i.resolutions[key]
+	if !ok || !resolution.done || resolution.image != image {
+		return imageResolution{}, false
+	}
+	delete(i.resolutions, key)
+	return *resolution, true
+}
+
+// forget drops the resolution of the Notebook.
+func (i *ImageResolutions) forget(key types.NamespacedName) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	delete(i.resolutions, key)
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable, since only the
+// leader reconciles the Notebooks.
+func (i *ImageResolutions) NeedLeaderElection() bool {
+	return true
+}
+
+// Start implements manager.Runnable. It runs the workers until the context
+// is done.
+func (i *ImageResolutions) Start(ctx context.Context) error {
+	var wg sync.WaitGroup
+	for n := 0; n < imageResolveWorkers; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i.processNextItem(ctx) {
+			}
+		}()
+	}
+
+	<-ctx.Done()
+	i.queue.ShutDown()
+	wg.Wait()
+	return nil
+}
+
+func (i *ImageResolutions) processNextItem(ctx context.Context) bool {
+	item, shutdown := i.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer i.queue.Done(item)
+
+	key := item.(types.NamespacedName)
+	i.mu.Lock()
+	resolution, ok := i.resolutions[key]
+	i.mu.Unlock()
+	if !ok || resolution.done {
+		// The Notebook was forgotten while waiting in the queue
+		return true
+	}
+
+	resolveCtx, cancel := context.WithTimeout(ctx, imageResolveTimeout)
+	digest, err := i.resolver().Resolve(resolveCtx, resolution.image, resolution.creds)
+	cancel()
+
+	i.mu.Lock()
+	if i.resolutions[key] != resolution {
+		// The image changed while it was resolved
+		i.mu.Unlock()
+		return true
+	}
+	resolution.done, resolution.digest, resolution.err, resolution.time = true, digest, err, metav1.Now()
+	i.mu.Unlock()
+
+	// Reconcile the Notebook to record the result
+	nb := &v1beta1.Notebook{ObjectMeta: metav1.ObjectMeta{Name: key.Name, Namespace: key.Namespace}}
+	select {
+	case i.events <- event.GenericEvent{Object: nb}:
+	case <-ctx.Done():
+	}
+	return true
+}
@@ -0,0 +1,158 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kubeflow/kubeflow/components/notebook-controller/api/v1beta1"
+	"github.com/kubeflow/kubeflow/components/notebook-controller/pkg/culler"
+	"github.com/kubeflow/kubeflow/components/notebook-controller/pkg/metrics"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// lifecycleActor is the actor of the transitions made by this controller,
+// e.g. when culling a Notebook.
+const lifecycleActor = "notebook-controller"
+
+// The ODH notebook controller stops the Notebooks it has not reconciled yet by
+// setting the STOP_ANNOTATION to this value.
+const (
+	reconciliationLockValue = "odh-notebook-controller-lock"
+	reconciliationLockActor = "odh-notebook-controller"
+)
+
+// observeLifecycle records the transitions of the Notebook that were not
+// recorded by the component that made them, e.g. a user setting or removing
+// the STOP_ANNOTATION.
+func observeLifecycle(nb *v1beta1.Notebook, status *v1beta1.NotebookStatus) {
+	status.Lifecycle = nb.Status.Lifecycle.DeepCopy()
+
+	value, stopped := nb.GetAnnotations()[culler.STOP_ANNOTATION]
+	if !stopped {
+		switch {
+		case status.Lifecycle == nil:
+			status.RecordLifecycleTransition(v1beta1.LifecycleActionStart,
+				v1beta1.LifecycleReasonCreated, "", nb.CreationTimestamp)
+		case status.Lifecycle.Reason == v1beta1.LifecycleReasonReconciliationLock:
+			status.RecordLifecycleTransition(v1beta1.LifecycleActionStart,
+				v1beta1.LifecycleReasonReconciliationLockReleased, reconciliationLockActor, metav1.Now())
+		default:
+			status.RecordLifecycleTransition(v1beta1.LifecycleActionStart,
+				v1beta1.LifecycleReasonManual, "", metav1.Now())
+		}
+		return
+	}
+
+	if value == reconciliationLockValue {
+		status.RecordLifecycleTransition(v1beta1.LifecycleActionStop,
+			v1beta1.LifecycleReasonReconciliationLock, reconciliationLockActor, nb.CreationTimestamp)
+		return
+	}
+
+	// The STOP_ANNOTATION holds the time at which the Notebook was stopped
+	stoppedAt := metav1.Now()
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		stoppedAt = metav1.NewTime(t)
+	}
+	status.RecordLifecycleTransition(v1beta1.LifecycleActionStop,
+		v1beta1.LifecycleReasonManual, stopAnnotationManager(nb.ObjectMeta), stoppedAt)
+}
+
+// stopAnnotationManager returns the field manager that owns the
+// STOP_ANNOTATION, e.g. the web app or kubectl, or an empty string if it is
+// not known.
+func stopAnnotationManager(meta metav1.ObjectMeta) string {
+	field := `"f:` + culler.STOP_ANNOTATION + `"`
+
+	var manager string
+	var latest time.Time
+	for _, entry := range meta.ManagedFields {
+		if entry.FieldsV1 == nil || !strings.Contains(string(entry.FieldsV1.Raw), field) {
+			continue
+		}
+		if manager == "" || (entry.Time != nil && entry.Time.After(latest)) {
+			manager = entry.Manager
+			if entry.Time != nil {
+				latest = entry.Time.Time
+			}
+		}
+	}
+	return manager
+}
+
+// observedStarts remembers the lifecycle transition of each Notebook whose
+// start was observed by the start metrics, so that each start is only
+// observed once, even if the Pod becomes ready again after a failed readiness
+// probe or is replaced, e.g. when it is evicted or restarted. Its zero value
+// is ready to use.
+type observedStarts struct {
+	mu          sync.Mutex
+	transitions map[types.NamespacedName]time.Time
+}
+
+// first returns true the first time it is called with a lifecycle transition
+// of a Notebook.
+func (s *observedStarts) first(key types.NamespacedName, transition metav1.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.transitions == nil {
+		s.transitions = map[types.NamespacedName]time.Time{}
+	}
+	if observed, ok := s.transitions[key]; ok && observed.Equal(transition.Time) {
+		return false
+	}
+	s.transitions[key] = transition.Time
+	return true
+}
+
+func (s *observedStarts) forget(key types.NamespacedName) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.transitions, key)
+}
+
+// observeStart observes the duration of the start of the Notebook in the
+// metrics when its Pod becomes ready for the first time since its last
+// lifecycle transition: from its creation if it was never stopped, or else
+// from its last start.
+func (r *NotebookReconciler) observeStart(nb *v1beta1.Notebook, status *v1beta1.NotebookStatus, pod *corev1.Pod) {
+	ready := status.GetCondition(v1beta1.NotebookConditionReady)
+	if r.Metrics == nil || pod == nil || ready == nil || ready.Status != v1beta1.ConditionTrue {
+		return
+	}
+	if old := nb.Status.GetCondition(v1beta1.NotebookConditionReady); old != nil && old.Status == v1beta1.ConditionTrue {
+		return
+	}
+	lifecycle := status.Lifecycle
+	if lifecycle == nil || lifecycle.Stopped {
+		return
+	}
+	if !r.observedStarts.first(types.NamespacedName{Name: nb.Name, Namespace: nb.Namespace}, lifecycle.LastTransitionTime) {
+		return
+	}
+
+	start, since := metrics.StartUnstop, lifecycle.LastTransitionTime.Time
+	if lifecycle.Reason == v1beta1.LifecycleReasonCreated {
+		start, since = metrics.StartCreate, nb.CreationTimestamp.Time
+	}
+	r.Metrics.NotebookStartDuration.WithLabelValues(start).Observe(ready.LastTransitionTime.Sub(since).Seconds())
+}
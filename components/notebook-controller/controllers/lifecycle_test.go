@@ -0,0 +1,214 @@
+package controllers
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	nbv1beta1 "github.com/kubeflow/kubeflow/components/notebook-controller/api/v1beta1"
+	"github.com/kubeflow/kubeflow/components/notebook-controller/pkg/culler"
+	"github.com/kubeflow/kubeflow/components/notebook-controller/pkg/metrics"
+)
+
+func TestRecordLifecycleTransition(t *testing.T) {
+	status := &nbv1beta1.NotebookStatus{}
+	now := metav1.Now()
+
+	if !status.RecordLifecycleTransition(nbv1beta1.LifecycleActionStart, nbv1beta1.LifecycleReasonCreated, "", now) {
+		t.Errorf("Expected the first transition to be recorded")
+	}
+	if status.RecordLifecycleTransition(nbv1beta1.LifecycleActionStart, nbv1beta1.LifecycleReasonManual, "", now) {
+		t.Errorf("Expected a start of a started Notebook to be ignored")
+	}
+
+	for i := 0; i < 2*nbv1beta1.MaxLifecycleHistory; i++ {
+		action := nbv1beta1.LifecycleActionStop
+		if i%2 == 1 {
+			action = nbv1beta1.LifecycleActionStart
+		}
+		status.RecordLifecycleTransition(action, nbv1beta1.LifecycleReasonManual, fmt.Sprint(i), now)
+	}
+
+	lifecycle := status.Lifecycle
+	if len(lifecycle.History) != nbv1beta1.MaxLifecycleHistory {
+		t.Errorf("Got %d transitions, expected %d", len(lifecycle.History), nbv1beta1.MaxLifecycleHistory)
+	}
+	last := fmt.Sprint(2*nbv1beta1.MaxLifecycleHistory - 1)
+	if lifecycle.Stopped || lifecycle.Actor != last || lifecycle.History[0].Actor != last {
+		t.Errorf("Expected the most recent transition first, got %+v", lifecycle)
+	}
+}
+
+func TestObserveLifecycle(t *testing.T) {
+	created := metav1.NewTime(time.Date(2022, 8, 30, 15, 0, 0, 0, time.UTC))
+	stopped := time.Date(2022, 8, 30, 16, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		managers    []metav1.ManagedFieldsEntry
+		lifecycle   *nbv1beta1.LifecycleStatus
+		expected    nbv1beta1.LifecycleTransition
+	}{
+		{
+			name: "new Notebook",
+			expected: nbv1beta1.LifecycleTransition{
+				Action: nbv1beta1.LifecycleActionStart,
+				Reason: nbv1beta1.LifecycleReasonCreated,
+				Time:   created,
+			},
+		},
+		{
+			name:        "new Notebook with the reconciliation lock",
+			annotations: map[string]string{culler.STOP_ANNOTATION: reconciliationLockValue},
+			expected: nbv1beta1.LifecycleTransition{
+				Action: nbv1beta1.LifecycleActionStop,
+				Reason: nbv1beta1.LifecycleReasonReconciliationLock,
+				Actor:  reconciliationLockActor,
+				Time:   created,
+			},
+		},
+		{
+			name: "reconciliation lock released",
+			lifecycle: &nbv1beta1.LifecycleStatus{
+				Stopped: true,
+				Reason:  nbv1beta1.LifecycleReasonReconciliationLock,
+			},
+			expected: nbv1beta1.LifecycleTransition{
+				Action: nbv1beta1.LifecycleActionStart,
+				Reason: nbv1beta1.LifecycleReasonReconciliationLockReleased,
+				Actor:  reconciliationLockActor,
+			},
+		},
+		{
+			name:        "stopped by a user",
+			annotations: map[string]string{culler.STOP_ANNOTATION: stopped.Format(time.RFC3339)},
+			managers: []metav1.ManagedFieldsEntry{
+				{
+					Manager:  "notebook-controller",
+					FieldsV1: &metav1.FieldsV1{Raw: []byte(`{"f:metadata":{"f:annotations":{"f:notebooks.kubeflow.org/last-activity":{}}}}`)},
+				},
+				{
+					Manager:  "kubectl-annotate",
+					FieldsV1: &metav1.FieldsV1{Raw: []byte(`{"f:metadata":{"f:annotations":{"f:kubeflow-resource-stopped":{}}}}`)},
+				},
+			},
+			lifecycle: &nbv1beta1.LifecycleStatus{Stopped: false},
+			expected: nbv1beta1.LifecycleTransition{
+				Action: nbv1beta1.LifecycleActionStop,
+				Reason: nbv1beta1.LifecycleReasonManual,
+				Actor:  "kubectl-annotate",
+				Time:   metav1.NewTime(stopped),
+			},
+		},
+		{
+			name: "started by a user",
+			lifecycle: &nbv1beta1.LifecycleStatus{
+				Stopped: true,
+				Reason:  nbv1beta1.LifecycleReasonCulled,
+			},
+			expected: nbv1beta1.LifecycleTransition{
+				Action: nbv1beta1.LifecycleActionStart,
+				Reason: nbv1beta1.LifecycleReasonManual,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			nb := &nbv1beta1.Notebook{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:              "test",
+					Namespace:         "default",
+					CreationTimestamp: created,
+					Annotations:       test.annotations,
+					ManagedFields:     test.managers,
+				},
+				Status: nbv1beta1.NotebookStatus{Lifecycle: test.lifecycle},
+			}
+
+			status := &nbv1beta1.NotebookStatus{}
+			observeLifecycle(nb, status)
+
+			if status.Lifecycle == nil || len(status.Lifecycle.History) == 0 {
+				t.Fatalf("Expected a transition, got %+v", status.Lifecycle)
+			}
+			got := status.Lifecycle.History[0]
+			if got.Action != test.expected.Action || got.Reason != test.expected.Reason ||
+				got.Actor != test.expected.Actor {
+				t.Errorf("Got %+v, expected %+v", got, test.expected)
+			}
+			if !test.expected.Time.IsZero() && !got.Time.Equal(&test.expected.Time) {
+				t.Errorf("Got time %v, expected %v", got.Time, test.expected.Time)
+			}
+			if nb.Status.Lifecycle != nil && nb.Status.Lifecycle == status.Lifecycle {
+				t.Errorf("Expected the lifecycle of the Notebook to be copied")
+			}
+		})
+	}
+}
+
+func TestObserveStart(t *testing.T) {
+	created := metav1.NewTime(time.Now().Add(-2 * time.Minute))
+	ready := metav1.Now()
+	readyStatus := func(lifecycle *nbv1beta1.LifecycleStatus) *nbv1beta1.NotebookStatus {
+		return &nbv1beta1.NotebookStatus{
+			Lifecycle: lifecycle,
+			Conditions: []nbv1beta1.NotebookCondition{{
+				Type:               nbv1beta1.NotebookConditionReady,
+				Status:             nbv1beta1.ConditionTrue,
+				LastTransitionTime: ready,
+			}},
+		}
+	}
+	nb := &nbv1beta1.Notebook{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default", CreationTimestamp: created}}
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{UID: "1"}}
+
+	r := &NotebookReconciler{Metrics: &metrics.Metrics{
+		NotebookStartDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "test"}, []string{"start"}),
+	}}
+	observed := func(start string) bool {
+		return r.Metrics.NotebookStartDuration.DeleteLabelValues(start)
+	}
+
+	createdLifecycle := &nbv1beta1.LifecycleStatus{Reason: nbv1beta1.LifecycleReasonCreated, LastTransitionTime: created}
+	r.observeStart(nb, readyStatus(createdLifecycle), pod)
+	if !observed(metrics.StartCreate) {
+		t.Errorf("Expected the first start to be observed")
+	}
+
+	// The same Pod becoming ready again isn't a start
+	r.observeStart(nb, readyStatus(createdLifecycle), pod)
+	if testutil.CollectAndCount(r.Metrics.NotebookStartDuration) != 0 {
+		t.Errorf("Expected the Pod to be observed once")
+	}
+
+	// Nor is the Pod replaced while the Notebook is running, e.g. when it is
+	// evicted
+	r.observeStart(nb, readyStatus(createdLifecycle), &corev1.Pod{ObjectMeta: metav1.ObjectMeta{UID: "replaced"}})
+	if testutil.CollectAndCount(r.Metrics.NotebookStartDuration) != 0 {
+		t.Errorf("Expected the replaced Pod not to be observed")
+	}
+
+	// A Notebook that was already ready isn't observed
+	nb.Status = *readyStatus(nil)
+	pod.UID = "2"
+	r.observeStart(nb, readyStatus(&nbv1beta1.LifecycleStatus{Reason: nbv1beta1.LifecycleReasonManual}), pod)
+	if testutil.CollectAndCount(r.Metrics.NotebookStartDuration) != 0 {
+		t.Errorf("Expected a ready Notebook not to be observed")
+	}
+
+	nb.Status = nbv1beta1.NotebookStatus{}
+	r.observeStart(nb, readyStatus(&nbv1beta1.LifecycleStatus{
+		Reason:             nbv1beta1.LifecycleReasonManual,
+		LastTransitionTime: metav1.NewTime(time.Now().Add(-time.Minute)),
+	}), pod)
+	if !observed(metrics.StartUnstop) {
+		t.Errorf("Expected the unstop to be observed")
+	}
+}
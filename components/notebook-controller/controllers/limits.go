@@ -0,0 +1,240 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/kubeflow/kubeflow/components/notebook-controller/api/v1beta1"
+	"github.com/kubeflow/kubeflow/components/notebook-controller/pkg/config"
+	"github.com/kubeflow/kubeflow/components/notebook-controller/pkg/culler"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// AnnotationCreator is the user who created the Notebook. The webhook sets it
+// to the user of the creation request, unless a trusted client set it.
+const AnnotationCreator = "notebooks.kubeflow.org/creator"
+
+// startAdmissionTTL is how long a Notebook that was allowed to start is
+// counted as running, until the cache has its scaled up StatefulSet.
+const startAdmissionTTL = time.Minute
+
+// startAdmissions remembers the Notebooks that were recently allowed to
+// start, so that the Notebooks started at the same time can't all take the
+// last slot. Its zero value is ready to use.
+type startAdmissions struct {
+	mu       sync.Mutex
+	admitted map[types.NamespacedName]time.Time
+}
+
+func (a *startAdmissions) admit(key types.NamespacedName) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.admitted == nil {
+		a.admitted = map[types.NamespacedName]time.Time{}
+	}
+	now := time.Now()
+	for k, t := range a.admitted {
+		if now.Sub(t) > startAdmissionTTL {
+			delete(a.admitted, k)
+		}
+	}
+	a.admitted[key] = now
+}
+
+func (a *startAdmissions) recent(key types.NamespacedName) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	t, ok := a.admitted[key]
+	return ok && time.Since(t) <= startAdmissionTTL
+}
+
+func notebookCreator(nb *v1beta1.Notebook) string {
+	return nb.GetAnnotations()[AnnotationCreator]
+}
+
+// runningLimitsSet returns true if a limit of running Notebooks applies.
+func runningLimitsSet(limits config.NotebookLimits) bool {
+	return limits.RunningNotebooks > 0 || limits.RunningNotebooksPerCreator > 0
+}
+
+// exceededLimit checks if one more Notebook like nb fits in the limits, given
+// the Notebooks of its namespace that count towards them. It returns the
+// reason why it doesn't, or an empty string.
+func exceededLimit(nb *v1beta1.Notebook, others []v1beta1.Notebook, limit, perCreator int32, what string) string {
+	creator := notebookCreator(nb)
+	count, creatorCount := int32(0), int32(0)
+	for i := range others {
+		if others[i].Name == nb.Name {
+			continue
+		}
+		count++
+		if creator != "" && notebookCreator(&others[i]) == creator {
+			creatorCount++
+		}
+	}
+
+	if limit > 0 && count >= limit {
+		return fmt.Sprintf("the namespace %s already has %d %s Notebooks, the limit is %d",
+			nb.Namespace, count, what, limit)
+	}
+	if perCreator > 0 && creator != "" && creatorCount >= perCreator {
+		return fmt.Sprintf("%s already has %d %s Notebooks in the namespace %s, the limit is %d",
+			creator, creatorCount, what, nb.Namespace, perCreator)
+	}
+	return ""
+}
+
+// exceededNotebooksLimit returns the reason why creating the Notebook would
+// exceed the limits of Notebooks of its namespace, or an empty string.
+func exceededNotebooksLimit(ctx context.Context, c client.Client, nb *v1beta1.Notebook) (string, error) {
+	limits := config.Get().Limits.ForNamespace(nb.Namespace)
+	if limits.Notebooks == 0 && limits.NotebooksPerCreator == 0 {
+		return "", nil
+	}
+
+	notebooks := &v1beta1.NotebookList{}
+	if err := c.List(ctx, notebooks, client.InNamespace(nb.Namespace)); err != nil {
+		return "", err
+	}
+	return exceededLimit(nb, notebooks.Items, limits.Notebooks, limits.NotebooksPerCreator, "existing"), nil
+}
+
+// runningNotebooks returns the Notebooks of the namespace that count towards
+// the limits of running Notebooks: the ones that aren't stopped and whose
+// StatefulSet is scaled up, or that were just allowed to start.
+func (r *NotebookReconciler) runningNotebooks(ctx context.Context, namespace string) ([]v1beta1.Notebook, error) {
+	notebooks := &v1beta1.NotebookList{}
+	if err := r.List(ctx, notebooks, client.InNamespace(namespace)); err != nil {
+		return nil, err
+	}
+	statefulSets := &appsv1.StatefulSetList{}
+	if err := r.List(ctx, statefulSets, client.InNamespace(namespace)); err != nil {
+		return nil, err
+	}
+	scaledUp := map[string]bool{}
+	for _, sts := range statefulSets.Items {
+		if sts.Spec.Replicas == nil || *sts.Spec.Replicas > 0 {
+			scaledUp[sts.Name] = true
+		}
+	}
+
+	running := []v1beta1.Notebook{}
+	for _, nb := range notebooks.Items {
+		if culler.StopAnnotationIsSet(nb.ObjectMeta) {
+			continue
+		}
+		key := types.NamespacedName{Name: nb.Name, Namespace: nb.Namespace}
+		if scaledUp[nb.Name] || r.startAdmissions.recent(key) {
+			running = append(running, nb)
+		}
+	}
+	return running, nil
+}
+
+// reconcileLimits keeps the Notebook stopped if starting it would exceed the
+// limits of running Notebooks of its namespace or of its creator. The
+// Notebooks that are already running are never stopped, e.g. when the limits
+// are lowered.
+func (r *NotebookReconciler) reconcileLimits(ctx context.Context, nb *v1beta1.Notebook) error {
+	limits := config.Get().Limits.ForNamespace(nb.Namespace)
+	if culler.StopAnnotationIsSet(nb.ObjectMeta) || !runningLimitsSet(limits) {
+		return nil
+	}
+
+	running, err := r.runningNotebooks(ctx, nb.Namespace)
+	if err != nil {
+		return err
+	}
+	for i := range running {
+		if running[i].Name == nb.Name {
+			return nil
+		}
+	}
+
+	key := types.NamespacedName{Name: nb.Name, Namespace: nb.Namespace}
+	reason := exceededLimit(nb, running, limits.RunningNotebooks, limits.RunningNotebooksPerCreator, "running")
+	if reason == "" {
+		r.startAdmissions.admit(key)
+		return nil
+	}
+
+	r.Log.Info("Keeping the Notebook stopped", "notebook", key, "reason", reason)
+	// Not a culling, so don't count it in the culling metrics
+	culler.SetStopAnnotation(&nb.ObjectMeta, nil)
+	if err := r.Update(ctx, nb); err != nil {
+		return err
+	}
+	// The status is updated later in the reconcile loop
+	now := metav1.Now()
+	nb.Status.RecordLifecycleTransition(v1beta1.LifecycleActionStop,
+		v1beta1.LifecycleReasonLimitExceeded, lifecycleActor, now)
+	nb.Status.SetCondition(v1beta1.NotebookCondition{
+		Type:               v1beta1.NotebookConditionWithinLimits,
+		Status:             v1beta1.ConditionFalse,
+		Reason:             v1beta1.NotebookReasonLimitExceeded,
+		Message:            "The Notebook was stopped: " + reason,
+		ObservedGeneration: nb.Generation,
+	}, now)
+	r.EventRecorder.Eventf(nb, corev1.EventTypeWarning, "LimitExceeded",
+		"Keeping the Notebook stopped: %s", reason)
+	return nil
+}
+
+// setLimitsCondition reports in the WithinLimits condition whether the
+// Notebook is kept stopped by the limits of running Notebooks. The condition
+// is removed if no such limits apply to the Notebook.
+func setLimitsCondition(nb *v1beta1.Notebook, status *v1beta1.NotebookStatus) {
+	held := culler.StopAnnotationIsSet(nb.ObjectMeta) && status.Lifecycle != nil &&
+		status.Lifecycle.Stopped && status.Lifecycle.Reason == v1beta1.LifecycleReasonLimitExceeded
+	if c := status.GetCondition(v1beta1.NotebookConditionWithinLimits); held && c != nil && c.Status == v1beta1.ConditionFalse {
+		// Keep the message set when the Notebook was stopped
+		return
+	}
+
+	if !held && !runningLimitsSet(config.Get().Limits.ForNamespace(nb.Namespace)) {
+		conditions := status.Conditions[:0]
+		for _, c := range status.Conditions {
+			if c.Type != v1beta1.NotebookConditionWithinLimits {
+				conditions = append(conditions, c)
+			}
+		}
+		status.Conditions = conditions
+		return
+	}
+
+	condition := v1beta1.NotebookCondition{
+		Type:               v1beta1.NotebookConditionWithinLimits,
+		Status:             v1beta1.ConditionTrue,
+		Reason:             v1beta1.NotebookReasonWithinLimits,
+		ObservedGeneration: nb.Generation,
+	}
+	if held {
+		condition.Status = v1beta1.ConditionFalse
+		condition.Reason = v1beta1.NotebookReasonLimitExceeded
+		condition.Message = "The Notebook was stopped by the limits of running Notebooks"
+	}
+	status.SetCondition(condition, metav1.Now())
+}
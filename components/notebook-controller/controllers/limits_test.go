@@ -0,0 +1,245 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	nbv1beta1 "github.com/kubeflow/kubeflow/components/notebook-controller/api/v1beta1"
+	"github.com/kubeflow/kubeflow/components/notebook-controller/pkg/config"
+	"github.com/kubeflow/kubeflow/components/notebook-controller/pkg/culler"
+)
+
+func limitsScheme() *runtime.Scheme {
+	scheme := runtime.NewScheme()
+	_ = corev1.AddToScheme(scheme)
+	_ = appsv1.AddToScheme(scheme)
+	_ = nbv1beta1.AddToScheme(scheme)
+	return scheme
+}
+
+func setLimits(limits config.LimitsConfig) {
+	c := config.FromEnv()
+	c.Limits = limits
+	config.Set(c)
+}
+
+// limitsNotebook returns a Notebook of the creator, with a StatefulSet scaled
+// to the given replicas.
+func limitsNotebook(name, creator string, replicas int32) (*nbv1beta1.Notebook, *appsv1.StatefulSet) {
+	nb := &nbv1beta1.Notebook{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   "default",
+			Annotations: map[string]string{AnnotationCreator: creator},
+		},
+	}
+	sts := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec:       appsv1.StatefulSetSpec{Replicas: &replicas},
+	}
+	return nb, sts
+}
+
+func TestReconcileLimits(t *testing.T) {
+	defer config.Set(nil)
+
+	running, runningSts := limitsNotebook("running", "alice", 1)
+	stopped, stoppedSts := limitsNotebook("stopped", "alice", 0)
+	stopped.Annotations[culler.STOP_ANNOTATION] = "2022-01-01T00:00:00Z"
+	starting, startingSts := limitsNotebook("starting", "bob", 0)
+	startingOfAlice, startingOfAliceSts := limitsNotebook("starting-of-alice", "alice", 0)
+	objects := []client.Object{running, runningSts, stopped, stoppedSts, starting, startingSts,
+		startingOfAlice, startingOfAliceSts}
+
+	tests := []struct {
+		name     string
+		limits   config.NotebookLimits
+		notebook *nbv1beta1.Notebook
+		held     bool
+	}{
+		{
+			name:     "no limits",
+			notebook: starting,
+		},
+		{
+			name:     "within the namespace limit",
+			limits:   config.NotebookLimits{RunningNotebooks: 2},
+			notebook: starting,
+		},
+		{
+			name:     "namespace limit reached",
+			limits:   config.NotebookLimits{RunningNotebooks: 1},
+			notebook: starting,
+			held:     true,
+		},
+		{
+			name:     "within the creator limit",
+			limits:   config.NotebookLimits{RunningNotebooksPerCreator: 1},
+			notebook: starting,
+		},
+		{
+			name:     "creator limit reached",
+			limits:   config.NotebookLimits{RunningNotebooksPerCreator: 1},
+			notebook: startingOfAlice,
+			held:     true,
+		},
+		{
+			name:     "stopped Notebooks are ignored",
+			limits:   config.NotebookLimits{RunningNotebooks: 1},
+			notebook: stopped,
+			held:     true,
+		},
+		{
+			name:     "running Notebooks are never stopped",
+			limits:   config.NotebookLimits{RunningNotebooks: 1},
+			notebook: running,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setLimits(config.LimitsConfig{NotebookLimits: test.limits})
+			nb := test.notebook.DeepCopy()
+			recorder := record.NewFakeRecorder(10)
+			r := &NotebookReconciler{
+				Client:        fake.NewClientBuilder().WithScheme(limitsScheme()).WithObjects(objects...).Build(),
+				Log:           logr.Discard(),
+				EventRecorder: recorder,
+			}
+
+			if err := r.reconcileLimits(context.TODO(), nb); err != nil {
+				t.Fatal(err)
+			}
+			if held := culler.StopAnnotationIsSet(nb.ObjectMeta); held != test.held {
+				t.Fatalf("Expected the Notebook to be held %t, got %t", test.held, held)
+			}
+			if !test.held || test.notebook == stopped {
+				return
+			}
+
+			condition := nb.Status.GetCondition(nbv1beta1.NotebookConditionWithinLimits)
+			if condition == nil || condition.Status != nbv1beta1.ConditionFalse ||
+				condition.Reason != nbv1beta1.NotebookReasonLimitExceeded {
+				t.Errorf("Expected the WithinLimits condition to be False, got %+v", condition)
+			}
+			if lifecycle := nb.Status.Lifecycle; lifecycle == nil || !lifecycle.Stopped ||
+				lifecycle.Reason != nbv1beta1.LifecycleReasonLimitExceeded {
+				t.Errorf("Expected the stop to be recorded, got %+v", lifecycle)
+			}
+			if len(recorder.Events) != 1 {
+				t.Errorf("Expected a LimitExceeded event")
+			}
+		})
+	}
+}
+
+func TestReconcileLimitsCountsAdmittedNotebooks(t *testing.T) {
+	defer config.Set(nil)
+	setLimits(config.LimitsConfig{NotebookLimits: config.NotebookLimits{RunningNotebooks: 1}})
+
+	first, firstSts := limitsNotebook("first", "alice", 0)
+	second, secondSts := limitsNotebook("second", "bob", 0)
+	r := &NotebookReconciler{
+		Client:        fake.NewClientBuilder().WithScheme(limitsScheme()).WithObjects(first, firstSts, second, secondSts).Build(),
+		Log:           logr.Discard(),
+		EventRecorder: record.NewFakeRecorder(10),
+	}
+
+	// Neither StatefulSet is scaled up yet, but the first Notebook took the
+	// only slot
+	if err := r.reconcileLimits(context.TODO(), first); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.reconcileLimits(context.TODO(), second); err != nil {
+		t.Fatal(err)
+	}
+	if culler.StopAnnotationIsSet(first.ObjectMeta) || !culler.StopAnnotationIsSet(second.ObjectMeta) {
+		t.Errorf("Expected only the second Notebook to be held")
+	}
+	if !r.startAdmissions.recent(types.NamespacedName{Name: "first", Namespace: "default"}) {
+		t.Errorf("Expected the first Notebook to be admitted")
+	}
+}
+
+func TestSetLimitsCondition(t *testing.T) {
+	defer config.Set(nil)
+	nb, _ := limitsNotebook("test", "alice", 1)
+
+	setLimits(config.LimitsConfig{})
+	status := &nbv1beta1.NotebookStatus{Conditions: []nbv1beta1.NotebookCondition{
+		{Type: nbv1beta1.NotebookConditionWithinLimits, Status: nbv1beta1.ConditionTrue},
+	}}
+	setLimitsCondition(nb, status)
+	if len(status.Conditions) != 0 {
+		t.Errorf("Expected the condition to be removed without limits, got %+v", status.Conditions)
+	}
+
+	setLimits(config.LimitsConfig{Namespaces: map[string]config.NotebookLimits{"default": {RunningNotebooks: 1}}})
+	setLimitsCondition(nb, status)
+	if c := status.GetCondition(nbv1beta1.NotebookConditionWithinLimits); c == nil || c.Status != nbv1beta1.ConditionTrue {
+		t.Errorf("Expected the condition to be True, got %+v", c)
+	}
+
+	// A Notebook held by the limits stays held when the limits are removed,
+	// until it is started again
+	setLimits(config.LimitsConfig{})
+	nb.Annotations[culler.STOP_ANNOTATION] = "2022-01-01T00:00:00Z"
+	status.RecordLifecycleTransition(nbv1beta1.LifecycleActionStop, nbv1beta1.LifecycleReasonLimitExceeded, lifecycleActor, metav1.Now())
+	setLimitsCondition(nb, status)
+	if c := status.GetCondition(nbv1beta1.NotebookConditionWithinLimits); c == nil || c.Status != nbv1beta1.ConditionFalse {
+		t.Errorf("Expected the condition to be False, got %+v", c)
+	}
+}
+
+func TestNotebookWebhookLimits(t *testing.T) {
+	defer config.Set(nil)
+	setLimits(config.LimitsConfig{NotebookLimits: config.NotebookLimits{Notebooks: 2, NotebooksPerCreator: 1}})
+
+	existing, _ := limitsNotebook("existing", "alice", 1)
+	w := &NotebookWebhook{Client: fake.NewClientBuilder().WithScheme(limitsScheme()).WithObjects(existing).Build()}
+
+	nb := webhookNotebook("test", corev1.Container{Name: "test"})
+	nb.Annotations = map[string]string{AnnotationCreator: "bob"}
+	if err := w.ValidateCreate(context.TODO(), nb); err != nil {
+		t.Errorf("Expected the Notebook to fit in the limits, got %v", err)
+	}
+
+	nb.Annotations[AnnotationCreator] = "alice"
+	if err := w.ValidateCreate(context.TODO(), nb); !apierrs.IsForbidden(err) {
+		t.Errorf("Expected the creator limit to be enforced, got %v", err)
+	}
+
+	setLimits(config.LimitsConfig{NotebookLimits: config.NotebookLimits{Notebooks: 1}})
+	nb.Annotations[AnnotationCreator] = "bob"
+	if err := w.ValidateCreate(context.TODO(), nb); !apierrs.IsForbidden(err) {
+		t.Errorf("Expected the namespace limit to be enforced, got %v", err)
+	}
+}
+
+func TestNotebookWebhookCreatorIsImmutable(t *testing.T) {
+	w := &NotebookWebhook{}
+	old := webhookNotebook("test", corev1.Container{Name: "test"})
+
+	updated := old.DeepCopy()
+	updated.Annotations = map[string]string{AnnotationCreator: "alice"}
+	if err := w.ValidateUpdate(context.TODO(), old, updated); err != nil {
+		t.Errorf("Expected the creator of an older Notebook to be settable, got %v", err)
+	}
+
+	changed := updated.DeepCopy()
+	changed.Annotations[AnnotationCreator] = "bob"
+	if err := w.ValidateUpdate(context.TODO(), updated, changed); !apierrs.IsInvalid(err) {
+		t.Errorf("Expected the creator change to be rejected, got %v", err)
+	}
+}
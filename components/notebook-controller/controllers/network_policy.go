@@ -0,0 +1,155 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"reflect"
+
+	"github.com/kubeflow/kubeflow/components/notebook-controller/api/v1beta1"
+	"github.com/kubeflow/kubeflow/components/notebook-controller/pkg/config"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// AnnotationNetworkPolicyPeers holds a JSON list of NetworkPolicyPeers that
+// are allowed to reach the Notebook, in addition to the default ones.
+const AnnotationNetworkPolicyPeers = "notebooks.kubeflow.org/network-policy-peers"
+
+// namespaceNameLabel is set on every namespace by the API server.
+const namespaceNameLabel = "kubernetes.io/metadata.name"
+
+// controllerPodLabels select the Pods of the controller, whose culler
+// probes the Notebooks.
+var controllerPodLabels = map[string]string{"app": "notebook-controller"}
+
+// networkPolicyEnabled returns true if the controller owns a NetworkPolicy
+// for each Notebook.
+func networkPolicyEnabled() bool {
+	return config.Get().NetworkPolicy.Enabled
+}
+
+// networkPolicyName returns the name of the NetworkPolicy of the Notebook.
+func networkPolicyName(instance *v1beta1.Notebook) string {
+	return instance.Name + "-network-policy"
+}
+
+// namespacePeer selects all the Pods of a namespace.
+func namespacePeer(namespace string) networkingv1.NetworkPolicyPeer {
+	return networkingv1.NetworkPolicyPeer{
+		NamespaceSelector: &metav1.LabelSelector{
+			MatchLabels: map[string]string{namespaceNameLabel: namespace},
+		},
+	}
+}
+
+// annotationPeers returns the NetworkPolicyPeers of the
+// AnnotationNetworkPolicyPeers annotation.
+func annotationPeers(instance *v1beta1.Notebook) ([]networkingv1.NetworkPolicyPeer, error) {
+	value := instance.GetAnnotations()[AnnotationNetworkPolicyPeers]
+	if len(value) == 0 {
+		return nil, nil
+	}
+	peers := []networkingv1.NetworkPolicyPeer{}
+	if err := json.Unmarshal([]byte(value), &peers); err != nil {
+		return nil, err
+	}
+	return peers, nil
+}
+
+// generateNetworkPolicy returns a NetworkPolicy that only lets the gateway,
+// the controller, the Pods of the Notebook's namespace and the peers of its
+// annotation reach the Notebook's Pod.
+func generateNetworkPolicy(instance *v1beta1.Notebook, peers []networkingv1.NetworkPolicyPeer) *networkingv1.NetworkPolicy {
+	from := []networkingv1.NetworkPolicyPeer{
+		namespacePeer(config.Get().NetworkPolicy.GatewayNamespace),
+		// Pods of the Notebook's namespace
+		{PodSelector: &metav1.LabelSelector{}},
+	}
+	if controllerNamespace := os.Getenv("POD_NAMESPACE"); len(controllerNamespace) > 0 {
+		controller := namespacePeer(controllerNamespace)
+		controller.PodSelector = &metav1.LabelSelector{MatchLabels: controllerPodLabels}
+		from = append(from, controller)
+	}
+	from = append(from, peers...)
+
+	return &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      networkPolicyName(instance),
+			Namespace: instance.Namespace,
+		},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{
+				MatchLabels: map[string]string{
+					"statefulset": instance.Name,
+				},
+			},
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+			Ingress: []networkingv1.NetworkPolicyIngressRule{{
+				From: from,
+			}},
+		},
+	}
+}
+
+// reconcileNetworkPolicy creates or updates the NetworkPolicy of the
+// Notebook. Invalid peers in its annotation are reported in an event and
+// ignored.
+func (r *NotebookReconciler) reconcileNetworkPolicy(ctx context.Context, instance *v1beta1.Notebook) error {
+	log := r.Log.WithValues("notebook", types.NamespacedName{Name: instance.Name, Namespace: instance.Namespace})
+
+	peers, err := annotationPeers(instance)
+	if err != nil {
+		log.Error(err, "invalid NetworkPolicy peers annotation")
+		r.EventRecorder.Eventf(instance, corev1.EventTypeWarning, "InvalidNetworkPolicyPeers",
+			"Ignoring the %s annotation: %v", AnnotationNetworkPolicyPeers, err)
+	}
+
+	policy := generateNetworkPolicy(instance, peers)
+	if err := ctrl.SetControllerReference(instance, policy, r.Scheme); err != nil {
+		return err
+	}
+	// Check if the NetworkPolicy already exists
+	found := &networkingv1.NetworkPolicy{}
+	err = r.Get(ctx, types.NamespacedName{Name: policy.Name, Namespace: policy.Namespace}, found)
+	if err != nil && apierrs.IsNotFound(err) {
+		log.Info("Creating NetworkPolicy", "namespace", policy.Namespace, "name", policy.Name)
+		if err := r.Create(ctx, policy); err != nil {
+			log.Error(err, "unable to create NetworkPolicy")
+			return err
+		}
+		return nil
+	} else if err != nil {
+		log.Error(err, "error getting NetworkPolicy")
+		return err
+	}
+
+	if !reflect.DeepEqual(policy.Spec, found.Spec) {
+		log.Info("Updating NetworkPolicy", "namespace", policy.Namespace, "name", policy.Name)
+		found.Spec = policy.Spec
+		if err := r.Update(ctx, found); err != nil {
+			log.Error(err, "unable to update NetworkPolicy")
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/go-logr/logr"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	nbv1beta1 "github.com/kubeflow/kubeflow/components/notebook-controller/api/v1beta1"
+)
+
+func TestGenerateNetworkPolicy(t *testing.T) {
+	t.Setenv("NETWORK_POLICY_GATEWAY_NAMESPACE", "ingress-nginx")
+	t.Setenv("POD_NAMESPACE", "kubeflow")
+
+	nb := &nbv1beta1.Notebook{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"}}
+	monitoring := networkingv1.NetworkPolicyPeer{
+		NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "monitoring"}},
+	}
+	policy := generateNetworkPolicy(nb, []networkingv1.NetworkPolicyPeer{monitoring})
+
+	if policy.Name != "test-network-policy" || policy.Spec.PodSelector.MatchLabels["statefulset"] != "test" {
+		t.Errorf("Expected the NetworkPolicy of the Notebook's Pod, got %+v", policy)
+	}
+	expected := []networkingv1.NetworkPolicyPeer{
+		{NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{namespaceNameLabel: "ingress-nginx"}}},
+		{PodSelector: &metav1.LabelSelector{}},
+		{
+			NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{namespaceNameLabel: "kubeflow"}},
+			PodSelector:       &metav1.LabelSelector{MatchLabels: controllerPodLabels},
+		},
+		monitoring,
+	}
+	if len(policy.Spec.Ingress) != 1 || !reflect.DeepEqual(policy.Spec.Ingress[0].From, expected) {
+		t.Errorf("Got ingress rules %+v, expected peers %+v", policy.Spec.Ingress, expected)
+	}
+}
+
+func TestReconcileNetworkPolicy(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = networkingv1.AddToScheme(scheme)
+	_ = nbv1beta1.AddToScheme(scheme)
+	key := types.NamespacedName{Name: "test-network-policy", Namespace: "default"}
+
+	recorder := record.NewFakeRecorder(10)
+	r := &NotebookReconciler{
+		Client:        fake.NewClientBuilder().WithScheme(scheme).Build(),
+		Scheme:        scheme,
+		Log:           logr.Discard(),
+		EventRecorder: recorder,
+	}
+	nb := &nbv1beta1.Notebook{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default", UID: "uid"}}
+
+	if err := r.reconcileNetworkPolicy(context.TODO(), nb); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	policy := &networkingv1.NetworkPolicy{}
+	if err := r.Get(context.TODO(), key, policy); err != nil {
+		t.Fatalf("Expected the NetworkPolicy to be created: %v", err)
+	}
+	if !metav1.IsControlledBy(policy, nb) || len(policy.Spec.Ingress[0].From) != 2 {
+		t.Errorf("Got NetworkPolicy %+v", policy)
+	}
+
+	nb.Annotations = map[string]string{
+		AnnotationNetworkPolicyPeers: `[{"namespaceSelector": {"matchLabels": {"team": "monitoring"}}}]`,
+	}
+	if err := r.reconcileNetworkPolicy(context.TODO(), nb); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := r.Get(context.TODO(), key, policy); err != nil {
+		t.Fatal(err)
+	}
+	if len(policy.Spec.Ingress[0].From) != 3 {
+		t.Errorf("Expected the peer of the annotation to be allowed, got %+v", policy.Spec.Ingress[0].From)
+	}
+
+	nb.Annotations[AnnotationNetworkPolicyPeers] = `{"namespaceSelector"`
+	if err := r.reconcileNetworkPolicy(context.TODO(), nb); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := r.Get(context.TODO(), key, policy); err != nil {
+		t.Fatal(err)
+	}
+	if len(policy.Spec.Ingress[0].From) != 2 || len(recorder.Events) != 1 {
+		t.Errorf("Expected the invalid annotation to be reported and ignored, got %+v", policy.Spec.Ingress[0].From)
+	}
+}
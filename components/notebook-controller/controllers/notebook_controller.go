@@ -17,20 +17,19 @@ package controllers
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
-	"os"
 	"reflect"
-	"strings"
 	"time"
 
 	"github.com/go-logr/logr"
 	reconcilehelper "github.com/kubeflow/kubeflow/components/common/reconcilehelper"
 	"github.com/kubeflow/kubeflow/components/notebook-controller/api/v1beta1"
+	"github.com/kubeflow/kubeflow/components/notebook-controller/pkg/config"
 	"github.com/kubeflow/kubeflow/components/notebook-controller/pkg/culler"
 	"github.com/kubeflow/kubeflow/components/notebook-controller/pkg/metrics"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
 	apierrs "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
@@ -40,8 +39,8 @@ import (
 	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/client"
-	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
@@ -50,6 +49,7 @@ import (
 
 const DefaultContainerPort = 8888
 const DefaultServingPort = 80
+const DefaultWorkingDir = "/home/jovyan"
 const AnnotationRewriteURI = "notebooks.kubeflow.org/http-rewrite-uri"
 const AnnotationHeadersRequestSet = "notebooks.kubeflow.org/http-headers-request-set"
 
@@ -78,56 +78,74 @@ type NotebookReconciler struct {
 	Scheme        *runtime.Scheme
 	Metrics       *metrics.Metrics
 	EventRecorder record.EventRecorder
+	Culler        *culler.Culler
+	// APIReader reads the objects that are not cached, e.g. the Secrets
+	// holding the tokens of the activity probes.
+	APIReader client.Reader
+	// CullingPolicies caches the ConfigMaps holding the culling policies of
+	// the namespaces, see NewCullingPolicyCache. They are read with the
+	// Client if it is nil.
+	CullingPolicies cache.Cache
+	// Images resolves the images of the Notebooks with the Digest image
+	// update policy in the background. They aren't resolved if it is nil.
+	Images *ImageResolutions
+
+	shutdownHooks   shutdownHooks
+	startAdmissions startAdmissions
+	observedStarts  observedStarts
+	probeTokens     probeTokens
+
+	// The routing backend and whether the Notebooks have a NetworkPolicy
+	// are read from the configuration once, when the controller is set up,
+	// since they select the kinds of objects it owns. Changing them requires
+	// a restart.
+	routingBackend  RoutingBackend
+	networkPolicies bool
 }
 
 // +kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch
+// +kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;list;watch;create;update;delete
+// +kubebuilder:rbac:groups=core,resources=secrets,verbs=get
 // +kubebuilder:rbac:groups=core,resources=events,verbs=get;list;watch;create;patch
 // +kubebuilder:rbac:groups=core,resources=services,verbs="*"
+// +kubebuilder:rbac:groups=core,resources=persistentvolumeclaims,verbs=get;list;watch;create;update;patch
 // +kubebuilder:rbac:groups=apps,resources=statefulsets,verbs="*"
 // +kubebuilder:rbac:groups=kubeflow.org,resources=notebooks;notebooks/status;notebooks/finalizers,verbs="*"
+// +kubebuilder:rbac:groups=metrics.k8s.io,resources=pods,verbs=get
 // +kubebuilder:rbac:groups="networking.istio.io",resources=virtualservices,verbs="*"
+// +kubebuilder:rbac:groups=networking.k8s.io,resources=ingresses;networkpolicies,verbs="*"
+// +kubebuilder:rbac:groups=gateway.networking.k8s.io,resources=httproutes,verbs="*"
+// +kubebuilder:rbac:groups=snapshot.storage.k8s.io,resources=volumesnapshots,verbs=get;list;watch;create;delete
 
 func (r *NotebookReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	log := r.Log.WithValues("notebook", req.NamespacedName)
 
-	// TODO(yanniszark): Can we avoid reconciling Events and Notebook in the same queue?
-	event := &corev1.Event{}
-	var getEventErr error
-	getEventErr = r.Get(ctx, req.NamespacedName, event)
-	if getEventErr == nil {
-		log.Info("Found event for Notebook. Re-emitting...")
-
-		// Find the Notebook that corresponds to the triggered event
-		involvedNotebook := &v1beta1.Notebook{}
-		nbName, err := nbNameFromInvolvedObject(r.Client, &event.InvolvedObject)
-		if err != nil {
-			return ctrl.Result{}, err
-		}
-
-		involvedNotebookKey := types.NamespacedName{Name: nbName, Namespace: req.Namespace}
-		if err := r.Get(ctx, involvedNotebookKey, involvedNotebook); err != nil {
-			log.Error(err, "unable to fetch Notebook by looking at event")
-			return ctrl.Result{}, ignoreNotFound(err)
-		}
-
-		// re-emit the event in the Notebook CR
-		log.Info("Emitting Notebook Event.", "Event", event)
-		r.EventRecorder.Eventf(involvedNotebook, event.Type, event.Reason,
-			"Reissued from %s/%s: %s", strings.ToLower(event.InvolvedObject.Kind), event.InvolvedObject.Name, event.Message)
-		return ctrl.Result{}, nil
-	}
-
-	if getEventErr != nil && !apierrs.IsNotFound(getEventErr) {
-		return ctrl.Result{}, getEventErr
-	}
-	// If not found, continue. Is not an event.
-
 	instance := &v1beta1.Notebook{}
 	if err := r.Get(ctx, req.NamespacedName, instance); err != nil {
 		log.Error(err, "unable to fetch Notebook")
+		if apierrs.IsNotFound(err) {
+			r.Culler.Forget(req.NamespacedName)
+			r.observedStarts.forget(req.NamespacedName)
+			r.probeTokens.forget(req.NamespacedName)
+			if r.Images != nil {
+				r.Images.forget(req.NamespacedName)
+			}
+			if r.Metrics != nil {
+				r.Metrics.Forget(req.Namespace, req.Name)
+			}
+		}
 		return ctrl.Result{}, ignoreNotFound(err)
 	}
 
+	// Shut down the server of a deleted Notebook before removing its
+	// finalizer
+	pending, err := r.reconcileShutdownHookFinalizer(ctx, instance)
+	if err != nil {
+		return ctrl.Result{}, err
+	} else if pending {
+		return ctrl.Result{RequeueAfter: shutdownHookPollInterval}, nil
+	}
+
 	// jupyter-web-app deletes objects using foreground deletion policy, Notebook CR will stay until all owned objects are deleted
 	// reconcile loop might keep on trying to recreate the resources that the API server tries to delete.
 	// so when Notebook CR is terminating, reconcile loop should do nothing
@@ -136,15 +154,45 @@ func (r *NotebookReconciler) Reconcile(ctx context.Context, req ctrl.Request) (c
 		return ctrl.Result{}, nil
 	}
 
+	// Start or stop the Notebook if its schedule says so
+	scheduleStatus, err := r.reconcileSchedule(ctx, instance)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// Keep the Notebook stopped if it would exceed the limits of running
+	// Notebooks
+	if err := r.reconcileLimits(ctx, instance); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// Keep a stopped Notebook running until its shutdown hook is done
+	pending, err = r.reconcileShutdownHook(ctx, instance)
+	if err != nil {
+		return ctrl.Result{}, err
+	} else if pending {
+		return ctrl.Result{RequeueAfter: shutdownHookPollInterval}, nil
+	}
+
+	// Reconcile the workspace PVC before the StatefulSet that mounts it
+	workspaceStatus, err := r.reconcileWorkspace(ctx, instance)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// Resolve the image of a Notebook that follows the digest of its tag
+	imageStatus := r.reconcileImage(ctx, instance)
+
 	// Reconcile StatefulSet
 	ss := generateStatefulSet(instance)
+	pinImage(ss, imageStatus)
 	if err := ctrl.SetControllerReference(instance, ss, r.Scheme); err != nil {
 		return ctrl.Result{}, err
 	}
 	// Check if the StatefulSet already exists
 	foundStateful := &appsv1.StatefulSet{}
 	justCreated := false
-	err := r.Get(ctx, types.NamespacedName{Name: ss.Name, Namespace: ss.Namespace}, foundStateful)
+	err = r.Get(ctx, types.NamespacedName{Name: ss.Name, Namespace: ss.Namespace}, foundStateful)
 	if err != nil && apierrs.IsNotFound(err) {
 		log.Info("Creating StatefulSet", "namespace", ss.Namespace, "name", ss.Name)
 		r.Metrics.NotebookCreation.WithLabelValues(ss.Namespace).Inc()
@@ -159,15 +207,22 @@ func (r *NotebookReconciler) Reconcile(ctx context.Context, req ctrl.Request) (c
 		log.Error(err, "error getting Statefulset")
 		return ctrl.Result{}, err
 	}
-	// Update the foundStateful object and write the result back if there are any changes
-	if !justCreated && reconcilehelper.CopyStatefulSetFields(ss, foundStateful) {
-		log.Info("Updating StatefulSet", "namespace", ss.Namespace, "name", ss.Name)
-		err = r.Update(ctx, foundStateful)
-		if err != nil {
-			log.Error(err, "unable to update Statefulset")
-			return ctrl.Result{}, err
+	// Update the foundStateful object and write the result back if there are any changes.
+	// The restart stamp of the Pod template isn't copied by the helper.
+	stamped := false
+	if !justCreated {
+		changed := reconcilehelper.CopyStatefulSetFields(ss, foundStateful)
+		stamped = copyRestartStamp(ss, foundStateful)
+		if changed || stamped {
+			log.Info("Updating StatefulSet", "namespace", ss.Namespace, "name", ss.Name)
+			err = r.Update(ctx, foundStateful)
+			if err != nil {
+				log.Error(err, "unable to update Statefulset")
+				return ctrl.Result{}, err
+			}
 		}
 	}
+	restartStatus := r.reconcileRestart(instance, stamped, imageStatus)
 
 	// Reconcile service
 	service := generateService(instance)
@@ -200,9 +255,24 @@ func (r *NotebookReconciler) Reconcile(ctx context.Context, req ctrl.Request) (c
 		}
 	}
 
-	// Reconcile virtual service if we use ISTIO.
-	if os.Getenv("USE_ISTIO") == "true" {
-		err = r.reconcileVirtualService(instance)
+	// Reconcile the NetworkPolicy of the Notebook, if enabled.
+	if r.networkPolicies {
+		err = r.reconcileNetworkPolicy(ctx, instance)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	// Reconcile the route of the Notebook, if it is routed.
+	backend := r.routingBackend
+	var route client.Object
+	if _, ok := backend.(ingressBackend); ok {
+		if err := r.reconcileIngressHeaders(ctx, instance); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+	if backend != nil {
+		route, err = r.reconcileRoute(backend, instance, foundStateful)
 		if err != nil {
 			return ctrl.Result{}, err
 		}
@@ -218,41 +288,62 @@ func (r *NotebookReconciler) Reconcile(ctx context.Context, req ctrl.Request) (c
 		return ctrl.Result{}, err
 	}
 
+	// Snapshot the workspace once the Pod of a stopped Notebook is gone
+	err = r.reconcileWorkspaceSnapshots(ctx, instance, podFound, workspaceStatus)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// Resolve the culling policy of the Notebook
+	cullingPolicy := r.cullingPolicy(ctx, instance)
+
 	// Update Notebook CR status
-	err = updateNotebookStatus(r, instance, foundStateful, foundPod, req)
+	err = updateNotebookStatus(r, instance, foundStateful, foundPod, cullingPolicy, scheduleStatus,
+		workspaceStatus, restartStatus, imageStatus, routingStatus(instance, backend, route), req)
 	if err != nil {
 		return ctrl.Result{}, err
 	}
 
 	if !podFound {
-		// Delete LAST_ACTIVITY_ANNOTATION annotations for CR objects
-		// that do not have a pod.
+		// Stop probing the activity of the Notebook. Its token is read again
+		// when it restarts, in case it was rotated.
+		r.Culler.Forget(req.NamespacedName)
+		r.probeTokens.forget(req.NamespacedName)
+
+		// Delete LAST_ACTIVITY_ANNOTATION and CULL_SCHEDULED_ANNOTATION
+		// annotations for CR objects that do not have a pod.
 		log.Info("Notebook has not Pod running. Will remove last-activity annotation")
 		meta := instance.ObjectMeta
 		if meta.GetAnnotations() == nil {
 			log.Info("No annotations found")
-			return ctrl.Result{}, nil
+			return requeueForSchedule(ctrl.Result{}, scheduleStatus), nil
 		}
 
-		if _, ok := meta.GetAnnotations()[culler.LAST_ACTIVITY_ANNOTATION]; !ok {
+		_, lastActivityFound := meta.GetAnnotations()[culler.LAST_ACTIVITY_ANNOTATION]
+		if !lastActivityFound && !culler.CullScheduledAnnotationIsSet(meta) {
 			log.Info("No last-activity annotations found")
-			return ctrl.Result{}, nil
+			return requeueForSchedule(ctrl.Result{}, scheduleStatus), nil
 		}
 
 		log.Info("Removing last-activity annotation")
 		delete(meta.GetAnnotations(), culler.LAST_ACTIVITY_ANNOTATION)
+		delete(meta.GetAnnotations(), culler.CULL_SCHEDULED_ANNOTATION)
 		err = r.Update(ctx, instance)
 		if err != nil {
 			return ctrl.Result{}, err
 		}
-		return ctrl.Result{}, nil
+		return requeueForSchedule(ctrl.Result{}, scheduleStatus), nil
 
 	}
 
 	// Pod is found
 	// Check if the Notebook needs to be stopped
-	// Update the LAST_ACTIVITY_ANNOTATION
-	if culler.UpdateNotebookLastActivityAnnotation(&instance.ObjectMeta) {
+	// The culler probes the activity of the Notebook in the background.
+	// Update the LAST_ACTIVITY_ANNOTATION from its last result.
+	probe := culler.GetActivityProbe(instance.ObjectMeta, instance.Spec.Template.Spec,
+		r.probeEndpoint(ctx, instance))
+	r.Culler.Track(instance.ObjectMeta, probe, cullingPolicy.CheckPeriod)
+	if culler.UpdateNotebookLastActivityAnnotation(&instance.ObjectMeta, r.Culler) {
 		err = r.Update(ctx, instance)
 		if err != nil {
 			return ctrl.Result{}, err
@@ -260,14 +351,43 @@ func (r *NotebookReconciler) Reconcile(ctx context.Context, req ctrl.Request) (c
 	}
 
 	// Check if the Notebook needs to be stopped
-	if culler.NotebookNeedsCulling(instance.ObjectMeta) {
+	if culler.NotebookNeedsCulling(instance.ObjectMeta, cullingPolicy) {
 		log.Info(fmt.Sprintf(
 			"Notebook %s/%s needs culling. Setting annotations",
 			instance.Namespace, instance.Name))
 
 		// Set annotations to the Notebook
 		culler.SetStopAnnotation(&instance.ObjectMeta, r.Metrics)
-		r.Metrics.NotebookCullingCount.WithLabelValues(instance.Namespace, instance.Name).Inc()
+		err = r.Update(ctx, instance)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if instance.Status.RecordLifecycleTransition(v1beta1.LifecycleActionStop,
+			v1beta1.LifecycleReasonCulled, lifecycleActor, metav1.Now()) {
+			err = r.Status().Update(ctx, instance)
+			if err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+	} else if deadline, ok := culler.NotebookCullingWarning(instance.ObjectMeta, cullingPolicy); ok {
+		// The Notebook is idle and will be culled at the end of the warning
+		// period, unless it becomes active in the meantime.
+		if culler.SetCullScheduledAnnotation(&instance.ObjectMeta, deadline) {
+			log.Info("Notebook is idle. Scheduling culling", "deadline", deadline)
+			r.EventRecorder.Eventf(instance, corev1.EventTypeWarning, "CullingScheduled",
+				"Notebook is idle and will be stopped at %s unless it becomes active",
+				deadline.Format(time.RFC3339))
+			err = r.Update(ctx, instance)
+			if err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+	} else if culler.RemoveCullScheduledAnnotation(&instance.ObjectMeta) {
+		// The Notebook became active during the warning period, or culling
+		// got disabled.
+		log.Info("Canceling the scheduled culling of the Notebook")
+		r.EventRecorder.Event(instance, corev1.EventTypeNormal, "CullingCanceled",
+			"Scheduled culling of the Notebook was canceled")
 		err = r.Update(ctx, instance)
 		if err != nil {
 			return ctrl.Result{}, err
@@ -276,13 +396,16 @@ func (r *NotebookReconciler) Reconcile(ctx context.Context, req ctrl.Request) (c
 		// The Pod is either too fresh, or the idle time has passed and it has
 		// received traffic. In this case we will be periodically checking if
 		// it needs culling.
-		return ctrl.Result{RequeueAfter: culler.GetRequeueTime()}, nil
+		return requeueForSchedule(ctrl.Result{RequeueAfter: cullingPolicy.CheckPeriod}, scheduleStatus), nil
 	}
-	return ctrl.Result{RequeueAfter: culler.GetRequeueTime()}, nil
+	return requeueForSchedule(ctrl.Result{RequeueAfter: cullingPolicy.CheckPeriod}, scheduleStatus), nil
 }
 
 func updateNotebookStatus(r *NotebookReconciler, nb *v1beta1.Notebook,
-	sts *appsv1.StatefulSet, pod *corev1.Pod, req ctrl.Request) error {
+	sts *appsv1.StatefulSet, pod *corev1.Pod, policy culler.Policy,
+	schedule *v1beta1.ScheduleStatus, workspace *v1beta1.WorkspaceStatus,
+	restart *v1beta1.RestartStatus, image *v1beta1.ImageStatus, routing *v1beta1.RoutingStatus,
+	req ctrl.Request) error {
 
 	log := r.Log.WithValues("notebook", req.NamespacedName)
 	ctx := context.Background()
@@ -291,6 +414,16 @@ func updateNotebookStatus(r *NotebookReconciler, nb *v1beta1.Notebook,
 	if err != nil {
 		return err
 	}
+	status.Culling = cullingStatus(policy)
+	status.Schedule = schedule
+	status.Workspace = workspace
+	status.Restart = restart
+	status.Image = image
+	status.Routing = routing
+	observeLifecycle(nb, &status)
+	r.observeStart(nb, &status, pod)
+	setLimitsCondition(nb, &status)
+	status.Phase = notebookPhase(nb, &status, pod)
 
 	log.Info("Updating Notebook CR Status", "status", status)
 	nb.Status = status
@@ -310,9 +443,12 @@ func createNotebookStatus(r *NotebookReconciler, nb *v1beta1.Notebook,
 		ContainerState: corev1.ContainerState{},
 	}
 
+	log.Info("Calculating Notebook's Conditions")
+	setNotebookConditions(nb, &status, sts, pod, r.routingBackend)
+
 	// Update the status based on the Pod's status
 	if reflect.DeepEqual(pod.Status, corev1.PodStatus{}) {
-		log.Info("No pod.Status found. Won't update notebook conditions and containerState")
+		log.Info("No pod.Status found. Won't update notebook containerState")
 		return status, nil
 	}
 
@@ -345,60 +481,9 @@ func createNotebookStatus(r *NotebookReconciler, nb *v1beta1.Notebook,
 			"status.containerState ")
 	}
 
-	// Mirroring pod condition
-	notebookConditions := []v1beta1.NotebookCondition{}
-	log.Info("Calculating Notebook's Conditions")
-	for i := range pod.Status.Conditions {
-		condition := PodCondToNotebookCond(pod.Status.Conditions[i])
-		notebookConditions = append(notebookConditions, condition)
-	}
-
-	status.Conditions = notebookConditions
-
 	return status, nil
 }
 
-func PodCondToNotebookCond(podc corev1.PodCondition) v1beta1.NotebookCondition {
-
-	condition := v1beta1.NotebookCondition{}
-
-	if len(podc.Type) > 0 {
-		condition.Type = string(podc.Type)
-	}
-
-	if len(podc.Status) > 0 {
-		condition.Status = string(podc.Status)
-	}
-
-	if len(podc.Message) > 0 {
-		condition.Message = podc.Message
-	}
-
-	if len(podc.Reason) > 0 {
-		condition.Reason = podc.Reason
-	}
-
-	// check if podc.LastProbeTime is null. If so initialize
-	// the field with metav1.Now()
-	check := podc.LastProbeTime.Time.Equal(time.Time{})
-	if !check {
-		condition.LastProbeTime = podc.LastProbeTime
-	} else {
-		condition.LastProbeTime = metav1.Now()
-	}
-
-	// check if podc.LastTransitionTime is null. If so initialize
-	// the field with metav1.Now()
-	check = podc.LastTransitionTime.Time.Equal(time.Time{})
-	if !check {
-		condition.LastTransitionTime = podc.LastTransitionTime
-	} else {
-		condition.LastTransitionTime = metav1.Now()
-	}
-
-	return condition
-}
-
 func setPrefixEnvVar(instance *v1beta1.Notebook, container *corev1.Container) {
 	prefix := "/notebook/" + instance.Namespace + "/" + instance.Name
 
@@ -415,6 +500,28 @@ func setPrefixEnvVar(instance *v1beta1.Notebook, container *corev1.Container) {
 	})
 }
 
+// setContainerDefaults fills in the working directory, the ports and the
+// NB_PREFIX env var of the Notebook's container.
+func setContainerDefaults(instance *v1beta1.Notebook, container *corev1.Container) {
+	if container.WorkingDir == "" {
+		container.WorkingDir = DefaultWorkingDir
+	}
+	if container.Ports == nil {
+		container.Ports = []corev1.ContainerPort{
+			{
+				ContainerPort: DefaultContainerPort,
+				Name:          "notebook-port",
+				Protocol:      "TCP",
+			},
+		}
+	}
+	// The name of the Notebooks created with a generateName is only known
+	// once they are created
+	if instance.Name != "" {
+		setPrefixEnvVar(instance, container)
+	}
+}
+
 func generateStatefulSet(instance *v1beta1.Notebook) *appsv1.StatefulSet {
 	replicas := int32(1)
 	if culler.StopAnnotationIsSet(instance.ObjectMeta) {
@@ -450,26 +557,17 @@ func generateStatefulSet(instance *v1beta1.Notebook) *appsv1.StatefulSet {
 
 	podSpec := &ss.Spec.Template.Spec
 	container := &podSpec.Containers[0]
-	if container.WorkingDir == "" {
-		container.WorkingDir = "/home/jovyan"
-	}
-	if container.Ports == nil {
-		container.Ports = []corev1.ContainerPort{
-			{
-				ContainerPort: DefaultContainerPort,
-				Name:          "notebook-port",
-				Protocol:      "TCP",
-			},
-		}
-	}
-
-	setPrefixEnvVar(instance, container)
+	setContainerDefaults(instance, container)
+	mountWorkspace(instance, podSpec)
+	setPreStopCommand(instance, container)
+	setShutdownGracePeriod(instance, podSpec)
+	setRestartStamp(instance, ss)
 
 	// For some platforms (like OpenShift), adding fsGroup: 100 is troublesome.
 	// This allows for those platforms to bypass the automatic addition of the fsGroup
 	// and will allow for the Pod Security Policy controller to make an appropriate choice
 	// https://github.com/kubernetes-sigs/controller-runtime/issues/4617
-	if value, exists := os.LookupEnv("ADD_FSGROUP"); !exists || value == "true" {
+	if config.Get().AddFSGroup {
 		if podSpec.SecurityContext == nil {
 			fsGroup := DefaultFSGroup
 			podSpec.SecurityContext = &corev1.PodSecurityContext{
@@ -506,6 +604,15 @@ func generateService(instance *v1beta1.Notebook) *corev1.Service {
 			},
 		},
 	}
+	// The endpoints are served on their container port
+	for _, endpoint := range instance.Spec.Endpoints {
+		svc.Spec.Ports = append(svc.Spec.Ports, corev1.ServicePort{
+			Name:       endpointPortName(endpoint),
+			Port:       endpoint.Port,
+			TargetPort: intstr.FromInt(int(endpoint.Port)),
+			Protocol:   "TCP",
+		})
+	}
 	return svc
 }
 
@@ -513,28 +620,17 @@ func virtualServiceName(kfName string, namespace string) string {
 	return fmt.Sprintf("notebook-%s-%s", namespace, kfName)
 }
 
-func generateVirtualService(instance *v1beta1.Notebook) (*unstructured.Unstructured, error) {
+// generateVirtualService routes the Notebook's prefix to its Service, or to
+// the activator if toActivator is true.
+func generateVirtualService(instance *v1beta1.Notebook, toActivator bool) (*unstructured.Unstructured, error) {
 	name := instance.Name
 	namespace := instance.Namespace
-	clusterDomain := "cluster.local"
-	prefix := fmt.Sprintf("/notebook/%s/%s/", namespace, name)
-
-	// unpack annotations from Notebook resource
-	annotations := make(map[string]string)
-	for k, v := range instance.ObjectMeta.Annotations {
-		annotations[k] = v
-	}
-
-	rewrite := fmt.Sprintf("/notebook/%s/%s/", namespace, name)
-	// If AnnotationRewriteURI is present, use this value for "rewrite"
-	if _, ok := annotations[AnnotationRewriteURI]; ok && len(annotations[AnnotationRewriteURI]) > 0 {
-		rewrite = annotations[AnnotationRewriteURI]
-	}
+	cfg := config.Get()
+	prefix := notebookPrefix(instance)
+	rewrite := rewriteURI(instance)
 
-	if clusterDomainFromEnv, ok := os.LookupEnv("CLUSTER_DOMAIN"); ok {
-		clusterDomain = clusterDomainFromEnv
-	}
-	service := fmt.Sprintf("%s.%s.svc.%s", name, namespace, clusterDomain)
+	service := fmt.Sprintf("%s.%s.svc.%s", name, namespace, cfg.ClusterDomain)
+	servicePort := int64(DefaultServingPort)
 
 	vsvc := &unstructured.Unstructured{}
 	vsvc.SetAPIVersion("networking.istio.io/v1alpha3")
@@ -545,32 +641,64 @@ func generateVirtualService(instance *v1beta1.Notebook) (*unstructured.Unstructu
 		return nil, fmt.Errorf("Set .spec.hosts error: %v", err)
 	}
 
-	istioGateway := os.Getenv("ISTIO_GATEWAY")
-	if len(istioGateway) == 0 {
-		istioGateway = "kubeflow/kubeflow-gateway"
-	}
-	if err := unstructured.SetNestedStringSlice(vsvc.Object, []string{istioGateway},
+	if err := unstructured.SetNestedStringSlice(vsvc.Object, []string{cfg.Routing.IstioGateway},
 		"spec", "gateways"); err != nil {
 		return nil, fmt.Errorf("Set .spec.gateways error: %v", err)
 	}
 
-	headersRequestSet := make(map[string]string)
-	// If AnnotationHeadersRequestSet is present, use its values in "headers.request.set"
-	if _, ok := annotations[AnnotationHeadersRequestSet]; ok && len(annotations[AnnotationHeadersRequestSet]) > 0 {
-		requestHeadersBytes := []byte(annotations[AnnotationHeadersRequestSet])
-		if err := json.Unmarshal(requestHeadersBytes, &headersRequestSet); err != nil {
-			// if JSON decoding fails, set an empty map
-			headersRequestSet = make(map[string]string)
-		}
-	}
+	// Use the values of AnnotationHeadersRequestSet in "headers.request.set"
+	headersRequestSet := requestHeaders(instance)
 	// cast from map[string]string, as SetNestedSlice needs map[string]interface{}
 	headersRequestSetInterface := make(map[string]interface{})
 	for key, element := range headersRequestSet {
 		headersRequestSetInterface[key] = element
 	}
 
-	// the http section of the istio VirtualService spec
-	http := []interface{}{
+	// Send the requests to the activator, which starts the Notebook
+	if toActivator {
+		service = activatorService()
+		servicePort = int64(DefaultActivatorPort)
+		headersRequestSetInterface[ActivatorNotebookHeader] = namespace + "/" + name
+	}
+
+	// the http section of the istio VirtualService spec. The routes of the
+	// endpoints come first, as their prefixes are longer than the Notebook's.
+	http := []interface{}{}
+	for _, endpoint := range instance.Spec.Endpoints {
+		// The activator serves all the ports of the Notebook on its own
+		port := int64(endpoint.Port)
+		if toActivator {
+			port = servicePort
+		}
+		http = append(http, map[string]interface{}{
+			"headers": map[string]interface{}{
+				"request": map[string]interface{}{
+					"set": headersRequestSetInterface,
+				},
+			},
+			"match": []interface{}{
+				map[string]interface{}{
+					"uri": map[string]interface{}{
+						"prefix": endpointPrefix(instance, endpoint),
+					},
+				},
+			},
+			"rewrite": map[string]interface{}{
+				"uri": endpointRewrite(instance, endpoint),
+			},
+			"route": []interface{}{
+				map[string]interface{}{
+					"destination": map[string]interface{}{
+						"host": service,
+						"port": map[string]interface{}{
+							"number": port,
+						},
+					},
+				},
+			},
+		})
+	}
+	http = append(http,
 		map[string]interface{}{
 			"headers": map[string]interface{}{
 				"request": map[string]interface{}{
@@ -592,13 +720,13 @@ func generateVirtualService(instance *v1beta1.Notebook) (*unstructured.Unstructu
 					"destination": map[string]interface{}{
 						"host": service,
 						"port": map[string]interface{}{
-							"number": int64(DefaultServingPort),
+							"number": servicePort,
 						},
 					},
 				},
 			},
 		},
-	}
+	)
 
 	// add http section to istio VirtualService spec
 	if err := unstructured.SetNestedSlice(vsvc.Object, http, "spec", "http"); err != nil {
@@ -609,81 +737,6 @@ func generateVirtualService(instance *v1beta1.Notebook) (*unstructured.Unstructu
 
 }
 
-func (r *NotebookReconciler) reconcileVirtualService(instance *v1beta1.Notebook) error {
-	log := r.Log.WithValues("notebook", instance.Namespace)
-	virtualService, err := generateVirtualService(instance)
-	if err := ctrl.SetControllerReference(instance, virtualService, r.Scheme); err != nil {
-		return err
-	}
-	// Check if the virtual service already exists.
-	foundVirtual := &unstructured.Unstructured{}
-	justCreated := false
-	foundVirtual.SetAPIVersion("networking.istio.io/v1alpha3")
-	foundVirtual.SetKind("VirtualService")
-	err = r.Get(context.TODO(), types.NamespacedName{Name: virtualServiceName(instance.Name,
-		instance.Namespace), Namespace: instance.Namespace}, foundVirtual)
-	if err != nil && apierrs.IsNotFound(err) {
-		log.Info("Creating virtual service", "namespace", instance.Namespace, "name",
-			virtualServiceName(instance.Name, instance.Namespace))
-		err = r.Create(context.TODO(), virtualService)
-		justCreated = true
-		if err != nil {
-			return err
-		}
-	} else if err != nil {
-		return err
-	}
-
-	if !justCreated && reconcilehelper.CopyVirtualService(virtualService, foundVirtual) {
-		log.Info("Updating virtual service", "namespace", instance.Namespace, "name",
-			virtualServiceName(instance.Name, instance.Namespace))
-		err = r.Update(context.TODO(), foundVirtual)
-		if err != nil {
-			return err
-		}
-	}
-
-	return nil
-}
-
-func isStsOrPodEvent(event *corev1.Event) bool {
-	return event.InvolvedObject.Kind == "Pod" || event.InvolvedObject.Kind == "StatefulSet"
-}
-
-func nbNameFromInvolvedObject(c client.Client, object *corev1.ObjectReference) (string, error) {
-	name, namespace := object.Name, object.Namespace
-
-	if object.Kind == "StatefulSet" {
-		return name, nil
-	}
-	if object.Kind == "Pod" {
-		pod := &corev1.Pod{}
-		err := c.Get(
-			context.TODO(),
-			types.NamespacedName{
-				Namespace: namespace,
-				Name:      name,
-			},
-			pod,
-		)
-		if err != nil {
-			return "", err
-		}
-		if nbName, ok := pod.Labels["notebook-name"]; ok {
-			return nbName, nil
-		}
-	}
-	return "", fmt.Errorf("object isn't related to a Notebook")
-}
-
-func nbNameExists(client client.Client, nbName string, namespace string) bool {
-	if err := client.Get(context.Background(), types.NamespacedName{Namespace: namespace, Name: nbName}, &v1beta1.Notebook{}); err != nil {
-		// If error != NotFound, trigger the reconcile call anyway to avoid loosing a potential relevant event
-		return !apierrs.IsNotFound(err)
-	}
-	return true
-}
-
 // predNBPodIsLabeled filters pods not containing the "notebook-name" label key
 func predNBPodIsLabeled() predicate.Funcs {
 	// Documented at
@@ -698,32 +751,12 @@ func predNBPodIsLabeled() predicate.Funcs {
 	return predicate.NewPredicateFuncs(checkNBLabel())
 }
 
-// predNBEvents filters events not coming from Pod or STS, and coming from
-// unknown NBs
-func predNBEvents(r *NotebookReconciler) predicate.Funcs {
-	checkEvent := func() func(object client.Object) bool {
-		return func(object client.Object) bool {
-			event := object.(*corev1.Event)
-			nbName, err := nbNameFromInvolvedObject(r.Client, &event.InvolvedObject)
-			if err != nil {
-				return false
-			}
-			return isStsOrPodEvent(event) && nbNameExists(r.Client, nbName, object.GetNamespace())
-		}
-	}
-
-	predicates := predicate.NewPredicateFuncs(checkEvent())
-
-	// Do not reconcile when an event gets deleted
-	predicates.DeleteFunc = func(e event.DeleteEvent) bool {
-		return false
-	}
-
-	return predicates
-}
-
 // SetupWithManager sets up the controller with the Manager.
 func (r *NotebookReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	// Report the usage of the limits of running Notebooks as they are enforced
+	if r.Metrics != nil {
+		r.Metrics.RunningNotebooks = r.runningNotebooks
+	}
 
 	// Map function to convert pod events to reconciliation requests
 	mapPodToRequest := func(object client.Object) []reconcile.Request {
@@ -735,16 +768,6 @@ func (r *NotebookReconciler) SetupWithManager(mgr ctrl.Manager) error {
 		}
 	}
 
-	// Map function to convert namespace events to reconciliation requests
-	mapEventToRequest := func(object client.Object) []reconcile.Request {
-		return []reconcile.Request{
-			{NamespacedName: types.NamespacedName{
-				Name:      object.GetName(),
-				Namespace: object.GetNamespace(),
-			}},
-		}
-	}
-
 	builder := ctrl.NewControllerManagedBy(mgr).
 		For(&v1beta1.Notebook{}).
 		Owns(&appsv1.StatefulSet{}).
@@ -753,19 +776,44 @@ func (r *NotebookReconciler) SetupWithManager(mgr ctrl.Manager) error {
 			&source.Kind{Type: &corev1.Pod{}},
 			handler.EnqueueRequestsFromMapFunc(mapPodToRequest),
 			builder.WithPredicates(predNBPodIsLabeled())).
+		// Retained workspaces aren't owned by the Notebook, so they are
+		// mapped to it by label like the Pods
 		Watches(
-			&source.Kind{Type: &corev1.Event{}},
-			handler.EnqueueRequestsFromMapFunc(mapEventToRequest),
-			builder.WithPredicates(predNBEvents(r)))
-	// watch Istio virtual service
-	if os.Getenv("USE_ISTIO") == "true" {
-		virtualService := &unstructured.Unstructured{}
-		virtualService.SetAPIVersion("networking.istio.io/v1alpha3")
-		virtualService.SetKind("VirtualService")
-		builder.Owns(virtualService)
-	}
-
-	err := builder.Complete(r)
+			&source.Kind{Type: &corev1.PersistentVolumeClaim{}},
+			handler.EnqueueRequestsFromMapFunc(mapPodToRequest),
+			builder.WithPredicates(predNBPodIsLabeled()))
+	// The Notebooks apply the changes of the culling policy of their
+	// namespace
+	if r.CullingPolicies != nil {
+		builder.Watches(
+			source.NewKindWithCache(&corev1.ConfigMap{}, r.CullingPolicies),
+			handler.EnqueueRequestsFromMapFunc(r.mapCullingPolicyToNotebooks))
+	}
+	// The Notebooks record the resolutions of their images
+	if r.Images != nil {
+		builder.Watches(
+			&source.Channel{Source: r.Images.events},
+			&handler.EnqueueRequestForObject{})
+	}
+	r.networkPolicies = networkPolicyEnabled()
+	if r.networkPolicies {
+		builder.Owns(&networkingv1.NetworkPolicy{})
+	}
+	// watch the routes of the routing backend
+	backend, err := newRoutingBackend()
+	if err != nil {
+		return err
+	}
+	r.routingBackend = backend
+	if backend != nil {
+		builder.Owns(backend.NewObject())
+	}
+	// and the ConfigMaps of the request headers of the Ingresses
+	if _, ok := backend.(ingressBackend); ok {
+		builder.Owns(&corev1.ConfigMap{})
+	}
+
+	err = builder.Complete(r)
 	if err != nil {
 		return err
 	}
@@ -111,7 +111,11 @@ func TestCreateNotebookStatus(t *testing.T) {
 			pod: corev1.Pod{},
 			sts: appsv1.StatefulSet{},
 			expectedNbStatus: nbv1beta1.NotebookStatus{
-				Conditions:     []nbv1beta1.NotebookCondition{},
+				Conditions: []nbv1beta1.NotebookCondition{
+					{Type: "Scheduled", Status: "False", Reason: "PodPending", Message: "Waiting for the Pod to be created"},
+					{Type: "ImagePulled", Status: "Unknown", Reason: "PodPending", Message: "Waiting for the Pod to be created"},
+					{Type: "Ready", Status: "False", Reason: "PodPending", Message: "Waiting for the Pod to be created"},
+				},
 				ReadyReplicas:  int32(0),
 				ContainerState: corev1.ContainerState{},
 			},
@@ -136,7 +140,11 @@ func TestCreateNotebookStatus(t *testing.T) {
 				},
 			},
 			expectedNbStatus: nbv1beta1.NotebookStatus{
-				Conditions:     []nbv1beta1.NotebookCondition{},
+				Conditions: []nbv1beta1.NotebookCondition{
+					{Type: "Scheduled", Status: "False", Reason: "PodPending", Message: "Waiting for the Pod to be created"},
+					{Type: "ImagePulled", Status: "Unknown", Reason: "PodPending", Message: "Waiting for the Pod to be created"},
+					{Type: "Ready", Status: "False", Reason: "PodPending", Message: "Waiting for the Pod to be created"},
+				},
 				ReadyReplicas:  int32(1),
 				ContainerState: corev1.ContainerState{},
 			},
@@ -170,7 +178,11 @@ func TestCreateNotebookStatus(t *testing.T) {
 			},
 			sts: appsv1.StatefulSet{},
 			expectedNbStatus: nbv1beta1.NotebookStatus{
-				Conditions:    []nbv1beta1.NotebookCondition{},
+				Conditions: []nbv1beta1.NotebookCondition{
+					{Type: "Scheduled", Status: "Unknown", Reason: "PodPending", Message: "Waiting for the Pod to be scheduled"},
+					{Type: "ImagePulled", Status: "Unknown", Reason: "ImagePulling"},
+					{Type: "Ready", Status: "False", Reason: "Starting", Message: "Waiting for the Notebook server to be ready"},
+				},
 				ReadyReplicas: int32(0),
 				ContainerState: corev1.ContainerState{
 					Running: &corev1.ContainerStateRunning{
@@ -180,7 +192,14 @@ func TestCreateNotebookStatus(t *testing.T) {
 			},
 		},
 		{
-			name: "mirroringPodConditions",
+			name: "readyPod",
+			currentNb: nbv1beta1.Notebook{
+				ObjectMeta: v1.ObjectMeta{
+					Name:       "test",
+					Namespace:  "kubeflow-user",
+					Generation: 2,
+				},
+			},
 			pod: corev1.Pod{
 				ObjectMeta: v1.ObjectMeta{
 					Name:      "test",
@@ -189,15 +208,20 @@ func TestCreateNotebookStatus(t *testing.T) {
 				Status: corev1.PodStatus{
 					Conditions: []corev1.PodCondition{
 						{
-							Type:               "Running",
-							LastProbeTime:      v1.Date(2022, time.Month(8), 30, 1, 10, 30, 0, time.UTC),
+							Type:               "PodScheduled",
+							Status:             "True",
 							LastTransitionTime: v1.Date(2022, time.Month(8), 30, 1, 10, 30, 0, time.UTC),
 						},
 						{
-							Type:               "Waiting",
-							LastProbeTime:      v1.Date(2022, time.Month(8), 30, 1, 10, 30, 0, time.UTC),
+							Type:               "Ready",
+							Status:             "True",
 							LastTransitionTime: v1.Date(2022, time.Month(8), 30, 1, 10, 30, 0, time.UTC),
-							Reason:             "PodInitializing",
+						},
+					},
+					ContainerStatuses: []corev1.ContainerStatus{
+						{
+							Name:    "test",
+							ImageID: "docker-pullable://kubeflownotebookswg/jupyter@sha256:1234",
 						},
 					},
 				},
@@ -213,17 +237,9 @@ func TestCreateNotebookStatus(t *testing.T) {
 			},
 			expectedNbStatus: nbv1beta1.NotebookStatus{
 				Conditions: []nbv1beta1.NotebookCondition{
-					{
-						Type:               "Running",
-						LastProbeTime:      v1.Date(2022, time.Month(8), 30, 1, 10, 30, 0, time.UTC),
-						LastTransitionTime: v1.Date(2022, time.Month(8), 30, 1, 10, 30, 0, time.UTC),
-					},
-					{
-						Type:               "Waiting",
-						LastProbeTime:      v1.Date(2022, time.Month(8), 30, 1, 10, 30, 0, time.UTC),
-						LastTransitionTime: v1.Date(2022, time.Month(8), 30, 1, 10, 30, 0, time.UTC),
-						Reason:             "PodInitializing",
-					},
+					{Type: "Scheduled", Status: "True", Reason: "Scheduled", ObservedGeneration: 2},
+					{Type: "ImagePulled", Status: "True", Reason: "ImagePulled", ObservedGeneration: 2},
+					{Type: "Ready", Status: "True", Reason: "Ready", ObservedGeneration: 2},
 				},
 				ReadyReplicas:  int32(1),
 				ContainerState: corev1.ContainerState{},
@@ -243,7 +259,7 @@ func TestCreateNotebookStatus(t *testing.T) {
 							LastProbeTime:      v1.Date(2022, time.Month(4), 21, 1, 10, 30, 0, time.UTC),
 							LastTransitionTime: v1.Date(2022, time.Month(4), 21, 1, 10, 30, 0, time.UTC),
 							Message:            "0/1 nodes are available: 1 Insufficient cpu.",
-							Status:             "false",
+							Status:             "False",
 							Reason:             "Unschedulable",
 						},
 					},
@@ -258,14 +274,9 @@ func TestCreateNotebookStatus(t *testing.T) {
 			},
 			expectedNbStatus: nbv1beta1.NotebookStatus{
 				Conditions: []nbv1beta1.NotebookCondition{
-					{
-						Type:               "PodScheduled",
-						LastProbeTime:      v1.Date(2022, time.Month(4), 21, 1, 10, 30, 0, time.UTC),
-						LastTransitionTime: v1.Date(2022, time.Month(4), 21, 1, 10, 30, 0, time.UTC),
-						Message:            "0/1 nodes are available: 1 Insufficient cpu.",
-						Status:             "false",
-						Reason:             "Unschedulable",
-					},
+					{Type: "Scheduled", Status: "False", Reason: "Unschedulable", Message: "0/1 nodes are available: 1 Insufficient cpu."},
+					{Type: "ImagePulled", Status: "Unknown", Reason: "ImagePulling"},
+					{Type: "Ready", Status: "False", Reason: "Starting", Message: "Waiting for the Notebook server to be ready"},
 				},
 				ReadyReplicas:  int32(0),
 				ContainerState: corev1.ContainerState{},
@@ -281,6 +292,13 @@ func TestCreateNotebookStatus(t *testing.T) {
 			if err != nil {
 				t.Errorf("Unexpected error: %v", err)
 			}
+			// The transition times are set to the current time
+			for i := range status.Conditions {
+				if status.Conditions[i].LastTransitionTime.IsZero() {
+					t.Errorf("Expected a transition time for condition %s", status.Conditions[i].Type)
+				}
+				status.Conditions[i].LastTransitionTime = v1.Time{}
+			}
 			if !reflect.DeepEqual(status, test.expectedNbStatus) {
 				t.Errorf("\nExpect: %v; \nOutput: %v", test.expectedNbStatus, status)
 			}
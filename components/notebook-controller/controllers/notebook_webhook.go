@@ -0,0 +1,346 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/kubeflow/kubeflow/components/notebook-controller/api/v1beta1"
+	"github.com/kubeflow/kubeflow/components/notebook-controller/pkg/config"
+	"github.com/kubeflow/kubeflow/components/notebook-controller/pkg/culler"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// MaxNameLength is the maximum length of a Notebook's name. The StatefulSet
+// controller labels the Pods with the name of the StatefulSet followed by a
+// hash, which must fit in the 63 characters of a label value.
+const MaxNameLength = 52
+
+const mutatingWebhookPath = "/mutate-kubeflow-org-v1beta1-notebook"
+
+//+kubebuilder:webhook:path=/mutate-kubeflow-org-v1beta1-notebook,mutating=true,failurePolicy=fail,sideEffects=None,groups=kubeflow.org,resources=notebooks,verbs=create,versions=v1beta1,name=mnotebook.kubeflow.org,admissionReviewVersions=v1
+//+kubebuilder:webhook:path=/validate-kubeflow-org-v1beta1-notebook,mutating=false,failurePolicy=fail,sideEffects=None,groups=kubeflow.org,resources=notebooks,verbs=create;update,versions=v1beta1,name=vnotebook.kubeflow.org,admissionReviewVersions=v1
+
+// NotebookWebhook defaults and validates the Notebooks at admission, so that
+// the specs the controller can't reconcile are rejected with a clear message
+// instead of failing in Reconcile. It also rejects the Notebooks that would
+// exceed the limits of Notebooks of their namespace.
+type NotebookWebhook struct {
+	// Client lists the Notebooks counted towards the limits. The limits
+	// aren't enforced if it is nil.
+	Client client.Client
+
+	decoder *admission.Decoder
+}
+
+var _ admission.Handler = &NotebookWebhook{}
+var _ admission.DecoderInjector = &NotebookWebhook{}
+var _ admission.CustomValidator = &NotebookWebhook{}
+
+// SetupWebhookWithManager registers the defaulting, validating and conversion
+// webhooks of the Notebooks.
+func (w *NotebookWebhook) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	// The defaulting webhook needs the user of the request, so it handles
+	// the requests itself instead of being a CustomDefaulter
+	mgr.GetWebhookServer().Register(mutatingWebhookPath, &webhook.Admission{Handler: w})
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(&v1beta1.Notebook{}).
+		WithValidator(w).
+		Complete()
+}
+
+// InjectDecoder injects the decoder of the admission requests.
+func (w *NotebookWebhook) InjectDecoder(d *admission.Decoder) error {
+	w.decoder = d
+	return nil
+}
+
+// Handle defaults a new Notebook, and sets its creator to the user of the
+// request. Only the trusted clients, which create Notebooks on behalf of their
+// users, may set another creator.
+func (w *NotebookWebhook) Handle(ctx context.Context, req admission.Request) admission.Response {
+	instance := &v1beta1.Notebook{}
+	if err := w.decoder.Decode(req, instance); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	if err := w.Default(ctx, instance); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	creator, user := notebookCreator(instance), req.UserInfo.Username
+	if user != "" && creator != user &&
+		(creator == "" || !config.Get().Limits.TrustedClient(user)) {
+		if instance.Annotations == nil {
+			instance.Annotations = map[string]string{}
+		}
+		instance.Annotations[AnnotationCreator] = user
+	}
+
+	marshaled, err := json.Marshal(instance)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	return admission.PatchResponseFromRaw(req.Object.Raw, marshaled)
+}
+
+// Default fills in the working directory, the ports and the env of the
+// Notebook's container, as the controller does in the StatefulSet. The
+// Notebooks are only defaulted on creation, so that the updates of the
+// Notebooks created before the webhook don't change their spec.
+func (w *NotebookWebhook) Default(ctx context.Context, obj runtime.Object) error {
+	instance, ok := obj.(*v1beta1.Notebook)
+	if !ok {
+		return fmt.Errorf("expected a Notebook, got %T", obj)
+	}
+	// The validation rejects the Notebooks without containers
+	if len(instance.Spec.Template.Spec.Containers) == 0 {
+		return nil
+	}
+	setContainerDefaults(instance, &instance.Spec.Template.Spec.Containers[0])
+	return nil
+}
+
+// ValidateCreate validates a new Notebook, and checks that it fits in the
+// limits of Notebooks of its namespace.
+func (w *NotebookWebhook) ValidateCreate(ctx context.Context, obj runtime.Object) error {
+	instance, ok := obj.(*v1beta1.Notebook)
+	if !ok {
+		return fmt.Errorf("expected a Notebook, got %T", obj)
+	}
+	if err := validateNotebook(instance); err != nil {
+		return err
+	}
+	if w.Client == nil {
+		return nil
+	}
+
+	reason, err := exceededNotebooksLimit(ctx, w.Client, instance)
+	if err != nil {
+		return apierrs.NewInternalError(err)
+	} else if reason != "" {
+		return apierrs.NewForbidden(v1beta1.GroupVersion.WithResource("notebooks").GroupResource(),
+			instance.Name, errors.New(reason))
+	}
+	return nil
+}
+
+// ValidateUpdate validates an updated Notebook. The Notebooks created before
+// the webhook may be invalid, so they are only validated if their spec or
+// annotations changed, and never while they are deleted, so that their
+// finalizers can be removed. The creator of a Notebook can't be changed.
+func (w *NotebookWebhook) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) error {
+	old, ok := oldObj.(*v1beta1.Notebook)
+	if !ok {
+		return fmt.Errorf("expected a Notebook, got %T", oldObj)
+	}
+	instance, ok := newObj.(*v1beta1.Notebook)
+	if !ok {
+		return fmt.Errorf("expected a Notebook, got %T", newObj)
+	}
+	if !instance.DeletionTimestamp.IsZero() {
+		return nil
+	}
+	if creator := notebookCreator(old); creator != "" && notebookCreator(instance) != creator {
+		return apierrs.NewInvalid(v1beta1.GroupVersion.WithKind("Notebook").GroupKind(), instance.Name, field.ErrorList{
+			field.Invalid(field.NewPath("metadata", "annotations").Key(AnnotationCreator),
+				notebookCreator(instance), "the creator of a Notebook is immutable"),
+		})
+	}
+	if apiequality.Semantic.DeepEqual(old.Spec, instance.Spec) && !validatedAnnotationsChanged(old, instance) {
+		return nil
+	}
+	return validateNotebook(instance)
+}
+
+// validatedAnnotations are the annotations of the Notebooks checked by the
+// webhook.
+var validatedAnnotations = []string{
+	AnnotationHeadersRequestSet,
+	AnnotationImageUpdatePolicy,
+	culler.ACTIVITY_PROBE_SCHEME_ANNOTATION,
+	culler.ACTIVITY_PROBE_SERVICE_ANNOTATION,
+	culler.ACTIVITY_PROBE_PORT_ANNOTATION,
+	culler.ACTIVITY_PROBE_PATH_ANNOTATION,
+	culler.ACTIVITY_PROBE_TOKEN_SECRET_ANNOTATION,
+}
+
+func validatedAnnotationsChanged(old, instance *v1beta1.Notebook) bool {
+	for _, annotation := range validatedAnnotations {
+		if old.GetAnnotations()[annotation] != instance.GetAnnotations()[annotation] {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateDelete allows deleting any Notebook.
+func (w *NotebookWebhook) ValidateDelete(ctx context.Context, obj runtime.Object) error {
+	return nil
+}
+
+// validateNotebook returns an Invalid error listing the fields of the
+// Notebook that the controller can't reconcile.
+func validateNotebook(instance *v1beta1.Notebook) error {
+	errs := field.ErrorList{}
+
+	namePath := field.NewPath("metadata", "name")
+	// The name is the one of the Service and of the Pod's hostname
+	for _, msg := range validation.IsDNS1035Label(instance.Name) {
+		errs = append(errs, field.Invalid(namePath, instance.Name, msg))
+	}
+	if len(instance.Name) > MaxNameLength {
+		errs = append(errs, field.TooLong(namePath, instance.Name, MaxNameLength))
+	}
+
+	containersPath := field.NewPath("spec", "template", "spec", "containers")
+	containers := instance.Spec.Template.Spec.Containers
+	if len(containers) == 0 {
+		errs = append(errs, field.Required(containersPath, "a Notebook needs at least one container"))
+	} else if containers[0].Name != instance.Name {
+		errs = append(errs, field.Invalid(containersPath.Index(0).Child("name"), containers[0].Name,
+			fmt.Sprintf("the first container must be named after the Notebook, %q", instance.Name)))
+	}
+
+	if value := instance.GetAnnotations()[AnnotationHeadersRequestSet]; len(value) > 0 {
+		headers := map[string]string{}
+		if err := json.Unmarshal([]byte(value), &headers); err != nil {
+			errs = append(errs, field.Invalid(field.NewPath("metadata", "annotations").Key(AnnotationHeadersRequestSet),
+				value, fmt.Sprintf("must be a JSON object of header names to values: %v", err)))
+		}
+	}
+
+	switch policy := imageUpdatePolicy(instance); policy {
+	case ImageUpdatePolicyNone, ImageUpdatePolicyDigest:
+	default:
+		errs = append(errs, field.NotSupported(field.NewPath("metadata", "annotations").Key(AnnotationImageUpdatePolicy),
+			policy, []string{ImageUpdatePolicyNone, ImageUpdatePolicyDigest}))
+	}
+
+	// The workspace volume is added to the Pod by the controller
+	if instance.Spec.Workspace != nil {
+		volumesPath := field.NewPath("spec", "template", "spec", "volumes")
+		for i, volume := range instance.Spec.Template.Spec.Volumes {
+			if volume.Name == workspaceVolumeName {
+				errs = append(errs, field.Invalid(volumesPath.Index(i).Child("name"), volume.Name,
+					"is reserved for the workspace of the Notebook"))
+			}
+		}
+	}
+
+	errs = append(errs, validateEndpoints(instance)...)
+	errs = append(errs, validateProbeAnnotations(instance)...)
+
+	if len(errs) > 0 {
+		return apierrs.NewInvalid(v1beta1.GroupVersion.WithKind("Notebook").GroupKind(), instance.Name, errs)
+	}
+	return nil
+}
+
+// validateProbeAnnotations checks that the activity probes of the Notebook
+// reach a Service of its namespace, since the controller sends them the
+// token of the probe Secret.
+func validateProbeAnnotations(instance *v1beta1.Notebook) field.ErrorList {
+	errs := field.ErrorList{}
+	annotations := instance.GetAnnotations()
+	annotationsPath := field.NewPath("metadata", "annotations")
+
+	if scheme, ok := annotations[culler.ACTIVITY_PROBE_SCHEME_ANNOTATION]; ok && scheme != "http" && scheme != "https" {
+		errs = append(errs, field.NotSupported(annotationsPath.Key(culler.ACTIVITY_PROBE_SCHEME_ANNOTATION),
+			scheme, []string{"http", "https"}))
+	}
+	if svc, ok := annotations[culler.ACTIVITY_PROBE_SERVICE_ANNOTATION]; ok {
+		for _, msg := range culler.ValidateProbeService(svc) {
+			errs = append(errs, field.Invalid(annotationsPath.Key(culler.ACTIVITY_PROBE_SERVICE_ANNOTATION), svc, msg))
+		}
+	}
+	if port, ok := annotations[culler.ACTIVITY_PROBE_PORT_ANNOTATION]; ok {
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			errs = append(errs, field.Invalid(annotationsPath.Key(culler.ACTIVITY_PROBE_PORT_ANNOTATION), port,
+				"must be a port number between 1 and 65535"))
+		}
+	}
+	if path, ok := annotations[culler.ACTIVITY_PROBE_PATH_ANNOTATION]; ok {
+		for _, msg := range culler.ValidateProbePath(path) {
+			errs = append(errs, field.Invalid(annotationsPath.Key(culler.ACTIVITY_PROBE_PATH_ANNOTATION), path, msg))
+		}
+	}
+	if secret, ok := annotations[culler.ACTIVITY_PROBE_TOKEN_SECRET_ANNOTATION]; ok {
+		for _, msg := range validation.IsDNS1123Subdomain(secret) {
+			errs = append(errs, field.Invalid(annotationsPath.Key(culler.ACTIVITY_PROBE_TOKEN_SECRET_ANNOTATION), secret, msg))
+		}
+	}
+	return errs
+}
+
+// validateEndpoints checks that the endpoints of the Notebook have their own
+// Service ports and prefixes, distinct from the ones of the Notebook.
+func validateEndpoints(instance *v1beta1.Notebook) field.ErrorList {
+	errs := field.ErrorList{}
+	endpointsPath := field.NewPath("spec", "endpoints")
+	names := map[string]bool{}
+	ports := map[int32]bool{}
+	paths := map[string]bool{}
+	for i, endpoint := range instance.Spec.Endpoints {
+		endpointPath := endpointsPath.Index(i)
+		namePath := endpointPath.Child("name")
+		// The Service port of the Notebook is named after the Notebook
+		if endpoint.Name == instance.Name {
+			errs = append(errs, field.Invalid(namePath, endpoint.Name, "must differ from the name of the Notebook"))
+		} else if names[endpoint.Name] {
+			errs = append(errs, field.Duplicate(namePath, endpoint.Name))
+		}
+		names[endpoint.Name] = true
+		for _, msg := range validation.IsDNS1035Label(endpointPortName(endpoint)) {
+			errs = append(errs, field.Invalid(namePath, endpoint.Name, msg))
+		}
+
+		if endpoint.Port == DefaultServingPort {
+			errs = append(errs, field.Invalid(endpointPath.Child("port"), endpoint.Port,
+				"is the Service port of the Notebook"))
+		} else if ports[endpoint.Port] {
+			errs = append(errs, field.Duplicate(endpointPath.Child("port"), endpoint.Port))
+		}
+		ports[endpoint.Port] = true
+
+		prefix := endpointPrefix(instance, endpoint)
+		if strings.HasPrefix(endpoint.Path, "/") || !strings.HasSuffix(prefix, "/") {
+			errs = append(errs, field.Invalid(endpointPath.Child("path"), endpoint.Path,
+				"must be relative to the prefix of the Notebook and end with a /"))
+		} else if paths[prefix] {
+			errs = append(errs, field.Duplicate(endpointPath.Child("path"), endpoint.Path))
+		}
+		paths[prefix] = true
+
+		if len(endpoint.Rewrite) > 0 && !strings.HasPrefix(endpoint.Rewrite, "/") {
+			errs = append(errs, field.Invalid(endpointPath.Child("rewrite"), endpoint.Rewrite,
+				"must be an absolute path"))
+		}
+	}
+	return errs
+}
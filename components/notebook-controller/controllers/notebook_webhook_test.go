@@ -0,0 +1,327 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	nbv1beta1 "github.com/kubeflow/kubeflow/components/notebook-controller/api/v1beta1"
+	"github.com/kubeflow/kubeflow/components/notebook-controller/pkg/config"
+	"github.com/kubeflow/kubeflow/components/notebook-controller/pkg/culler"
+)
+
+func webhookNotebook(name string, containers ...corev1.Container) *nbv1beta1.Notebook {
+	return &nbv1beta1.Notebook{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec: nbv1beta1.NotebookSpec{
+			Template: nbv1beta1.NotebookTemplateSpec{
+				Spec: corev1.PodSpec{Containers: containers},
+			},
+		},
+	}
+}
+
+func TestNotebookWebhookDefault(t *testing.T) {
+	nb := webhookNotebook("test", corev1.Container{Name: "test", Image: "jupyter"})
+	if err := (&NotebookWebhook{}).Default(context.TODO(), nb); err != nil {
+		t.Fatal(err)
+	}
+
+	container := nb.Spec.Template.Spec.Containers[0]
+	if container.WorkingDir != DefaultWorkingDir {
+		t.Errorf("Expected the working directory to be defaulted, got %q", container.WorkingDir)
+	}
+	if len(container.Ports) != 1 || container.Ports[0].ContainerPort != DefaultContainerPort {
+		t.Errorf("Expected the default port, got %+v", container.Ports)
+	}
+	if len(container.Env) != 1 || container.Env[0].Name != PrefixEnvVar || container.Env[0].Value != "/notebook/default/test" {
+		t.Errorf("Expected the %s env var, got %+v", PrefixEnvVar, container.Env)
+	}
+
+	// The values of the user are kept
+	nb = webhookNotebook("test", corev1.Container{
+		Name:       "test",
+		WorkingDir: "/workspace",
+		Ports:      []corev1.ContainerPort{{ContainerPort: 8080}},
+	})
+	if err := (&NotebookWebhook{}).Default(context.TODO(), nb); err != nil {
+		t.Fatal(err)
+	}
+	container = nb.Spec.Template.Spec.Containers[0]
+	if container.WorkingDir != "/workspace" || container.Ports[0].ContainerPort != 8080 {
+		t.Errorf("Expected the container's values to be kept, got %+v", container)
+	}
+
+	// The Notebooks without containers are left to the validation
+	nb = webhookNotebook("test")
+	if err := (&NotebookWebhook{}).Default(context.TODO(), nb); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestNotebookWebhookValidateCreate(t *testing.T) {
+	tests := []struct {
+		name     string
+		notebook *nbv1beta1.Notebook
+		errs     []string
+	}{
+		{
+			name:     "valid",
+			notebook: webhookNotebook("test", corev1.Container{Name: "test"}, corev1.Container{Name: "sidecar"}),
+		},
+		{
+			name:     "no containers",
+			notebook: webhookNotebook("test"),
+			errs:     []string{"spec.template.spec.containers: Required value"},
+		},
+		{
+			name:     "first container named differently",
+			notebook: webhookNotebook("test", corev1.Container{Name: "notebook"}),
+			errs:     []string{`spec.template.spec.containers[0].name: Invalid value: "notebook": the first container must be named after the Notebook, "test"`},
+		},
+		{
+			name: "invalid headers annotation",
+			notebook: func() *nbv1beta1.Notebook {
+				nb := webhookNotebook("test", corev1.Container{Name: "test"})
+				nb.Annotations = map[string]string{AnnotationHeadersRequestSet: `{"X-User": 1}`}
+				return nb
+			}(),
+			errs: []string{"metadata.annotations[notebooks.kubeflow.org/http-headers-request-set]: Invalid value"},
+		},
+		{
+			name: "unknown image update policy",
+			notebook: func() *nbv1beta1.Notebook {
+				nb := webhookNotebook("test", corev1.Container{Name: "test"})
+				nb.Annotations = map[string]string{AnnotationImageUpdatePolicy: "Always"}
+				return nb
+			}(),
+			errs: []string{`metadata.annotations[notebooks.kubeflow.org/image-update-policy]: Unsupported value: "Always"`},
+		},
+		{
+			name: "valid activity probe annotations",
+			notebook: func() *nbv1beta1.Notebook {
+				nb := webhookNotebook("test", corev1.Container{Name: "test"})
+				nb.Annotations = map[string]string{
+					culler.ACTIVITY_PROBE_SCHEME_ANNOTATION:       "https",
+					culler.ACTIVITY_PROBE_SERVICE_ANNOTATION:      "test-tls",
+					culler.ACTIVITY_PROBE_PORT_ANNOTATION:         "8443",
+					culler.ACTIVITY_PROBE_PATH_ANNOTATION:         "/healthz",
+					culler.ACTIVITY_PROBE_TOKEN_SECRET_ANNOTATION: "test-token",
+				}
+				return nb
+			}(),
+		},
+		{
+			name: "invalid activity probe annotations",
+			notebook: func() *nbv1beta1.Notebook {
+				nb := webhookNotebook("test", corev1.Container{Name: "test"})
+				nb.Annotations = map[string]string{
+					culler.ACTIVITY_PROBE_SCHEME_ANNOTATION:       "ftp",
+					culler.ACTIVITY_PROBE_SERVICE_ANNOTATION:      "evil.example/x#",
+					culler.ACTIVITY_PROBE_PORT_ANNOTATION:         "0",
+					culler.ACTIVITY_PROBE_PATH_ANNOTATION:         "@evil.example/",
+					culler.ACTIVITY_PROBE_TOKEN_SECRET_ANNOTATION: "../token",
+				}
+				return nb
+			}(),
+			errs: []string{
+				`metadata.annotations[notebooks.kubeflow.org/activity-probe-scheme]: Unsupported value: "ftp"`,
+				`metadata.annotations[notebooks.kubeflow.org/activity-probe-service]: Invalid value: "evil.example/x#"`,
+				`metadata.annotations[notebooks.kubeflow.org/activity-probe-port]: Invalid value: "0"`,
+				`metadata.annotations[notebooks.kubeflow.org/activity-probe-path]: Invalid value: "@evil.example/": must start with '/'`,
+				`metadata.annotations[notebooks.kubeflow.org/activity-probe-path]: Invalid value: "@evil.example/": must not contain`,
+				`metadata.annotations[notebooks.kubeflow.org/activity-probe-token-secret]: Invalid value: "../token"`,
+			},
+		},
+		{
+			name: "valid endpoints",
+			notebook: func() *nbv1beta1.Notebook {
+				nb := webhookNotebook("test", corev1.Container{Name: "test"})
+				nb.Spec.Endpoints = []nbv1beta1.NotebookEndpoint{
+					{Name: "tensorboard", Port: 6006},
+					{Name: "api", Port: 8000, Path: "v1/api/", Rewrite: "/"},
+				}
+				return nb
+			}(),
+		},
+		{
+			name: "invalid endpoints",
+			notebook: func() *nbv1beta1.Notebook {
+				nb := webhookNotebook("test", corev1.Container{Name: "test"})
+				nb.Spec.Endpoints = []nbv1beta1.NotebookEndpoint{
+					{Name: "test", Port: 80},
+					{Name: "api", Port: 8000, Path: "/api", Rewrite: "api/"},
+					{Name: "api", Port: 8000, Path: "test/"},
+					{Name: "Docs", Port: 8001, Path: "test/"},
+				}
+				return nb
+			}(),
+			errs: []string{
+				`spec.endpoints[0].name: Invalid value: "test": must differ from the name of the Notebook`,
+				"spec.endpoints[0].port: Invalid value: 80: is the Service port of the Notebook",
+				`spec.endpoints[1].path: Invalid value: "/api"`,
+				`spec.endpoints[1].rewrite: Invalid value: "api/": must be an absolute path`,
+				`spec.endpoints[2].name: Duplicate value: "api"`,
+				"spec.endpoints[2].port: Duplicate value: 8000",
+				`spec.endpoints[3].name: Invalid value: "Docs"`,
+				`spec.endpoints[3].path: Duplicate value: "test/"`,
+			},
+		},
+		{
+			name: "volume named like the workspace",
+			notebook: func() *nbv1beta1.Notebook {
+				nb := webhookNotebook("test", corev1.Container{Name: "test"})
+				nb.Spec.Template.Spec.Volumes = []corev1.Volume{{Name: "dshm"}, {Name: workspaceVolumeName}}
+				nb.Spec.Workspace = &nbv1beta1.NotebookWorkspace{Size: resource.MustParse("10Gi")}
+				return nb
+			}(),
+			errs: []string{`spec.template.spec.volumes[1].name: Invalid value: "notebook-workspace": is reserved for the workspace of the Notebook`},
+		},
+		{
+			name:     "name too long",
+			notebook: webhookNotebook(strings.Repeat("a", 53), corev1.Container{Name: strings.Repeat("a", 53)}),
+			errs:     []string{"metadata.name: Too long"},
+		},
+		{
+			name:     "name starting with a digit",
+			notebook: webhookNotebook("1test", corev1.Container{Name: "1test"}),
+			errs:     []string{"metadata.name: Invalid value"},
+		},
+		{
+			name:     "all errors",
+			notebook: webhookNotebook(strings.Repeat("a", 53)),
+			errs:     []string{"metadata.name: Too long", "spec.template.spec.containers: Required value"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := (&NotebookWebhook{}).ValidateCreate(context.TODO(), test.notebook)
+			if len(test.errs) == 0 {
+				if err != nil {
+					t.Errorf("Unexpected error: %v", err)
+				}
+				return
+			}
+			if !apierrs.IsInvalid(err) {
+				t.Fatalf("Expected an Invalid error, got %v", err)
+			}
+			for _, msg := range test.errs {
+				if !strings.Contains(err.Error(), msg) {
+					t.Errorf("Expected error %q to contain %q", err, msg)
+				}
+			}
+		})
+	}
+}
+
+func TestNotebookWebhookValidateUpdate(t *testing.T) {
+	w := &NotebookWebhook{}
+	// A Notebook created before the webhook
+	old := webhookNotebook("test", corev1.Container{Name: "notebook"})
+
+	updated := old.DeepCopy()
+	updated.Annotations = map[string]string{"notebooks.kubeflow.org/last-activity": "now"}
+	if err := w.ValidateUpdate(context.TODO(), old, updated); err != nil {
+		t.Errorf("Expected the metadata of an invalid Notebook to be updatable, got %v", err)
+	}
+
+	updated = old.DeepCopy()
+	updated.Annotations = map[string]string{culler.ACTIVITY_PROBE_PATH_ANNOTATION: "/healthz"}
+	if err := w.ValidateUpdate(context.TODO(), old, updated); !apierrs.IsInvalid(err) {
+		t.Errorf("Expected the activity probe annotations to be validated, got %v", err)
+	}
+
+	updated = old.DeepCopy()
+	updated.Spec.Template.Spec.Containers[0].Image = "jupyter"
+	if err := w.ValidateUpdate(context.TODO(), old, updated); !apierrs.IsInvalid(err) {
+		t.Errorf("Expected the spec update to be validated, got %v", err)
+	}
+
+	now := metav1.Now()
+	updated.DeletionTimestamp = &now
+	if err := w.ValidateUpdate(context.TODO(), old, updated); err != nil {
+		t.Errorf("Expected the Notebook being deleted to be updatable, got %v", err)
+	}
+}
+
+func TestNotebookWebhookHandleSetsCreator(t *testing.T) {
+	decoder, err := admission.NewDecoder(limitsScheme())
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := &NotebookWebhook{}
+	if err := w.InjectDecoder(decoder); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		user    string
+		creator string
+		result  string
+	}{
+		{
+			name:   "user of the request",
+			user:   "alice@example.com",
+			result: "alice@example.com",
+		},
+		{
+			name:    "creator set by a trusted client",
+			user:    config.DefaultTrustedClient,
+			creator: "alice@example.com",
+			result:  "alice@example.com",
+		},
+		{
+			name:    "creator set by another client",
+			user:    "mallory@example.com",
+			creator: "alice@example.com",
+			result:  "mallory@example.com",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			nb := webhookNotebook("test", corev1.Container{Name: "test"})
+			if test.creator != "" {
+				nb.Annotations = map[string]string{AnnotationCreator: test.creator}
+			}
+			raw, err := json.Marshal(nb)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req := admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+				Operation: admissionv1.Create,
+				Object:    runtime.RawExtension{Raw: raw},
+				UserInfo:  authenticationv1.UserInfo{Username: test.user},
+			}}
+			resp := w.Handle(context.TODO(), req)
+			if !resp.Allowed {
+				t.Fatalf("Expected the Notebook to be allowed, got %+v", resp.Result)
+			}
+
+			creator := test.creator
+			for _, patch := range resp.Patches {
+				switch patch.Path {
+				case "/metadata/annotations":
+					creator, _ = patch.Value.(map[string]interface{})[AnnotationCreator].(string)
+				case "/metadata/annotations/notebooks.kubeflow.org~1creator":
+					creator, _ = patch.Value.(string)
+				}
+			}
+			if creator != test.result {
+				t.Errorf("Got creator %q, expected %q", creator, test.result)
+			}
+		})
+	}
+}
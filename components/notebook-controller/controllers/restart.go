@@ -0,0 +1,224 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	"github.com/kubeflow/kubeflow/components/notebook-controller/api/v1beta1"
+	"github.com/kubeflow/kubeflow/components/notebook-controller/pkg/config"
+	"github.com/kubeflow/kubeflow/components/notebook-controller/pkg/culler"
+	"github.com/kubeflow/kubeflow/components/notebook-controller/pkg/registry"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	// AnnotationRestartRequestedAt restarts the Notebook's Pod whenever its
+	// value changes. It is usually set to the current time.
+	AnnotationRestartRequestedAt = "notebooks.kubeflow.org/restart-requested-at"
+	// AnnotationRestartedAt is the restart stamp of the Pod template. It
+	// holds the value of AnnotationRestartRequestedAt, so that changing the
+	// latter rolls the Pod.
+	AnnotationRestartedAt = "notebooks.kubeflow.org/restarted-at"
+	// AnnotationImageUpdatePolicy selects how the image of the Notebook's
+	// first container is updated, one of the ImageUpdatePolicy values.
+	AnnotationImageUpdatePolicy = "notebooks.kubeflow.org/image-update-policy"
+)
+
+// Image update policies
+const (
+	// ImageUpdatePolicyNone leaves pulling the image to the kubelet and its
+	// imagePullPolicy.
+	ImageUpdatePolicyNone = "None"
+	// ImageUpdatePolicyDigest periodically resolves the tag of the image, and
+	// pins the Pod to the digest it points to, so that the Pod is rolled when
+	// the tag is pushed again.
+	ImageUpdatePolicyDigest = "Digest"
+)
+
+// ImageResolver resolves the tag of an image to the digest of its manifest.
+type ImageResolver interface {
+	Resolve(ctx context.Context, image string, creds registry.Credentials) (string, error)
+}
+
+func imageUpdatePolicy(nb *v1beta1.Notebook) string {
+	if policy := nb.GetAnnotations()[AnnotationImageUpdatePolicy]; policy != "" {
+		return policy
+	}
+	return ImageUpdatePolicyNone
+}
+
+// reconcileImage returns the image status of a Notebook with the Digest image
+// update policy. Its image is resolved again in the background if the
+// previous resolution is older than the check period, and the previous status
+// is kept until the resolution is done. A failed resolution keeps the
+// previous digest, so that the Pod isn't rolled because the registry is
+// unreachable.
+func (r *NotebookReconciler) reconcileImage(ctx context.Context, nb *v1beta1.Notebook) *v1beta1.ImageStatus {
+	containers := nb.Spec.Template.Spec.Containers
+	if r.Images == nil || imageUpdatePolicy(nb) != ImageUpdatePolicyDigest || len(containers) == 0 {
+		return nil
+	}
+	image := containers[0].Image
+	if ref, err := registry.ParseReference(image); err == nil && ref.Digest != "" {
+		// The image is already pinned
+		return nil
+	}
+
+	previous := nb.Status.Image
+	if previous != nil && previous.Image != image {
+		previous = nil
+	}
+	// The stopped Notebooks are resolved when they start
+	if culler.StopAnnotationIsSet(nb.ObjectMeta) {
+		return previous
+	}
+	checkPeriod := config.Get().ImageUpdate.CheckPeriod.Duration
+	if previous != nil && time.Since(previous.LastResolveTime.Time) < checkPeriod {
+		return previous
+	}
+
+	key := types.NamespacedName{Name: nb.Name, Namespace: nb.Namespace}
+	resolution, ok := r.Images.result(key, image)
+	if !ok {
+		r.Images.request(key, image, r.imagePullCredentials(ctx, nb))
+		return previous
+	}
+
+	status := &v1beta1.ImageStatus{Image: image, LastResolveTime: resolution.time}
+	if err := resolution.err; err != nil {
+		r.Log.Error(err, "unable to resolve the image", "notebook", key, "image", image)
+		r.EventRecorder.Eventf(nb, corev1.EventTypeWarning, "ImageResolveFailed",
+			"Unable to resolve the digest of %s: %v", image, err)
+		status.Message = err.Error()
+		if previous != nil {
+			status.Digest = previous.Digest
+		}
+		return status
+	}
+	status.Digest = resolution.digest
+	return status
+}
+
+// imagePullCredentials returns the credentials of the imagePullSecrets of
+// the Notebook. The Secrets are read from the API server, as the controller
+// doesn't cache them.
+func (r *NotebookReconciler) imagePullCredentials(ctx context.Context, nb *v1beta1.Notebook) registry.Credentials {
+	creds := registry.Credentials{}
+	if r.APIReader == nil {
+		return creds
+	}
+	for _, ref := range nb.Spec.Template.Spec.ImagePullSecrets {
+		secret := &corev1.Secret{}
+		key := types.NamespacedName{Name: ref.Name, Namespace: nb.Namespace}
+		if err := r.APIReader.Get(ctx, key, secret); err != nil {
+			r.Log.Error(err, "unable to get the image pull secret", "notebook", nb.Namespace+"/"+nb.Name,
+				"secret", ref.Name)
+			continue
+		}
+		data, ok := secret.Data[corev1.DockerConfigJsonKey]
+		if !ok {
+			data = secret.Data[corev1.DockerConfigKey]
+		}
+		secretCreds, err := registry.CredentialsFromDockerConfig(data)
+		if err != nil {
+			r.Log.Error(err, "invalid image pull secret", "notebook", nb.Namespace+"/"+nb.Name,
+				"secret", ref.Name)
+			continue
+		}
+		for host, cred := range secretCreds {
+			creds[host] = cred
+		}
+	}
+	return creds
+}
+
+// pinImage replaces the image of the first container of the StatefulSet with
+// the digest it resolved to.
+func pinImage(ss *appsv1.StatefulSet, status *v1beta1.ImageStatus) {
+	containers := ss.Spec.Template.Spec.Containers
+	if status == nil || status.Digest == "" || len(containers) == 0 || containers[0].Image != status.Image {
+		return
+	}
+	ref, err := registry.ParseReference(status.Image)
+	if err != nil {
+		return
+	}
+	containers[0].Image = ref.Name + "@" + status.Digest
+}
+
+// setRestartStamp stamps the Pod template with the last restart requested on
+// the Notebook.
+func setRestartStamp(nb *v1beta1.Notebook, ss *appsv1.StatefulSet) {
+	requestedAt := nb.GetAnnotations()[AnnotationRestartRequestedAt]
+	if requestedAt == "" {
+		return
+	}
+	if ss.Spec.Template.Annotations == nil {
+		ss.Spec.Template.Annotations = map[string]string{}
+	}
+	ss.Spec.Template.Annotations[AnnotationRestartedAt] = requestedAt
+}
+
+// copyRestartStamp copies the restart stamp of the Pod template, and returns
+// true if it changed. A stamp is never removed, so that removing the
+// annotation of the Notebook doesn't restart it.
+func copyRestartStamp(from, to *appsv1.StatefulSet) bool {
+	stamp := from.Spec.Template.Annotations[AnnotationRestartedAt]
+	if stamp == "" || to.Spec.Template.Annotations[AnnotationRestartedAt] == stamp {
+		return false
+	}
+	if to.Spec.Template.Annotations == nil {
+		to.Spec.Template.Annotations = map[string]string{}
+	}
+	to.Spec.Template.Annotations[AnnotationRestartedAt] = stamp
+	return true
+}
+
+// reconcileRestart returns the restart status of the Notebook, recording a
+// restart if the Pod template was stamped with a new restart request, or if
+// the image resolved to a new digest.
+func (r *NotebookReconciler) reconcileRestart(nb *v1beta1.Notebook, stamped bool,
+	image *v1beta1.ImageStatus) *v1beta1.RestartStatus {
+
+	restart := nb.Status.Restart.DeepCopy()
+	previous := nb.Status.Image
+	switch {
+	case stamped:
+		requestedAt := nb.GetAnnotations()[AnnotationRestartRequestedAt]
+		restart = &v1beta1.RestartStatus{
+			RequestedAt:     requestedAt,
+			Reason:          v1beta1.RestartReasonRequested,
+			LastRestartTime: metav1.Now(),
+		}
+		r.EventRecorder.Eventf(nb, corev1.EventTypeNormal, "Restarting",
+			"Restarting the Notebook as requested at %s", requestedAt)
+	case image != nil && previous != nil && previous.Image == image.Image &&
+		previous.Digest != "" && previous.Digest != image.Digest:
+		if restart == nil {
+			restart = &v1beta1.RestartStatus{}
+		}
+		restart.Reason = v1beta1.RestartReasonImageUpdated
+		restart.LastRestartTime = metav1.Now()
+		r.EventRecorder.Eventf(nb, corev1.EventTypeNormal, "ImageUpdated",
+			"Restarting the Notebook on the new digest %s of %s", image.Digest, image.Image)
+	}
+	return restart
+}
@@ -0,0 +1,216 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	nbv1beta1 "github.com/kubeflow/kubeflow/components/notebook-controller/api/v1beta1"
+	"github.com/kubeflow/kubeflow/components/notebook-controller/pkg/config"
+	"github.com/kubeflow/kubeflow/components/notebook-controller/pkg/culler"
+	"github.com/kubeflow/kubeflow/components/notebook-controller/pkg/registry"
+)
+
+// fakeResolver resolves every image to its digest, and records the
+// credentials it was given.
+type fakeResolver struct {
+	digest   string
+	err      error
+	resolved int
+	creds    registry.Credentials
+}
+
+func (f *fakeResolver) Resolve(ctx context.Context, image string, creds registry.Credentials) (string, error) {
+	f.resolved++
+	f.creds = creds
+	return f.digest, f.err
+}
+
+func restartNotebook(annotations map[string]string) *nbv1beta1.Notebook {
+	return &nbv1beta1.Notebook{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default", Annotations: annotations},
+		Spec: nbv1beta1.NotebookSpec{
+			Template: nbv1beta1.NotebookTemplateSpec{
+				Spec: corev1.PodSpec{Containers: []corev1.Container{{
+					Name:  "test",
+					Image: "kubeflownotebookswg/jupyter:v1",
+				}}},
+			},
+		},
+	}
+}
+
+func TestReconcileImage(t *testing.T) {
+	defer config.Set(nil)
+	config.Set(config.FromEnv())
+	digestPolicy := map[string]string{AnnotationImageUpdatePolicy: ImageUpdatePolicyDigest}
+	resolver := &fakeResolver{digest: "sha256:new"}
+	recorder := record.NewFakeRecorder(10)
+	images := NewImageResolutions()
+	images.Resolver = resolver
+	r := &NotebookReconciler{Log: logr.Discard(), EventRecorder: recorder, Images: images}
+	// resolve runs the pending resolution, as the workers do
+	resolve := func() {
+		if images.queue.Len() == 0 {
+			t.Fatalf("Expected a pending resolution")
+		}
+		images.processNextItem(context.TODO())
+		<-images.events
+	}
+
+	if status := r.reconcileImage(context.TODO(), restartNotebook(nil)); status != nil {
+		t.Errorf("Expected no image status without the Digest policy, got %+v", status)
+	}
+
+	// The image is resolved in the background
+	nb := restartNotebook(digestPolicy)
+	if status := r.reconcileImage(context.TODO(), nb); status != nil || resolver.resolved != 0 {
+		t.Fatalf("Expected the resolution to be pending, got %+v", status)
+	}
+	resolve()
+	status := r.reconcileImage(context.TODO(), nb)
+	if status == nil || status.Image != "kubeflownotebookswg/jupyter:v1" || status.Digest != "sha256:new" {
+		t.Fatalf("Expected the image to be resolved, got %+v", status)
+	}
+
+	// The image is resolved once per check period
+	nb.Status.Image = status
+	resolver.digest = "sha256:newer"
+	if status := r.reconcileImage(context.TODO(), nb); status.Digest != "sha256:new" || resolver.resolved != 1 {
+		t.Errorf("Expected the previous resolution to be reused, got %+v", status)
+	}
+
+	// A failure keeps the previous digest
+	nb.Status.Image.LastResolveTime = metav1.NewTime(time.Now().Add(-2 * config.DefaultImageUpdateCheckPeriod))
+	resolver.err = errors.New("registry unavailable")
+	if status := r.reconcileImage(context.TODO(), nb); status != nb.Status.Image {
+		t.Errorf("Expected the previous status while resolving, got %+v", status)
+	}
+	resolve()
+	status = r.reconcileImage(context.TODO(), nb)
+	if status.Digest != "sha256:new" || status.Message != "registry unavailable" {
+		t.Errorf("Expected the previous digest and the error, got %+v", status)
+	}
+	if len(recorder.Events) != 1 {
+		t.Errorf("Expected an ImageResolveFailed event")
+	}
+
+	// The stopped Notebooks are not resolved
+	resolver.err = nil
+	stopped := nb.DeepCopy()
+	stopped.Annotations[culler.STOP_ANNOTATION] = "2022-01-01T00:00:00Z"
+	if status := r.reconcileImage(context.TODO(), stopped); status != stopped.Status.Image || resolver.resolved != 2 {
+		t.Errorf("Expected the stopped Notebook to keep its image status, got %+v", status)
+	}
+
+	// A pinned image has nothing to resolve
+	pinned := restartNotebook(digestPolicy)
+	pinned.Spec.Template.Spec.Containers[0].Image = "jupyter@sha256:abc"
+	if status := r.reconcileImage(context.TODO(), pinned); status != nil {
+		t.Errorf("Expected no image status for a pinned image, got %+v", status)
+	}
+}
+
+func TestImagePullCredentials(t *testing.T) {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "pull", Namespace: "default"},
+		Type:       corev1.SecretTypeDockerConfigJson,
+		Data: map[string][]byte{
+			corev1.DockerConfigJsonKey: []byte(`{"auths": {"quay.io": {"username": "alice", "password": "secret"}}}`),
+		},
+	}
+	r := &NotebookReconciler{
+		Log:       logr.Discard(),
+		APIReader: fake.NewClientBuilder().WithScheme(limitsScheme()).WithObjects(secret).Build(),
+	}
+	nb := restartNotebook(nil)
+	nb.Spec.Template.Spec.ImagePullSecrets = []corev1.LocalObjectReference{{Name: "missing"}, {Name: "pull"}}
+
+	creds := r.imagePullCredentials(context.TODO(), nb)
+	if cred := creds["quay.io"]; cred.Username != "alice" || cred.Password != "secret" {
+		t.Errorf("Expected the credentials of the pull secret, got %v", creds)
+	}
+}
+
+func TestPinImage(t *testing.T) {
+	nb := restartNotebook(nil)
+	ss := generateStatefulSet(nb)
+
+	pinImage(ss, &nbv1beta1.ImageStatus{Image: "kubeflownotebookswg/jupyter:v2", Digest: "sha256:abc"})
+	if image := ss.Spec.Template.Spec.Containers[0].Image; image != "kubeflownotebookswg/jupyter:v1" {
+		t.Errorf("Expected the digest of another image to be ignored, got %q", image)
+	}
+
+	pinImage(ss, &nbv1beta1.ImageStatus{Image: "kubeflownotebookswg/jupyter:v1", Digest: "sha256:abc"})
+	if image := ss.Spec.Template.Spec.Containers[0].Image; image != "kubeflownotebookswg/jupyter@sha256:abc" {
+		t.Errorf("Expected the image to be pinned, got %q", image)
+	}
+}
+
+func TestCopyRestartStamp(t *testing.T) {
+	found := generateStatefulSet(restartNotebook(nil))
+
+	if copyRestartStamp(generateStatefulSet(restartNotebook(nil)), found) {
+		t.Errorf("Expected no stamp without a restart request")
+	}
+
+	requested := generateStatefulSet(restartNotebook(map[string]string{
+		AnnotationRestartRequestedAt: "2022-08-30T16:37:36Z",
+	}))
+	if !copyRestartStamp(requested, found) ||
+		found.Spec.Template.Annotations[AnnotationRestartedAt] != "2022-08-30T16:37:36Z" {
+		t.Errorf("Expected the Pod template to be stamped, got %v", found.Spec.Template.Annotations)
+	}
+	if copyRestartStamp(requested, found) {
+		t.Errorf("Expected the same request to be honored once")
+	}
+
+	// Removing the annotation doesn't restart the Notebook
+	if copyRestartStamp(generateStatefulSet(restartNotebook(nil)), found) ||
+		found.Spec.Template.Annotations[AnnotationRestartedAt] == "" {
+		t.Errorf("Expected the stamp to be kept")
+	}
+}
+
+func TestReconcileRestart(t *testing.T) {
+	recorder := record.NewFakeRecorder(10)
+	r := &NotebookReconciler{Log: logr.Discard(), EventRecorder: recorder}
+	nb := restartNotebook(map[string]string{AnnotationRestartRequestedAt: "2022-08-30T16:37:36Z"})
+
+	restart := r.reconcileRestart(nb, true, nil)
+	if restart == nil || restart.RequestedAt != "2022-08-30T16:37:36Z" ||
+		restart.Reason != nbv1beta1.RestartReasonRequested || restart.LastRestartTime.IsZero() {
+		t.Fatalf("Expected the requested restart to be recorded, got %+v", restart)
+	}
+	nb.Status.Restart = restart
+
+	if unchanged := r.reconcileRestart(nb, false, nil); *unchanged != *restart || len(recorder.Events) != 1 {
+		t.Errorf("Expected nothing to be recorded without a new request, got %+v", unchanged)
+	}
+
+	nb.Status.Image = &nbv1beta1.ImageStatus{Image: "kubeflownotebookswg/jupyter:v1", Digest: "sha256:old"}
+	restart = r.reconcileRestart(nb, false, &nbv1beta1.ImageStatus{
+		Image:  "kubeflownotebookswg/jupyter:v1",
+		Digest: "sha256:new",
+	})
+	if restart.Reason != nbv1beta1.RestartReasonImageUpdated || restart.RequestedAt != "2022-08-30T16:37:36Z" {
+		t.Errorf("Expected the image update to be recorded, got %+v", restart)
+	}
+
+	expected := []string{
+		"Normal Restarting Restarting the Notebook as requested at 2022-08-30T16:37:36Z",
+		"Normal ImageUpdated Restarting the Notebook on the new digest sha256:new of kubeflownotebookswg/jupyter:v1",
+	}
+	for _, e := range expected {
+		if got := <-recorder.Events; got != e {
+			t.Errorf("Expected event %q, got %q", e, got)
+		}
+	}
+}
@@ -0,0 +1,693 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	reconcilehelper "github.com/kubeflow/kubeflow/components/common/reconcilehelper"
+	"github.com/kubeflow/kubeflow/components/notebook-controller/api/v1beta1"
+	"github.com/kubeflow/kubeflow/components/notebook-controller/pkg/config"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Routing backends, picked with routing.backend in the configuration.
+const (
+	RoutingBackendIstio   = config.RoutingBackendIstio
+	RoutingBackendIngress = config.RoutingBackendIngress
+	RoutingBackendGateway = config.RoutingBackendGateway
+	RoutingBackendNone    = config.RoutingBackendNone
+)
+
+// Annotations of the Ingresses understood by ingress-nginx
+const (
+	ingressNginxUseRegex        = "nginx.ingress.kubernetes.io/use-regex"
+	ingressNginxRewriteTarget   = "nginx.ingress.kubernetes.io/rewrite-target"
+	ingressNginxProxySetHeaders = "nginx.ingress.kubernetes.io/proxy-set-headers"
+)
+
+// headerNameRegexp and headerValueRegexp restrict the request headers set by
+// an Ingress to the ones that can be safely written in an nginx directive,
+// which ingress-nginx does with the values of the proxy-set-headers
+// ConfigMap.
+var (
+	headerNameRegexp  = regexp.MustCompile("^[A-Za-z0-9-]+$")
+	headerValueRegexp = regexp.MustCompile(`^[^"\\;{}$\x00-\x1f\x7f]*$`)
+)
+
+// RoutingBackend exposes the Notebooks under their /notebook/<namespace>/<name>/
+// prefix with an object of a routing API.
+type RoutingBackend interface {
+	// Name returns the name of the backend in the configuration.
+	Name() string
+	// Generate returns the route of the Notebook to its Service, or to the
+	// activator if toActivator is true.
+	Generate(instance *v1beta1.Notebook, toActivator bool) (client.Object, error)
+	// NewObject returns an empty object of the kind of the routes.
+	NewObject() client.Object
+	// Copy copies the fields of the desired route to the existing one, and
+	// returns true if they changed.
+	Copy(from, to client.Object) bool
+	// URLs returns the external URLs of the Notebook exposed by its route.
+	URLs(instance *v1beta1.Notebook, route client.Object) []string
+}
+
+// newRoutingBackend returns the routing backend in the configuration, or nil
+// if the Notebooks aren't routed.
+func newRoutingBackend() (RoutingBackend, error) {
+	switch name := config.Get().Routing.Backend; name {
+	case RoutingBackendIstio:
+		return istioBackend{}, nil
+	case RoutingBackendIngress:
+		return ingressBackend{}, nil
+	case RoutingBackendGateway:
+		return gatewayBackend{}, nil
+	case RoutingBackendNone:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unknown routing backend %q, should be one of %s, %s, %s or %s",
+			name, RoutingBackendIstio, RoutingBackendIngress, RoutingBackendGateway, RoutingBackendNone)
+	}
+}
+
+// routesToActivator returns true if the backend sends the requests for the
+// Notebook to the activator. Ingresses can only route to the Services of
+// their namespace, so they always route to the Notebook.
+func routesToActivator(backend RoutingBackend, instance *v1beta1.Notebook, sts *appsv1.StatefulSet) bool {
+	if _, ok := backend.(ingressBackend); ok {
+		return false
+	}
+	return routeToActivator(instance, sts)
+}
+
+// notebookPrefix returns the path prefix of the Notebook.
+func notebookPrefix(instance *v1beta1.Notebook) string {
+	return fmt.Sprintf("/notebook/%s/%s/", instance.Namespace, instance.Name)
+}
+
+// rewriteURI returns the path the prefix of the Notebook is rewritten to,
+// from the AnnotationRewriteURI annotation.
+func rewriteURI(instance *v1beta1.Notebook) string {
+	if rewrite := instance.GetAnnotations()[AnnotationRewriteURI]; len(rewrite) > 0 {
+		return rewrite
+	}
+	return notebookPrefix(instance)
+}
+
+// endpointPrefix returns the prefix of an endpoint of the Notebook, its path
+// appended to the prefix of the Notebook.
+func endpointPrefix(instance *v1beta1.Notebook, endpoint v1beta1.NotebookEndpoint) string {
+	if len(endpoint.Path) > 0 {
+		return notebookPrefix(instance) + endpoint.Path
+	}
+	return notebookPrefix(instance) + endpoint.Name + "/"
+}
+
+// endpointRewrite returns the path the prefix of an endpoint is rewritten to.
+func endpointRewrite(instance *v1beta1.Notebook, endpoint v1beta1.NotebookEndpoint) string {
+	if len(endpoint.Rewrite) > 0 {
+		return endpoint.Rewrite
+	}
+	return endpointPrefix(instance, endpoint)
+}
+
+// endpointPortName returns the name of the Service port of an endpoint.
+func endpointPortName(endpoint v1beta1.NotebookEndpoint) string {
+	return "http-" + endpoint.Name
+}
+
+// requestHeaders returns the headers set on the requests for the Notebook,
+// from the AnnotationHeadersRequestSet annotation. Invalid JSON is ignored.
+func requestHeaders(instance *v1beta1.Notebook) map[string]string {
+	headers := make(map[string]string)
+	if value := instance.GetAnnotations()[AnnotationHeadersRequestSet]; len(value) > 0 {
+		if err := json.Unmarshal([]byte(value), &headers); err != nil {
+			// if JSON decoding fails, set an empty map
+			headers = make(map[string]string)
+		}
+	}
+	return headers
+}
+
+// sortedHeaderNames returns the names of the headers in a stable order, so
+// that the generated routes don't change between reconciliations.
+func sortedHeaderNames(headers map[string]string) []string {
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// istioBackend routes the Notebooks with Istio VirtualServices.
+type istioBackend struct{}
+
+func (istioBackend) Name() string {
+	return RoutingBackendIstio
+}
+
+func (istioBackend) Generate(instance *v1beta1.Notebook, toActivator bool) (client.Object, error) {
+	return generateVirtualService(instance, toActivator)
+}
+
+func (istioBackend) NewObject() client.Object {
+	virtualService := &unstructured.Unstructured{}
+	virtualService.SetAPIVersion("networking.istio.io/v1alpha3")
+	virtualService.SetKind("VirtualService")
+	return virtualService
+}
+
+func (istioBackend) Copy(from, to client.Object) bool {
+	return reconcilehelper.CopyVirtualService(from.(*unstructured.Unstructured), to.(*unstructured.Unstructured))
+}
+
+// URLs returns the URLs of the hosts of the VirtualService, or of the
+// external URL of the configuration when it matches any host of the Istio
+// gateway.
+func (istioBackend) URLs(instance *v1beta1.Notebook, route client.Object) []string {
+	hosts, _, _ := unstructured.NestedStringSlice(route.(*unstructured.Unstructured).Object, "spec", "hosts")
+	return externalURLs(instance, hosts)
+}
+
+// ingressBackend routes the Notebooks with networking.k8s.io/v1 Ingresses.
+// The prefix is rewritten and the request headers are set with the
+// annotations of ingress-nginx.
+type ingressBackend struct{}
+
+func (ingressBackend) Name() string {
+	return RoutingBackendIngress
+}
+
+func (ingressBackend) Generate(instance *v1beta1.Notebook, toActivator bool) (client.Object, error) {
+	return generateIngress(instance), nil
+}
+
+func (ingressBackend) NewObject() client.Object {
+	return &networkingv1.Ingress{}
+}
+
+func (ingressBackend) Copy(from, to client.Object) bool {
+	fromIngress := from.(*networkingv1.Ingress)
+	toIngress := to.(*networkingv1.Ingress)
+
+	requireUpdate := false
+	if !reflect.DeepEqual(fromIngress.Annotations, toIngress.Annotations) {
+		toIngress.Annotations = fromIngress.Annotations
+		requireUpdate = true
+	}
+	if !reflect.DeepEqual(fromIngress.Spec, toIngress.Spec) {
+		toIngress.Spec = fromIngress.Spec
+		requireUpdate = true
+	}
+	return requireUpdate
+}
+
+// URLs returns the URL of the host of the Ingress, or of its load balancer
+// when the Ingress matches any host. The scheme is https if the Ingress
+// terminates TLS for the host. Until the load balancer is known, it is the
+// external URL of the configuration.
+func (ingressBackend) URLs(instance *v1beta1.Notebook, route client.Object) []string {
+	ingress := route.(*networkingv1.Ingress)
+	hosts := []string{}
+	for _, rule := range ingress.Spec.Rules {
+		if rule.Host != "" {
+			hosts = append(hosts, rule.Host)
+		}
+	}
+	if len(hosts) == 0 {
+		for _, lb := range ingress.Status.LoadBalancer.Ingress {
+			if lb.Hostname != "" {
+				hosts = append(hosts, lb.Hostname)
+			} else if lb.IP != "" {
+				hosts = append(hosts, lb.IP)
+			}
+		}
+	}
+	if len(hosts) == 0 {
+		return externalURLs(instance, nil)
+	}
+
+	urls := []string{}
+	for _, host := range hosts {
+		scheme := "http"
+		for _, tls := range ingress.Spec.TLS {
+			if len(tls.Hosts) == 0 || containsString(tls.Hosts, host) {
+				scheme = "https"
+			}
+		}
+		urls = append(urls, fmt.Sprintf("%s://%s%s", scheme, host, notebookPrefix(instance)))
+	}
+	return urls
+}
+
+// externalURLs returns the URLs of the Notebook on the hosts of its route,
+// skipping the wildcard ones, or on the host of the external URL of the
+// configuration. The scheme is the one of the external URL, as TLS is
+// terminated by the gateway. Without an external URL, only the prefix of the
+// Notebook is known.
+func externalURLs(instance *v1beta1.Notebook, hosts []string) []string {
+	external, err := url.Parse(config.Get().Routing.ExternalURL)
+	if err != nil || external.Host == "" {
+		return []string{notebookPrefix(instance)}
+	}
+
+	names := []string{}
+	for _, host := range hosts {
+		if host != "" && !strings.Contains(host, "*") {
+			names = append(names, host)
+		}
+	}
+	if len(names) == 0 {
+		names = append(names, external.Host)
+	}
+	urls := []string{}
+	for _, host := range names {
+		u := url.URL{Scheme: external.Scheme, Host: host, Path: notebookPrefix(instance)}
+		urls = append(urls, u.String())
+	}
+	return urls
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// generateIngress routes the Notebook's prefix, and the prefixes of its
+// endpoints, to its Service.
+func generateIngress(instance *v1beta1.Notebook) *networkingv1.Ingress {
+	prefix := notebookPrefix(instance)
+	path := prefix
+	pathType := networkingv1.PathTypePrefix
+	annotations := map[string]string{}
+
+	if rewrite := rewriteURI(instance); rewrite != prefix {
+		path = prefix + "(.*)"
+		pathType = networkingv1.PathTypeImplementationSpecific
+		annotations[ingressNginxUseRegex] = "true"
+		annotations[ingressNginxRewriteTarget] = rewrite + "$1"
+	}
+
+	// The snippet annotations are disabled by default since ingress-nginx
+	// 1.9, so the headers are set with a ConfigMap
+	if headers := generateIngressHeaders(instance); headers != nil {
+		annotations[ingressNginxProxySetHeaders] = headers.Namespace + "/" + headers.Name
+	}
+
+	if len(annotations) == 0 {
+		annotations = nil
+	}
+
+	var ingressClassName *string
+	if className := config.Get().Routing.IngressClassName; className != "" {
+		ingressClassName = &className
+	}
+
+	paths := []networkingv1.HTTPIngressPath{}
+	for _, endpoint := range instance.Spec.Endpoints {
+		if !ingressRoutesEndpoint(instance, endpoint) {
+			continue
+		}
+		paths = append(paths, ingressPath(instance, endpointPrefix(instance, endpoint),
+			networkingv1.PathTypePrefix, endpoint.Port))
+	}
+	paths = append(paths, ingressPath(instance, path, pathType, DefaultServingPort))
+
+	return &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        instance.Name,
+			Namespace:   instance.Namespace,
+			Annotations: annotations,
+		},
+		Spec: networkingv1.IngressSpec{
+			IngressClassName: ingressClassName,
+			Rules: []networkingv1.IngressRule{{
+				Host: config.Get().Routing.IngressHost,
+				IngressRuleValue: networkingv1.IngressRuleValue{
+					HTTP: &networkingv1.HTTPIngressRuleValue{
+						Paths: paths,
+					},
+				},
+			}},
+		},
+	}
+}
+
+func ingressHeadersName(instance *v1beta1.Notebook) string {
+	return instance.Name + "-request-headers"
+}
+
+// generateIngressHeaders returns the ConfigMap of the request headers of the
+// Ingress of the Notebook, or nil if it sets none. The headers whose name or
+// value can't be written safely in an nginx directive are skipped.
+func generateIngressHeaders(instance *v1beta1.Notebook) *corev1.ConfigMap {
+	headers := requestHeaders(instance)
+	data := map[string]string{}
+	for name, value := range headers {
+		if headerNameRegexp.MatchString(name) && headerValueRegexp.MatchString(value) {
+			data[name] = value
+		}
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      ingressHeadersName(instance),
+			Namespace: instance.Namespace,
+		},
+		Data: data,
+	}
+}
+
+// reconcileIngressHeaders creates or updates the ConfigMap of the request
+// headers of the Ingress of the Notebook, and deletes it once the Notebook
+// sets no headers. A ConfigMap of the same name that the Notebook doesn't own
+// is left untouched.
+func (r *NotebookReconciler) reconcileIngressHeaders(ctx context.Context, instance *v1beta1.Notebook) error {
+	log := r.Log.WithValues("notebook", types.NamespacedName{Name: instance.Name, Namespace: instance.Namespace})
+
+	found := &corev1.ConfigMap{}
+	err := r.Get(ctx, types.NamespacedName{Name: ingressHeadersName(instance), Namespace: instance.Namespace}, found)
+	if err != nil && !apierrs.IsNotFound(err) {
+		return err
+	}
+	exists := err == nil
+	if exists && !metav1.IsControlledBy(found, instance) {
+		r.EventRecorder.Eventf(instance, corev1.EventTypeWarning, "IngressHeadersConflict",
+			"The ConfigMap %s is not owned by the Notebook", found.Name)
+		return fmt.Errorf("the ConfigMap %s/%s is not owned by the Notebook", found.Namespace, found.Name)
+	}
+
+	headers := generateIngressHeaders(instance)
+	if headers == nil {
+		if exists {
+			log.Info("Deleting the ConfigMap of the request headers", "name", found.Name)
+			return client.IgnoreNotFound(r.Delete(ctx, found))
+		}
+		return nil
+	}
+	if err := ctrl.SetControllerReference(instance, headers, r.Scheme); err != nil {
+		return err
+	}
+	if !exists {
+		log.Info("Creating the ConfigMap of the request headers", "name", headers.Name)
+		return r.Create(ctx, headers)
+	}
+	if !reflect.DeepEqual(headers.Data, found.Data) {
+		log.Info("Updating the ConfigMap of the request headers", "name", headers.Name)
+		found.Data = headers.Data
+		return r.Update(ctx, found)
+	}
+	return nil
+}
+
+// ingressPath routes the path to the port of the Notebook's Service.
+func ingressPath(instance *v1beta1.Notebook, path string, pathType networkingv1.PathType, port int32) networkingv1.HTTPIngressPath {
+	return networkingv1.HTTPIngressPath{
+		Path:     path,
+		PathType: &pathType,
+		Backend: networkingv1.IngressBackend{
+			Service: &networkingv1.IngressServiceBackend{
+				Name: instance.Name,
+				Port: networkingv1.ServiceBackendPort{Number: port},
+			},
+		},
+	}
+}
+
+// ingressRoutesEndpoint returns true if the Ingress of the Notebook routes
+// the endpoint. ingress-nginx rewrites all the paths of an Ingress to the
+// same target, so the endpoints are only routed when neither the Notebook
+// nor the endpoint rewrites its prefix.
+func ingressRoutesEndpoint(instance *v1beta1.Notebook, endpoint v1beta1.NotebookEndpoint) bool {
+	return rewriteURI(instance) == notebookPrefix(instance) &&
+		endpointRewrite(instance, endpoint) == endpointPrefix(instance, endpoint)
+}
+
+// gatewayBackend routes the Notebooks with Gateway API HTTPRoutes attached
+// to the Gateway of the configuration.
+type gatewayBackend struct{}
+
+func (gatewayBackend) Name() string {
+	return RoutingBackendGateway
+}
+
+func (gatewayBackend) Generate(instance *v1beta1.Notebook, toActivator bool) (client.Object, error) {
+	return generateHTTPRoute(instance, toActivator)
+}
+
+func (gatewayBackend) NewObject() client.Object {
+	route := &unstructured.Unstructured{}
+	route.SetAPIVersion("gateway.networking.k8s.io/v1beta1")
+	route.SetKind("HTTPRoute")
+	return route
+}
+
+func (gatewayBackend) Copy(from, to client.Object) bool {
+	return reconcilehelper.CopyVirtualService(from.(*unstructured.Unstructured), to.(*unstructured.Unstructured))
+}
+
+// URLs returns the URLs of the hostnames of the HTTPRoute, or of the external
+// URL of the configuration when it has no hostnames and takes the ones of
+// the listeners of the Gateway.
+func (gatewayBackend) URLs(instance *v1beta1.Notebook, route client.Object) []string {
+	hostnames, _, _ := unstructured.NestedStringSlice(route.(*unstructured.Unstructured).Object, "spec", "hostnames")
+	return externalURLs(instance, hostnames)
+}
+
+// generateHTTPRoute routes the Notebook's prefix to its Service, or to the
+// activator if toActivator is true. The activator is in another namespace,
+// which needs a ReferenceGrant for the HTTPRoutes of the Notebooks.
+func generateHTTPRoute(instance *v1beta1.Notebook, toActivator bool) (*unstructured.Unstructured, error) {
+	gateway := config.Get().Routing.Gateway
+	parentRef := map[string]interface{}{"name": gateway}
+	if parts := strings.SplitN(gateway, "/", 2); len(parts) == 2 {
+		parentRef = map[string]interface{}{"namespace": parts[0], "name": parts[1]}
+	}
+
+	headers := requestHeaders(instance)
+	backendRef := func(port int32) map[string]interface{} {
+		return map[string]interface{}{
+			"name": instance.Name,
+			"port": int64(port),
+		}
+	}
+	// Send the requests to the activator, which starts the Notebook
+	if toActivator {
+		host := strings.Split(activatorService(), ".")
+		activatorRef := map[string]interface{}{
+			"name": host[0],
+			"port": int64(DefaultActivatorPort),
+		}
+		if len(host) > 1 {
+			activatorRef["namespace"] = host[1]
+		}
+		backendRef = func(int32) map[string]interface{} {
+			return activatorRef
+		}
+		headers[ActivatorNotebookHeader] = instance.Namespace + "/" + instance.Name
+	}
+
+	// The endpoints have their own rules, which take precedence over the
+	// rule of the Notebook as their prefixes are longer
+	rules := []interface{}{}
+	for _, endpoint := range instance.Spec.Endpoints {
+		rules = append(rules, httpRouteRule(endpointPrefix(instance, endpoint),
+			endpointRewrite(instance, endpoint), headers, backendRef(endpoint.Port)))
+	}
+	rules = append(rules, httpRouteRule(notebookPrefix(instance), rewriteURI(instance), headers,
+		backendRef(DefaultServingPort)))
+
+	route := gatewayBackend{}.NewObject().(*unstructured.Unstructured)
+	route.SetName(instance.Name)
+	route.SetNamespace(instance.Namespace)
+	if err := unstructured.SetNestedSlice(route.Object, []interface{}{parentRef}, "spec", "parentRefs"); err != nil {
+		return nil, fmt.Errorf("Set .spec.parentRefs error: %v", err)
+	}
+	if err := unstructured.SetNestedSlice(route.Object, rules, "spec", "rules"); err != nil {
+		return nil, fmt.Errorf("Set .spec.rules error: %v", err)
+	}
+	return route, nil
+}
+
+// httpRouteRule routes the prefix to the backend, rewriting it and setting
+// the headers of the requests.
+func httpRouteRule(prefix, rewrite string, headers map[string]string, backendRef map[string]interface{}) map[string]interface{} {
+	filters := []interface{}{}
+	if rewrite != prefix {
+		filters = append(filters, map[string]interface{}{
+			"type": "URLRewrite",
+			"urlRewrite": map[string]interface{}{
+				"path": map[string]interface{}{
+					"type":               "ReplacePrefixMatch",
+					"replacePrefixMatch": rewrite,
+				},
+			},
+		})
+	}
+	if len(headers) > 0 {
+		set := []interface{}{}
+		for _, name := range sortedHeaderNames(headers) {
+			set = append(set, map[string]interface{}{"name": name, "value": headers[name]})
+		}
+		filters = append(filters, map[string]interface{}{
+			"type": "RequestHeaderModifier",
+			"requestHeaderModifier": map[string]interface{}{
+				"set": set,
+			},
+		})
+	}
+
+	rule := map[string]interface{}{
+		"matches": []interface{}{
+			map[string]interface{}{
+				"path": map[string]interface{}{
+					"type":  "PathPrefix",
+					"value": prefix,
+				},
+			},
+		},
+		"backendRefs": []interface{}{backendRef},
+	}
+	if len(filters) > 0 {
+		rule["filters"] = filters
+	}
+	return rule
+}
+
+// reconcileRoute creates or updates the route of the Notebook with the
+// routing backend, and returns it.
+func (r *NotebookReconciler) reconcileRoute(backend RoutingBackend, instance *v1beta1.Notebook,
+	sts *appsv1.StatefulSet) (client.Object, error) {
+
+	log := r.Log.WithValues("notebook", instance.Namespace)
+	route, err := backend.Generate(instance, routesToActivator(backend, instance, sts))
+	if err != nil {
+		return nil, err
+	}
+	if err := ctrl.SetControllerReference(instance, route, r.Scheme); err != nil {
+		return nil, err
+	}
+	// Check if the route already exists.
+	found := backend.NewObject()
+	justCreated := false
+	err = r.Get(context.TODO(), types.NamespacedName{Name: route.GetName(), Namespace: route.GetNamespace()}, found)
+	if err != nil && apierrs.IsNotFound(err) {
+		log.Info("Creating route", "namespace", route.GetNamespace(), "name", route.GetName())
+		err = r.Create(context.TODO(), route)
+		justCreated = true
+		if err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	}
+	if justCreated {
+		return route, nil
+	}
+
+	if backend.Copy(route, found) {
+		log.Info("Updating route", "namespace", route.GetNamespace(), "name", route.GetName())
+		err = r.Update(context.TODO(), found)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return found, nil
+}
+
+// serviceURL returns the URL of the path on the Notebook's Service within
+// the cluster.
+func serviceURL(instance *v1beta1.Notebook, port int, path string) string {
+	host := fmt.Sprintf("%s.%s.svc.%s", instance.Name, instance.Namespace, config.Get().ClusterDomain)
+	if port != 80 {
+		host = fmt.Sprintf("%s:%d", host, port)
+	}
+	return "http://" + host + path
+}
+
+// routingStatus returns where the Notebook and its endpoints are reached
+// through its route. The route is nil if the controller doesn't route the
+// Notebook, in which case the external URLs are kept if another controller,
+// like the ODH notebook controller with its OpenShift Routes, set them. The
+// endpoints only have an internal URL then, as those routes only expose the
+// main port.
+func routingStatus(instance *v1beta1.Notebook, backend RoutingBackend, route client.Object) *v1beta1.RoutingStatus {
+	status := &v1beta1.RoutingStatus{
+		Backend:     RoutingBackendNone,
+		InternalURL: serviceURL(instance, DefaultServingPort, rewriteURI(instance)),
+	}
+	for _, endpoint := range instance.Spec.Endpoints {
+		status.Endpoints = append(status.Endpoints, v1beta1.EndpointStatus{
+			Name:        endpoint.Name,
+			InternalURL: serviceURL(instance, int(endpoint.Port), endpointRewrite(instance, endpoint)),
+		})
+	}
+	if backend == nil || route == nil {
+		previous := instance.Status.Routing
+		switch {
+		case previous == nil, previous.Backend == RoutingBackendIstio, previous.Backend == RoutingBackendIngress,
+			previous.Backend == RoutingBackendGateway, previous.Backend == RoutingBackendNone:
+		default:
+			status.Backend = previous.Backend
+			status.URL = previous.URL
+			status.URLs = previous.URLs
+		}
+		return status
+	}
+
+	status.Backend = backend.Name()
+	urls := backend.URLs(instance, route)
+	if len(urls) > 0 {
+		status.URL = urls[0]
+	}
+	if len(urls) > 1 {
+		status.URLs = urls
+	}
+	for i, endpoint := range instance.Spec.Endpoints {
+		if _, ok := backend.(ingressBackend); ok && !ingressRoutesEndpoint(instance, endpoint) {
+			continue
+		}
+		if status.URL != "" {
+			// The URL of the Notebook ends with its prefix
+			status.Endpoints[i].URL = strings.TrimSuffix(status.URL, notebookPrefix(instance)) +
+				endpointPrefix(instance, endpoint)
+		}
+	}
+	return status
+}
@@ -0,0 +1,487 @@
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	nbv1beta1 "github.com/kubeflow/kubeflow/components/notebook-controller/api/v1beta1"
+)
+
+func TestNewRoutingBackend(t *testing.T) {
+	tests := []struct {
+		name     string
+		useIstio string
+		backend  string
+		result   RoutingBackend
+		err      bool
+	}{
+		{
+			name:     "Istio",
+			useIstio: "true",
+			result:   istioBackend{},
+		},
+		{
+			name:   "not routed",
+			result: nil,
+		},
+		{
+			name:     "Ingress",
+			useIstio: "true",
+			backend:  RoutingBackendIngress,
+			result:   ingressBackend{},
+		},
+		{
+			name:    "Gateway API",
+			backend: RoutingBackendGateway,
+			result:  gatewayBackend{},
+		},
+		{
+			name:     "none",
+			useIstio: "true",
+			backend:  RoutingBackendNone,
+			result:   nil,
+		},
+		{
+			name:    "unknown",
+			backend: "traefik",
+			err:     true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("USE_ISTIO", test.useIstio)
+			t.Setenv("ROUTING_BACKEND", test.backend)
+
+			backend, err := newRoutingBackend()
+			if (err != nil) != test.err {
+				t.Fatalf("Got error %v, expected an error: %t", err, test.err)
+			}
+			if backend != test.result {
+				t.Errorf("Got backend %#v, expected %#v", backend, test.result)
+			}
+		})
+	}
+}
+
+func routedNotebook(annotations map[string]string) *nbv1beta1.Notebook {
+	return &nbv1beta1.Notebook{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "test",
+			Namespace:   "default",
+			Annotations: annotations,
+		},
+	}
+}
+
+func TestGenerateIngress(t *testing.T) {
+	t.Setenv("INGRESS_CLASS_NAME", "nginx")
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		path        string
+		pathType    networkingv1.PathType
+		result      map[string]string
+	}{
+		{
+			name:     "default",
+			path:     "/notebook/default/test/",
+			pathType: networkingv1.PathTypePrefix,
+		},
+		{
+			name: "rewrite and headers",
+			annotations: map[string]string{
+				AnnotationRewriteURI:        "/",
+				AnnotationHeadersRequestSet: `{"X-Forwarded-Prefix": "/notebook/default/test", "X-Bad": "a\"; evil"}`,
+			},
+			path:     "/notebook/default/test/(.*)",
+			pathType: networkingv1.PathTypeImplementationSpecific,
+			result: map[string]string{
+				ingressNginxUseRegex:        "true",
+				ingressNginxRewriteTarget:   "/$1",
+				ingressNginxProxySetHeaders: "default/test-request-headers",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ingress := generateIngress(routedNotebook(test.annotations))
+
+			if !reflect.DeepEqual(ingress.Annotations, test.result) {
+				t.Errorf("Got annotations %v, expected %v", ingress.Annotations, test.result)
+			}
+			// ingress-nginx rejects the snippets by default
+			for annotation := range ingress.Annotations {
+				if strings.HasSuffix(annotation, "-snippet") {
+					t.Errorf("Expected no snippet annotation, got %s", annotation)
+				}
+			}
+			if *ingress.Spec.IngressClassName != "nginx" {
+				t.Errorf("Got IngressClass %s, expected nginx", *ingress.Spec.IngressClassName)
+			}
+			path := ingress.Spec.Rules[0].HTTP.Paths[0]
+			if path.Path != test.path || *path.PathType != test.pathType {
+				t.Errorf("Got path %s of type %s, expected %s of type %s", path.Path, *path.PathType, test.path, test.pathType)
+			}
+			if path.Backend.Service.Name != "test" || path.Backend.Service.Port.Number != DefaultServingPort {
+				t.Errorf("Expected the Notebook's Service as the backend, got %+v", path.Backend.Service)
+			}
+		})
+	}
+}
+
+func TestReconcileIngressHeaders(t *testing.T) {
+	nb := routedNotebook(map[string]string{
+		AnnotationHeadersRequestSet: `{"X-Forwarded-Prefix": "/notebook/default/test", "X-Bad": "a\"; evil"}`,
+	})
+	nb.UID = "test-uid"
+	scheme := runtime.NewScheme()
+	_ = corev1.AddToScheme(scheme)
+	_ = nbv1beta1.AddToScheme(scheme)
+	r := &NotebookReconciler{
+		Client:        fake.NewClientBuilder().WithScheme(scheme).WithObjects(nb).Build(),
+		Scheme:        scheme,
+		Log:           logr.Discard(),
+		EventRecorder: record.NewFakeRecorder(10),
+	}
+	key := types.NamespacedName{Name: "test-request-headers", Namespace: "default"}
+
+	if err := r.reconcileIngressHeaders(context.TODO(), nb); err != nil {
+		t.Fatal(err)
+	}
+	found := &corev1.ConfigMap{}
+	if err := r.Get(context.TODO(), key, found); err != nil {
+		t.Fatal(err)
+	}
+	if expected := map[string]string{"X-Forwarded-Prefix": "/notebook/default/test"}; !reflect.DeepEqual(found.Data, expected) {
+		t.Errorf("Expected the headers %v without the unsafe ones, got %v", expected, found.Data)
+	}
+	if !metav1.IsControlledBy(found, nb) {
+		t.Errorf("Expected the ConfigMap to be owned by the Notebook, got %v", found.OwnerReferences)
+	}
+
+	// The ConfigMap is deleted with the headers
+	nb.Annotations = nil
+	if err := r.reconcileIngressHeaders(context.TODO(), nb); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Get(context.TODO(), key, found); !apierrs.IsNotFound(err) {
+		t.Errorf("Expected the ConfigMap to be deleted, got %v", err)
+	}
+
+	// A ConfigMap of the same name that the Notebook doesn't own is kept
+	own := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: key.Name, Namespace: key.Namespace}}
+	if err := r.Create(context.TODO(), own); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.reconcileIngressHeaders(context.TODO(), nb); err == nil {
+		t.Errorf("Expected a conflict with the ConfigMap of another owner")
+	}
+	if err := r.Get(context.TODO(), key, found); err != nil {
+		t.Errorf("Expected the ConfigMap of another owner to be kept, got %v", err)
+	}
+}
+
+func TestGenerateHTTPRoute(t *testing.T) {
+	t.Setenv("GATEWAY", "gateways/notebooks")
+	t.Setenv("ACTIVATOR_SERVICE", "notebook-controller-activator.kubeflow.svc.cluster.local")
+
+	nb := routedNotebook(map[string]string{
+		AnnotationRewriteURI:        "/",
+		AnnotationHeadersRequestSet: `{"X-Forwarded-Prefix": "/notebook/default/test"}`,
+	})
+
+	route, err := generateHTTPRoute(nb, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parentRefs, _, _ := unstructured.NestedSlice(route.Object, "spec", "parentRefs")
+	if !reflect.DeepEqual(parentRefs, []interface{}{map[string]interface{}{"namespace": "gateways", "name": "notebooks"}}) {
+		t.Errorf("Got parentRefs %v", parentRefs)
+	}
+	rules, _, _ := unstructured.NestedSlice(route.Object, "spec", "rules")
+	rule := rules[0].(map[string]interface{})
+	expectedFilters := []interface{}{
+		map[string]interface{}{
+			"type": "URLRewrite",
+			"urlRewrite": map[string]interface{}{
+				"path": map[string]interface{}{"type": "ReplacePrefixMatch", "replacePrefixMatch": "/"},
+			},
+		},
+		map[string]interface{}{
+			"type": "RequestHeaderModifier",
+			"requestHeaderModifier": map[string]interface{}{
+				"set": []interface{}{
+					map[string]interface{}{"name": "X-Forwarded-Prefix", "value": "/notebook/default/test"},
+				},
+			},
+		},
+	}
+	if !reflect.DeepEqual(rule["filters"], expectedFilters) {
+		t.Errorf("Got filters %v, expected %v", rule["filters"], expectedFilters)
+	}
+	backendRef := rule["backendRefs"].([]interface{})[0]
+	if !reflect.DeepEqual(backendRef, map[string]interface{}{"name": "test", "port": int64(DefaultServingPort)}) {
+		t.Errorf("Expected the Notebook's Service as the backend, got %v", backendRef)
+	}
+
+	route, err = generateHTTPRoute(nb, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules, _, _ = unstructured.NestedSlice(route.Object, "spec", "rules")
+	backendRef = rules[0].(map[string]interface{})["backendRefs"].([]interface{})[0]
+	expectedBackendRef := map[string]interface{}{
+		"name":      "notebook-controller-activator",
+		"namespace": "kubeflow",
+		"port":      int64(DefaultActivatorPort),
+	}
+	if !reflect.DeepEqual(backendRef, expectedBackendRef) {
+		t.Errorf("Expected the activator as the backend, got %v", backendRef)
+	}
+}
+
+func TestIngressURLs(t *testing.T) {
+	nb := routedNotebook(nil)
+
+	ingress := generateIngress(nb)
+	if urls := (ingressBackend{}).URLs(nb, ingress); !reflect.DeepEqual(urls, []string{"/notebook/default/test/"}) {
+		t.Errorf("Expected the prefix without a host, got %v", urls)
+	}
+
+	ingress.Status.LoadBalancer.Ingress = []corev1.LoadBalancerIngress{{IP: "10.0.0.1"}}
+	if urls := (ingressBackend{}).URLs(nb, ingress); !reflect.DeepEqual(urls, []string{"http://10.0.0.1/notebook/default/test/"}) {
+		t.Errorf("Expected the URL of the load balancer, got %v", urls)
+	}
+
+	ingress.Spec.Rules[0].Host = "notebooks.example.com"
+	ingress.Spec.TLS = []networkingv1.IngressTLS{{Hosts: []string{"notebooks.example.com"}}}
+	if urls := (ingressBackend{}).URLs(nb, ingress); !reflect.DeepEqual(urls, []string{"https://notebooks.example.com/notebook/default/test/"}) {
+		t.Errorf("Expected the https URL of the host, got %v", urls)
+	}
+}
+
+func TestExternalURLs(t *testing.T) {
+	nb := routedNotebook(nil)
+
+	virtualService, err := generateVirtualService(nb, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if urls := (istioBackend{}).URLs(nb, virtualService); !reflect.DeepEqual(urls, []string{"/notebook/default/test/"}) {
+		t.Errorf("Expected the prefix without an external URL, got %v", urls)
+	}
+	t.Setenv("EXTERNAL_URL", "https://kubeflow.example.com")
+	if urls := (istioBackend{}).URLs(nb, virtualService); !reflect.DeepEqual(urls, []string{"https://kubeflow.example.com/notebook/default/test/"}) {
+		t.Errorf("Expected the external URL for the wildcard host, got %v", urls)
+	}
+	if err := unstructured.SetNestedStringSlice(virtualService.Object, []string{"notebooks.example.com", "*.example.com"}, "spec", "hosts"); err != nil {
+		t.Fatal(err)
+	}
+	if urls := (istioBackend{}).URLs(nb, virtualService); !reflect.DeepEqual(urls, []string{"https://notebooks.example.com/notebook/default/test/"}) {
+		t.Errorf("Expected the URL of the host, got %v", urls)
+	}
+
+	route, err := generateHTTPRoute(nb, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if urls := (gatewayBackend{}).URLs(nb, route); !reflect.DeepEqual(urls, []string{"https://kubeflow.example.com/notebook/default/test/"}) {
+		t.Errorf("Expected the external URL without hostnames, got %v", urls)
+	}
+	if err := unstructured.SetNestedStringSlice(route.Object, []string{"notebooks.example.com", "lab.example.com"}, "spec", "hostnames"); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"https://notebooks.example.com/notebook/default/test/", "https://lab.example.com/notebook/default/test/"}
+	if urls := (gatewayBackend{}).URLs(nb, route); !reflect.DeepEqual(urls, expected) {
+		t.Errorf("Expected the URLs of the hostnames, got %v", urls)
+	}
+}
+
+func TestRoutingStatus(t *testing.T) {
+	t.Setenv("ROUTING_BACKEND", RoutingBackendIngress)
+	nb := routedNotebook(map[string]string{AnnotationRewriteURI: "/"})
+	ingress := generateIngress(nb)
+	ingress.Spec.Rules[0].Host = "notebooks.example.com"
+
+	status := routingStatus(nb, ingressBackend{}, ingress)
+	expected := &nbv1beta1.RoutingStatus{
+		Backend:     RoutingBackendIngress,
+		URL:         "http://notebooks.example.com/notebook/default/test/",
+		InternalURL: "http://test.default.svc.cluster.local/",
+	}
+	if !reflect.DeepEqual(status, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, status)
+	}
+
+	// The URLs of the route of another controller are kept
+	nb.Status.Routing = &nbv1beta1.RoutingStatus{Backend: "route", URL: "https://test.apps.example.com/notebook/default/test/"}
+	status = routingStatus(nb, nil, nil)
+	if status.Backend != "route" || status.URL != nb.Status.Routing.URL || status.InternalURL != expected.InternalURL {
+		t.Errorf("Expected the URL of the Route to be kept, got %+v", status)
+	}
+
+	// but not the ones of a backend that is no longer used
+	nb.Status.Routing = expected
+	status = routingStatus(nb, nil, nil)
+	if status.Backend != RoutingBackendNone || status.URL != "" {
+		t.Errorf("Expected the Notebook not to be routed, got %+v", status)
+	}
+}
+
+func endpointsNotebook(annotations map[string]string) *nbv1beta1.Notebook {
+	nb := routedNotebook(annotations)
+	nb.Spec.Template.Spec.Containers = []corev1.Container{{Name: "test"}}
+	nb.Spec.Endpoints = []nbv1beta1.NotebookEndpoint{
+		{Name: "tensorboard", Port: 6006},
+		{Name: "api", Port: 8000, Path: "v1/api/", Rewrite: "/"},
+	}
+	return nb
+}
+
+func TestEndpointsService(t *testing.T) {
+	svc := generateService(endpointsNotebook(nil))
+
+	names := []string{}
+	for _, port := range svc.Spec.Ports {
+		if port.Port != DefaultServingPort && port.TargetPort.IntVal != port.Port {
+			t.Errorf("Expected the port %s to target the same container port, got %d", port.Name, port.TargetPort.IntVal)
+		}
+		names = append(names, port.Name)
+	}
+	if expected := []string{"http-test", "http-tensorboard", "http-api"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("Got ports %v, expected %v", names, expected)
+	}
+}
+
+func TestEndpointsVirtualService(t *testing.T) {
+	vsvc, err := generateVirtualService(endpointsNotebook(nil), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	http, _, _ := unstructured.NestedSlice(vsvc.Object, "spec", "http")
+	expected := []struct {
+		prefix  string
+		rewrite string
+		port    int64
+	}{
+		{"/notebook/default/test/tensorboard/", "/notebook/default/test/tensorboard/", 6006},
+		{"/notebook/default/test/v1/api/", "/", 8000},
+		{"/notebook/default/test/", "/notebook/default/test/", DefaultServingPort},
+	}
+	if len(http) != len(expected) {
+		t.Fatalf("Got %d routes, expected %d", len(http), len(expected))
+	}
+	for i, e := range expected {
+		route := http[i].(map[string]interface{})
+		prefix, _, _ := unstructured.NestedString(route["match"].([]interface{})[0].(map[string]interface{}), "uri", "prefix")
+		rewrite, _, _ := unstructured.NestedString(route, "rewrite", "uri")
+		port, _, _ := unstructured.NestedInt64(route["route"].([]interface{})[0].(map[string]interface{}),
+			"destination", "port", "number")
+		if prefix != e.prefix || rewrite != e.rewrite || port != e.port {
+			t.Errorf("Got route %d from %s to %s on port %d, expected %+v", i, prefix, rewrite, port, e)
+		}
+	}
+}
+
+func TestEndpointsIngress(t *testing.T) {
+	paths := generateIngress(endpointsNotebook(nil)).Spec.Rules[0].HTTP.Paths
+	// The rewritten endpoint isn't routed
+	if len(paths) != 2 || paths[0].Path != "/notebook/default/test/tensorboard/" ||
+		paths[0].Backend.Service.Port.Number != 6006 || paths[1].Path != "/notebook/default/test/" {
+		t.Errorf("Expected the paths of the tensorboard endpoint and of the Notebook, got %+v", paths)
+	}
+
+	paths = generateIngress(endpointsNotebook(map[string]string{AnnotationRewriteURI: "/"})).Spec.Rules[0].HTTP.Paths
+	if len(paths) != 1 {
+		t.Errorf("Expected the endpoints not to be routed when the Notebook is rewritten, got %+v", paths)
+	}
+}
+
+func TestEndpointsHTTPRoute(t *testing.T) {
+	t.Setenv("ACTIVATOR_SERVICE", "notebook-controller-activator.kubeflow.svc.cluster.local")
+	nb := endpointsNotebook(nil)
+
+	route, err := generateHTTPRoute(nb, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules, _, _ := unstructured.NestedSlice(route.Object, "spec", "rules")
+	if len(rules) != 3 {
+		t.Fatalf("Expected a rule per endpoint and one for the Notebook, got %v", rules)
+	}
+	tensorboard := rules[0].(map[string]interface{})
+	if _, ok := tensorboard["filters"]; ok {
+		t.Errorf("Expected the tensorboard endpoint not to be rewritten, got %v", tensorboard["filters"])
+	}
+	backendRef := tensorboard["backendRefs"].([]interface{})[0]
+	if !reflect.DeepEqual(backendRef, map[string]interface{}{"name": "test", "port": int64(6006)}) {
+		t.Errorf("Expected the port of the endpoint as the backend, got %v", backendRef)
+	}
+	api := rules[1].(map[string]interface{})
+	rewrite, _, _ := unstructured.NestedString(api["filters"].([]interface{})[0].(map[string]interface{}),
+		"urlRewrite", "path", "replacePrefixMatch")
+	if rewrite != "/" {
+		t.Errorf("Expected the api endpoint to be rewritten to /, got %q", rewrite)
+	}
+
+	route, err = generateHTTPRoute(nb, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules, _, _ = unstructured.NestedSlice(route.Object, "spec", "rules")
+	backendRef = rules[0].(map[string]interface{})["backendRefs"].([]interface{})[0]
+	if name := backendRef.(map[string]interface{})["name"]; name != "notebook-controller-activator" {
+		t.Errorf("Expected the activator as the backend of the endpoints, got %v", backendRef)
+	}
+}
+
+func TestEndpointsRoutingStatus(t *testing.T) {
+	t.Setenv("ROUTING_BACKEND", RoutingBackendIngress)
+	nb := endpointsNotebook(nil)
+	ingress := generateIngress(nb)
+	ingress.Spec.Rules[0].Host = "notebooks.example.com"
+
+	status := routingStatus(nb, ingressBackend{}, ingress)
+	expected := []nbv1beta1.EndpointStatus{
+		{
+			Name:        "tensorboard",
+			URL:         "http://notebooks.example.com/notebook/default/test/tensorboard/",
+			InternalURL: "http://test.default.svc.cluster.local:6006/notebook/default/test/tensorboard/",
+		},
+		{
+			// The Ingress doesn't route the rewritten endpoint
+			Name:        "api",
+			InternalURL: "http://test.default.svc.cluster.local:8000/",
+		},
+	}
+	if !reflect.DeepEqual(status.Endpoints, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, status.Endpoints)
+	}
+
+	virtualService := &unstructured.Unstructured{Object: map[string]interface{}{}}
+	status = routingStatus(nb, istioBackend{}, virtualService)
+	if url := status.Endpoints[1].URL; url != "/notebook/default/test/v1/api/" {
+		t.Errorf("Expected the prefix of the api endpoint, got %q", url)
+	}
+	t.Setenv("EXTERNAL_URL", "https://kubeflow.example.com/")
+	status = routingStatus(nb, istioBackend{}, virtualService)
+	if url := status.Endpoints[1].URL; url != "https://kubeflow.example.com/notebook/default/test/v1/api/" {
+		t.Errorf("Expected the external URL of the api endpoint, got %q", url)
+	}
+}
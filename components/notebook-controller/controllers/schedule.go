@@ -0,0 +1,184 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/kubeflow/kubeflow/components/notebook-controller/api/v1beta1"
+	"github.com/kubeflow/kubeflow/components/notebook-controller/pkg/culler"
+	"github.com/robfig/cron/v3"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// Actions of a Notebook's schedule
+const (
+	ScheduleActionStart = v1beta1.LifecycleActionStart
+	ScheduleActionStop  = v1beta1.LifecycleActionStop
+)
+
+// maxMissedScheduleActions bounds the search for the most recent action
+// that was missed, e.g. while the controller was down.
+const maxMissedScheduleActions = 1000
+
+var scheduleParser = cron.NewParser(
+	cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+
+// notebookSchedule is a parsed NotebookSchedule.
+type notebookSchedule struct {
+	start cron.Schedule
+	stop  cron.Schedule
+	loc   *time.Location
+}
+
+// parseSchedule parses the cron expressions and time zone of a schedule.
+func parseSchedule(schedule *v1beta1.NotebookSchedule) (*notebookSchedule, error) {
+	parsed := &notebookSchedule{loc: time.UTC}
+
+	if schedule.TimeZone != "" {
+		loc, err := time.LoadLocation(schedule.TimeZone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid time zone %q: %v", schedule.TimeZone, err)
+		}
+		parsed.loc = loc
+	}
+
+	var err error
+	if schedule.Start != "" {
+		if parsed.start, err = scheduleParser.Parse(schedule.Start); err != nil {
+			return nil, fmt.Errorf("invalid start schedule %q: %v", schedule.Start, err)
+		}
+	}
+	if schedule.Stop != "" {
+		if parsed.stop, err = scheduleParser.Parse(schedule.Stop); err != nil {
+			return nil, fmt.Errorf("invalid stop schedule %q: %v", schedule.Stop, err)
+		}
+	}
+	return parsed, nil
+}
+
+// next returns the first action of the schedule after t. If the Notebook is
+// to be started and stopped at the same time, it is stopped.
+func (s *notebookSchedule) next(t time.Time) (string, time.Time) {
+	t = t.In(s.loc)
+
+	var action string
+	var at time.Time
+	if s.stop != nil {
+		action, at = ScheduleActionStop, s.stop.Next(t)
+	}
+	if s.start != nil {
+		if start := s.start.Next(t); at.IsZero() || start.Before(at) {
+			action, at = ScheduleActionStart, start
+		}
+	}
+	return action, at
+}
+
+// lastAction returns the most recent action of the schedule between since
+// and now.
+func (s *notebookSchedule) lastAction(since, now time.Time) string {
+	var last string
+	t := since.Add(-time.Second)
+	for i := 0; i < maxMissedScheduleActions; i++ {
+		action, at := s.next(t)
+		if at.IsZero() || at.After(now) {
+			break
+		}
+		last, t = action, at
+	}
+	return last
+}
+
+// reconcileSchedule applies the due action of the Notebook's schedule, by
+// setting or removing the STOP_ANNOTATION, and returns its next action.
+func (r *NotebookReconciler) reconcileSchedule(ctx context.Context, nb *v1beta1.Notebook) (*v1beta1.ScheduleStatus, error) {
+	log := r.Log.WithValues("notebook", nb.Namespace+"/"+nb.Name)
+
+	if nb.Spec.Schedule == nil {
+		return nil, nil
+	}
+
+	schedule, err := parseSchedule(nb.Spec.Schedule)
+	if err != nil {
+		log.Error(err, "unable to parse the Notebook's schedule. Ignoring it")
+		r.EventRecorder.Eventf(nb, corev1.EventTypeWarning, "InvalidSchedule",
+			"Ignoring the schedule of the Notebook: %v", err)
+		return nil, nil
+	}
+
+	now := time.Now()
+	// Only act on the actions that were announced in the status, so that a
+	// Notebook is not stopped or started as soon as its schedule is set.
+	if status := nb.Status.Schedule; status != nil && !now.Before(status.NextActionTime.Time) {
+		switch schedule.lastAction(status.NextActionTime.Time, now) {
+		case ScheduleActionStop:
+			if !culler.StopAnnotationIsSet(nb.ObjectMeta) {
+				log.Info("Stopping the Notebook on schedule")
+				// Not a culling, so don't count it in the culling metrics
+				culler.SetStopAnnotation(&nb.ObjectMeta, nil)
+				if err := r.Update(ctx, nb); err != nil {
+					return nil, err
+				}
+				// The status is updated later in the reconcile loop
+				nb.Status.RecordLifecycleTransition(v1beta1.LifecycleActionStop,
+					v1beta1.LifecycleReasonScheduled, lifecycleActor, metav1.Now())
+				r.EventRecorder.Event(nb, corev1.EventTypeNormal, "ScheduledStop",
+					"Stopping the Notebook on schedule")
+			}
+		case ScheduleActionStart:
+			if culler.StopAnnotationIsSet(nb.ObjectMeta) {
+				log.Info("Starting the Notebook on schedule")
+				delete(nb.Annotations, culler.STOP_ANNOTATION)
+				if err := r.Update(ctx, nb); err != nil {
+					return nil, err
+				}
+				nb.Status.RecordLifecycleTransition(v1beta1.LifecycleActionStart,
+					v1beta1.LifecycleReasonScheduled, lifecycleActor, metav1.Now())
+				r.EventRecorder.Event(nb, corev1.EventTypeNormal, "ScheduledStart",
+					"Starting the Notebook on schedule")
+			}
+		}
+	}
+
+	action, at := schedule.next(now)
+	if at.IsZero() {
+		return nil, nil
+	}
+	return &v1beta1.ScheduleStatus{
+		NextAction:     action,
+		NextActionTime: metav1.NewTime(at),
+	}, nil
+}
+
+// requeueForSchedule makes sure that the Notebook is reconciled again at the
+// time of its next scheduled action.
+func requeueForSchedule(result ctrl.Result, schedule *v1beta1.ScheduleStatus) ctrl.Result {
+	if schedule == nil {
+		return result
+	}
+
+	// Add a second, so that the action is due when the Notebook is reconciled
+	next := time.Until(schedule.NextActionTime.Time) + time.Second
+	if result.RequeueAfter == 0 || next < result.RequeueAfter {
+		result.RequeueAfter = next
+	}
+	return result
+}
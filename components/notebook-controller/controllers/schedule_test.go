@@ -0,0 +1,240 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	nbv1beta1 "github.com/kubeflow/kubeflow/components/notebook-controller/api/v1beta1"
+	"github.com/kubeflow/kubeflow/components/notebook-controller/pkg/culler"
+)
+
+func TestParseSchedule(t *testing.T) {
+	tests := []struct {
+		name     string
+		schedule nbv1beta1.NotebookSchedule
+		err      bool
+	}{
+		{
+			name: "stop and start",
+			schedule: nbv1beta1.NotebookSchedule{
+				Stop:     "0 19 * * *",
+				Start:    "0 8 * * 1-5",
+				TimeZone: "Europe/Berlin",
+			},
+		},
+		{
+			name:     "descriptor",
+			schedule: nbv1beta1.NotebookSchedule{Stop: "@daily"},
+		},
+		{
+			name:     "invalid cron expression",
+			schedule: nbv1beta1.NotebookSchedule{Stop: "every evening"},
+			err:      true,
+		},
+		{
+			name:     "seconds are not supported",
+			schedule: nbv1beta1.NotebookSchedule{Start: "0 0 8 * * *"},
+			err:      true,
+		},
+		{
+			name:     "invalid time zone",
+			schedule: nbv1beta1.NotebookSchedule{Stop: "@daily", TimeZone: "Mars/Olympus"},
+			err:      true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := parseSchedule(&test.schedule)
+			if (err != nil) != test.err {
+				t.Errorf("Unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestScheduleNext(t *testing.T) {
+	schedule, err := parseSchedule(&nbv1beta1.NotebookSchedule{
+		Stop:     "0 19 * * *",
+		Start:    "0 8 * * 1-5",
+		TimeZone: "Europe/Berlin",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	berlin, _ := time.LoadLocation("Europe/Berlin")
+
+	tests := []struct {
+		name   string
+		now    time.Time
+		action string
+		at     time.Time
+	}{
+		{
+			name:   "weekday morning",
+			now:    time.Date(2022, 9, 1, 10, 0, 0, 0, berlin),
+			action: ScheduleActionStop,
+			at:     time.Date(2022, 9, 1, 19, 0, 0, 0, berlin),
+		},
+		{
+			name:   "weekday evening",
+			now:    time.Date(2022, 9, 1, 20, 0, 0, 0, berlin),
+			action: ScheduleActionStart,
+			at:     time.Date(2022, 9, 2, 8, 0, 0, 0, berlin),
+		},
+		{
+			name:   "friday evening",
+			now:    time.Date(2022, 9, 2, 20, 0, 0, 0, berlin),
+			action: ScheduleActionStop,
+			at:     time.Date(2022, 9, 3, 19, 0, 0, 0, berlin),
+		},
+		{
+			name:   "time zone of the controller doesn't matter",
+			now:    time.Date(2022, 9, 1, 16, 30, 0, 0, time.UTC),
+			action: ScheduleActionStop,
+			at:     time.Date(2022, 9, 1, 19, 0, 0, 0, berlin),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			action, at := schedule.next(test.now)
+			if action != test.action || !at.Equal(test.at) {
+				t.Errorf("Got %s at %v, expected %s at %v", action, at, test.action, test.at)
+			}
+		})
+	}
+
+	// Sunday, after missing the stop of Friday and Saturday
+	last := schedule.lastAction(time.Date(2022, 9, 2, 19, 0, 0, 0, berlin),
+		time.Date(2022, 9, 4, 12, 0, 0, 0, berlin))
+	if last != ScheduleActionStop {
+		t.Errorf("Got %s, expected %s", last, ScheduleActionStop)
+	}
+	// Monday, after missing the start of the morning
+	last = schedule.lastAction(time.Date(2022, 9, 4, 19, 0, 0, 0, berlin),
+		time.Date(2022, 9, 5, 12, 0, 0, 0, berlin))
+	if last != ScheduleActionStart {
+		t.Errorf("Got %s, expected %s", last, ScheduleActionStart)
+	}
+}
+
+func TestReconcileSchedule(t *testing.T) {
+	now := time.Now()
+	past := metav1.NewTime(now.Add(-time.Minute))
+	future := metav1.NewTime(now.Add(time.Hour))
+
+	tests := []struct {
+		name     string
+		schedule *nbv1beta1.NotebookSchedule
+		status   *nbv1beta1.ScheduleStatus
+		stopped  bool
+		expected bool
+		next     bool
+	}{
+		{
+			name:     "no schedule",
+			stopped:  false,
+			expected: false,
+		},
+		{
+			name:     "new schedule doesn't act",
+			schedule: &nbv1beta1.NotebookSchedule{Stop: "* * * * *"},
+			stopped:  false,
+			expected: false,
+			next:     true,
+		},
+		{
+			name:     "action is not due",
+			schedule: &nbv1beta1.NotebookSchedule{Stop: "* * * * *"},
+			status:   &nbv1beta1.ScheduleStatus{NextAction: ScheduleActionStop, NextActionTime: future},
+			stopped:  false,
+			expected: false,
+			next:     true,
+		},
+		{
+			name:     "stop is due",
+			schedule: &nbv1beta1.NotebookSchedule{Stop: "* * * * *"},
+			status:   &nbv1beta1.ScheduleStatus{NextAction: ScheduleActionStop, NextActionTime: past},
+			stopped:  false,
+			expected: true,
+			next:     true,
+		},
+		{
+			name:     "start is due",
+			schedule: &nbv1beta1.NotebookSchedule{Start: "* * * * *"},
+			status:   &nbv1beta1.ScheduleStatus{NextAction: ScheduleActionStart, NextActionTime: past},
+			stopped:  true,
+			expected: false,
+			next:     true,
+		},
+		{
+			name:     "invalid schedule is ignored",
+			schedule: &nbv1beta1.NotebookSchedule{Start: "sometimes"},
+			status:   &nbv1beta1.ScheduleStatus{NextAction: ScheduleActionStart, NextActionTime: past},
+			stopped:  true,
+			expected: true,
+		},
+	}
+
+	scheme := runtime.NewScheme()
+	_ = nbv1beta1.AddToScheme(scheme)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			nb := &nbv1beta1.Notebook{
+				ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"},
+				Spec:       nbv1beta1.NotebookSpec{Schedule: test.schedule},
+				Status:     nbv1beta1.NotebookStatus{Schedule: test.status},
+			}
+			if test.stopped {
+				culler.SetStopAnnotation(&nb.ObjectMeta, nil)
+			}
+
+			r := &NotebookReconciler{
+				Client:        fake.NewClientBuilder().WithScheme(scheme).WithObjects(nb).Build(),
+				Log:           logr.Discard(),
+				EventRecorder: record.NewFakeRecorder(10),
+			}
+			status, err := r.reconcileSchedule(context.TODO(), nb)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			if stopped := culler.StopAnnotationIsSet(nb.ObjectMeta); stopped != test.expected {
+				t.Errorf("Got stopped %t, expected %t", stopped, test.expected)
+			}
+			if !test.next {
+				if status != nil {
+					t.Errorf("Expected no next action, got %+v", status)
+				}
+				return
+			}
+			if status == nil || !status.NextActionTime.After(now) {
+				t.Errorf("Expected a future next action, got %+v", status)
+			}
+		})
+	}
+}
+
+func TestRequeueForSchedule(t *testing.T) {
+	soon := &nbv1beta1.ScheduleStatus{NextActionTime: metav1.NewTime(time.Now().Add(time.Minute))}
+	later := &nbv1beta1.ScheduleStatus{NextActionTime: metav1.NewTime(time.Now().Add(time.Hour))}
+
+	if r := requeueForSchedule(ctrl.Result{}, nil); r.RequeueAfter != 0 {
+		t.Errorf("Expected no requeue, got %v", r.RequeueAfter)
+	}
+	if r := requeueForSchedule(ctrl.Result{}, soon); r.RequeueAfter <= 0 || r.RequeueAfter > 2*time.Minute {
+		t.Errorf("Expected a requeue at the next action, got %v", r.RequeueAfter)
+	}
+	if r := requeueForSchedule(ctrl.Result{RequeueAfter: time.Minute}, later); r.RequeueAfter != time.Minute {
+		t.Errorf("Expected the earlier requeue to be kept, got %v", r.RequeueAfter)
+	}
+}
@@ -0,0 +1,262 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/kubeflow/kubeflow/components/notebook-controller/api/v1beta1"
+	"github.com/kubeflow/kubeflow/components/notebook-controller/pkg/config"
+	"github.com/kubeflow/kubeflow/components/notebook-controller/pkg/culler"
+	corev1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// AnnotationShutdownHook selects the hook that runs before the Notebook is
+// stopped or deleted.
+const AnnotationShutdownHook = "notebooks.kubeflow.org/shutdown-hook"
+
+// Shutdown hooks
+const (
+	// ShutdownHookJupyter makes the controller checkpoint the saved version
+	// of the open files and shut down the Jupyter server before scaling the
+	// Notebook down.
+	ShutdownHookJupyter = "jupyter"
+	// ShutdownHookCommand runs the command of the configuration as the
+	// preStop hook of the Notebook's container.
+	ShutdownHookCommand = "command"
+)
+
+// AnnotationShutdownHookDone is set to the value of the stop annotation once
+// the jupyter hook ran for that stop.
+const AnnotationShutdownHookDone = "notebooks.kubeflow.org/shutdown-hook-done"
+
+// ShutdownHookFinalizer holds the deletion of the Notebooks with a jupyter
+// hook until the hook ran.
+const ShutdownHookFinalizer = "notebooks.kubeflow.org/shutdown-hook"
+
+// shutdownHookPollInterval is how often a Notebook is reconciled while its
+// shutdown hook runs.
+var shutdownHookPollInterval = time.Second
+
+// shutdownHookDeletion is the id of the hook run on deletion.
+const shutdownHookDeletion = "deletion"
+
+func shutdownHook(instance *v1beta1.Notebook) string {
+	return instance.GetAnnotations()[AnnotationShutdownHook]
+}
+
+// setPreStopCommand sets the command of the configuration as the preStop hook
+// of the container, if the Notebook uses the command hook and the container
+// has no preStop hook of its own.
+func setPreStopCommand(instance *v1beta1.Notebook, container *corev1.Container) {
+	command := config.Get().ShutdownHook.Command
+	if shutdownHook(instance) != ShutdownHookCommand || len(command) == 0 {
+		return
+	}
+	if container.Lifecycle == nil {
+		container.Lifecycle = &corev1.Lifecycle{}
+	}
+	if container.Lifecycle.PreStop == nil {
+		container.Lifecycle.PreStop = &corev1.LifecycleHandler{
+			Exec: &corev1.ExecAction{Command: command},
+		}
+	}
+}
+
+// setShutdownGracePeriod raises the terminationGracePeriodSeconds of the
+// Notebooks with a jupyter hook to the timeout of the hook, so that their
+// server can still be shut down once their Pod is terminating.
+func setShutdownGracePeriod(instance *v1beta1.Notebook, podSpec *corev1.PodSpec) {
+	if shutdownHook(instance) != ShutdownHookJupyter {
+		return
+	}
+	timeout := int64(math.Ceil(config.Get().ShutdownHook.Timeout.Seconds()))
+	gracePeriod := int64(corev1.DefaultTerminationGracePeriodSeconds)
+	if podSpec.TerminationGracePeriodSeconds != nil {
+		gracePeriod = *podSpec.TerminationGracePeriodSeconds
+	}
+	if gracePeriod < timeout {
+		gracePeriod = timeout
+	}
+	podSpec.TerminationGracePeriodSeconds = &gracePeriod
+}
+
+// shutdownHookRun is a run of the shutdown hook of a Notebook.
+type shutdownHookRun struct {
+	id   string
+	done bool
+	err  error
+}
+
+// shutdownHooks runs the shutdown hooks of the Notebooks in the background,
+// so that the reconcile loop doesn't block on the Notebook servers. Its zero
+// value is ready to use.
+type shutdownHooks struct {
+	mu   sync.Mutex
+	runs map[types.NamespacedName]*shutdownHookRun
+}
+
+// run starts the hook of the Notebook, unless the run with the same id was
+// already started. It returns whether that run is done and its error. The
+// result of a run is only returned once.
+func (h *shutdownHooks) run(key types.NamespacedName, id string, hook func() error) (bool, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.runs == nil {
+		h.runs = map[types.NamespacedName]*shutdownHookRun{}
+	}
+	if run, ok := h.runs[key]; ok && run.id == id {
+		if run.done {
+			delete(h.runs, key)
+		}
+		return run.done, run.err
+	}
+
+	run := &shutdownHookRun{id: id}
+	h.runs[key] = run
+	go func() {
+		err := hook()
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		run.done, run.err = true, err
+	}()
+	return false, nil
+}
+
+// runJupyterShutdownHook shuts down the Jupyter server of the Notebook, and
+// records the result in an event.
+func (r *NotebookReconciler) runJupyterShutdownHook(instance *v1beta1.Notebook, endpoint culler.ProbeEndpoint) error {
+	ctx, cancel := context.WithTimeout(context.Background(), config.Get().ShutdownHook.Timeout.Duration)
+	defer cancel()
+
+	checkpoints, err := culler.ShutdownJupyter(ctx, endpoint, instance.Name, instance.Namespace)
+	if err != nil {
+		r.EventRecorder.Eventf(instance, corev1.EventTypeWarning, "ShutdownHookFailed",
+			"Unable to shut down the Notebook server gracefully: %v", err)
+		return err
+	}
+	r.EventRecorder.Eventf(instance, corev1.EventTypeNormal, "ShutdownHookSucceeded",
+		"Checkpointed the saved version of %d open files and shut down the Notebook server", checkpoints)
+	return nil
+}
+
+// notebookRunning returns true if the Pod of the Notebook is ready, i.e. its
+// server can be shut down. A terminating Pod only counts while the Notebook
+// is deleted, since the garbage collector deletes its StatefulSet, and the
+// Pod, while the finalizer is pending on a foreground deletion.
+func (r *NotebookReconciler) notebookRunning(ctx context.Context, instance *v1beta1.Notebook) (bool, error) {
+	pod := &corev1.Pod{}
+	err := r.Get(ctx, types.NamespacedName{Name: instance.Name + "-0", Namespace: instance.Namespace}, pod)
+	if apierrs.IsNotFound(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	if !pod.DeletionTimestamp.IsZero() && instance.DeletionTimestamp.IsZero() {
+		return false, nil
+	}
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady {
+			return condition.Status == corev1.ConditionTrue, nil
+		}
+	}
+	return false, nil
+}
+
+// runShutdownHook runs the jupyter hook of the Notebook with the given id in
+// the background, and returns true while it is pending. The hook is skipped
+// if the Notebook isn't running.
+func (r *NotebookReconciler) runShutdownHook(ctx context.Context, instance *v1beta1.Notebook, id string) (bool, error) {
+	running, err := r.notebookRunning(ctx, instance)
+	if err != nil || !running {
+		return false, err
+	}
+
+	key := types.NamespacedName{Name: instance.Name, Namespace: instance.Namespace}
+	nb, endpoint := instance.DeepCopy(), r.probeEndpoint(ctx, instance)
+	done, err := r.shutdownHooks.run(key, id, func() error {
+		return r.runJupyterShutdownHook(nb, endpoint)
+	})
+	if done && err != nil {
+		// The failure is recorded in an event, and doesn't prevent stopping
+		// or deleting the Notebook
+		r.Log.Error(err, "shutdown hook failed", "notebook", key)
+	}
+	return !done, nil
+}
+
+// reconcileShutdownHook runs the jupyter hook of a Notebook that is being
+// stopped, and returns true while the Notebook must keep running for it.
+func (r *NotebookReconciler) reconcileShutdownHook(ctx context.Context, instance *v1beta1.Notebook) (bool, error) {
+	if shutdownHook(instance) != ShutdownHookJupyter || !culler.StopAnnotationIsSet(instance.ObjectMeta) {
+		return false, nil
+	}
+	stoppedAt := instance.GetAnnotations()[culler.STOP_ANNOTATION]
+	if instance.GetAnnotations()[AnnotationShutdownHookDone] == stoppedAt {
+		return false, nil
+	}
+
+	pending, err := r.runShutdownHook(ctx, instance, stoppedAt)
+	if err != nil || pending {
+		return pending, err
+	}
+	instance.Annotations[AnnotationShutdownHookDone] = stoppedAt
+	return false, r.Update(ctx, instance)
+}
+
+// reconcileShutdownHookFinalizer adds the finalizer to the Notebooks with a
+// jupyter hook, and removes it from the other ones. On deletion, the
+// finalizer is removed once the hook ran. It returns true while the
+// Notebook must keep running for the hook.
+func (r *NotebookReconciler) reconcileShutdownHookFinalizer(ctx context.Context, instance *v1beta1.Notebook) (bool, error) {
+	hasFinalizer := controllerutil.ContainsFinalizer(instance, ShutdownHookFinalizer)
+
+	if instance.DeletionTimestamp.IsZero() {
+		wantsFinalizer := shutdownHook(instance) == ShutdownHookJupyter
+		if wantsFinalizer == hasFinalizer {
+			return false, nil
+		}
+		if wantsFinalizer {
+			controllerutil.AddFinalizer(instance, ShutdownHookFinalizer)
+		} else {
+			controllerutil.RemoveFinalizer(instance, ShutdownHookFinalizer)
+		}
+		return false, r.Update(ctx, instance)
+	}
+
+	if !hasFinalizer {
+		return false, nil
+	}
+	if shutdownHook(instance) == ShutdownHookJupyter {
+		pending, err := r.runShutdownHook(ctx, instance, shutdownHookDeletion)
+		if err != nil || pending {
+			return pending, err
+		}
+	}
+	controllerutil.RemoveFinalizer(instance, ShutdownHookFinalizer)
+	if err := r.Update(ctx, instance); err != nil {
+		return false, fmt.Errorf("unable to remove the finalizer: %v", err)
+	}
+	return false, nil
+}
@@ -0,0 +1,216 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	nbv1beta1 "github.com/kubeflow/kubeflow/components/notebook-controller/api/v1beta1"
+	"github.com/kubeflow/kubeflow/components/notebook-controller/pkg/config"
+	"github.com/kubeflow/kubeflow/components/notebook-controller/pkg/culler"
+)
+
+func TestShutdownHooksRun(t *testing.T) {
+	hooks := &shutdownHooks{}
+	key := types.NamespacedName{Name: "test", Namespace: "default"}
+	release := make(chan struct{})
+	runs := 0
+	hook := func() error {
+		runs++
+		<-release
+		return errors.New("server unreachable")
+	}
+
+	for i := 0; i < 2; i++ {
+		if done, _ := hooks.run(key, "stop", hook); done {
+			t.Fatalf("Expected the hook to be pending")
+		}
+	}
+	close(release)
+
+	var done bool
+	var err error
+	for start := time.Now(); !done && time.Since(start) < 5*time.Second; time.Sleep(10 * time.Millisecond) {
+		done, err = hooks.run(key, "stop", hook)
+	}
+	if !done || err == nil || runs != 1 {
+		t.Errorf("Expected a single run of the hook to fail, got done %t, error %v, %d runs", done, err, runs)
+	}
+	if _, ok := hooks.runs[key]; ok {
+		t.Errorf("Expected the result of the run to be returned once")
+	}
+}
+
+func TestSetPreStopCommand(t *testing.T) {
+	c := config.FromEnv()
+	c.ShutdownHook.Command = []string{"jupyter", "nbconvert", "--to", "notebook", "--inplace", "*.ipynb"}
+	config.Set(c)
+	defer config.Set(nil)
+
+	nb := workspaceNotebook("10Gi", "")
+	if lifecycle := generateStatefulSet(nb).Spec.Template.Spec.Containers[0].Lifecycle; lifecycle != nil {
+		t.Errorf("Expected no preStop hook without the annotation, got %+v", lifecycle)
+	}
+
+	nb.Annotations = map[string]string{AnnotationShutdownHook: ShutdownHookCommand}
+	lifecycle := generateStatefulSet(nb).Spec.Template.Spec.Containers[0].Lifecycle
+	if lifecycle == nil || lifecycle.PreStop == nil || lifecycle.PreStop.Exec == nil ||
+		!reflect.DeepEqual(lifecycle.PreStop.Exec.Command, c.ShutdownHook.Command) {
+		t.Errorf("Expected the command of the configuration as the preStop hook, got %+v", lifecycle)
+	}
+
+	own := &corev1.LifecycleHandler{Exec: &corev1.ExecAction{Command: []string{"true"}}}
+	nb.Spec.Template.Spec.Containers[0].Lifecycle = &corev1.Lifecycle{PreStop: own}
+	lifecycle = generateStatefulSet(nb).Spec.Template.Spec.Containers[0].Lifecycle
+	if !reflect.DeepEqual(lifecycle.PreStop, own) {
+		t.Errorf("Expected the preStop hook of the container to be kept, got %+v", lifecycle.PreStop)
+	}
+}
+
+func TestSetShutdownGracePeriod(t *testing.T) {
+	c := config.FromEnv()
+	c.ShutdownHook.Timeout = metav1.Duration{Duration: 90 * time.Second}
+	config.Set(c)
+	defer config.Set(nil)
+
+	nb := workspaceNotebook("10Gi", "")
+	if gracePeriod := generateStatefulSet(nb).Spec.Template.Spec.TerminationGracePeriodSeconds; gracePeriod != nil {
+		t.Errorf("Expected the default grace period without the annotation, got %d", *gracePeriod)
+	}
+
+	nb.Annotations = map[string]string{AnnotationShutdownHook: ShutdownHookJupyter}
+	gracePeriod := generateStatefulSet(nb).Spec.Template.Spec.TerminationGracePeriodSeconds
+	if gracePeriod == nil || *gracePeriod != 90 {
+		t.Errorf("Expected the timeout of the hook as the grace period, got %v", gracePeriod)
+	}
+
+	own := int64(300)
+	nb.Spec.Template.Spec.TerminationGracePeriodSeconds = &own
+	if gracePeriod := generateStatefulSet(nb).Spec.Template.Spec.TerminationGracePeriodSeconds; *gracePeriod != own {
+		t.Errorf("Expected the longer grace period of the Notebook to be kept, got %d", *gracePeriod)
+	}
+}
+
+func shutdownHookScheme() *runtime.Scheme {
+	scheme := runtime.NewScheme()
+	_ = corev1.AddToScheme(scheme)
+	_ = nbv1beta1.AddToScheme(scheme)
+	return scheme
+}
+
+func TestReconcileShutdownHook(t *testing.T) {
+	nb := workspaceNotebook("10Gi", "")
+	nb.Annotations = map[string]string{
+		AnnotationShutdownHook: ShutdownHookJupyter,
+		culler.STOP_ANNOTATION: "2022-08-30T16:37:36Z",
+	}
+	// The Pod of the Notebook is not ready, so its server can't be shut down
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "test-0", Namespace: "default"}}
+	r := &NotebookReconciler{
+		Client:        fake.NewClientBuilder().WithScheme(shutdownHookScheme()).WithObjects(nb, pod).Build(),
+		Log:           logr.Discard(),
+		EventRecorder: record.NewFakeRecorder(10),
+	}
+
+	pending, err := r.reconcileShutdownHook(context.TODO(), nb)
+	if err != nil || pending {
+		t.Errorf("Expected the stopped Notebook to be scaled down, got pending %t, error %v", pending, err)
+	}
+	if _, ok := r.shutdownHooks.runs[types.NamespacedName{Name: "test", Namespace: "default"}]; ok {
+		t.Errorf("Expected the hook not to run")
+	}
+}
+
+func TestReconcileShutdownHookFinalizer(t *testing.T) {
+	nb := workspaceNotebook("10Gi", "")
+	nb.Annotations = map[string]string{AnnotationShutdownHook: ShutdownHookJupyter}
+	r := &NotebookReconciler{
+		Client:        fake.NewClientBuilder().WithScheme(shutdownHookScheme()).WithObjects(nb).Build(),
+		Log:           logr.Discard(),
+		EventRecorder: record.NewFakeRecorder(10),
+	}
+	key := types.NamespacedName{Name: "test", Namespace: "default"}
+
+	if _, err := r.reconcileShutdownHookFinalizer(context.TODO(), nb); err != nil {
+		t.Fatal(err)
+	}
+	found := &nbv1beta1.Notebook{}
+	if err := r.Get(context.TODO(), key, found); err != nil {
+		t.Fatal(err)
+	}
+	if !controllerutil.ContainsFinalizer(found, ShutdownHookFinalizer) {
+		t.Fatalf("Expected the finalizer to be added, got %v", found.Finalizers)
+	}
+
+	// The deleted Notebook has no Pod, so the finalizer is removed right away
+	now := metav1.Now()
+	found.DeletionTimestamp = &now
+	pending, err := r.reconcileShutdownHookFinalizer(context.TODO(), found)
+	if err != nil || pending {
+		t.Fatalf("Got pending %t, error %v", pending, err)
+	}
+	if controllerutil.ContainsFinalizer(found, ShutdownHookFinalizer) {
+		t.Errorf("Expected the finalizer to be removed, got %v", found.Finalizers)
+	}
+
+	delete(nb.Annotations, AnnotationShutdownHook)
+	nb.Finalizers = []string{ShutdownHookFinalizer}
+	r.Client = fake.NewClientBuilder().WithScheme(shutdownHookScheme()).WithObjects(nb).Build()
+	if _, err := r.reconcileShutdownHookFinalizer(context.TODO(), nb); err != nil {
+		t.Fatal(err)
+	}
+	if controllerutil.ContainsFinalizer(nb, ShutdownHookFinalizer) {
+		t.Errorf("Expected the finalizer to be removed with the annotation, got %v", nb.Finalizers)
+	}
+}
+
+func TestReconcileShutdownHookFinalizerForegroundDeletion(t *testing.T) {
+	nb := workspaceNotebook("10Gi", "")
+	nb.Annotations = map[string]string{AnnotationShutdownHook: ShutdownHookJupyter}
+	nb.Finalizers = []string{ShutdownHookFinalizer}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-0", Namespace: "default", Finalizers: []string{"test"}},
+		Status: corev1.PodStatus{
+			Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}},
+		},
+	}
+	r := &NotebookReconciler{
+		Client:        fake.NewClientBuilder().WithScheme(shutdownHookScheme()).WithObjects(nb, pod).Build(),
+		Log:           logr.Discard(),
+		EventRecorder: record.NewFakeRecorder(10),
+	}
+	key := types.NamespacedName{Name: "test", Namespace: "default"}
+
+	// The foreground deletion of the Notebook deletes its Pod while the
+	// finalizer is pending
+	if err := r.Delete(context.TODO(), nb, client.PropagationPolicy(metav1.DeletePropagationForeground)); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Delete(context.TODO(), pod); err != nil {
+		t.Fatal(err)
+	}
+	found := &nbv1beta1.Notebook{}
+	if err := r.Get(context.TODO(), key, found); err != nil {
+		t.Fatal(err)
+	}
+
+	pending, err := r.reconcileShutdownHookFinalizer(context.TODO(), found)
+	if err != nil || !pending {
+		t.Fatalf("Expected the hook to run on the terminating Pod, got pending %t, error %v", pending, err)
+	}
+	if run, ok := r.shutdownHooks.runs[key]; !ok || run.id != shutdownHookDeletion {
+		t.Errorf("Expected the deletion hook to run, got %+v", r.shutdownHooks.runs)
+	}
+}
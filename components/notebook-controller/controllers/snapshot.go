@@ -0,0 +1,196 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/kubeflow/kubeflow/components/notebook-controller/api/v1beta1"
+	"github.com/kubeflow/kubeflow/components/notebook-controller/pkg/culler"
+	corev1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// The VolumeSnapshots of a workspace are labeled with the name of the
+// Notebook and WorkspaceSnapshotLabel, and annotated with the time and the
+// reason of the stop they were taken for.
+const (
+	WorkspaceSnapshotLabel            = "notebooks.kubeflow.org/workspace-snapshot"
+	WorkspaceSnapshotTimeAnnotation   = "notebooks.kubeflow.org/stopped-at"
+	WorkspaceSnapshotReasonAnnotation = "notebooks.kubeflow.org/stop-reason"
+)
+
+const volumeSnapshotGroup = "snapshot.storage.k8s.io"
+
+var volumeSnapshotGVK = schema.GroupVersionKind{
+	Group:   volumeSnapshotGroup,
+	Version: "v1",
+	Kind:    "VolumeSnapshot",
+}
+
+// workspaceSnapshotName returns the name of the snapshot of the workspace
+// taken when the Notebook was stopped at the given time.
+func workspaceSnapshotName(nb *v1beta1.Notebook, stoppedAt time.Time) string {
+	return fmt.Sprintf("%s-%d", workspaceClaimName(nb), stoppedAt.Unix())
+}
+
+// snapshotRetention returns the number of snapshots of the workspace to keep.
+func snapshotRetention(snapshots *v1beta1.WorkspaceSnapshots) int {
+	if snapshots.Retain == nil || *snapshots.Retain < 1 {
+		return v1beta1.DefaultWorkspaceSnapshotRetention
+	}
+	return int(*snapshots.Retain)
+}
+
+// restoreDataSource returns the data source of the workspace claim, or nil if
+// the workspace isn't restored from a snapshot.
+func restoreDataSource(workspace *v1beta1.NotebookWorkspace) *corev1.TypedLocalObjectReference {
+	if workspace.RestoreFromSnapshot == "" {
+		return nil
+	}
+	apiGroup := volumeSnapshotGroup
+	return &corev1.TypedLocalObjectReference{
+		APIGroup: &apiGroup,
+		Kind:     volumeSnapshotGVK.Kind,
+		Name:     workspace.RestoreFromSnapshot,
+	}
+}
+
+// generateWorkspaceSnapshot returns the VolumeSnapshot of the workspace taken
+// for the Notebook's last stop.
+func generateWorkspaceSnapshot(nb *v1beta1.Notebook) *unstructured.Unstructured {
+	lifecycle := nb.Status.Lifecycle
+	snapshot := &unstructured.Unstructured{}
+	snapshot.SetGroupVersionKind(volumeSnapshotGVK)
+	snapshot.SetName(workspaceSnapshotName(nb, lifecycle.LastTransitionTime.Time))
+	snapshot.SetNamespace(nb.Namespace)
+	snapshot.SetLabels(map[string]string{
+		"notebook-name":        nb.Name,
+		WorkspaceSnapshotLabel: "true",
+	})
+	snapshot.SetAnnotations(map[string]string{
+		WorkspaceSnapshotTimeAnnotation:   lifecycle.LastTransitionTime.UTC().Format(time.RFC3339),
+		WorkspaceSnapshotReasonAnnotation: lifecycle.Reason,
+	})
+
+	spec := map[string]interface{}{
+		"source": map[string]interface{}{
+			"persistentVolumeClaimName": workspaceClaimName(nb),
+		},
+	}
+	if class := nb.Spec.Workspace.Snapshots.VolumeSnapshotClassName; class != nil {
+		spec["volumeSnapshotClassName"] = *class
+	}
+	snapshot.Object["spec"] = spec
+	return snapshot
+}
+
+// needsWorkspaceSnapshot returns true if the workspace should be snapshotted:
+// the Notebook is stopped, its Pod is gone and its claim was bound. Notebooks
+// held by the reconciliation lock were never started, so there is nothing to
+// save.
+func needsWorkspaceSnapshot(nb *v1beta1.Notebook, podFound bool, workspace *v1beta1.WorkspaceStatus) bool {
+	lifecycle := nb.Status.Lifecycle
+	return culler.StopAnnotationIsSet(nb.ObjectMeta) && !podFound &&
+		workspace.Phase == corev1.ClaimBound &&
+		lifecycle != nil && lifecycle.Stopped &&
+		lifecycle.Reason != v1beta1.LifecycleReasonReconciliationLock
+}
+
+// reconcileWorkspaceSnapshots takes a VolumeSnapshot of the workspace of a
+// stopped Notebook, deletes the oldest snapshots beyond the retention count
+// and sets the last snapshot in the status of the workspace. It is a no-op
+// if the snapshot CRDs aren't installed.
+func (r *NotebookReconciler) reconcileWorkspaceSnapshots(ctx context.Context, nb *v1beta1.Notebook,
+	podFound bool, workspace *v1beta1.WorkspaceStatus) error {
+
+	log := r.Log.WithValues("notebook", types.NamespacedName{Name: nb.Name, Namespace: nb.Namespace})
+	if workspace == nil || nb.Spec.Workspace.Snapshots == nil {
+		return nil
+	}
+
+	list := &unstructured.UnstructuredList{}
+	list.SetGroupVersionKind(volumeSnapshotGVK.GroupVersion().WithKind(volumeSnapshotGVK.Kind + "List"))
+	err := r.List(ctx, list, client.InNamespace(nb.Namespace), client.MatchingLabels{
+		"notebook-name":        nb.Name,
+		WorkspaceSnapshotLabel: "true",
+	})
+	if meta.IsNoMatchError(err) {
+		log.Info("VolumeSnapshots are not available in the cluster. Won't snapshot the workspace")
+		return nil
+	} else if err != nil {
+		log.Error(err, "error listing workspace VolumeSnapshots")
+		return err
+	}
+	snapshots := list.Items
+
+	if needsWorkspaceSnapshot(nb, podFound, workspace) {
+		snapshot := generateWorkspaceSnapshot(nb)
+		taken := false
+		for _, s := range snapshots {
+			if s.GetName() == snapshot.GetName() {
+				taken = true
+				break
+			}
+		}
+
+		if !taken {
+			log.Info("Creating workspace VolumeSnapshot", "name", snapshot.GetName())
+			err := r.Create(ctx, snapshot)
+			if err != nil && !apierrs.IsAlreadyExists(err) {
+				log.Error(err, "unable to create workspace VolumeSnapshot")
+				r.EventRecorder.Eventf(nb, corev1.EventTypeWarning, "WorkspaceSnapshotFailed",
+					"Unable to create VolumeSnapshot %s: %v", snapshot.GetName(), err)
+				return err
+			}
+			r.EventRecorder.Eventf(nb, corev1.EventTypeNormal, "WorkspaceSnapshotCreated",
+				"Created VolumeSnapshot %s of the workspace", snapshot.GetName())
+			snapshots = append(snapshots, *snapshot)
+		}
+	}
+
+	// Newest first
+	sort.SliceStable(snapshots, func(i, j int) bool {
+		return snapshots[i].GetAnnotations()[WorkspaceSnapshotTimeAnnotation] >
+			snapshots[j].GetAnnotations()[WorkspaceSnapshotTimeAnnotation]
+	})
+	if len(snapshots) > 0 {
+		workspace.LastSnapshot = snapshots[0].GetName()
+	}
+
+	for i := snapshotRetention(nb.Spec.Workspace.Snapshots); i < len(snapshots); i++ {
+		snapshot := &snapshots[i]
+		// The claim might still be provisioned from the snapshot
+		if snapshot.GetName() == nb.Spec.Workspace.RestoreFromSnapshot && workspace.Phase != corev1.ClaimBound {
+			continue
+		}
+
+		log.Info("Deleting workspace VolumeSnapshot beyond the retention count", "name", snapshot.GetName())
+		if err := r.Delete(ctx, snapshot); err != nil && !apierrs.IsNotFound(err) {
+			log.Error(err, "unable to delete workspace VolumeSnapshot")
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+
+	nbv1beta1 "github.com/kubeflow/kubeflow/components/notebook-controller/api/v1beta1"
+	"github.com/kubeflow/kubeflow/components/notebook-controller/pkg/culler"
+)
+
+var _ = Describe("Workspace snapshots", func() {
+
+	const (
+		Namespace = "default"
+		timeout   = time.Second * 10
+		interval  = time.Millisecond * 250
+	)
+
+	newNotebook := func(name string, workspace *nbv1beta1.NotebookWorkspace) *nbv1beta1.Notebook {
+		return &nbv1beta1.Notebook{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: Namespace,
+			},
+			Spec: nbv1beta1.NotebookSpec{
+				Template: nbv1beta1.NotebookTemplateSpec{
+					Spec: v1.PodSpec{Containers: []v1.Container{{
+						Name:  "busybox",
+						Image: "busybox",
+					}}}},
+				Workspace: workspace,
+			}}
+	}
+
+	Context("When a Notebook with workspace snapshots is stopped", func() {
+		It("Should snapshot the workspace", func() {
+			ctx := context.Background()
+			stoppedAt := time.Date(2022, 8, 30, 16, 37, 36, 0, time.UTC)
+
+			By("By creating a stopped Notebook")
+			notebook := newNotebook("test-snapshot", &nbv1beta1.NotebookWorkspace{
+				Size:      resource.MustParse("1Gi"),
+				Snapshots: &nbv1beta1.WorkspaceSnapshots{},
+			})
+			notebook.Annotations = map[string]string{culler.STOP_ANNOTATION: stoppedAt.Format(time.RFC3339)}
+			Expect(k8sClient.Create(ctx, notebook)).Should(Succeed())
+
+			/*
+				There is no PersistentVolume controller in envtest, so the
+				claim is bound by hand.
+			*/
+			By("By binding the workspace PersistentVolumeClaim")
+			claim := &v1.PersistentVolumeClaim{}
+			claimKey := types.NamespacedName{Name: "test-snapshot-workspace", Namespace: Namespace}
+			Eventually(func() error {
+				return k8sClient.Get(ctx, claimKey, claim)
+			}, timeout, interval).Should(Succeed())
+			claim.Status.Phase = v1.ClaimBound
+			Expect(k8sClient.Status().Update(ctx, claim)).Should(Succeed())
+
+			By("By checking that the workspace was snapshotted")
+			snapshot := &unstructured.Unstructured{}
+			snapshot.SetGroupVersionKind(volumeSnapshotGVK)
+			snapshotKey := types.NamespacedName{
+				Name:      "test-snapshot-workspace-1661877456",
+				Namespace: Namespace,
+			}
+			Eventually(func() error {
+				return k8sClient.Get(ctx, snapshotKey, snapshot)
+			}, timeout, interval).Should(Succeed())
+			source, _, _ := unstructured.NestedString(snapshot.Object, "spec", "source", "persistentVolumeClaimName")
+			Expect(source).To(Equal("test-snapshot-workspace"))
+
+			Eventually(func() string {
+				nb := &nbv1beta1.Notebook{}
+				if err := k8sClient.Get(ctx, types.NamespacedName{Name: "test-snapshot", Namespace: Namespace}, nb); err != nil {
+					return ""
+				}
+				if nb.Status.Workspace == nil {
+					return ""
+				}
+				return nb.Status.Workspace.LastSnapshot
+			}, timeout, interval).Should(Equal(snapshotKey.Name))
+		})
+	})
+
+	Context("When a Notebook is restored from a snapshot", func() {
+		It("Should use the snapshot as the data source of the workspace", func() {
+			ctx := context.Background()
+
+			By("By creating a Notebook restored from a snapshot")
+			notebook := newNotebook("test-restore", &nbv1beta1.NotebookWorkspace{
+				Size:                resource.MustParse("1Gi"),
+				RestoreFromSnapshot: "test-snapshot-workspace-1661877456",
+			})
+			Expect(k8sClient.Create(ctx, notebook)).Should(Succeed())
+
+			By("By checking the data source of the workspace PersistentVolumeClaim")
+			claim := &v1.PersistentVolumeClaim{}
+			claimKey := types.NamespacedName{Name: "test-restore-workspace", Namespace: Namespace}
+			Eventually(func() error {
+				return k8sClient.Get(ctx, claimKey, claim)
+			}, timeout, interval).Should(Succeed())
+			Expect(claim.Spec.DataSource).NotTo(BeNil())
+			Expect(claim.Spec.DataSource.Kind).To(Equal("VolumeSnapshot"))
+			Expect(claim.Spec.DataSource.Name).To(Equal("test-snapshot-workspace-1661877456"))
+		})
+	})
+})
@@ -0,0 +1,152 @@
+package controllers
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	nbv1beta1 "github.com/kubeflow/kubeflow/components/notebook-controller/api/v1beta1"
+	"github.com/kubeflow/kubeflow/components/notebook-controller/pkg/culler"
+)
+
+func stoppedWorkspaceNotebook(stoppedAt time.Time, reason string, retain int32) *nbv1beta1.Notebook {
+	nb := workspaceNotebook("10Gi", "")
+	nb.Spec.Workspace.Snapshots = &nbv1beta1.WorkspaceSnapshots{Retain: &retain}
+	nb.Annotations = map[string]string{culler.STOP_ANNOTATION: stoppedAt.Format(time.RFC3339)}
+	nb.Status.Lifecycle = &nbv1beta1.LifecycleStatus{
+		Stopped:            true,
+		Reason:             reason,
+		LastTransitionTime: metav1.NewTime(stoppedAt),
+	}
+	return nb
+}
+
+func TestReconcileWorkspaceSnapshots(t *testing.T) {
+	stoppedAt := time.Date(2022, 8, 30, 16, 37, 36, 0, time.UTC)
+	previous := []runtime.Object{}
+	for i := 1; i <= 3; i++ {
+		nb := stoppedWorkspaceNotebook(stoppedAt.Add(-time.Duration(i)*time.Hour), nbv1beta1.LifecycleReasonCulled, 2)
+		previous = append(previous, generateWorkspaceSnapshot(nb))
+	}
+
+	tests := []struct {
+		name      string
+		nb        *nbv1beta1.Notebook
+		podFound  bool
+		phase     corev1.PersistentVolumeClaimPhase
+		snapshots []string
+		event     bool
+	}{
+		{
+			name:  "stopped",
+			nb:    stoppedWorkspaceNotebook(stoppedAt, nbv1beta1.LifecycleReasonCulled, 2),
+			phase: corev1.ClaimBound,
+			snapshots: []string{
+				"test-workspace-1661877456",
+				"test-workspace-1661873856",
+			},
+			event: true,
+		},
+		{
+			name:     "Pod still running",
+			nb:       stoppedWorkspaceNotebook(stoppedAt, nbv1beta1.LifecycleReasonCulled, 2),
+			podFound: true,
+			phase:    corev1.ClaimBound,
+			snapshots: []string{
+				"test-workspace-1661873856",
+				"test-workspace-1661870256",
+			},
+		},
+		{
+			name:  "claim never bound",
+			nb:    stoppedWorkspaceNotebook(stoppedAt, nbv1beta1.LifecycleReasonManual, 5),
+			phase: corev1.ClaimPending,
+			snapshots: []string{
+				"test-workspace-1661873856",
+				"test-workspace-1661870256",
+				"test-workspace-1661866656",
+			},
+		},
+		{
+			name:  "reconciliation lock",
+			nb:    stoppedWorkspaceNotebook(stoppedAt, nbv1beta1.LifecycleReasonReconciliationLock, 5),
+			phase: corev1.ClaimBound,
+			snapshots: []string{
+				"test-workspace-1661873856",
+				"test-workspace-1661870256",
+				"test-workspace-1661866656",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			objects := []runtime.Object{}
+			for _, o := range previous {
+				objects = append(objects, o.DeepCopyObject())
+			}
+			recorder := record.NewFakeRecorder(10)
+			r := &NotebookReconciler{
+				Client:        fake.NewClientBuilder().WithRuntimeObjects(objects...).Build(),
+				Log:           logr.Discard(),
+				EventRecorder: recorder,
+			}
+			status := &nbv1beta1.WorkspaceStatus{ClaimName: "test-workspace", Phase: test.phase}
+
+			if err := r.reconcileWorkspaceSnapshots(context.TODO(), test.nb, test.podFound, status); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			list := &unstructured.UnstructuredList{}
+			list.SetGroupVersionKind(volumeSnapshotGVK.GroupVersion().WithKind("VolumeSnapshotList"))
+			if err := r.List(context.TODO(), list, client.InNamespace("default")); err != nil {
+				t.Fatal(err)
+			}
+			names := []string{}
+			for _, s := range list.Items {
+				names = append(names, s.GetName())
+			}
+			sort.Sort(sort.Reverse(sort.StringSlice(names)))
+			if len(names) != len(test.snapshots) {
+				t.Fatalf("Got snapshots %v, expected %v", names, test.snapshots)
+			}
+			for i := range names {
+				if names[i] != test.snapshots[i] {
+					t.Errorf("Got snapshots %v, expected %v", names, test.snapshots)
+					break
+				}
+			}
+
+			if status.LastSnapshot != test.snapshots[0] {
+				t.Errorf("Got last snapshot %s, expected %s", status.LastSnapshot, test.snapshots[0])
+			}
+			if event := len(recorder.Events) > 0; event != test.event {
+				t.Errorf("Got event %t, expected %t", event, test.event)
+			}
+		})
+	}
+}
+
+func TestGenerateWorkspaceClaimRestore(t *testing.T) {
+	nb := workspaceNotebook("10Gi", "")
+	if claim := generateWorkspaceClaim(nb); claim.Spec.DataSource != nil {
+		t.Errorf("Expected no data source, got %+v", claim.Spec.DataSource)
+	}
+
+	nb.Spec.Workspace.RestoreFromSnapshot = "test-workspace-1661877456"
+	dataSource := generateWorkspaceClaim(nb).Spec.DataSource
+	if dataSource == nil || dataSource.APIGroup == nil || *dataSource.APIGroup != "snapshot.storage.k8s.io" ||
+		dataSource.Kind != "VolumeSnapshot" || dataSource.Name != "test-workspace-1661877456" {
+		t.Errorf("Expected the snapshot as the data source, got %+v", dataSource)
+	}
+}
@@ -0,0 +1,207 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"github.com/kubeflow/kubeflow/components/notebook-controller/api/v1beta1"
+	"github.com/kubeflow/kubeflow/components/notebook-controller/pkg/culler"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// podFailure is a failure of a container of the Notebook's Pod that won't go
+// away without the user's attention.
+type podFailure struct {
+	reason  string
+	message string
+}
+
+// classifyPodFailure returns the first failure of the containers of the Pod,
+// or nil.
+func classifyPodFailure(pod *corev1.Pod) *podFailure {
+	statuses := append(append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...),
+		pod.Status.ContainerStatuses...)
+
+	for i := range statuses {
+		waiting := statuses[i].State.Waiting
+		if waiting == nil {
+			continue
+		}
+
+		switch waiting.Reason {
+		case "ErrImagePull", "ImagePullBackOff", "InvalidImageName", "ErrImageNeverPull":
+			return &podFailure{v1beta1.NotebookReasonImagePullFailed, waiting.Message}
+		case "CreateContainerConfigError", "CreateContainerError":
+			return &podFailure{v1beta1.NotebookReasonInvalidConfiguration, waiting.Message}
+		case "CrashLoopBackOff":
+			if last := statuses[i].LastTerminationState.Terminated; last != nil && last.Reason == "OOMKilled" {
+				return &podFailure{v1beta1.NotebookReasonOutOfMemory,
+					"Container " + statuses[i].Name + " was killed for using more memory than its limit"}
+			}
+			return &podFailure{v1beta1.NotebookReasonCrashLoopBackOff, waiting.Message}
+		}
+	}
+	return nil
+}
+
+// podCondition returns the condition of the given type of the Pod, or nil.
+func podCondition(pod *corev1.Pod, conditionType corev1.PodConditionType) *corev1.PodCondition {
+	for i := range pod.Status.Conditions {
+		if pod.Status.Conditions[i].Type == conditionType {
+			return &pod.Status.Conditions[i]
+		}
+	}
+	return nil
+}
+
+// notebookContainerStatus returns the status of the container with the same
+// name as the Notebook, or nil.
+func notebookContainerStatus(nb *v1beta1.Notebook, pod *corev1.Pod) *corev1.ContainerStatus {
+	for i := range pod.Status.ContainerStatuses {
+		if pod.Status.ContainerStatuses[i].Name == nb.Name {
+			return &pod.Status.ContainerStatuses[i]
+		}
+	}
+	return nil
+}
+
+// setNotebookConditions sets the conditions owned by the controller, keeping
+// the transition times of the conditions of the current status. The Routed
+// condition is only set if the Notebooks are routed by the backend.
+func setNotebookConditions(nb *v1beta1.Notebook, status *v1beta1.NotebookStatus,
+	sts *appsv1.StatefulSet, pod *corev1.Pod, backend RoutingBackend) {
+
+	status.Conditions = append([]v1beta1.NotebookCondition{}, nb.Status.Conditions...)
+	// Drop the conditions that were mirrored from the Pod by older versions
+	// of the controller
+	owned := status.Conditions[:0]
+	for _, c := range status.Conditions {
+		switch c.Type {
+		case v1beta1.NotebookConditionReady, v1beta1.NotebookConditionScheduled,
+			v1beta1.NotebookConditionImagePulled, v1beta1.NotebookConditionRouted,
+			v1beta1.NotebookConditionWithinLimits:
+			owned = append(owned, c)
+		}
+	}
+	status.Conditions = owned
+
+	now := metav1.Now()
+	set := func(conditionType, conditionStatus, reason, message string) {
+		status.SetCondition(v1beta1.NotebookCondition{
+			Type:               conditionType,
+			Status:             conditionStatus,
+			Reason:             reason,
+			Message:            message,
+			ObservedGeneration: nb.Generation,
+		}, now)
+	}
+
+	podExists := pod.Name != ""
+	if !podExists {
+		reason, message := v1beta1.NotebookReasonPodPending, "Waiting for the Pod to be created"
+		if culler.StopAnnotationIsSet(nb.ObjectMeta) {
+			reason, message = v1beta1.NotebookReasonStopped, "The Notebook is stopped"
+		}
+		set(v1beta1.NotebookConditionScheduled, v1beta1.ConditionFalse, reason, message)
+		set(v1beta1.NotebookConditionImagePulled, v1beta1.ConditionUnknown, reason, message)
+		set(v1beta1.NotebookConditionReady, v1beta1.ConditionFalse, reason, message)
+	} else {
+		failure := classifyPodFailure(pod)
+
+		// Scheduled
+		scheduled := podCondition(pod, corev1.PodScheduled)
+		switch {
+		case scheduled == nil:
+			set(v1beta1.NotebookConditionScheduled, v1beta1.ConditionUnknown,
+				v1beta1.NotebookReasonPodPending, "Waiting for the Pod to be scheduled")
+		case scheduled.Status == corev1.ConditionTrue:
+			set(v1beta1.NotebookConditionScheduled, v1beta1.ConditionTrue,
+				v1beta1.NotebookReasonScheduled, "")
+		default:
+			reason := scheduled.Reason
+			if reason != v1beta1.NotebookReasonUnschedulable {
+				reason = v1beta1.NotebookReasonPodPending
+			}
+			set(v1beta1.NotebookConditionScheduled, v1beta1.ConditionFalse, reason, scheduled.Message)
+		}
+
+		// ImagePulled
+		container := notebookContainerStatus(nb, pod)
+		switch {
+		case failure != nil && failure.reason == v1beta1.NotebookReasonImagePullFailed:
+			set(v1beta1.NotebookConditionImagePulled, v1beta1.ConditionFalse, failure.reason, failure.message)
+		case container != nil && container.ImageID != "":
+			set(v1beta1.NotebookConditionImagePulled, v1beta1.ConditionTrue,
+				v1beta1.NotebookReasonImagePulled, "")
+		default:
+			set(v1beta1.NotebookConditionImagePulled, v1beta1.ConditionUnknown,
+				v1beta1.NotebookReasonImagePulling, "")
+		}
+
+		// Ready
+		ready := podCondition(pod, corev1.PodReady)
+		switch {
+		case sts.Status.ReadyReplicas > 0 && ready != nil && ready.Status == corev1.ConditionTrue:
+			set(v1beta1.NotebookConditionReady, v1beta1.ConditionTrue, v1beta1.NotebookReasonReady, "")
+		case failure != nil:
+			set(v1beta1.NotebookConditionReady, v1beta1.ConditionFalse, failure.reason, failure.message)
+		case culler.StopAnnotationIsSet(nb.ObjectMeta):
+			set(v1beta1.NotebookConditionReady, v1beta1.ConditionFalse,
+				v1beta1.NotebookReasonStopped, "The Notebook is stopping")
+		default:
+			set(v1beta1.NotebookConditionReady, v1beta1.ConditionFalse,
+				v1beta1.NotebookReasonStarting, "Waiting for the Notebook server to be ready")
+		}
+	}
+
+	// Routed is only known when the controller manages the route, which is
+	// reconciled before the status
+	if backend != nil {
+		if routesToActivator(backend, nb, sts) {
+			set(v1beta1.NotebookConditionRouted, v1beta1.ConditionTrue,
+				v1beta1.NotebookReasonRoutedToActivator, "Requests start the Notebook")
+		} else {
+			set(v1beta1.NotebookConditionRouted, v1beta1.ConditionTrue,
+				v1beta1.NotebookReasonRoutedToNotebook, "")
+		}
+	}
+}
+
+// notebookPhase summarizes the status of the Notebook.
+func notebookPhase(nb *v1beta1.Notebook, status *v1beta1.NotebookStatus, pod *corev1.Pod) string {
+	if culler.StopAnnotationIsSet(nb.ObjectMeta) {
+		switch {
+		case pod.Name != "":
+			return v1beta1.NotebookPhaseStopping
+		case status.Lifecycle != nil && status.Lifecycle.Reason == v1beta1.LifecycleReasonCulled:
+			return v1beta1.NotebookPhaseCulled
+		default:
+			return v1beta1.NotebookPhaseStopped
+		}
+	}
+
+	if pod.Name != "" && (pod.Status.Phase == corev1.PodFailed || classifyPodFailure(pod) != nil) {
+		return v1beta1.NotebookPhaseFailed
+	}
+	if c := status.GetCondition(v1beta1.NotebookConditionReady); c != nil && c.Status == v1beta1.ConditionTrue {
+		return v1beta1.NotebookPhaseRunning
+	}
+	if c := status.GetCondition(v1beta1.NotebookConditionScheduled); c != nil && c.Status == v1beta1.ConditionTrue {
+		return v1beta1.NotebookPhaseStarting
+	}
+	return v1beta1.NotebookPhasePending
+}
@@ -0,0 +1,199 @@
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	nbv1beta1 "github.com/kubeflow/kubeflow/components/notebook-controller/api/v1beta1"
+	"github.com/kubeflow/kubeflow/components/notebook-controller/pkg/culler"
+)
+
+func waitingPod(name, reason string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name + "-0", Namespace: "default"},
+		Status: corev1.PodStatus{
+			Phase: corev1.PodPending,
+			Conditions: []corev1.PodCondition{
+				{Type: corev1.PodScheduled, Status: corev1.ConditionTrue},
+			},
+			ContainerStatuses: []corev1.ContainerStatus{{
+				Name: name,
+				State: corev1.ContainerState{
+					Waiting: &corev1.ContainerStateWaiting{Reason: reason, Message: reason + " message"},
+				},
+			}},
+		},
+	}
+}
+
+func TestClassifyPodFailure(t *testing.T) {
+	tests := []struct {
+		name   string
+		pod    *corev1.Pod
+		reason string
+	}{
+		{
+			name: "starting",
+			pod:  waitingPod("test", "ContainerCreating"),
+		},
+		{
+			name:   "image pull back-off",
+			pod:    waitingPod("test", "ImagePullBackOff"),
+			reason: nbv1beta1.NotebookReasonImagePullFailed,
+		},
+		{
+			name:   "invalid image name",
+			pod:    waitingPod("test", "InvalidImageName"),
+			reason: nbv1beta1.NotebookReasonImagePullFailed,
+		},
+		{
+			name:   "crash loop",
+			pod:    waitingPod("test", "CrashLoopBackOff"),
+			reason: nbv1beta1.NotebookReasonCrashLoopBackOff,
+		},
+		{
+			name:   "missing Secret",
+			pod:    waitingPod("test", "CreateContainerConfigError"),
+			reason: nbv1beta1.NotebookReasonInvalidConfiguration,
+		},
+		{
+			name: "out of memory",
+			pod: func() *corev1.Pod {
+				pod := waitingPod("test", "CrashLoopBackOff")
+				pod.Status.ContainerStatuses[0].LastTerminationState.Terminated =
+					&corev1.ContainerStateTerminated{Reason: "OOMKilled", ExitCode: 137}
+				return pod
+			}(),
+			reason: nbv1beta1.NotebookReasonOutOfMemory,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			failure := classifyPodFailure(test.pod)
+			if test.reason == "" {
+				if failure != nil {
+					t.Errorf("Expected no failure, got %+v", failure)
+				}
+				return
+			}
+			if failure == nil || failure.reason != test.reason {
+				t.Errorf("Got %+v, expected reason %s", failure, test.reason)
+			}
+		})
+	}
+}
+
+func TestSetNotebookConditionsTransitionTime(t *testing.T) {
+	before := metav1.NewTime(time.Now().Add(-time.Hour).Truncate(time.Second))
+	nb := &nbv1beta1.Notebook{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default", Generation: 3},
+		Status: nbv1beta1.NotebookStatus{
+			Conditions: []nbv1beta1.NotebookCondition{
+				{Type: nbv1beta1.NotebookConditionScheduled, Status: nbv1beta1.ConditionTrue, LastTransitionTime: before},
+				{Type: nbv1beta1.NotebookConditionReady, Status: nbv1beta1.ConditionTrue, LastTransitionTime: before},
+				// Mirrored from the Pod by older versions of the controller
+				{Type: "ContainersReady", Status: "True", LastTransitionTime: before},
+			},
+		},
+	}
+	pod := waitingPod("test", "CrashLoopBackOff")
+
+	status := nbv1beta1.NotebookStatus{}
+	setNotebookConditions(nb, &status, &appsv1.StatefulSet{}, pod, nil)
+
+	if len(status.Conditions) != 3 {
+		t.Errorf("Expected the Scheduled, ImagePulled and Ready conditions, got %+v", status.Conditions)
+	}
+	scheduled := status.GetCondition(nbv1beta1.NotebookConditionScheduled)
+	if scheduled == nil || !scheduled.LastTransitionTime.Equal(&before) || scheduled.ObservedGeneration != 3 {
+		t.Errorf("Expected the transition time of an unchanged condition to be kept, got %+v", scheduled)
+	}
+	ready := status.GetCondition(nbv1beta1.NotebookConditionReady)
+	if ready == nil || ready.Status != nbv1beta1.ConditionFalse || ready.Reason != nbv1beta1.NotebookReasonCrashLoopBackOff {
+		t.Errorf("Expected the Notebook not to be ready because of its crash loop, got %+v", ready)
+	}
+	if ready != nil && !ready.LastTransitionTime.After(before.Time) {
+		t.Errorf("Expected the transition time of a changed condition to be updated, got %v", ready.LastTransitionTime)
+	}
+}
+
+func TestNotebookPhase(t *testing.T) {
+	ready := nbv1beta1.NotebookCondition{Type: nbv1beta1.NotebookConditionReady, Status: nbv1beta1.ConditionTrue}
+	scheduled := nbv1beta1.NotebookCondition{Type: nbv1beta1.NotebookConditionScheduled, Status: nbv1beta1.ConditionTrue}
+	runningPod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-0"},
+		Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+	}
+
+	tests := []struct {
+		name       string
+		stopped    bool
+		pod        *corev1.Pod
+		conditions []nbv1beta1.NotebookCondition
+		lifecycle  *nbv1beta1.LifecycleStatus
+		phase      string
+	}{
+		{
+			name:  "no Pod yet",
+			pod:   &corev1.Pod{},
+			phase: nbv1beta1.NotebookPhasePending,
+		},
+		{
+			name:       "scheduled",
+			pod:        runningPod,
+			conditions: []nbv1beta1.NotebookCondition{scheduled},
+			phase:      nbv1beta1.NotebookPhaseStarting,
+		},
+		{
+			name:       "ready",
+			pod:        runningPod,
+			conditions: []nbv1beta1.NotebookCondition{scheduled, ready},
+			phase:      nbv1beta1.NotebookPhaseRunning,
+		},
+		{
+			name:       "failed",
+			pod:        waitingPod("test", "ErrImagePull"),
+			conditions: []nbv1beta1.NotebookCondition{scheduled},
+			phase:      nbv1beta1.NotebookPhaseFailed,
+		},
+		{
+			name:    "stopping",
+			stopped: true,
+			pod:     runningPod,
+			phase:   nbv1beta1.NotebookPhaseStopping,
+		},
+		{
+			name:      "stopped",
+			stopped:   true,
+			pod:       &corev1.Pod{},
+			lifecycle: &nbv1beta1.LifecycleStatus{Stopped: true, Reason: nbv1beta1.LifecycleReasonManual},
+			phase:     nbv1beta1.NotebookPhaseStopped,
+		},
+		{
+			name:      "culled",
+			stopped:   true,
+			pod:       &corev1.Pod{},
+			lifecycle: &nbv1beta1.LifecycleStatus{Stopped: true, Reason: nbv1beta1.LifecycleReasonCulled},
+			phase:     nbv1beta1.NotebookPhaseCulled,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			nb := &nbv1beta1.Notebook{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"}}
+			if test.stopped {
+				culler.SetStopAnnotation(&nb.ObjectMeta, nil)
+			}
+			status := &nbv1beta1.NotebookStatus{Conditions: test.conditions, Lifecycle: test.lifecycle}
+
+			if phase := notebookPhase(nb, status, test.pod); phase != test.phase {
+				t.Errorf("Got phase %s, expected %s", phase, test.phase)
+			}
+		})
+	}
+}
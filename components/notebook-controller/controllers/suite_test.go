@@ -20,6 +20,7 @@ import (
 	"path/filepath"
 	"testing"
 
+	"github.com/kubeflow/kubeflow/components/notebook-controller/pkg/culler"
 	controllermetrics "github.com/kubeflow/kubeflow/components/notebook-controller/pkg/metrics"
 
 	. "github.com/onsi/ginkgo"
@@ -55,7 +56,10 @@ var _ = BeforeSuite(func() {
 
 	By("bootstrapping test environment")
 	testEnv = &envtest.Environment{
-		CRDDirectoryPaths:     []string{filepath.Join("..", "config", "crd", "bases")},
+		CRDDirectoryPaths: []string{
+			filepath.Join("..", "config", "crd", "bases"),
+			filepath.Join("..", "config", "crd", "external"),
+		},
 		ErrorIfCRDPathMissing: true,
 	}
 
@@ -77,11 +81,25 @@ var _ = BeforeSuite(func() {
 	})
 	Expect(err).NotTo(HaveOccurred())
 
+	metrics := controllermetrics.NewMetrics(k8sManager.GetClient())
+	notebookCuller := culler.NewCuller(metrics, k8sManager.GetAPIReader(), k8sManager.GetEventRecorderFor("notebook-controller"))
+	err = k8sManager.Add(notebookCuller)
+	Expect(err).NotTo(HaveOccurred())
+
 	err = (&NotebookReconciler{
 		Client:        k8sManager.GetClient(),
 		Log:           ctrl.Log.WithName("controllers").WithName("notebook-controller"),
 		Scheme:        k8sManager.GetScheme(),
-		Metrics:       controllermetrics.NewMetrics(k8sManager.GetClient()),
+		Metrics:       metrics,
+		EventRecorder: k8sManager.GetEventRecorderFor("notebook-controller"),
+		Culler:        notebookCuller,
+		APIReader:     k8sManager.GetAPIReader(),
+	}).SetupWithManager(k8sManager)
+	Expect(err).ToNot(HaveOccurred())
+
+	err = (&NotebookEventReconciler{
+		Client:        k8sManager.GetClient(),
+		Log:           ctrl.Log.WithName("controllers").WithName("notebook-event-controller"),
 		EventRecorder: k8sManager.GetEventRecorderFor("notebook-controller"),
 	}).SetupWithManager(k8sManager)
 	Expect(err).ToNot(HaveOccurred())
@@ -0,0 +1,214 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/kubeflow/kubeflow/components/notebook-controller/api/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// workspaceVolumeName is the name of the workspace volume in the Pod.
+const workspaceVolumeName = "notebook-workspace"
+
+// workspaceClaimName returns the name of the PVC of the Notebook's workspace.
+func workspaceClaimName(nb *v1beta1.Notebook) string {
+	return nb.Name + "-workspace"
+}
+
+// workspaceMountPath returns where the workspace is mounted in the
+// Notebook's container.
+func workspaceMountPath(workspace *v1beta1.NotebookWorkspace) string {
+	if workspace.MountPath == "" {
+		return v1beta1.DefaultWorkspaceMountPath
+	}
+	return workspace.MountPath
+}
+
+// retainWorkspace returns true if the workspace outlives the Notebook.
+func retainWorkspace(workspace *v1beta1.NotebookWorkspace) bool {
+	return workspace.RetentionPolicy == v1beta1.WorkspaceRetentionRetain
+}
+
+// generateWorkspaceClaim returns the PVC of the Notebook's workspace.
+func generateWorkspaceClaim(nb *v1beta1.Notebook) *corev1.PersistentVolumeClaim {
+	workspace := nb.Spec.Workspace
+	accessModes := workspace.AccessModes
+	if len(accessModes) == 0 {
+		accessModes = []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce}
+	}
+
+	return &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      workspaceClaimName(nb),
+			Namespace: nb.Namespace,
+			Labels: map[string]string{
+				"notebook-name": nb.Name,
+			},
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes:      accessModes,
+			StorageClassName: workspace.StorageClassName,
+			DataSource:       restoreDataSource(workspace),
+			Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{
+					corev1.ResourceStorage: workspace.Size,
+				},
+			},
+		},
+	}
+}
+
+// mountWorkspace adds the workspace volume to the Pod and mounts it in the
+// Notebook's container, in place of any volume already mounted at its path.
+func mountWorkspace(nb *v1beta1.Notebook, podSpec *corev1.PodSpec) {
+	if nb.Spec.Workspace == nil {
+		return
+	}
+
+	podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+		Name: workspaceVolumeName,
+		VolumeSource: corev1.VolumeSource{
+			PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+				ClaimName: workspaceClaimName(nb),
+			},
+		},
+	})
+
+	container := &podSpec.Containers[0]
+	mountPath := workspaceMountPath(nb.Spec.Workspace)
+	mounts := []corev1.VolumeMount{}
+	for _, m := range container.VolumeMounts {
+		if m.MountPath != mountPath {
+			mounts = append(mounts, m)
+		}
+	}
+	container.VolumeMounts = append(mounts, corev1.VolumeMount{
+		Name:      workspaceVolumeName,
+		MountPath: mountPath,
+	})
+}
+
+// reconcileWorkspace creates the PVC of the Notebook's workspace, expands it
+// when the requested size grows and keeps its owner reference in line with
+// the retention policy. It returns the status of the PVC, or nil if the
+// Notebook has no workspace.
+func (r *NotebookReconciler) reconcileWorkspace(ctx context.Context, nb *v1beta1.Notebook) (*v1beta1.WorkspaceStatus, error) {
+	log := r.Log.WithValues("notebook", types.NamespacedName{Name: nb.Name, Namespace: nb.Namespace})
+
+	workspace := nb.Spec.Workspace
+	if workspace == nil {
+		return nil, nil
+	}
+
+	claim := generateWorkspaceClaim(nb)
+	if !retainWorkspace(workspace) {
+		if err := ctrl.SetControllerReference(nb, claim, r.Scheme); err != nil {
+			return nil, err
+		}
+	}
+
+	found := &corev1.PersistentVolumeClaim{}
+	err := r.Get(ctx, types.NamespacedName{Name: claim.Name, Namespace: claim.Namespace}, found)
+	if err != nil && apierrs.IsNotFound(err) {
+		log.Info("Creating workspace PersistentVolumeClaim", "name", claim.Name)
+		if err := r.Create(ctx, claim); err != nil {
+			log.Error(err, "unable to create workspace PersistentVolumeClaim")
+			return nil, err
+		}
+		found = claim
+	} else if err != nil {
+		log.Error(err, "error getting workspace PersistentVolumeClaim")
+		return nil, err
+	} else if found.Labels["notebook-name"] != nb.Name {
+		// Only the claims created for a Notebook of the same name are
+		// adopted, so that a Notebook can't mount another claim by taking its
+		// name
+		r.EventRecorder.Eventf(nb, corev1.EventTypeWarning, "WorkspaceClaimConflict",
+			"PersistentVolumeClaim %s exists and wasn't created for the workspace of the Notebook", found.Name)
+		return nil, fmt.Errorf("PersistentVolumeClaim %s wasn't created for the workspace of the Notebook", found.Name)
+	} else {
+		patch := client.MergeFrom(found.DeepCopy())
+		changed := false
+
+		// A retained claim is adopted again by a Notebook of the same name
+		controlled := metav1.IsControlledBy(found, nb)
+		if retainWorkspace(workspace) && controlled {
+			found.OwnerReferences = removeOwnerReference(found.OwnerReferences, nb.UID)
+			changed = true
+		} else if !retainWorkspace(workspace) && !controlled {
+			if err := ctrl.SetControllerReference(nb, found, r.Scheme); err != nil {
+				return nil, err
+			}
+			changed = true
+		}
+
+		requested := found.Spec.Resources.Requests[corev1.ResourceStorage]
+		switch requested.Cmp(workspace.Size) {
+		case -1:
+			log.Info("Expanding workspace PersistentVolumeClaim", "name", found.Name,
+				"from", requested.String(), "to", workspace.Size.String())
+			if found.Spec.Resources.Requests == nil {
+				found.Spec.Resources.Requests = corev1.ResourceList{}
+			}
+			found.Spec.Resources.Requests[corev1.ResourceStorage] = workspace.Size
+			r.EventRecorder.Eventf(nb, corev1.EventTypeNormal, "WorkspaceResizing",
+				"Expanding workspace PersistentVolumeClaim %s from %s to %s",
+				found.Name, requested.String(), workspace.Size.String())
+			changed = true
+		case 1:
+			r.EventRecorder.Eventf(nb, corev1.EventTypeWarning, "WorkspaceShrinkRejected",
+				"Workspace PersistentVolumeClaim %s can't be shrunk from %s to %s",
+				found.Name, requested.String(), workspace.Size.String())
+		}
+
+		if changed {
+			if err := r.Patch(ctx, found, patch); err != nil {
+				log.Error(err, "unable to update workspace PersistentVolumeClaim")
+				return nil, err
+			}
+		}
+	}
+
+	status := &v1beta1.WorkspaceStatus{
+		ClaimName:  found.Name,
+		Phase:      found.Status.Phase,
+		VolumeName: found.Spec.VolumeName,
+	}
+	if capacity, ok := found.Status.Capacity[corev1.ResourceStorage]; ok {
+		status.Capacity = &capacity
+	}
+	return status, nil
+}
+
+// removeOwnerReference returns the owner references without the one of the
+// given owner.
+func removeOwnerReference(refs []metav1.OwnerReference, uid types.UID) []metav1.OwnerReference {
+	kept := []metav1.OwnerReference{}
+	for _, ref := range refs {
+		if ref.UID != uid {
+			kept = append(kept, ref)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,215 @@
+package controllers
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	nbv1beta1 "github.com/kubeflow/kubeflow/components/notebook-controller/api/v1beta1"
+)
+
+func workspaceNotebook(size, retention string) *nbv1beta1.Notebook {
+	return &nbv1beta1.Notebook{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default", UID: "uid"},
+		Spec: nbv1beta1.NotebookSpec{
+			Template: nbv1beta1.NotebookTemplateSpec{
+				Spec: corev1.PodSpec{Containers: []corev1.Container{{
+					Name:  "test",
+					Image: "jupyter",
+					VolumeMounts: []corev1.VolumeMount{
+						{Name: "home", MountPath: "/home/jovyan"},
+						{Name: "dshm", MountPath: "/dev/shm"},
+					},
+				}}},
+			},
+			Workspace: &nbv1beta1.NotebookWorkspace{
+				Size:            resource.MustParse(size),
+				RetentionPolicy: retention,
+			},
+		},
+	}
+}
+
+func TestGenerateStatefulSetWorkspace(t *testing.T) {
+	nb := workspaceNotebook("10Gi", "")
+	sts := generateStatefulSet(nb)
+
+	podSpec := sts.Spec.Template.Spec
+	volume := podSpec.Volumes[len(podSpec.Volumes)-1]
+	if volume.Name != workspaceVolumeName || volume.PersistentVolumeClaim == nil ||
+		volume.PersistentVolumeClaim.ClaimName != "test-workspace" {
+		t.Errorf("Expected the workspace volume, got %+v", podSpec.Volumes)
+	}
+
+	mounts := podSpec.Containers[0].VolumeMounts
+	expected := []corev1.VolumeMount{
+		{Name: "dshm", MountPath: "/dev/shm"},
+		{Name: workspaceVolumeName, MountPath: "/home/jovyan"},
+	}
+	if len(mounts) != len(expected) || mounts[0] != expected[0] || mounts[1] != expected[1] {
+		t.Errorf("Expected the workspace to replace the mount at its path, got %+v", mounts)
+	}
+
+	if len(nb.Spec.Template.Spec.Containers[0].VolumeMounts) != 2 {
+		t.Errorf("Expected the Notebook's template to be left untouched")
+	}
+}
+
+func TestReconcileWorkspace(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = corev1.AddToScheme(scheme)
+	_ = nbv1beta1.AddToScheme(scheme)
+	key := types.NamespacedName{Name: "test-workspace", Namespace: "default"}
+
+	tests := []struct {
+		name      string
+		existing  *corev1.PersistentVolumeClaim
+		size      string
+		retention string
+		request   string
+		owned     bool
+		event     string
+	}{
+		{
+			name:    "create",
+			size:    "10Gi",
+			request: "10Gi",
+			owned:   true,
+		},
+		{
+			name:      "create retained",
+			size:      "10Gi",
+			retention: nbv1beta1.WorkspaceRetentionRetain,
+			request:   "10Gi",
+		},
+		{
+			name:     "expand",
+			existing: generateWorkspaceClaim(workspaceNotebook("10Gi", nbv1beta1.WorkspaceRetentionRetain)),
+			size:     "20Gi",
+			request:  "20Gi",
+			owned:    true,
+			event:    "WorkspaceResizing",
+		},
+		{
+			name:      "shrink",
+			existing:  generateWorkspaceClaim(workspaceNotebook("10Gi", "")),
+			size:      "5Gi",
+			retention: nbv1beta1.WorkspaceRetentionRetain,
+			request:   "10Gi",
+			event:     "WorkspaceShrinkRejected",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			objects := []runtime.Object{}
+			if test.existing != nil {
+				existing := test.existing.DeepCopy()
+				existing.Status = corev1.PersistentVolumeClaimStatus{
+					Phase:    corev1.ClaimBound,
+					Capacity: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("10Gi")},
+				}
+				existing.Spec.VolumeName = "pv-1"
+				if test.retention == nbv1beta1.WorkspaceRetentionRetain {
+					existing.OwnerReferences = []metav1.OwnerReference{{
+						APIVersion: "kubeflow.org/v1beta1", Kind: "Notebook", Name: "test", UID: "uid",
+						Controller: &[]bool{true}[0],
+					}}
+				}
+				objects = append(objects, existing)
+			}
+
+			recorder := record.NewFakeRecorder(10)
+			r := &NotebookReconciler{
+				Client:        fake.NewClientBuilder().WithScheme(scheme).WithRuntimeObjects(objects...).Build(),
+				Scheme:        scheme,
+				Log:           logr.Discard(),
+				EventRecorder: recorder,
+			}
+			nb := workspaceNotebook(test.size, test.retention)
+
+			status, err := r.reconcileWorkspace(context.TODO(), nb)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			claim := &corev1.PersistentVolumeClaim{}
+			if err := r.Get(context.TODO(), key, claim); err != nil {
+				t.Fatalf("Expected the workspace PVC to exist: %v", err)
+			}
+			request := claim.Spec.Resources.Requests[corev1.ResourceStorage]
+			if request.String() != test.request {
+				t.Errorf("Got request %s, expected %s", request.String(), test.request)
+			}
+			if owned := metav1.IsControlledBy(claim, nb); owned != test.owned {
+				t.Errorf("Got owned %t, expected %t", owned, test.owned)
+			}
+
+			if status.ClaimName != "test-workspace" {
+				t.Errorf("Got status %+v", status)
+			}
+			if test.existing != nil &&
+				(status.Phase != corev1.ClaimBound || status.VolumeName != "pv-1" || status.Capacity.String() != "10Gi") {
+				t.Errorf("Expected the status of the bound PVC, got %+v", status)
+			}
+
+			select {
+			case event := <-recorder.Events:
+				if test.event == "" {
+					t.Errorf("Unexpected event %q", event)
+				} else if !strings.Contains(event, test.event) {
+					t.Errorf("Got event %q, expected %s", event, test.event)
+				}
+			default:
+				if test.event != "" {
+					t.Errorf("Expected a %s event", test.event)
+				}
+			}
+		})
+	}
+}
+
+func TestReconcileWorkspaceConflict(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = corev1.AddToScheme(scheme)
+	_ = nbv1beta1.AddToScheme(scheme)
+
+	nb := workspaceNotebook("20Gi", "")
+	other := generateWorkspaceClaim(workspaceNotebook("10Gi", ""))
+	other.Labels = map[string]string{"notebook-name": "other"}
+
+	recorder := record.NewFakeRecorder(10)
+	r := &NotebookReconciler{
+		Client:        fake.NewClientBuilder().WithScheme(scheme).WithRuntimeObjects(other).Build(),
+		Scheme:        scheme,
+		Log:           logr.Discard(),
+		EventRecorder: recorder,
+	}
+
+	if _, err := r.reconcileWorkspace(context.TODO(), nb); err == nil {
+		t.Fatalf("Expected the claim of another Notebook to be refused")
+	}
+
+	claim := &corev1.PersistentVolumeClaim{}
+	if err := r.Get(context.TODO(), types.NamespacedName{Name: "test-workspace", Namespace: "default"}, claim); err != nil {
+		t.Fatal(err)
+	}
+	if request := claim.Spec.Resources.Requests[corev1.ResourceStorage]; request.String() != "10Gi" {
+		t.Errorf("Expected the claim to be left alone, got a request of %s", request.String())
+	}
+	if len(claim.OwnerReferences) > 0 {
+		t.Errorf("Expected the claim not to be adopted, got %+v", claim.OwnerReferences)
+	}
+	if event := <-recorder.Events; !strings.Contains(event, "WorkspaceClaimConflict") {
+		t.Errorf("Got event %q, expected WorkspaceClaimConflict", event)
+	}
+}
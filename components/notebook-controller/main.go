@@ -18,6 +18,7 @@ package main
 import (
 	"flag"
 	"os"
+	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
@@ -34,6 +35,8 @@ import (
 	nbv1alpha1 "github.com/kubeflow/kubeflow/components/notebook-controller/api/v1alpha1"
 	nbv1beta1 "github.com/kubeflow/kubeflow/components/notebook-controller/api/v1beta1"
 	"github.com/kubeflow/kubeflow/components/notebook-controller/controllers"
+	"github.com/kubeflow/kubeflow/components/notebook-controller/pkg/config"
+	"github.com/kubeflow/kubeflow/components/notebook-controller/pkg/culler"
 	controller_metrics "github.com/kubeflow/kubeflow/components/notebook-controller/pkg/metrics"
 	//+kubebuilder:scaffold:imports
 )
@@ -54,13 +57,27 @@ func init() {
 }
 
 func main() {
-	var metricsAddr, leaderElectionNamespace string
-	var enableLeaderElection bool
-	var probeAddr string
+	var metricsAddr, leaderElectionNamespace, configFile string
+	var configReloadPeriod time.Duration
+	var enableLeaderElection, enableWebhooks bool
+	var webhookPort int
+	var probeAddr, activatorAddr string
+	var activatorHoldTimeout time.Duration
 	var Burst int
 	var QPS int
 	flag.StringVar(&metricsAddr, "metrics-addr", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "probe-addr", ":8081", "The address the health endpoint binds to.")
+	flag.StringVar(&activatorAddr, "activator-addr", "0",
+		"The address the activator, which starts the Notebooks that wake on request, binds to. Use \"0\" to disable it.")
+	flag.DurationVar(&activatorHoldTimeout, "activator-hold-timeout", 30*time.Second,
+		"How long the activator holds a request while the Notebook starts.")
+	flag.StringVar(&configFile, "config", "",
+		"The configuration file of the controller. The ENV vars configure the fields it leaves unset.")
+	flag.DurationVar(&configReloadPeriod, "config-reload-period", config.DefaultReloadPeriod,
+		"How often the configuration file is checked for changes.")
+	flag.BoolVar(&enableWebhooks, "enable-webhooks", true,
+		"Serve the defaulting, validating and conversion webhooks of the Notebooks. It needs a certificate in /tmp/k8s-webhook-server/serving-certs.")
+	flag.IntVar(&webhookPort, "webhook-port", 9443, "The port the webhook server binds to.")
 	flag.StringVar(&leaderElectionNamespace, "leader-election-namespace", "",
 		"Determines the namespace in which the leader election configmap will be created.")
 	flag.BoolVar(&enableLeaderElection, "enable-leader-election", false,
@@ -73,6 +90,20 @@ func main() {
 	opts.BindFlags(flag.CommandLine)
 	flag.Parse()
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
+
+	// The configuration file is validated at startup, and so are the ENV vars
+	// if there is none
+	controllerConfig := config.FromEnv()
+	err := controllerConfig.Validate()
+	if configFile != "" {
+		controllerConfig, err = config.Load(configFile)
+	}
+	if err != nil {
+		setupLog.Error(err, "unable to load the configuration", "file", configFile)
+		os.Exit(1)
+	}
+	config.Set(controllerConfig)
+
 	cfg := ctrl.GetConfigOrDie()
 	if Burst != 0 {
 		cfg.Burst = Burst
@@ -85,6 +116,7 @@ func main() {
 		Scheme:                  scheme,
 		MetricsBindAddress:      metricsAddr,
 		HealthProbeBindAddress:  probeAddr,
+		Port:                    webhookPort,
 		LeaderElection:          enableLeaderElection,
 		LeaderElectionNamespace: leaderElectionNamespace,
 		LeaderElectionID:        "kubeflow-notebook-controller",
@@ -94,17 +126,87 @@ func main() {
 		os.Exit(1)
 	}
 
+	// Reload the configuration when the file, usually mounted from a
+	// ConfigMap, changes
+	if configFile != "" {
+		watcher, err := config.NewWatcher(configFile, configReloadPeriod)
+		if err != nil {
+			setupLog.Error(err, "unable to watch the configuration", "file", configFile)
+			os.Exit(1)
+		}
+		if err := mgr.Add(watcher); err != nil {
+			setupLog.Error(err, "unable to add the configuration watcher")
+			os.Exit(1)
+		}
+	}
+	if err := mgr.AddMetricsExtraHandler("/debug/config", config.Handler()); err != nil {
+		setupLog.Error(err, "unable to serve the configuration")
+		os.Exit(1)
+	}
+
+	metrics := controller_metrics.NewMetrics(mgr.GetClient())
+
+	// The culler probes the activity of the Notebooks outside of the
+	// reconcile loop
+	notebookCuller := culler.NewCuller(metrics, mgr.GetAPIReader(), mgr.GetEventRecorderFor("notebook-controller"))
+	if err := mgr.Add(notebookCuller); err != nil {
+		setupLog.Error(err, "unable to add the culler")
+		os.Exit(1)
+	}
+
+	// The images of the Notebooks are resolved outside of the reconcile loop
+	images := controllers.NewImageResolutions()
+	if err := mgr.Add(images); err != nil {
+		setupLog.Error(err, "unable to add the image resolutions")
+		os.Exit(1)
+	}
+
+	cullingPolicies, err := controllers.NewCullingPolicyCache(mgr)
+	if err != nil {
+		setupLog.Error(err, "unable to cache the culling policies")
+		os.Exit(1)
+	}
+
 	if err = (&controllers.NotebookReconciler{
+		Client:          mgr.GetClient(),
+		Log:             ctrl.Log.WithName("controllers").WithName("Notebook"),
+		Scheme:          mgr.GetScheme(),
+		Metrics:         metrics,
+		EventRecorder:   mgr.GetEventRecorderFor("notebook-controller"),
+		Culler:          notebookCuller,
+		APIReader:       mgr.GetAPIReader(),
+		CullingPolicies: cullingPolicies,
+		Images:          images,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "Notebook")
+		os.Exit(1)
+	}
+
+	// The events of the Pods and StatefulSets are mirrored on the Notebooks
+	// by their own controller, so that they don't delay the Notebooks
+	if err = (&controllers.NotebookEventReconciler{
 		Client:        mgr.GetClient(),
-		Log:           ctrl.Log.WithName("controllers").WithName("Notebook"),
-		Scheme:        mgr.GetScheme(),
-		Metrics:       controller_metrics.NewMetrics(mgr.GetClient()),
+		Log:           ctrl.Log.WithName("controllers").WithName("NotebookEvent"),
 		EventRecorder: mgr.GetEventRecorderFor("notebook-controller"),
 	}).SetupWithManager(mgr); err != nil {
-		setupLog.Error(err, "unable to create controller", "controller", "Notebook")
+		setupLog.Error(err, "unable to create controller", "controller", "NotebookEvent")
 		os.Exit(1)
 	}
 
+	// The activator starts the stopped Notebooks when their URL is visited
+	if activatorAddr != "0" {
+		if err := mgr.Add(&controllers.Activator{
+			Client:        mgr.GetClient(),
+			Log:           ctrl.Log.WithName("activator"),
+			EventRecorder: mgr.GetEventRecorderFor("notebook-controller"),
+			Addr:          activatorAddr,
+			HoldTimeout:   activatorHoldTimeout,
+		}); err != nil {
+			setupLog.Error(err, "unable to add the activator")
+			os.Exit(1)
+		}
+	}
+
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
 		setupLog.Error(err, "unable to set up health check")
 		os.Exit(1)
@@ -115,11 +217,18 @@ func main() {
 		os.Exit(1)
 	}
 
-	// uncomment when we need the conversion webhook.
-	// if err = (&nbv1beta1.Notebook{}).SetupWebhookWithManager(mgr); err != nil {
-	// 	setupLog.Error(err, "unable to create webhook", "webhook", "Captain")
-	// 	os.Exit(1)
-	// }
+	// The API server defaults, validates and converts the Notebooks through the
+	// webhooks
+	if enableWebhooks {
+		if err = (&controllers.NotebookWebhook{Client: mgr.GetClient()}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "Notebook")
+			os.Exit(1)
+		}
+		if err := mgr.AddReadyzCheck("webhook", mgr.GetWebhookServer().StartedChecker()); err != nil {
+			setupLog.Error(err, "unable to set up webhook ready check")
+			os.Exit(1)
+		}
+	}
 
 	//+kubebuilder:scaffold:builder
 
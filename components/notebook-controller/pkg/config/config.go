@@ -0,0 +1,530 @@
+// Package config holds the configuration of the notebook controller. It is
+// loaded from a versioned file, usually mounted from a ConfigMap, validated
+// at startup and reloaded when the file changes.
+//
+// The ENV vars that configured the controller before the file are still
+// supported: they provide the values of the fields that the file leaves
+// unset.
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/yaml"
+)
+
+var log = logf.Log.WithName("config")
+
+// The version of the configuration file.
+const (
+	APIVersion = "config.notebooks.kubeflow.org/v1alpha1"
+	Kind       = "NotebookControllerConfig"
+)
+
+// The backends that route the Notebooks under their
+// /notebook/<namespace>/<name>/ prefix.
+const (
+	RoutingBackendIstio   = "istio"
+	RoutingBackendIngress = "ingress"
+	RoutingBackendGateway = "gateway"
+	RoutingBackendNone    = "none"
+)
+
+// The defaults of the configuration, used if neither the file nor the ENV
+// vars set a value.
+const (
+	DefaultClusterDomain                 = "cluster.local"
+	DefaultIstioGateway                  = "kubeflow/kubeflow-gateway"
+	DefaultGateway                       = "kubeflow/kubeflow-gateway"
+	DefaultUserIDHeader                  = "kubeflow-userid"
+	DefaultNetworkPolicyGatewayNamespace = "istio-system"
+	DefaultCullIdleTime                  = 24 * time.Hour
+	DefaultIdlenessCheckPeriod           = time.Minute
+	DefaultProbeWorkers                  = 10
+	DefaultProbeMaxBackoff               = 30 * time.Minute
+	DefaultActivityCPUThreshold          = 100
+	DefaultActivityCPUWindow             = 10 * time.Minute
+	DefaultShutdownHookTimeout           = 30 * time.Second
+	DefaultImageUpdateCheckPeriod        = time.Hour
+	// DefaultTrustedClient is the ServiceAccount of the jupyter-web-app,
+	// which creates the Notebooks on behalf of its users.
+	DefaultTrustedClient = "system:serviceaccount:kubeflow:jupyter-web-app-service-account"
+)
+
+// Config is the configuration of the notebook controller.
+type Config struct {
+	metav1.TypeMeta `json:",inline"`
+
+	// Dev makes the controller reach the Notebooks through `kubectl proxy`,
+	// for running it outside of the cluster. ENV var: DEV.
+	Dev bool `json:"dev"`
+	// ClusterDomain is the domain of the cluster's Services.
+	// ENV var: CLUSTER_DOMAIN.
+	ClusterDomain string `json:"clusterDomain"`
+	// AddFSGroup sets the fsGroup of the Notebooks' Pods that have no
+	// security context. ENV var: ADD_FSGROUP.
+	AddFSGroup bool `json:"addFSGroup"`
+
+	Routing       RoutingConfig       `json:"routing"`
+	Culling       CullingConfig       `json:"culling"`
+	NetworkPolicy NetworkPolicyConfig `json:"networkPolicy"`
+	ShutdownHook  ShutdownHookConfig  `json:"shutdownHook"`
+	Limits        LimitsConfig        `json:"limits"`
+	ImageUpdate   ImageUpdateConfig   `json:"imageUpdate"`
+}
+
+// RoutingConfig configures how the Notebooks are exposed.
+type RoutingConfig struct {
+	// Backend is one of istio, ingress, gateway or none. Changing it requires
+	// a restart. ENV vars: ROUTING_BACKEND, USE_ISTIO.
+	Backend string `json:"backend"`
+	// IstioGateway is the Gateway of the VirtualServices.
+	// ENV var: ISTIO_GATEWAY.
+	IstioGateway string `json:"istioGateway"`
+	// IngressClassName and IngressHost are set in the Ingresses.
+	// ENV vars: INGRESS_CLASS_NAME, INGRESS_HOST.
+	IngressClassName string `json:"ingressClassName,omitempty"`
+	IngressHost      string `json:"ingressHost,omitempty"`
+	// Gateway is the Gateway the HTTPRoutes are attached to, as
+	// <namespace>/<name>. ENV var: GATEWAY.
+	Gateway string `json:"gateway"`
+	// ExternalURL is the scheme and host the gateway is reached on, e.g.
+	// https://kubeflow.example.com. It completes the URLs of the Notebooks
+	// in their status when their routes match any host.
+	// ENV var: EXTERNAL_URL.
+	ExternalURL string `json:"externalURL,omitempty"`
+	// ActivatorService is the host of the activator's Service. Empty
+	// disables waking the Notebooks on request. ENV var: ACTIVATOR_SERVICE.
+	ActivatorService string `json:"activatorService,omitempty"`
+	// ActivatorUserIDHeader is the header holding the user of the requests,
+	// set by the authenticating proxy of the gateway. The activator only
+	// starts the Notebooks that the user may patch. Empty disables the
+	// check. ENV var: USERID_HEADER.
+	ActivatorUserIDHeader string `json:"activatorUserIDHeader,omitempty"`
+	// ActivatorUserIDPrefix is removed from the value of the header, e.g.
+	// "accounts.google.com:". ENV var: USERID_PREFIX.
+	ActivatorUserIDPrefix string `json:"activatorUserIDPrefix,omitempty"`
+}
+
+// CullingConfig is the controller wide culling policy.
+type CullingConfig struct {
+	// ENV var: ENABLE_CULLING.
+	Enabled bool `json:"enabled"`
+	// IdleTime is how long a Notebook can be idle before it is culled.
+	// ENV var: CULL_IDLE_TIME, in minutes.
+	IdleTime metav1.Duration `json:"idleTime"`
+	// CheckPeriod is how often the activity of the Notebooks is checked.
+	// ENV var: IDLENESS_CHECK_PERIOD, in minutes.
+	CheckPeriod metav1.Duration `json:"checkPeriod"`
+	// WarningPeriod is how long before culling a Notebook is marked as
+	// scheduled for culling. Zero disables the warning.
+	// ENV var: CULL_WARNING_PERIOD, in minutes.
+	WarningPeriod metav1.Duration `json:"warningPeriod"`
+	// ProbeWorkers is the number of workers probing the activity of the
+	// Notebooks. Changing it requires a restart. ENV var: PROBE_WORKERS.
+	ProbeWorkers int `json:"probeWorkers"`
+	// ProbeMaxBackoff caps the exponential backoff of the failed probes,
+	// which starts at CheckPeriod. Changing it requires a restart.
+	// ENV var: PROBE_MAX_BACKOFF, in minutes.
+	ProbeMaxBackoff metav1.Duration `json:"probeMaxBackoff"`
+	// ProbeCABundle is a PEM file of CA certificates trusted, on top of the
+	// system ones, when probing the Notebooks over HTTPS. Changing it
+	// requires a restart. ENV var: PROBE_CA_BUNDLE.
+	ProbeCABundle string `json:"probeCABundle,omitempty"`
+	// Activity selects the signals of the Jupyter servers that count as
+	// activity.
+	Activity ActivityConfig `json:"activity"`
+}
+
+// ActivityConfig selects the signals of the Jupyter servers that count as
+// activity.
+type ActivityConfig struct {
+	// BusyKernels makes a kernel that is not idle count as current activity.
+	// ENV var: ACTIVITY_BUSY_KERNELS.
+	BusyKernels bool `json:"busyKernels"`
+	// KernelConnections makes a kernel with connected clients count as
+	// current activity. ENV var: ACTIVITY_KERNEL_CONNECTIONS.
+	KernelConnections bool `json:"kernelConnections"`
+	// Terminals adds the last activity of the terminals to the one of the
+	// kernels. ENV var: ACTIVITY_TERMINALS.
+	Terminals bool `json:"terminals"`
+	// CPU makes a Notebook whose Pod uses enough CPU count as active.
+	CPU CPUActivityConfig `json:"cpu"`
+}
+
+// CPUActivityConfig configures the CPU signal, read from the metrics.k8s.io
+// API. It counts the jobs started from a terminal, e.g. with `nohup`, which
+// leave the kernels idle.
+type CPUActivityConfig struct {
+	// ENV var: ACTIVITY_CPU.
+	Enabled bool `json:"enabled"`
+	// Threshold is the average CPU usage, in millicores, above which the
+	// Notebook is active. ENV var: ACTIVITY_CPU_THRESHOLD.
+	Threshold int64 `json:"threshold"`
+	// Window is the period over which the CPU usage is averaged.
+	// ENV var: ACTIVITY_CPU_WINDOW, in minutes.
+	Window metav1.Duration `json:"window"`
+}
+
+// NetworkPolicyConfig configures the NetworkPolicies of the Notebooks.
+type NetworkPolicyConfig struct {
+	// Enabled makes the controller own a NetworkPolicy for each Notebook.
+	// Changing it requires a restart. ENV var: ENABLE_NETWORK_POLICY.
+	Enabled bool `json:"enabled"`
+	// GatewayNamespace is the namespace of the gateway's Pods.
+	// ENV var: NETWORK_POLICY_GATEWAY_NAMESPACE.
+	GatewayNamespace string `json:"gatewayNamespace"`
+}
+
+// ShutdownHookConfig configures the hooks run before the Notebooks are
+// stopped or deleted.
+type ShutdownHookConfig struct {
+	// Timeout bounds the calls to the Jupyter API of the jupyter hook.
+	Timeout metav1.Duration `json:"timeout"`
+	// Command is the preStop command of the command hook.
+	Command []string `json:"command,omitempty"`
+}
+
+// ImageUpdateConfig configures how the images of the Notebooks with the
+// Digest image update policy are resolved.
+type ImageUpdateConfig struct {
+	// CheckPeriod is how often the tags of the images are resolved.
+	CheckPeriod metav1.Duration `json:"checkPeriod"`
+	// Registries are the registries whose images are resolved, as
+	// host[:port]. The insecure registries are resolved too.
+	Registries []string `json:"registries"`
+	// InsecureRegistries are the registries reached over plain HTTP, as
+	// host[:port].
+	InsecureRegistries []string `json:"insecureRegistries,omitempty"`
+	// TokenRealms are the hosts of the token servers of the registries
+	// that don't serve their tokens themselves, e.g. gitlab.com for
+	// registry.gitlab.com.
+	TokenRealms []string `json:"tokenRealms,omitempty"`
+}
+
+// DefaultImageUpdateRegistries are the public registries whose images are
+// resolved by default.
+var DefaultImageUpdateRegistries = []string{"docker.io", "ghcr.io", "quay.io", "gcr.io", "public.ecr.aws"}
+
+// NotebookLimits caps the number of Notebooks of a namespace, and of each of
+// its creators. Zero means no limit.
+type NotebookLimits struct {
+	// Notebooks and RunningNotebooks cap the Notebooks of the namespace.
+	Notebooks        int32 `json:"notebooks,omitempty"`
+	RunningNotebooks int32 `json:"runningNotebooks,omitempty"`
+	// NotebooksPerCreator and RunningNotebooksPerCreator cap the Notebooks
+	// with the same creator annotation in the namespace.
+	NotebooksPerCreator        int32 `json:"notebooksPerCreator,omitempty"`
+	RunningNotebooksPerCreator int32 `json:"runningNotebooksPerCreator,omitempty"`
+}
+
+// LimitsConfig configures the limits of the Notebooks. The new Notebooks
+// over a Notebooks limit are rejected, and the ones started over a running
+// Notebooks limit are kept stopped.
+type LimitsConfig struct {
+	// The limits of the namespaces that are not in Namespaces.
+	NotebookLimits `json:",inline"`
+	// Namespaces replaces the limits of some namespaces.
+	Namespaces map[string]NotebookLimits `json:"namespaces,omitempty"`
+	// TrustedClients are the users who create Notebooks on behalf of other
+	// users, and may set the creator annotation to them.
+	TrustedClients []string `json:"trustedClients,omitempty"`
+}
+
+// TrustedClient returns true if the user may set the creator of the
+// Notebooks it creates.
+func (c LimitsConfig) TrustedClient(user string) bool {
+	for _, client := range c.TrustedClients {
+		if client == user {
+			return true
+		}
+	}
+	return false
+}
+
+// ForNamespace returns the limits of a namespace.
+func (c LimitsConfig) ForNamespace(namespace string) NotebookLimits {
+	if limits, ok := c.Namespaces[namespace]; ok {
+		return limits
+	}
+	return c.NotebookLimits
+}
+
+// validate returns the invalid fields of the limits.
+func (l NotebookLimits) validate(path string) []string {
+	errs := []string{}
+	fields := []struct {
+		name  string
+		value int32
+	}{
+		{"notebooks", l.Notebooks},
+		{"runningNotebooks", l.RunningNotebooks},
+		{"notebooksPerCreator", l.NotebooksPerCreator},
+		{"runningNotebooksPerCreator", l.RunningNotebooksPerCreator},
+	}
+	for _, field := range fields {
+		if field.value < 0 {
+			errs = append(errs, fmt.Sprintf("%s.%s: must not be negative", path, field.name))
+		}
+	}
+	return errs
+}
+
+// current is the configuration in effect.
+var current atomic.Value
+
+// Get returns the configuration in effect. If none was set, e.g. in tests, it
+// returns the configuration of the ENV vars.
+func Get() *Config {
+	if c, ok := current.Load().(*Config); ok && c != nil {
+		return c
+	}
+	return FromEnv()
+}
+
+// Set makes the configuration the one in effect. It must not be modified
+// afterwards. Setting nil goes back to the configuration of the ENV vars.
+func Set(c *Config) {
+	current.Store(c)
+}
+
+// FromEnv returns the configuration of the ENV vars. Invalid values are
+// logged and replaced by the defaults, as the controller always did.
+func FromEnv() *Config {
+	c := &Config{
+		TypeMeta:      metav1.TypeMeta{APIVersion: APIVersion, Kind: Kind},
+		Dev:           getEnvDefault("DEV", "false") != "false",
+		ClusterDomain: getEnvDefault("CLUSTER_DOMAIN", DefaultClusterDomain),
+		// The fsGroup is only skipped if ADD_FSGROUP is set to another value
+		// than true
+		AddFSGroup: getEnvDefault("ADD_FSGROUP", "true") == "true",
+		Routing: RoutingConfig{
+			Backend:               os.Getenv("ROUTING_BACKEND"),
+			IstioGateway:          getEnvDefault("ISTIO_GATEWAY", DefaultIstioGateway),
+			IngressClassName:      os.Getenv("INGRESS_CLASS_NAME"),
+			IngressHost:           os.Getenv("INGRESS_HOST"),
+			Gateway:               getEnvDefault("GATEWAY", DefaultGateway),
+			ExternalURL:           os.Getenv("EXTERNAL_URL"),
+			ActivatorService:      os.Getenv("ACTIVATOR_SERVICE"),
+			ActivatorUserIDHeader: getEnvDefault("USERID_HEADER", DefaultUserIDHeader),
+			ActivatorUserIDPrefix: os.Getenv("USERID_PREFIX"),
+		},
+		Culling: CullingConfig{
+			Enabled:       os.Getenv("ENABLE_CULLING") == "true",
+			IdleTime:      metav1.Duration{Duration: getEnvMinutes("CULL_IDLE_TIME", DefaultCullIdleTime, false)},
+			CheckPeriod:   metav1.Duration{Duration: getEnvMinutes("IDLENESS_CHECK_PERIOD", DefaultIdlenessCheckPeriod, false)},
+			WarningPeriod: metav1.Duration{Duration: getEnvMinutes("CULL_WARNING_PERIOD", 0, true)},
+			ProbeWorkers:  getEnvPositiveInt("PROBE_WORKERS", DefaultProbeWorkers),
+			ProbeMaxBackoff: metav1.Duration{
+				Duration: getEnvMinutes("PROBE_MAX_BACKOFF", DefaultProbeMaxBackoff, false),
+			},
+			ProbeCABundle: os.Getenv("PROBE_CA_BUNDLE"),
+			Activity: ActivityConfig{
+				BusyKernels:       getEnvDefault("ACTIVITY_BUSY_KERNELS", "true") == "true",
+				KernelConnections: getEnvDefault("ACTIVITY_KERNEL_CONNECTIONS", "true") == "true",
+				Terminals:         getEnvDefault("ACTIVITY_TERMINALS", "true") == "true",
+				CPU: CPUActivityConfig{
+					Enabled:   os.Getenv("ACTIVITY_CPU") == "true",
+					Threshold: int64(getEnvPositiveInt("ACTIVITY_CPU_THRESHOLD", DefaultActivityCPUThreshold)),
+					Window: metav1.Duration{
+						Duration: getEnvMinutes("ACTIVITY_CPU_WINDOW", DefaultActivityCPUWindow, false),
+					},
+				},
+			},
+		},
+		NetworkPolicy: NetworkPolicyConfig{
+			Enabled:          os.Getenv("ENABLE_NETWORK_POLICY") == "true",
+			GatewayNamespace: getEnvDefault("NETWORK_POLICY_GATEWAY_NAMESPACE", DefaultNetworkPolicyGatewayNamespace),
+		},
+		ShutdownHook: ShutdownHookConfig{
+			Timeout: metav1.Duration{Duration: DefaultShutdownHookTimeout},
+		},
+		ImageUpdate: ImageUpdateConfig{
+			CheckPeriod: metav1.Duration{Duration: DefaultImageUpdateCheckPeriod},
+			Registries:  append([]string{}, DefaultImageUpdateRegistries...),
+		},
+		Limits: LimitsConfig{
+			TrustedClients: []string{DefaultTrustedClient},
+		},
+	}
+	if c.Routing.Backend == "" {
+		c.Routing.Backend = RoutingBackendNone
+		if os.Getenv("USE_ISTIO") == "true" {
+			c.Routing.Backend = RoutingBackendIstio
+		}
+	}
+	return c
+}
+
+// Parse returns the configuration of a file, on top of the one of the ENV
+// vars. Unknown fields are rejected.
+func Parse(data []byte) (*Config, error) {
+	c := FromEnv()
+	// The file must declare its version
+	c.TypeMeta = metav1.TypeMeta{}
+	if err := yaml.UnmarshalStrict(data, c); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %v", err)
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Load reads and parses the configuration file at path.
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+// Validate returns an error describing all the invalid fields of the
+// configuration.
+func (c *Config) Validate() error {
+	errs := []string{}
+	if c.APIVersion != APIVersion || c.Kind != Kind {
+		errs = append(errs, fmt.Sprintf("unsupported version %s, kind %s: expected %s, kind %s",
+			c.APIVersion, c.Kind, APIVersion, Kind))
+	}
+	if c.ClusterDomain == "" {
+		errs = append(errs, "clusterDomain: must not be empty")
+	}
+	switch c.Routing.Backend {
+	case RoutingBackendIstio, RoutingBackendIngress, RoutingBackendGateway, RoutingBackendNone:
+	default:
+		errs = append(errs, fmt.Sprintf("routing.backend: unsupported value %q", c.Routing.Backend))
+	}
+	if c.Routing.IstioGateway == "" {
+		errs = append(errs, "routing.istioGateway: must not be empty")
+	}
+	if c.Routing.Gateway == "" {
+		errs = append(errs, "routing.gateway: must not be empty")
+	}
+	if c.Routing.ExternalURL != "" {
+		u, err := url.Parse(c.Routing.ExternalURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" ||
+			(u.Path != "" && u.Path != "/") || u.User != nil || u.RawQuery != "" || u.Fragment != "" {
+			errs = append(errs, fmt.Sprintf("routing.externalURL: %q must be an http or https scheme and a host",
+				c.Routing.ExternalURL))
+		}
+	}
+	if c.Culling.IdleTime.Duration <= 0 {
+		errs = append(errs, "culling.idleTime: must be positive")
+	}
+	if c.Culling.CheckPeriod.Duration <= 0 {
+		errs = append(errs, "culling.checkPeriod: must be positive")
+	}
+	if c.Culling.WarningPeriod.Duration < 0 {
+		errs = append(errs, "culling.warningPeriod: must not be negative")
+	}
+	if c.Culling.ProbeWorkers <= 0 {
+		errs = append(errs, "culling.probeWorkers: must be positive")
+	}
+	if c.Culling.ProbeMaxBackoff.Duration <= 0 {
+		errs = append(errs, "culling.probeMaxBackoff: must be positive")
+	}
+	if c.Culling.Activity.CPU.Threshold <= 0 {
+		errs = append(errs, "culling.activity.cpu.threshold: must be positive")
+	}
+	if c.Culling.Activity.CPU.Window.Duration <= 0 {
+		errs = append(errs, "culling.activity.cpu.window: must be positive")
+	}
+	if c.NetworkPolicy.GatewayNamespace == "" {
+		errs = append(errs, "networkPolicy.gatewayNamespace: must not be empty")
+	}
+	if c.ShutdownHook.Timeout.Duration <= 0 {
+		errs = append(errs, "shutdownHook.timeout: must be positive")
+	}
+	if c.ImageUpdate.CheckPeriod.Duration <= 0 {
+		errs = append(errs, "imageUpdate.checkPeriod: must be positive")
+	}
+	errs = append(errs, c.Limits.NotebookLimits.validate("limits")...)
+	namespaces := make([]string, 0, len(c.Limits.Namespaces))
+	for namespace := range c.Limits.Namespaces {
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Strings(namespaces)
+	for _, namespace := range namespaces {
+		errs = append(errs, c.Limits.Namespaces[namespace].validate("limits.namespaces."+namespace)...)
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// restartRequired returns the fields that changed between the configurations
+// and only take effect after a restart of the controller.
+func restartRequired(old, updated *Config) []string {
+	fields := []string{}
+	if old.Routing.Backend != updated.Routing.Backend {
+		fields = append(fields, "routing.backend")
+	}
+	if old.NetworkPolicy.Enabled != updated.NetworkPolicy.Enabled {
+		fields = append(fields, "networkPolicy.enabled")
+	}
+	if old.Culling.ProbeWorkers != updated.Culling.ProbeWorkers {
+		fields = append(fields, "culling.probeWorkers")
+	}
+	if old.Culling.ProbeMaxBackoff != updated.Culling.ProbeMaxBackoff {
+		fields = append(fields, "culling.probeMaxBackoff")
+	}
+	if old.Culling.ProbeCABundle != updated.Culling.ProbeCABundle {
+		fields = append(fields, "culling.probeCABundle")
+	}
+	return fields
+}
+
+func getEnvDefault(variable string, defaultVal string) string {
+	envVar := os.Getenv(variable)
+	if len(envVar) == 0 {
+		return defaultVal
+	}
+	return envVar
+}
+
+// getEnvMinutes returns the duration of an ENV var holding a number of
+// minutes, which must be positive, or non negative if zero is allowed.
+func getEnvMinutes(variable string, defaultVal time.Duration, allowZero bool) time.Duration {
+	value := os.Getenv(variable)
+	if len(value) == 0 {
+		return defaultVal
+	}
+	minutes, err := strconv.Atoi(value)
+	if err != nil || minutes < 0 || (minutes == 0 && !allowZero) {
+		log.Info(fmt.Sprintf(
+			"%s should be a positive Int. Got %s instead. Using default value.",
+			variable, value))
+		return defaultVal
+	}
+	return time.Duration(minutes) * time.Minute
+}
+
+// getEnvPositiveInt returns the value of an ENV var holding a positive
+// integer.
+func getEnvPositiveInt(variable string, defaultVal int) int {
+	value := os.Getenv(variable)
+	if len(value) == 0 {
+		return defaultVal
+	}
+	number, err := strconv.Atoi(value)
+	if err != nil || number < 1 {
+		log.Info(fmt.Sprintf(
+			"%s should be a positive Int. Got %s instead. Using default value.",
+			variable, value))
+		return defaultVal
+	}
+	return number
+}
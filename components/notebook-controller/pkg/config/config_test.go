@@ -0,0 +1,290 @@
+package config
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestFromEnv(t *testing.T) {
+	tests := []struct {
+		name     string
+		env      map[string]string
+		validate func(c *Config) bool
+	}{
+		{
+			name: "defaults",
+			validate: func(c *Config) bool {
+				return c.AddFSGroup && !c.Dev && c.ClusterDomain == DefaultClusterDomain &&
+					c.Routing.Backend == RoutingBackendNone &&
+					c.Culling.IdleTime.Duration == DefaultCullIdleTime &&
+					c.Culling.CheckPeriod.Duration == DefaultIdlenessCheckPeriod &&
+					c.Culling.WarningPeriod.Duration == 0 &&
+					c.Culling.ProbeWorkers == DefaultProbeWorkers &&
+					c.Culling.ProbeMaxBackoff.Duration == DefaultProbeMaxBackoff
+			},
+		},
+		{
+			name: "USE_ISTIO",
+			env:  map[string]string{"USE_ISTIO": "true", "ISTIO_GATEWAY": "istio-system/gateway"},
+			validate: func(c *Config) bool {
+				return c.Routing.Backend == RoutingBackendIstio && c.Routing.IstioGateway == "istio-system/gateway"
+			},
+		},
+		{
+			name: "ROUTING_BACKEND has precedence over USE_ISTIO",
+			env:  map[string]string{"USE_ISTIO": "true", "ROUTING_BACKEND": RoutingBackendIngress},
+			validate: func(c *Config) bool {
+				return c.Routing.Backend == RoutingBackendIngress
+			},
+		},
+		{
+			name: "culling in minutes",
+			env: map[string]string{
+				"ENABLE_CULLING":        "true",
+				"CULL_IDLE_TIME":        "60",
+				"IDLENESS_CHECK_PERIOD": "5",
+				"CULL_WARNING_PERIOD":   "10",
+				"PROBE_WORKERS":         "4",
+				"PROBE_MAX_BACKOFF":     "60",
+				"PROBE_CA_BUNDLE":       "/etc/probe/ca.crt",
+			},
+			validate: func(c *Config) bool {
+				return c.Culling.Enabled && c.Culling.IdleTime.Duration == time.Hour &&
+					c.Culling.CheckPeriod.Duration == 5*time.Minute &&
+					c.Culling.WarningPeriod.Duration == 10*time.Minute &&
+					c.Culling.ProbeWorkers == 4 && c.Culling.ProbeMaxBackoff.Duration == time.Hour &&
+					c.Culling.ProbeCABundle == "/etc/probe/ca.crt"
+			},
+		},
+		{
+			name: "invalid values use the defaults",
+			env: map[string]string{"CULL_IDLE_TIME": "a day", "IDLENESS_CHECK_PERIOD": "0", "CULL_WARNING_PERIOD": "-1",
+				"PROBE_WORKERS": "0"},
+			validate: func(c *Config) bool {
+				return c.Culling.IdleTime.Duration == DefaultCullIdleTime &&
+					c.Culling.CheckPeriod.Duration == DefaultIdlenessCheckPeriod &&
+					c.Culling.WarningPeriod.Duration == 0 && c.Culling.ProbeWorkers == DefaultProbeWorkers
+			},
+		},
+		{
+			name: "activity signals",
+			env:  map[string]string{"ACTIVITY_KERNEL_CONNECTIONS": "false"},
+			validate: func(c *Config) bool {
+				activity := c.Culling.Activity
+				return activity.BusyKernels && !activity.KernelConnections && activity.Terminals && !activity.CPU.Enabled
+			},
+		},
+		{
+			name: "CPU signal",
+			env:  map[string]string{"ACTIVITY_CPU": "true", "ACTIVITY_CPU_THRESHOLD": "250", "ACTIVITY_CPU_WINDOW": "0"},
+			validate: func(c *Config) bool {
+				cpu := c.Culling.Activity.CPU
+				return cpu.Enabled && cpu.Threshold == 250 && cpu.Window.Duration == DefaultActivityCPUWindow
+			},
+		},
+		{
+			name: "fsGroup disabled",
+			env:  map[string]string{"ADD_FSGROUP": "false", "DEV": "true"},
+			validate: func(c *Config) bool {
+				return !c.AddFSGroup && c.Dev
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for variable, value := range test.env {
+				t.Setenv(variable, value)
+			}
+			c := FromEnv()
+			if !test.validate(c) {
+				t.Errorf("Got unexpected configuration %+v", c)
+			}
+			if err := c.Validate(); err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	t.Setenv("CLUSTER_DOMAIN", "example.com")
+	t.Setenv("ENABLE_CULLING", "true")
+
+	tests := []struct {
+		name     string
+		data     string
+		validate func(c *Config) bool
+		err      string
+	}{
+		{
+			name: "ENV vars for the unset fields",
+			data: `
+apiVersion: config.notebooks.kubeflow.org/v1alpha1
+kind: NotebookControllerConfig
+routing:
+  backend: gateway
+  externalURL: https://kubeflow.example.com
+culling:
+  idleTime: 2h
+`,
+			validate: func(c *Config) bool {
+				return c.ClusterDomain == "example.com" && c.Routing.Backend == RoutingBackendGateway &&
+					c.Routing.ExternalURL == "https://kubeflow.example.com" &&
+					c.Routing.Gateway == DefaultGateway && c.Culling.Enabled &&
+					c.Culling.IdleTime.Duration == 2*time.Hour &&
+					c.Culling.CheckPeriod.Duration == DefaultIdlenessCheckPeriod
+			},
+		},
+		{
+			name: "the file overrides the ENV vars",
+			data: `
+apiVersion: config.notebooks.kubeflow.org/v1alpha1
+kind: NotebookControllerConfig
+clusterDomain: cluster.local
+culling:
+  enabled: false
+  activity:
+    busyKernels: true
+    terminals: false
+    cpu:
+      enabled: true
+      window: 5m
+`,
+			validate: func(c *Config) bool {
+				activity := c.Culling.Activity
+				return c.ClusterDomain == "cluster.local" && !c.Culling.Enabled &&
+					activity.BusyKernels && activity.KernelConnections && !activity.Terminals &&
+					activity.CPU == CPUActivityConfig{
+						Enabled:   true,
+						Threshold: DefaultActivityCPUThreshold,
+						Window:    metav1.Duration{Duration: 5 * time.Minute},
+					}
+			},
+		},
+		{
+			name: "limits",
+			data: `
+apiVersion: config.notebooks.kubeflow.org/v1alpha1
+kind: NotebookControllerConfig
+limits:
+  runningNotebooksPerCreator: 2
+  namespaces:
+    team-a:
+      notebooks: 20
+`,
+			validate: func(c *Config) bool {
+				return c.Limits.ForNamespace("default") == NotebookLimits{RunningNotebooksPerCreator: 2} &&
+					c.Limits.ForNamespace("team-a") == NotebookLimits{Notebooks: 20}
+			},
+		},
+		{
+			name: "no version",
+			data: `clusterDomain: cluster.local`,
+			err:  "unsupported version",
+		},
+		{
+			name: "unknown field",
+			data: `
+apiVersion: config.notebooks.kubeflow.org/v1alpha1
+kind: NotebookControllerConfig
+useIstio: true
+`,
+			err: "unknown field",
+		},
+		{
+			name: "invalid fields",
+			data: `
+apiVersion: config.notebooks.kubeflow.org/v1alpha1
+kind: NotebookControllerConfig
+routing:
+  backend: traefik
+  externalURL: kubeflow.example.com/notebooks
+culling:
+  checkPeriod: 0s
+  probeWorkers: 0
+imageUpdate:
+  checkPeriod: -1h
+limits:
+  namespaces:
+    team-a:
+      runningNotebooks: -1
+`,
+			err: `routing.backend: unsupported value "traefik"; ` +
+				`routing.externalURL: "kubeflow.example.com/notebooks" must be an http or https scheme and a host; ` +
+				`culling.checkPeriod: must be positive; ` +
+				`culling.probeWorkers: must be positive; ` +
+				`imageUpdate.checkPeriod: must be positive; limits.namespaces.team-a.runningNotebooks: must not be negative`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c, err := Parse([]byte(test.data))
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Errorf("Got error %v, expected %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !test.validate(c) {
+				t.Errorf("Got unexpected configuration %+v", c)
+			}
+		})
+	}
+}
+
+func TestWatcher(t *testing.T) {
+	defer Set(nil)
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	write := func(data string) {
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	version := "apiVersion: config.notebooks.kubeflow.org/v1alpha1\nkind: NotebookControllerConfig\n"
+	write(version + "clusterDomain: a.example.com\n")
+
+	c, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	Set(c)
+	watcher, err := NewWatcher(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	write(version + "clusterDomain: b.example.com\n")
+	watcher.reload()
+	if domain := Get().ClusterDomain; domain != "b.example.com" {
+		t.Errorf("Expected the configuration to be reloaded, got cluster domain %s", domain)
+	}
+
+	write(version + "clusterDomain: \"\"\n")
+	watcher.reload()
+	if domain := Get().ClusterDomain; domain != "b.example.com" {
+		t.Errorf("Expected the invalid configuration to be rejected, got cluster domain %s", domain)
+	}
+
+	recorder := httptest.NewRecorder()
+	Handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/debug/config", nil))
+	served := &Config{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), served); err != nil {
+		t.Fatal(err)
+	}
+	if served.ClusterDomain != "b.example.com" || served.Kind != Kind {
+		t.Errorf("Got configuration %s", recorder.Body.String())
+	}
+}
@@ -0,0 +1,95 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"time"
+)
+
+// DefaultReloadPeriod is how often the Watcher checks the configuration file
+// for changes. The kubelet updates the files of mounted ConfigMaps with a
+// delay anyway.
+const DefaultReloadPeriod = 10 * time.Second
+
+// Watcher reloads the configuration file when its content changes. An invalid
+// file is logged and the configuration in effect is kept.
+type Watcher struct {
+	Path   string
+	Period time.Duration
+
+	// last is the content of the file that was last loaded, or rejected
+	last []byte
+}
+
+// NewWatcher creates a Watcher of the configuration file, whose content was
+// already loaded.
+func NewWatcher(path string, period time.Duration) (*Watcher, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if period <= 0 {
+		period = DefaultReloadPeriod
+	}
+	return &Watcher{Path: path, Period: period, last: data}, nil
+}
+
+// NeedLeaderElection returns false, so that every replica of the controller
+// uses the same configuration.
+func (w *Watcher) NeedLeaderElection() bool {
+	return false
+}
+
+// Start checks the file every Period until the context is done.
+func (w *Watcher) Start(ctx context.Context) error {
+	ticker := time.NewTicker(w.Period)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			w.reload()
+		}
+	}
+}
+
+// reload loads the file if its content changed since the last time.
+func (w *Watcher) reload() {
+	data, err := os.ReadFile(w.Path)
+	if err != nil {
+		log.Error(err, "unable to read the configuration file", "path", w.Path)
+		return
+	}
+	if bytes.Equal(data, w.last) {
+		return
+	}
+	w.last = data
+
+	c, err := Parse(data)
+	if err != nil {
+		log.Error(err, "keeping the current configuration", "path", w.Path)
+		return
+	}
+	if fields := restartRequired(Get(), c); len(fields) > 0 {
+		log.Info("Some changes of the configuration only take effect after a restart", "fields", fields)
+	}
+	Set(c)
+	log.Info("Reloaded the configuration", "path", w.Path)
+}
+
+// Handler serves the configuration in effect as JSON, for debugging.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(Get()); err != nil {
+			log.Error(err, "unable to serve the configuration")
+		}
+	})
+}
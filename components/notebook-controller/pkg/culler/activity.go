@@ -0,0 +1,90 @@
+package culler
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/kubeflow/kubeflow/components/notebook-controller/pkg/config"
+)
+
+// ActivityRules configure how the activity of a Jupyter server is computed
+// from its kernels and terminals.
+type ActivityRules struct {
+	// BusyKernels makes a kernel that is not idle count as current activity.
+	BusyKernels bool
+	// KernelConnections makes a kernel with connected clients count as
+	// current activity.
+	KernelConnections bool
+	// Terminals adds the last activity of the terminals to the one of the
+	// kernels.
+	Terminals bool
+}
+
+// DefaultActivityRules returns the rules of the culling.activity section of
+// the configuration.
+func DefaultActivityRules() ActivityRules {
+	activity := config.Get().Culling.Activity
+	return ActivityRules{
+		BusyKernels:       activity.BusyKernels,
+		KernelConnections: activity.KernelConnections,
+		Terminals:         activity.Terminals,
+	}
+}
+
+// jupyterActivity holds the signals reported by a Jupyter server.
+type jupyterActivity struct {
+	Kernels   []KernelStatus
+	Terminals []TerminalStatus
+}
+
+// LastActivity aggregates the signals of a Jupyter server. It returns now if
+// a signal shows current activity, the most recent last_activity among the
+// kernels and terminals otherwise, or a zero time if there are neither.
+func (r ActivityRules) LastActivity(activity jupyterActivity, now time.Time) (time.Time, error) {
+	kernels := activity.Kernels
+
+	if r.BusyKernels && len(kernels) > 0 && !allKernelsAreIdle(kernels, log) {
+		// At least one kernel is "busy" so the last-activity annotation
+		// should be the current time.
+		return now, nil
+	}
+
+	if r.KernelConnections {
+		for i := range kernels {
+			if kernels[i].Connections > 0 {
+				log.Info(fmt.Sprintf("Kernel %s has %d connections", kernels[i].ID, kernels[i].Connections))
+				return now, nil
+			}
+		}
+	}
+
+	var recentTime time.Time
+	for i := range kernels {
+		kernelLastActivity, err := time.Parse(time.RFC3339, kernels[i].LastActivity)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("error parsing the last-activity of kernel %s: %v",
+				kernels[i].ID, err)
+		}
+		if kernelLastActivity.After(recentTime) {
+			recentTime = kernelLastActivity
+		}
+	}
+
+	if !r.Terminals {
+		return recentTime, nil
+	}
+	for i := range activity.Terminals {
+		if activity.Terminals[i].LastActivity == "" {
+			continue
+		}
+		terminalLastActivity, err := time.Parse(time.RFC3339, activity.Terminals[i].LastActivity)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("error parsing the last-activity of terminal %s: %v",
+				activity.Terminals[i].Name, err)
+		}
+		if terminalLastActivity.After(recentTime) {
+			recentTime = terminalLastActivity
+		}
+	}
+	return recentTime, nil
+}
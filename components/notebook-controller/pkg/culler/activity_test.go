@@ -0,0 +1,160 @@
+package culler
+
+import (
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestActivityRules(t *testing.T) {
+	now := time.Date(2022, 8, 30, 18, 0, 0, 0, time.UTC)
+	old := "2022-08-30T15:37:36Z"
+	recent := "2022-08-30T16:37:36Z"
+	all := ActivityRules{BusyKernels: true, KernelConnections: true, Terminals: true}
+
+	testCases := []struct {
+		testName  string
+		rules     ActivityRules
+		responses map[string]string
+		result    string
+		err       bool
+	}{
+		{
+			testName: "No kernels and no terminals",
+			rules:    all,
+			responses: map[string]string{
+				"/notebook/ns/nb/api/kernels":   `[]`,
+				"/notebook/ns/nb/api/terminals": `[]`,
+			},
+			result: "",
+		},
+		{
+			testName: "Busy kernel",
+			rules:    all,
+			responses: map[string]string{
+				"/notebook/ns/nb/api/kernels": `[
+					{"id": "1", "execution_state": "busy", "last_activity": "` + old + `", "connections": 0}]`,
+				"/notebook/ns/nb/api/terminals": `[]`,
+			},
+			result: "now",
+		},
+		{
+			testName: "Busy kernel is ignored",
+			rules:    ActivityRules{},
+			responses: map[string]string{
+				"/notebook/ns/nb/api/kernels": `[
+					{"id": "1", "execution_state": "busy", "last_activity": "` + old + `", "connections": 0}]`,
+			},
+			result: old,
+		},
+		{
+			testName: "Idle kernel open in a browser",
+			rules:    all,
+			responses: map[string]string{
+				"/notebook/ns/nb/api/kernels": `[
+					{"id": "1", "execution_state": "idle", "last_activity": "` + old + `", "connections": 1}]`,
+				"/notebook/ns/nb/api/terminals": `[]`,
+			},
+			result: "now",
+		},
+		{
+			testName: "Kernel connections are ignored",
+			rules:    ActivityRules{BusyKernels: true, Terminals: true},
+			responses: map[string]string{
+				"/notebook/ns/nb/api/kernels": `[
+					{"id": "1", "execution_state": "idle", "last_activity": "` + old + `", "connections": 1}]`,
+				"/notebook/ns/nb/api/terminals": `[]`,
+			},
+			result: old,
+		},
+		{
+			testName: "Terminal more recent than the kernels",
+			rules:    all,
+			responses: map[string]string{
+				"/notebook/ns/nb/api/kernels": `[
+					{"id": "1", "execution_state": "idle", "last_activity": "` + old + `", "connections": 0}]`,
+				"/notebook/ns/nb/api/terminals": `[{"name": "1", "last_activity": "` + recent + `"}]`,
+			},
+			result: recent,
+		},
+		{
+			testName: "Terminal without kernels",
+			rules:    all,
+			responses: map[string]string{
+				"/notebook/ns/nb/api/kernels":   `[]`,
+				"/notebook/ns/nb/api/terminals": `[{"name": "1", "last_activity": "` + recent + `"}]`,
+			},
+			result: recent,
+		},
+		{
+			testName: "Terminals are ignored",
+			rules:    ActivityRules{BusyKernels: true, KernelConnections: true},
+			responses: map[string]string{
+				"/notebook/ns/nb/api/kernels": `[
+					{"id": "1", "execution_state": "idle", "last_activity": "` + old + `", "connections": 0}]`,
+				"/notebook/ns/nb/api/terminals": `[{"name": "1", "last_activity": "` + recent + `"}]`,
+			},
+			result: old,
+		},
+		{
+			testName: "Terminals are disabled on the server",
+			rules:    all,
+			responses: map[string]string{
+				"/notebook/ns/nb/api/kernels": `[
+					{"id": "1", "execution_state": "idle", "last_activity": "` + old + `", "connections": 0}]`,
+			},
+			result: old,
+		},
+		{
+			testName: "Invalid terminal last activity",
+			rules:    all,
+			responses: map[string]string{
+				"/notebook/ns/nb/api/kernels":   `[]`,
+				"/notebook/ns/nb/api/terminals": `[{"name": "1", "last_activity": "yesterday"}]`,
+			},
+			err: true,
+		},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.testName, func(t *testing.T) {
+			fakeNotebookServer(t, c.responses)
+
+			// Fetch the signals from the fake server, and aggregate them at a
+			// fixed time
+			meta := metav1.ObjectMeta{Name: "nb", Namespace: "ns"}
+			kernels, err := getNotebookApiKernels(ProbeEndpoint{}, meta.Name, meta.Namespace)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			terminals, err := getNotebookApiTerminals(ProbeEndpoint{}, meta.Name, meta.Namespace)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			result, err := c.rules.LastActivity(jupyterActivity{Kernels: kernels, Terminals: terminals}, now)
+			if (err != nil) != c.err {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if c.err {
+				return
+			}
+
+			switch c.result {
+			case "":
+				if !result.IsZero() {
+					t.Errorf("Got %v, expected no activity", result)
+				}
+			case "now":
+				if !result.Equal(now) {
+					t.Errorf("Got %v, expected %v", result, now)
+				}
+			default:
+				if result.UTC().Format(time.RFC3339) != c.result {
+					t.Errorf("Got %v, expected %s", result, c.result)
+				}
+			}
+		})
+	}
+}
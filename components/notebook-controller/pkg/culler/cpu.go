@@ -0,0 +1,141 @@
+package culler
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/kubeflow/kubeflow/components/notebook-controller/api/v1beta1"
+	"github.com/kubeflow/kubeflow/components/notebook-controller/pkg/config"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+var podMetricsGVK = schema.GroupVersionKind{
+	Group:   "metrics.k8s.io",
+	Version: "v1beta1",
+	Kind:    "PodMetrics",
+}
+
+// CPURules configure the CPU signal of the culler. When enabled, a Notebook
+// whose Pod used more than Threshold millicores on average over the last
+// Window counts as active, e.g. while it runs a job started from a terminal
+// with `nohup`. The usage is read from the metrics.k8s.io API, so it needs
+// the metrics-server.
+type CPURules struct {
+	Enabled bool
+	// Threshold is the average CPU usage, in millicores, above which the
+	// Notebook is active.
+	Threshold int64
+	// Window is the period over which the CPU usage is averaged.
+	Window time.Duration
+}
+
+// DefaultCPURules returns the rules of the culling.activity.cpu section of
+// the configuration in effect.
+func DefaultCPURules() CPURules {
+	cpu := config.Get().Culling.Activity.CPU
+	return CPURules{
+		Enabled:   cpu.Enabled,
+		Threshold: cpu.Threshold,
+		Window:    cpu.Window.Duration,
+	}
+}
+
+// cpuSample is the CPU usage of a Notebook's Pod at a point in time.
+type cpuSample struct {
+	time  time.Time
+	usage int64
+}
+
+// cpuHistory holds the CPU samples of a Notebook within the window, and
+// whether its average was above the threshold.
+type cpuHistory struct {
+	samples []cpuSample
+	active  bool
+}
+
+// add records a sample and returns the average usage over the window.
+func (h *cpuHistory) add(sample cpuSample, window time.Duration) int64 {
+	h.samples = append(h.samples, sample)
+
+	cutoff := sample.time.Add(-window)
+	for len(h.samples) > 0 && h.samples[0].time.Before(cutoff) {
+		h.samples = h.samples[1:]
+	}
+
+	var total int64
+	for _, s := range h.samples {
+		total += s.usage
+	}
+	return total / int64(len(h.samples))
+}
+
+// podCPUUsage returns the CPU usage of the Notebook's Pod, in millicores, as
+// reported by the metrics.k8s.io API.
+func (c *Culler) podCPUUsage(meta metav1.ObjectMeta) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), client.Timeout)
+	defer cancel()
+
+	podMetrics := &unstructured.Unstructured{}
+	podMetrics.SetGroupVersionKind(podMetricsGVK)
+	key := types.NamespacedName{Name: meta.GetName() + "-0", Namespace: meta.GetNamespace()}
+	if err := c.reader.Get(ctx, key, podMetrics); err != nil {
+		return 0, err
+	}
+	return podMetricsCPU(podMetrics)
+}
+
+// podMetricsCPU sums the CPU usage of the containers of a PodMetrics.
+func podMetricsCPU(podMetrics *unstructured.Unstructured) (int64, error) {
+	containers, _, err := unstructured.NestedSlice(podMetrics.Object, "containers")
+	if err != nil {
+		return 0, err
+	}
+
+	var usage int64
+	for _, container := range containers {
+		cpu, _, err := unstructured.NestedString(container.(map[string]interface{}), "usage", "cpu")
+		if err != nil {
+			return 0, err
+		}
+		quantity, err := resource.ParseQuantity(cpu)
+		if err != nil {
+			return 0, fmt.Errorf("invalid CPU usage %q: %v", cpu, err)
+		}
+		usage += quantity.MilliValue()
+	}
+	return usage, nil
+}
+
+// applyCPU records the CPU usage of the Notebook and returns now as its last
+// activity if the average usage is above the threshold of the rules. The
+// changes of the decision are recorded in the Notebook's events. It must be
+// called with the lock held.
+func (c *Culler) applyCPU(target *probeTarget, rules CPURules, usage int64, lastActivity, now time.Time) time.Time {
+	average := target.cpu.add(cpuSample{time: now, usage: usage}, rules.Window)
+	active := average >= rules.Threshold
+
+	if active != target.cpu.active && c.recorder != nil {
+		nb := &v1beta1.Notebook{ObjectMeta: target.meta}
+		if active {
+			c.recorder.Eventf(nb, corev1.EventTypeNormal, "CPUActive",
+				"Average CPU usage of %dm over %v is above %dm. The Notebook is considered active",
+				average, rules.Window, rules.Threshold)
+		} else {
+			c.recorder.Eventf(nb, corev1.EventTypeNormal, "CPUIdle",
+				"Average CPU usage of %dm over %v is below %dm. The Notebook's activity is taken from its server again",
+				average, rules.Window, rules.Threshold)
+		}
+	}
+	target.cpu.active = active
+
+	if active && lastActivity.Before(now) {
+		return now
+	}
+	return lastActivity
+}
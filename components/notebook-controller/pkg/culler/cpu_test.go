@@ -0,0 +1,98 @@
+package culler
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func newPodMetrics(name, namespace string, usages ...string) *unstructured.Unstructured {
+	containers := []interface{}{}
+	for _, usage := range usages {
+		containers = append(containers, map[string]interface{}{
+			"name":  "container",
+			"usage": map[string]interface{}{"cpu": usage, "memory": "100Mi"},
+		})
+	}
+
+	podMetrics := &unstructured.Unstructured{Object: map[string]interface{}{
+		"containers": containers,
+	}}
+	podMetrics.SetGroupVersionKind(podMetricsGVK)
+	podMetrics.SetName(name)
+	podMetrics.SetNamespace(namespace)
+	return podMetrics
+}
+
+func TestPodCPUUsage(t *testing.T) {
+	meta := metav1.ObjectMeta{Name: "nb", Namespace: "ns"}
+	reader := fake.NewClientBuilder().WithScheme(runtime.NewScheme()).
+		WithObjects(newPodMetrics("nb-0", "ns", "250m", "1500000n")).Build()
+	c := &Culler{reader: reader}
+
+	usage, err := c.podCPUUsage(meta)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if usage != 252 {
+		t.Errorf("Got %dm, expected 252m", usage)
+	}
+
+	if _, err := podMetricsCPU(newPodMetrics("nb-0", "ns", "lots")); err == nil {
+		t.Errorf("Expected an invalid usage to fail")
+	}
+	if _, err := c.podCPUUsage(metav1.ObjectMeta{Name: "other", Namespace: "ns"}); err == nil {
+		t.Errorf("Expected missing PodMetrics to fail")
+	}
+}
+
+func TestApplyCPU(t *testing.T) {
+	start := time.Date(2022, 8, 30, 15, 0, 0, 0, time.UTC)
+	old := start.Add(-time.Hour)
+	recorder := record.NewFakeRecorder(10)
+	c := &Culler{recorder: recorder}
+	rules := CPURules{Enabled: true, Threshold: 100, Window: 10 * time.Minute}
+	target := &probeTarget{meta: metav1.ObjectMeta{Name: "nb", Namespace: "ns"}}
+
+	steps := []struct {
+		minutes int
+		usage   int64
+		active  bool
+		event   string
+	}{
+		{minutes: 0, usage: 10, active: false},
+		{minutes: 1, usage: 400, active: true, event: "CPUActive"},
+		{minutes: 2, usage: 10, active: true},
+		// The usage of the first minutes leaves the window
+		{minutes: 12, usage: 10, active: false, event: "CPUIdle"},
+		{minutes: 13, usage: 10, active: false},
+	}
+
+	for _, step := range steps {
+		now := start.Add(time.Duration(step.minutes) * time.Minute)
+		lastActivity := c.applyCPU(target, rules, step.usage, old, now)
+
+		if active := lastActivity.Equal(now); active != step.active {
+			t.Errorf("Minute %d: got active %t, expected %t", step.minutes, active, step.active)
+		}
+
+		var event string
+		select {
+		case e := <-recorder.Events:
+			event = e
+		default:
+		}
+		if step.event == "" && event != "" {
+			t.Errorf("Minute %d: unexpected event %q", step.minutes, event)
+		}
+		if step.event != "" && !strings.HasPrefix(event, "Normal "+step.event) {
+			t.Errorf("Minute %d: got event %q, expected %s", step.minutes, event, step.event)
+		}
+	}
+}
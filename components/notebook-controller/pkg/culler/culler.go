@@ -1,14 +1,12 @@
 package culler
 
 import (
-	"encoding/json"
 	"fmt"
 	"net/http"
-	"os"
-	"strconv"
 	"time"
 
 	"github.com/go-logr/logr"
+	"github.com/kubeflow/kubeflow/components/notebook-controller/pkg/config"
 	"github.com/kubeflow/kubeflow/components/notebook-controller/pkg/metrics"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
@@ -17,18 +15,10 @@ import (
 
 var log = logf.Log.WithName("culler")
 var client = &http.Client{
-	Timeout: time.Second * 10,
+	Timeout:   time.Second * 10,
+	Transport: &probeTransport{},
 }
 
-// The constants with name 'DEFAULT_{ENV_Var}' are the default values to be
-// used, if the respective ENV vars are not present.
-// All the time numbers correspond to minutes.
-const DEFAULT_CULL_IDLE_TIME = "1440" // One day
-const DEFAULT_IDLENESS_CHECK_PERIOD = "1"
-const DEFAULT_ENABLE_CULLING = "false"
-const DEFAULT_CLUSTER_DOMAIN = "cluster.local"
-const DEFAULT_DEV = "false"
-
 // When a Resource should be stopped/culled, then the controller should add this
 // annotation in the Resource's Metadata. Then, inside the reconcile loop,
 // the controller must check if this annotation is set and then apply the
@@ -40,6 +30,11 @@ const DEFAULT_DEV = "false"
 const STOP_ANNOTATION = "kubeflow-resource-stopped"
 const LAST_ACTIVITY_ANNOTATION = "notebooks.kubeflow.org/last-activity"
 
+// When an idle Notebook enters the warning period before its culling, the
+// controller sets this annotation to the time at which it will be culled.
+// The annotation is removed if the Notebook becomes active again.
+const CULL_SCHEDULED_ANNOTATION = "notebooks.kubeflow.org/cull-scheduled-at"
+
 const (
 	KERNEL_EXECUTION_STATE_IDLE     = "idle"
 	KERNEL_EXECUTION_STATE_BUSY     = "busy"
@@ -57,14 +52,6 @@ type KernelStatus struct {
 }
 
 // Some Utility Functions
-func getEnvDefault(variable string, defaultVal string) string {
-	envVar := os.Getenv(variable)
-	if len(envVar) == 0 {
-		return defaultVal
-	}
-	return envVar
-}
-
 func getNamespacedNameFromMeta(meta metav1.ObjectMeta) types.NamespacedName {
 	return types.NamespacedName{
 		Name:      meta.GetName(),
@@ -80,31 +67,33 @@ func createTimestamp() string {
 
 func GetRequeueTime() time.Duration {
 	// The frequency in which we check if the Pod needs culling
-	// Uses ENV var: IDLENESS_CHECK_PERIOD
-	cullingPeriod := getEnvDefault(
-		"IDLENESS_CHECK_PERIOD", DEFAULT_IDLENESS_CHECK_PERIOD)
-	realCullingPeriod, err := strconv.Atoi(cullingPeriod)
-	if err != nil {
-		log.Info(fmt.Sprintf(
-			"Culling Period should be Int. Got '%s'. Using default value.",
-			cullingPeriod))
-		realCullingPeriod, _ = strconv.Atoi(DEFAULT_IDLENESS_CHECK_PERIOD)
-	}
+	return config.Get().Culling.CheckPeriod.Duration
+}
 
-	return time.Duration(realCullingPeriod) * time.Minute
+// Policy is the culling policy in effect for a Notebook.
+type Policy struct {
+	Enabled     bool
+	IdleTime    time.Duration
+	CheckPeriod time.Duration
+	// WarningPeriod is how long before culling the Notebook is marked as
+	// scheduled for culling. Zero disables the warning.
+	WarningPeriod time.Duration
 }
 
-func getMaxIdleTime() time.Duration {
-	idleTime := getEnvDefault("CULL_IDLE_TIME", DEFAULT_CULL_IDLE_TIME)
-	realIdleTime, err := strconv.Atoi(idleTime)
-	if err != nil {
-		log.Info(fmt.Sprintf(
-			"CULL_IDLE_TIME should be Int. Got %s instead. Using default value.",
-			idleTime))
-		realIdleTime, _ = strconv.Atoi(DEFAULT_CULL_IDLE_TIME)
+// DefaultPolicy returns the controller wide culling policy of the
+// configuration.
+func DefaultPolicy() Policy {
+	culling := config.Get().Culling
+	return Policy{
+		Enabled:       culling.Enabled,
+		IdleTime:      culling.IdleTime.Duration,
+		CheckPeriod:   culling.CheckPeriod.Duration,
+		WarningPeriod: culling.WarningPeriod.Duration,
 	}
+}
 
-	return time.Minute * time.Duration(realIdleTime)
+func getMaxIdleTime() time.Duration {
+	return config.Get().Culling.IdleTime.Duration
 }
 
 // Stop Annotation handling functions
@@ -131,6 +120,7 @@ func SetStopAnnotation(meta *metav1.ObjectMeta, m *metrics.Metrics) {
 			delete(meta.GetAnnotations(), "notebooks.kubeflow.org/last_activity")
 		}
 	}
+	RemoveCullScheduledAnnotation(meta)
 }
 
 func StopAnnotationIsSet(meta metav1.ObjectMeta) bool {
@@ -146,42 +136,29 @@ func StopAnnotationIsSet(meta metav1.ObjectMeta) bool {
 }
 
 // Culling Logic
-func getNotebookApiKernels(nm, ns string) []KernelStatus {
+func getNotebookApiKernels(endpoint ProbeEndpoint, nm, ns string) ([]KernelStatus, error) {
 	// Get the Kernels' status from the Server's `/api/kernels` endpoint
-
-	domain := getEnvDefault("CLUSTER_DOMAIN", DEFAULT_CLUSTER_DOMAIN)
-	url := fmt.Sprintf(
-		"http://%s.%s.svc.%s/notebook/%s/%s/api/kernels",
-		nm, ns, domain, ns, nm)
-	if getEnvDefault("DEV", DEFAULT_DEV) != "false" {
-		url = fmt.Sprintf(
-			"http://localhost:8001/api/v1/namespaces/%s/services/%s:http-%s/proxy/notebook/%s/%s/api/kernels",
-			ns, nm, nm, ns, nm)
-	}
-
-	resp, err := client.Get(url)
-	if err != nil {
-		log.Error(err, fmt.Sprintf("Error talking to %s", url))
-		return nil
+	var kernels []KernelStatus
+	url := jupyterURL(endpoint, nm, ns, "/api/kernels")
+	if err := getJSON(url, endpoint.jupyterAuthorization(), &kernels); err != nil {
+		return nil, err
 	}
+	return kernels, nil
+}
 
-	// Decode the body
-	defer resp.Body.Close()
-	if resp.StatusCode != 200 {
-		log.Info(fmt.Sprintf(
-			"Warning: GET to %s: %d", url, resp.StatusCode))
-		return nil
+func getNotebookApiTerminals(endpoint ProbeEndpoint, nm, ns string) ([]TerminalStatus, error) {
+	// Get the Terminals' status from the Server's `/api/terminals` endpoint.
+	// Servers with terminals disabled don't serve it.
+	var terminals []TerminalStatus
+	url := jupyterURL(endpoint, nm, ns, "/api/terminals")
+	err := getJSON(url, endpoint.jupyterAuthorization(), &terminals)
+	if statusErr, ok := err.(*statusError); ok && statusErr.code == http.StatusNotFound {
+		return nil, nil
 	}
-
-	var kernels []KernelStatus
-
-	err = json.NewDecoder(resp.Body).Decode(&kernels)
 	if err != nil {
-		log.Error(err, "Error parsing JSON response for Notebook API Kernels.")
-		return nil
+		return nil, err
 	}
-
-	return kernels
+	return terminals, nil
 }
 
 func allKernelsAreIdle(kernels []KernelStatus, log logr.Logger) bool {
@@ -204,15 +181,14 @@ func allKernelsAreIdle(kernels []KernelStatus, log logr.Logger) bool {
 }
 
 // Update LAST_ACTIVITY_ANNOTATION
-func UpdateNotebookLastActivityAnnotation(meta *metav1.ObjectMeta) bool {
-	log := log.WithValues("notebook", getNamespacedNameFromMeta(*meta))
+func UpdateNotebookLastActivityAnnotation(meta *metav1.ObjectMeta, probe ActivityProbe) bool {
 	if meta == nil {
 		log.Info("Metadata is Nil. Can't update Last Activity Annotation.")
 		return false
 	}
+	log := log.WithValues("notebook", getNamespacedNameFromMeta(*meta))
 
 	log.Info("Updating the last-activity annotation.")
-	nm, ns := meta.GetName(), meta.GetNamespace()
 
 	// No last-activity found in the CR. Setting to Now()
 	if _, ok := meta.GetAnnotations()[LAST_ACTIVITY_ANNOTATION]; !ok {
@@ -226,60 +202,96 @@ func UpdateNotebookLastActivityAnnotation(meta *metav1.ObjectMeta) bool {
 		return true
 	}
 
-	log.Info("last-activity annotation exists. Checking /api/kernels")
-	kernels := getNotebookApiKernels(nm, ns)
-	if kernels == nil {
-		log.Info("Could not GET the kernels status. Will not update last-activity.")
+	log.Info(fmt.Sprintf("last-activity annotation exists. Checking the '%s' probe", probe.Name()))
+	lastActivity, err := probe.LastActivity(*meta)
+	if err != nil {
+		log.Error(err, "Could not probe the Notebook's activity. Will not update last-activity.")
 		return false
 	}
 
-	return updateTimestampFromKernelsActivity(meta, kernels)
+	return updateTimestampFromActivity(meta, lastActivity)
 }
 
-func updateTimestampFromKernelsActivity(meta *metav1.ObjectMeta, kernels []KernelStatus) bool {
+func updateTimestampFromActivity(meta *metav1.ObjectMeta, lastActivity time.Time) bool {
 	log := log.WithValues("notebook", getNamespacedNameFromMeta(*meta))
 
-	if len(kernels) == 0 {
-		log.Info("Notebook has no kernels. Will not update last-activity")
+	if lastActivity.IsZero() {
+		log.Info("Notebook reported no activity. Will not update last-activity")
 		return false
 	}
 
-	if !allKernelsAreIdle(kernels, log) {
-		// At least on kernel is "busy" so the last-activity annotation should
-		// should be the current time.
-		t := createTimestamp()
-		log.Info(fmt.Sprintf("Found a busy kernel. Updating the last-activity to %s", t))
-
-		meta.Annotations[LAST_ACTIVITY_ANNOTATION] = t
-		return true
+	t := lastActivity.Format(time.RFC3339)
+	if meta.Annotations[LAST_ACTIVITY_ANNOTATION] == t {
+		log.Info("last-activity is up to date")
+		return false
 	}
+	meta.Annotations[LAST_ACTIVITY_ANNOTATION] = t
+	log.Info(fmt.Sprintf("Successfully updated last-activity to %s", t))
+	return true
+}
 
-	// Checking for the most recent kernel last_activity. The LAST_ACTIVITY_ANNOTATION
-	// should be the most recent kernel last-activity among the kernels.
-	recentTime, err := time.Parse(time.RFC3339, kernels[0].LastActivity)
-	if err != nil {
-		log.Error(err, "Error parsing the last-activity from the /api/kernels")
+// Cull Scheduled Annotation handling functions
+
+// SetCullScheduledAnnotation sets the time at which the Notebook will be
+// culled. It returns true if the annotation changed.
+func SetCullScheduledAnnotation(meta *metav1.ObjectMeta, deadline time.Time) bool {
+	t := deadline.Format(time.RFC3339)
+	if meta.GetAnnotations()[CULL_SCHEDULED_ANNOTATION] == t {
 		return false
 	}
 
-	for i := 1; i < len(kernels); i++ {
-		kernelLastActivity, err := time.Parse(time.RFC3339, kernels[i].LastActivity)
-		if err != nil {
-			log.Error(err, "Error parsing the last-activity from the /api/kernels")
-			return false
-		}
-		if kernelLastActivity.After(recentTime) {
-			recentTime = kernelLastActivity
-		}
+	if meta.GetAnnotations() == nil {
+		meta.SetAnnotations(map[string]string{})
 	}
-	t := recentTime.Format(time.RFC3339)
+	meta.Annotations[CULL_SCHEDULED_ANNOTATION] = t
+	return true
+}
 
-	meta.Annotations[LAST_ACTIVITY_ANNOTATION] = t
-	log.Info(fmt.Sprintf("Successfully updated last-activity from latest kernel action, %s", t))
+// RemoveCullScheduledAnnotation returns true if the annotation was set.
+func RemoveCullScheduledAnnotation(meta *metav1.ObjectMeta) bool {
+	if _, ok := meta.GetAnnotations()[CULL_SCHEDULED_ANNOTATION]; !ok {
+		return false
+	}
+	delete(meta.GetAnnotations(), CULL_SCHEDULED_ANNOTATION)
 	return true
 }
 
-func notebookIsIdle(meta metav1.ObjectMeta) bool {
+func CullScheduledAnnotationIsSet(meta metav1.ObjectMeta) bool {
+	_, ok := meta.GetAnnotations()[CULL_SCHEDULED_ANNOTATION]
+	return ok
+}
+
+// cullingDeadline returns the time at which the Notebook becomes idle,
+// based on its LAST_ACTIVITY_ANNOTATION.
+func cullingDeadline(meta metav1.ObjectMeta, idleTime time.Duration) (time.Time, bool) {
+	lastActivity, err := time.Parse(time.RFC3339, meta.GetAnnotations()[LAST_ACTIVITY_ANNOTATION])
+	if err != nil {
+		return time.Time{}, false
+	}
+	return lastActivity.Add(idleTime), true
+}
+
+// NotebookCullingWarning returns the time at which the Notebook will be
+// culled, if culling is enabled and the Notebook is within the warning period
+// before its culling.
+func NotebookCullingWarning(meta metav1.ObjectMeta, policy Policy) (time.Time, bool) {
+	if !policy.Enabled || policy.WarningPeriod <= 0 || StopAnnotationIsSet(meta) {
+		return time.Time{}, false
+	}
+
+	deadline, ok := cullingDeadline(meta, policy.IdleTime)
+	if !ok {
+		return time.Time{}, false
+	}
+
+	now := time.Now()
+	if now.Before(deadline.Add(-policy.WarningPeriod)) || now.After(deadline) {
+		return time.Time{}, false
+	}
+	return deadline, true
+}
+
+func notebookIsIdle(meta metav1.ObjectMeta, idleTime time.Duration) bool {
 	// Being idle means that the Notebook can be culled
 	log := log.WithValues("notebook", getNamespacedNameFromMeta(meta))
 
@@ -292,7 +304,7 @@ func notebookIsIdle(meta metav1.ObjectMeta) bool {
 			return false
 		}
 
-		timeCap := LastActivity.Add(getMaxIdleTime())
+		timeCap := LastActivity.Add(idleTime)
 		if time.Now().After(timeCap) {
 			return true
 		}
@@ -300,12 +312,12 @@ func notebookIsIdle(meta metav1.ObjectMeta) bool {
 	return false
 }
 
-func NotebookNeedsCulling(meta metav1.ObjectMeta) bool {
+func NotebookNeedsCulling(meta metav1.ObjectMeta, policy Policy) bool {
 	log := log.WithValues("notebook", getNamespacedNameFromMeta(meta))
 
-	if getEnvDefault("ENABLE_CULLING", DEFAULT_ENABLE_CULLING) != "true" {
+	if !policy.Enabled {
 		log.Info("Culling of idle Pods is Disabled. To enable it set the " +
-			"ENV Var 'ENABLE_CULLING=true'")
+			"ENV Var 'ENABLE_CULLING=true' or the Notebook's culling policy")
 		return false
 	}
 
@@ -314,5 +326,5 @@ func NotebookNeedsCulling(meta metav1.ObjectMeta) bool {
 		return false
 	}
 
-	return notebookIsIdle(meta)
+	return notebookIsIdle(meta, policy.IdleTime)
 }
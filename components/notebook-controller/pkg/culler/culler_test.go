@@ -251,7 +251,7 @@ func TestNotebookIsIdle(t *testing.T) {
 			for envVar, val := range c.env {
 				os.Setenv(envVar, val)
 			}
-			if notebookIsIdle(c.meta) != c.result {
+			if notebookIsIdle(c.meta, getMaxIdleTime()) != c.result {
 				t.Errorf("ENV VAR: %+v\n", c.env)
 				t.Errorf("Wrong result for case object: %+v\n", c.meta)
 			}
@@ -361,10 +361,129 @@ func TestNotebookNeedsCulling(t *testing.T) {
 				os.Setenv(envVar, val)
 			}
 
-			if NotebookNeedsCulling(c.meta) != c.result {
+			if NotebookNeedsCulling(c.meta, DefaultPolicy()) != c.result {
 				t.Errorf("Wrong result for case: %+v", c)
 			}
 		})
 	}
 
 }
+
+func TestNotebookCullingWarning(t *testing.T) {
+	policy := Policy{
+		Enabled:       true,
+		IdleTime:      10 * time.Minute,
+		CheckPeriod:   time.Minute,
+		WarningPeriod: 5 * time.Minute,
+	}
+	noWarning := policy
+	noWarning.WarningPeriod = 0
+	disabled := policy
+	disabled.Enabled = false
+
+	testCases := []struct {
+		testName string
+		meta     metav1.ObjectMeta
+		policy   Policy
+		result   bool
+	}{
+		{
+			testName: "No LAST_ACTIVITY_ANNOTATION",
+			meta:     metav1.ObjectMeta{},
+			policy:   policy,
+			result:   false,
+		},
+		{
+			testName: "Before the warning period",
+			meta: metav1.ObjectMeta{
+				Annotations: map[string]string{
+					LAST_ACTIVITY_ANNOTATION: time.Now().Add(-4 * time.Minute).Format(time.RFC3339),
+				},
+			},
+			policy: policy,
+			result: false,
+		},
+		{
+			testName: "Within the warning period",
+			meta: metav1.ObjectMeta{
+				Annotations: map[string]string{
+					LAST_ACTIVITY_ANNOTATION: time.Now().Add(-6 * time.Minute).Format(time.RFC3339),
+				},
+			},
+			policy: policy,
+			result: true,
+		},
+		{
+			testName: "After the culling deadline",
+			meta: metav1.ObjectMeta{
+				Annotations: map[string]string{
+					LAST_ACTIVITY_ANNOTATION: time.Now().Add(-11 * time.Minute).Format(time.RFC3339),
+				},
+			},
+			policy: policy,
+			result: false,
+		},
+		{
+			testName: "Warning period disabled",
+			meta: metav1.ObjectMeta{
+				Annotations: map[string]string{
+					LAST_ACTIVITY_ANNOTATION: time.Now().Add(-6 * time.Minute).Format(time.RFC3339),
+				},
+			},
+			policy: noWarning,
+			result: false,
+		},
+		{
+			testName: "Culling disabled",
+			meta: metav1.ObjectMeta{
+				Annotations: map[string]string{
+					LAST_ACTIVITY_ANNOTATION: time.Now().Add(-6 * time.Minute).Format(time.RFC3339),
+				},
+			},
+			policy: disabled,
+			result: false,
+		},
+		{
+			testName: "STOP_ANNOTATION is already set",
+			meta: metav1.ObjectMeta{
+				Annotations: map[string]string{
+					STOP_ANNOTATION:          time.Now().Format(time.RFC3339),
+					LAST_ACTIVITY_ANNOTATION: time.Now().Add(-6 * time.Minute).Format(time.RFC3339),
+				},
+			},
+			policy: policy,
+			result: false,
+		},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.testName, func(t *testing.T) {
+			if _, ok := NotebookCullingWarning(c.meta, c.policy); ok != c.result {
+				t.Errorf("Wrong result for case: %+v", c)
+			}
+		})
+	}
+}
+
+func TestCullScheduledAnnotation(t *testing.T) {
+	meta := &metav1.ObjectMeta{}
+	deadline := time.Now()
+
+	if !SetCullScheduledAnnotation(meta, deadline) {
+		t.Errorf("Expected the annotation to be set")
+	}
+	if SetCullScheduledAnnotation(meta, deadline) {
+		t.Errorf("Expected the annotation to be unchanged")
+	}
+	if !CullScheduledAnnotationIsSet(*meta) {
+		t.Errorf("Expected the annotation to be set")
+	}
+
+	SetStopAnnotation(meta, nil)
+	if CullScheduledAnnotationIsSet(*meta) {
+		t.Errorf("Expected the annotation to be removed when the Notebook is culled")
+	}
+	if RemoveCullScheduledAnnotation(meta) {
+		t.Errorf("Expected the annotation to be already removed")
+	}
+}
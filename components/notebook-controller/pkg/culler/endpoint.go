@@ -0,0 +1,168 @@
+package culler
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/kubeflow/kubeflow/components/notebook-controller/pkg/config"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// The annotations below allow a Notebook that is not reachable over plain
+// HTTP on its Service, e.g. because it sits behind an authenticating proxy,
+// to tell the culler how to reach it.
+const ACTIVITY_PROBE_SCHEME_ANNOTATION = "notebooks.kubeflow.org/activity-probe-scheme"
+const ACTIVITY_PROBE_SERVICE_ANNOTATION = "notebooks.kubeflow.org/activity-probe-service"
+const ACTIVITY_PROBE_PORT_ANNOTATION = "notebooks.kubeflow.org/activity-probe-port"
+const ACTIVITY_PROBE_TOKEN_SECRET_ANNOTATION = "notebooks.kubeflow.org/activity-probe-token-secret"
+
+// PROBE_TOKEN_SECRET_KEY is the key of the Jupyter token in the Secret named
+// by ACTIVITY_PROBE_TOKEN_SECRET_ANNOTATION.
+const PROBE_TOKEN_SECRET_KEY = "token"
+
+// ProbeEndpoint is where and how the probes reach a Notebook server. Its zero
+// value is the plain HTTP port of the Notebook's Service, without
+// credentials.
+type ProbeEndpoint struct {
+	// Scheme is either "http" or "https".
+	Scheme string
+	// Service is the name of the Service in the Notebook's namespace. It
+	// defaults to the Notebook's Service.
+	Service string
+	// Port of the Service. Zero is the default port of the scheme.
+	Port int
+	// BasePath is the path under which a Jupyter server serves its API. It
+	// defaults to the NB_PREFIX of the Notebook.
+	BasePath string
+	// Token is sent to the server in the Authorization header.
+	Token string
+}
+
+// GetProbeEndpoint reads the ProbeEndpoint of the Notebook from its
+// annotations. The Token is not set, as it is read from a Secret by the
+// caller.
+func GetProbeEndpoint(meta metav1.ObjectMeta) ProbeEndpoint {
+	log := log.WithValues("notebook", getNamespacedNameFromMeta(meta))
+	annotations := meta.GetAnnotations()
+
+	endpoint := ProbeEndpoint{}
+	if svc := annotations[ACTIVITY_PROBE_SERVICE_ANNOTATION]; svc != "" {
+		if msgs := ValidateProbeService(svc); len(msgs) > 0 {
+			log.Info(fmt.Sprintf("Invalid activity probe service '%s': %s. Using the Notebook's Service.",
+				svc, strings.Join(msgs, ", ")))
+		} else {
+			endpoint.Service = svc
+		}
+	}
+	// The generic HTTP probe has its own path
+	if path := annotations[ACTIVITY_PROBE_PATH_ANNOTATION]; path != "" {
+		if msgs := ValidateProbePath(path); len(msgs) > 0 {
+			log.Info(fmt.Sprintf("Invalid activity probe path '%s': %s. Using the default path.",
+				path, strings.Join(msgs, ", ")))
+		} else {
+			endpoint.BasePath = path
+		}
+	}
+
+	switch scheme := annotations[ACTIVITY_PROBE_SCHEME_ANNOTATION]; scheme {
+	case "", "http", "https":
+		endpoint.Scheme = scheme
+	default:
+		log.Info(fmt.Sprintf("Unknown activity probe scheme '%s'. Using 'http' instead.", scheme))
+	}
+
+	if port, ok := annotations[ACTIVITY_PROBE_PORT_ANNOTATION]; ok {
+		realPort, err := strconv.Atoi(port)
+		if err != nil || realPort < 1 || realPort > 65535 {
+			log.Info(fmt.Sprintf("Activity probe port should be a valid port. Got '%s'. Using the default port.", port))
+		} else {
+			endpoint.Port = realPort
+		}
+	}
+	return endpoint
+}
+
+// ValidateProbeService returns why the Service of the
+// ACTIVITY_PROBE_SERVICE_ANNOTATION is invalid, if it is. It must be the
+// name of a Service, so that the probes stay in the Notebook's namespace.
+func ValidateProbeService(svc string) []string {
+	return validation.IsDNS1035Label(svc)
+}
+
+// ValidateProbePath returns why the path of the
+// ACTIVITY_PROBE_PATH_ANNOTATION is invalid, if it is. It must be an
+// absolute path, without a userinfo, query or fragment which would change
+// the URL the probes are sent to.
+func ValidateProbePath(path string) []string {
+	msgs := []string{}
+	if !strings.HasPrefix(path, "/") {
+		msgs = append(msgs, "must start with '/'")
+	}
+	if strings.ContainsAny(path, "@?#\\") {
+		msgs = append(msgs, "must not contain '@', '?', '#' or '\\'")
+	}
+	return msgs
+}
+
+// ProbeTokenSecretName returns the name of the Secret holding the Jupyter
+// token of the Notebook, or an empty string.
+func ProbeTokenSecretName(meta metav1.ObjectMeta) string {
+	return meta.GetAnnotations()[ACTIVITY_PROBE_TOKEN_SECRET_ANNOTATION]
+}
+
+// isDefault returns true if the endpoint is the plain HTTP port of the
+// Notebook's Service.
+func (e ProbeEndpoint) isDefault(nm string) bool {
+	return (e.Scheme == "" || e.Scheme == "http") && (e.Service == "" || e.Service == nm) && e.Port == 0
+}
+
+// probeTransport is the transport of the probes. It is built on the first
+// probe, once the configuration of the controller is loaded, so changing the
+// CA bundle requires a restart.
+type probeTransport struct {
+	once      sync.Once
+	transport http.RoundTripper
+}
+
+func (t *probeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.once.Do(func() {
+		t.transport = newProbeTransport(config.Get().Culling.ProbeCABundle)
+	})
+	return t.transport.RoundTrip(req)
+}
+
+// newProbeTransport returns a transport which trusts the CA certificates of
+// the bundle, if any.
+func newProbeTransport(bundle string) http.RoundTripper {
+	if bundle == "" {
+		return http.DefaultTransport
+	}
+
+	pem, err := os.ReadFile(bundle)
+	if err != nil {
+		log.Error(err, "Could not read the probe CA bundle. Using the system CA certificates.")
+		return http.DefaultTransport
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		log.Info(fmt.Sprintf("No certificates found in the probe CA bundle '%s'. Using the system CA certificates.", bundle))
+		return http.DefaultTransport
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{
+		RootCAs:    pool,
+		MinVersion: tls.VersionTLS12,
+	}
+	return transport
+}
@@ -0,0 +1,175 @@
+package culler
+
+import (
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestGetProbeEndpoint(t *testing.T) {
+	testCases := []struct {
+		testName    string
+		annotations map[string]string
+		result      ProbeEndpoint
+	}{
+		{
+			testName: "No annotations",
+			result:   ProbeEndpoint{},
+		},
+		{
+			testName: "HTTPS Service",
+			annotations: map[string]string{
+				ACTIVITY_PROBE_SCHEME_ANNOTATION:  "https",
+				ACTIVITY_PROBE_SERVICE_ANNOTATION: "nb-tls",
+				ACTIVITY_PROBE_PORT_ANNOTATION:    "8443",
+				ACTIVITY_PROBE_PATH_ANNOTATION:    "/",
+			},
+			result: ProbeEndpoint{Scheme: "https", Service: "nb-tls", Port: 8443, BasePath: "/"},
+		},
+		{
+			testName: "Invalid scheme and port",
+			annotations: map[string]string{
+				ACTIVITY_PROBE_SCHEME_ANNOTATION: "ftp",
+				ACTIVITY_PROBE_PORT_ANNOTATION:   "https",
+			},
+			result: ProbeEndpoint{},
+		},
+		{
+			testName: "Service and path outside of the namespace",
+			annotations: map[string]string{
+				ACTIVITY_PROBE_SERVICE_ANNOTATION: "evil.example/x#",
+				ACTIVITY_PROBE_PATH_ANNOTATION:    "@evil.example/",
+			},
+			result: ProbeEndpoint{},
+		},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.testName, func(t *testing.T) {
+			meta := metav1.ObjectMeta{Name: "nb", Namespace: "ns", Annotations: c.annotations}
+			if result := GetProbeEndpoint(meta); result != c.result {
+				t.Errorf("Got %+v, expected %+v", result, c.result)
+			}
+		})
+	}
+}
+
+func TestNotebookServiceURL(t *testing.T) {
+	testCases := []struct {
+		testName string
+		dev      string
+		endpoint ProbeEndpoint
+		result   string
+	}{
+		{
+			testName: "Default endpoint",
+			endpoint: ProbeEndpoint{},
+			result:   "http://nb.ns.svc.cluster.local/notebook/ns/nb/api/kernels",
+		},
+		{
+			testName: "HTTPS Service with a base path",
+			endpoint: ProbeEndpoint{Scheme: "https", Service: "nb-tls", Port: 8443, BasePath: "/"},
+			result:   "https://nb-tls.ns.svc.cluster.local:8443/api/kernels",
+		},
+		{
+			testName: "Default endpoint in DEV mode",
+			dev:      "true",
+			endpoint: ProbeEndpoint{},
+			result:   "http://localhost:8001/api/v1/namespaces/ns/services/nb:http-nb/proxy/notebook/ns/nb/api/kernels",
+		},
+		{
+			testName: "HTTPS Service in DEV mode",
+			dev:      "true",
+			endpoint: ProbeEndpoint{Scheme: "https", Service: "nb-tls", Port: 8443},
+			result:   "http://localhost:8001/api/v1/namespaces/ns/services/https:nb-tls:8443/proxy/notebook/ns/nb/api/kernels",
+		},
+		{
+			testName: "Base path with a fragment",
+			endpoint: ProbeEndpoint{BasePath: "/x#@evil.example"},
+			result:   "http://nb.ns.svc.cluster.local/x%23@evil.example/api/kernels",
+		},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.testName, func(t *testing.T) {
+			t.Setenv("DEV", c.dev)
+			if result := jupyterURL(c.endpoint, "nb", "ns", "/api/kernels"); result != c.result {
+				t.Errorf("Got %s, expected %s", result, c.result)
+			}
+		})
+	}
+}
+
+func TestProbeToken(t *testing.T) {
+	meta := metav1.ObjectMeta{Name: "nb", Namespace: "ns"}
+	kernels := `[{"id": "1", "execution_state": "idle", "last_activity": "2022-08-30T16:37:36Z"}]`
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		switch r.URL.Path {
+		case "/notebook/ns/nb/api/kernels":
+			if auth != "token secret" {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			fmt.Fprint(w, kernels)
+		case "/healthz":
+			if auth != "Bearer secret" {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			fmt.Fprint(w, `{"lastHeartbeat": 1661877456000}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	origURL := notebookServiceURL
+	notebookServiceURL = func(endpoint ProbeEndpoint, nm, ns, path string) string {
+		return server.URL + path
+	}
+	defer func() { notebookServiceURL = origURL }()
+
+	rules := ActivityRules{BusyKernels: true}
+	if _, err := (&JupyterKernelsProbe{Rules: rules}).LastActivity(meta); err == nil {
+		t.Errorf("Expected the probe without a token to fail")
+	}
+	probe := &JupyterKernelsProbe{Rules: rules, Endpoint: ProbeEndpoint{Token: "secret"}}
+	if _, err := probe.LastActivity(meta); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	httpProbe := &HTTPProbe{Path: "/healthz", Field: "lastHeartbeat", Endpoint: ProbeEndpoint{Token: "secret"}}
+	if _, err := httpProbe.LastActivity(meta); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestProbeCABundle(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[]`)
+	}))
+	defer server.Close()
+
+	bundle := filepath.Join(t.TempDir(), "ca.crt")
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(bundle, cert, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := (&http.Client{Transport: newProbeTransport("")}).Get(server.URL); err == nil {
+		t.Errorf("Expected the server's certificate to be untrusted")
+	}
+
+	resp, err := (&http.Client{Transport: newProbeTransport(bundle)}).Get(server.URL)
+	if err != nil {
+		t.Fatalf("Expected the server's certificate to be trusted: %v", err)
+	}
+	resp.Body.Close()
+}
@@ -0,0 +1,366 @@
+package culler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kubeflow/kubeflow/components/notebook-controller/pkg/config"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// The annotations below allow a Notebook to select how the culler learns
+// about its activity. If ACTIVITY_PROBE_ANNOTATION is not set, a default
+// probe is picked based on the image of the Notebook's first container.
+const ACTIVITY_PROBE_ANNOTATION = "notebooks.kubeflow.org/activity-probe"
+const ACTIVITY_PROBE_PATH_ANNOTATION = "notebooks.kubeflow.org/activity-probe-path"
+const ACTIVITY_PROBE_FIELD_ANNOTATION = "notebooks.kubeflow.org/activity-probe-field"
+
+// Names of the built-in activity probes.
+const (
+	PROBE_JUPYTER_KERNELS  = "jupyter-kernels"
+	PROBE_JUPYTER_SESSIONS = "jupyter-sessions"
+	PROBE_HTTP             = "http"
+	PROBE_NEVER_CULL       = "never"
+)
+
+// Defaults of the generic HTTP probe. Jupyter servers expose the time of the
+// last request they served in the `last_activity` field of `/api/status`.
+const DEFAULT_HTTP_PROBE_FIELD = "last_activity"
+
+// ActivityProbe reports when a Notebook server was last active.
+type ActivityProbe interface {
+	// Name returns the name that selects the probe in the
+	// ACTIVITY_PROBE_ANNOTATION.
+	Name() string
+	// LastActivity queries the Notebook server and returns the time of its
+	// most recent activity. A zero time means that the server is reachable
+	// but had nothing to report, e.g. it has no running kernels.
+	LastActivity(meta metav1.ObjectMeta) (time.Time, error)
+}
+
+// notebookServiceURL returns the URL of the given path on the Notebook's
+// Service, or on the Service of the endpoint, as seen from the controller.
+var notebookServiceURL = func(endpoint ProbeEndpoint, nm, ns, path string) string {
+	scheme, svc := endpoint.Scheme, endpoint.Service
+	if scheme == "" {
+		scheme = "http"
+	}
+	if svc == "" {
+		svc = nm
+	}
+
+	cfg := config.Get()
+	if cfg.Dev {
+		proxy := fmt.Sprintf("/api/v1/namespaces/%s/services/%s:%s:%d/proxy", ns, scheme, svc, endpoint.Port)
+		if endpoint.isDefault(nm) {
+			proxy = fmt.Sprintf("/api/v1/namespaces/%s/services/%s:http-%s/proxy", ns, nm, nm)
+		}
+		u := url.URL{Scheme: "http", Host: "localhost:8001", Path: proxy + path}
+		return u.String()
+	}
+
+	// The URL is built from its parts, so that the path can't change its host
+	host := fmt.Sprintf("%s.%s.svc.%s", svc, ns, cfg.ClusterDomain)
+	if endpoint.Port != 0 {
+		host = fmt.Sprintf("%s:%d", host, endpoint.Port)
+	}
+	u := url.URL{Scheme: scheme, Host: host, Path: path}
+	return u.String()
+}
+
+// jupyterURL returns the URL of a Jupyter API endpoint. Jupyter servers
+// serve everything under the NB_PREFIX of the Notebook, unless the endpoint
+// has another base path.
+func jupyterURL(endpoint ProbeEndpoint, nm, ns, api string) string {
+	base := endpoint.BasePath
+	if base == "" {
+		base = fmt.Sprintf("/notebook/%s/%s", ns, nm)
+	}
+	return notebookServiceURL(endpoint, nm, ns, strings.TrimSuffix(base, "/")+api)
+}
+
+// jupyterAuthorization returns the Authorization header of the requests to a
+// Jupyter server.
+func (e ProbeEndpoint) jupyterAuthorization() string {
+	if e.Token == "" {
+		return ""
+	}
+	return "token " + e.Token
+}
+
+// statusError is returned by getJSON when the server doesn't answer with 200.
+type statusError struct {
+	url  string
+	code int
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("GET to %s: %d", e.url, e.code)
+}
+
+// getJSON makes a GET request to url and decodes the JSON body into v. The
+// Authorization header is only sent if it is not empty.
+func getJSON(url, authorization string, v interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("error creating request to %s: %v", url, err)
+	}
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error talking to %s: %w", url, err)
+	}
+
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return &statusError{url: url, code: resp.StatusCode}
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("error parsing JSON response of %s: %v", url, err)
+	}
+	return nil
+}
+
+// JupyterKernelsProbe derives the activity of a Jupyter server from the
+// execution state, connections and last activity of its kernels, and from its
+// terminals.
+type JupyterKernelsProbe struct {
+	Rules    ActivityRules
+	Endpoint ProbeEndpoint
+}
+
+func (p *JupyterKernelsProbe) Name() string {
+	return PROBE_JUPYTER_KERNELS
+}
+
+func (p *JupyterKernelsProbe) LastActivity(meta metav1.ObjectMeta) (time.Time, error) {
+	nm, ns := meta.GetName(), meta.GetNamespace()
+
+	kernels, err := getNotebookApiKernels(p.Endpoint, nm, ns)
+	if err != nil {
+		return time.Time{}, err
+	}
+	activity := jupyterActivity{Kernels: kernels}
+	if p.Rules.Terminals {
+		if activity.Terminals, err = getNotebookApiTerminals(p.Endpoint, nm, ns); err != nil {
+			return time.Time{}, err
+		}
+	}
+	return p.Rules.LastActivity(activity, time.Now())
+}
+
+// SessionStatus is an entry of the `/api/sessions` endpoint of a Jupyter
+// server.
+type SessionStatus struct {
+	ID     string       `json:"id"`
+	Path   string       `json:"path"`
+	Kernel KernelStatus `json:"kernel"`
+}
+
+// TerminalStatus is an entry of the `/api/terminals` endpoint of a Jupyter
+// server.
+type TerminalStatus struct {
+	Name         string `json:"name"`
+	LastActivity string `json:"last_activity"`
+}
+
+// JupyterSessionsProbe derives the activity of a Jupyter server from the
+// kernels of its open sessions and from its terminals. Unlike
+// JupyterKernelsProbe, kernels without a session are ignored.
+type JupyterSessionsProbe struct {
+	Rules    ActivityRules
+	Endpoint ProbeEndpoint
+}
+
+func (p *JupyterSessionsProbe) Name() string {
+	return PROBE_JUPYTER_SESSIONS
+}
+
+func (p *JupyterSessionsProbe) LastActivity(meta metav1.ObjectMeta) (time.Time, error) {
+	nm, ns := meta.GetName(), meta.GetNamespace()
+
+	var sessions []SessionStatus
+	url := jupyterURL(p.Endpoint, nm, ns, "/api/sessions")
+	if err := getJSON(url, p.Endpoint.jupyterAuthorization(), &sessions); err != nil {
+		return time.Time{}, err
+	}
+
+	activity := jupyterActivity{Kernels: make([]KernelStatus, 0, len(sessions))}
+	for i := range sessions {
+		activity.Kernels = append(activity.Kernels, sessions[i].Kernel)
+	}
+	if p.Rules.Terminals {
+		var err error
+		if activity.Terminals, err = getNotebookApiTerminals(p.Endpoint, nm, ns); err != nil {
+			return time.Time{}, err
+		}
+	}
+	return p.Rules.LastActivity(activity, time.Now())
+}
+
+// HTTPProbe is a generic probe for servers that report the time of their last
+// request in a JSON field, e.g. code-server's `/healthz` endpoint. The field
+// may hold an RFC3339 timestamp or a Unix time in seconds or milliseconds.
+type HTTPProbe struct {
+	// Path on the Notebook's Service to GET. It is not relative to the
+	// Notebook's NB_PREFIX.
+	Path string
+	// Field is the top level JSON field holding the last activity.
+	Field string
+	// Endpoint is the Service the Path is requested from. Its Token is sent
+	// as a bearer token, and its BasePath is ignored.
+	Endpoint ProbeEndpoint
+}
+
+func (p *HTTPProbe) Name() string {
+	return PROBE_HTTP
+}
+
+func (p *HTTPProbe) LastActivity(meta metav1.ObjectMeta) (time.Time, error) {
+	url := notebookServiceURL(p.Endpoint, meta.GetName(), meta.GetNamespace(), p.Path)
+
+	var authorization string
+	if p.Endpoint.Token != "" {
+		authorization = "Bearer " + p.Endpoint.Token
+	}
+	body := map[string]interface{}{}
+	if err := getJSON(url, authorization, &body); err != nil {
+		return time.Time{}, err
+	}
+
+	value, ok := body[p.Field]
+	if !ok {
+		return time.Time{}, fmt.Errorf("field %q not found in the response of %s", p.Field, url)
+	}
+	return parseActivityValue(value)
+}
+
+// parseActivityValue converts a decoded JSON value to a time.
+func parseActivityValue(value interface{}) (time.Time, error) {
+	switch v := value.(type) {
+	case string:
+		if t, err := time.Parse(time.RFC3339, v); err == nil {
+			return t, nil
+		}
+		n, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("%q is neither RFC3339 nor a Unix time", v)
+		}
+		return unixToTime(n), nil
+	case float64:
+		return unixToTime(v), nil
+	default:
+		return time.Time{}, fmt.Errorf("unsupported last activity value %v", value)
+	}
+}
+
+// unixToTime converts a Unix time in seconds or milliseconds to a time.
+func unixToTime(n float64) time.Time {
+	// Any Unix time in seconds larger than this is centuries away.
+	if n > 1e11 {
+		return time.UnixMilli(int64(n))
+	}
+	return time.Unix(int64(n), 0)
+}
+
+// NeverCullProbe always reports activity. It is meant for servers that
+// expose no way to detect activity, where culling them would lose work.
+type NeverCullProbe struct{}
+
+func (p *NeverCullProbe) Name() string {
+	return PROBE_NEVER_CULL
+}
+
+func (p *NeverCullProbe) LastActivity(meta metav1.ObjectMeta) (time.Time, error) {
+	return time.Now(), nil
+}
+
+// defaultProbeName picks a probe based on the image family of the Notebook's
+// first container.
+func defaultProbeName(podSpec corev1.PodSpec) string {
+	if len(podSpec.Containers) == 0 {
+		return PROBE_JUPYTER_KERNELS
+	}
+
+	image := strings.ToLower(podSpec.Containers[0].Image)
+	// Drop the registry and the tag, to only match the image name
+	image = image[strings.LastIndex(image, "/")+1:]
+	if i := strings.Index(image, ":"); i >= 0 {
+		image = image[:i]
+	}
+
+	switch {
+	case strings.Contains(image, "codeserver") || strings.Contains(image, "code-server"):
+		return PROBE_HTTP
+	case strings.Contains(image, "rstudio"):
+		// RStudio exposes no endpoint with its activity
+		return PROBE_NEVER_CULL
+	default:
+		return PROBE_JUPYTER_KERNELS
+	}
+}
+
+// GetActivityProbe returns the probe that the Notebook selected with the
+// ACTIVITY_PROBE_ANNOTATION, or the default probe for its image, reaching
+// the Notebook server through the endpoint. Unknown probe names fall back to
+// the default.
+func GetActivityProbe(meta metav1.ObjectMeta, podSpec corev1.PodSpec, endpoint ProbeEndpoint) ActivityProbe {
+	log := log.WithValues("notebook", getNamespacedNameFromMeta(meta))
+
+	name := defaultProbeName(podSpec)
+	if selected, ok := meta.GetAnnotations()[ACTIVITY_PROBE_ANNOTATION]; ok {
+		switch selected {
+		case PROBE_JUPYTER_KERNELS, PROBE_JUPYTER_SESSIONS, PROBE_HTTP, PROBE_NEVER_CULL:
+			name = selected
+		default:
+			log.Info(fmt.Sprintf("Unknown activity probe '%s'. Using '%s' instead.",
+				selected, name))
+		}
+	}
+
+	switch name {
+	case PROBE_JUPYTER_SESSIONS:
+		return &JupyterSessionsProbe{Rules: DefaultActivityRules(), Endpoint: endpoint}
+	case PROBE_HTTP:
+		probe := newHTTPProbe(meta, podSpec)
+		probe.Endpoint = endpoint
+		return probe
+	case PROBE_NEVER_CULL:
+		return &NeverCullProbe{}
+	default:
+		return &JupyterKernelsProbe{Rules: DefaultActivityRules(), Endpoint: endpoint}
+	}
+}
+
+// newHTTPProbe configures an HTTPProbe from the Notebook's annotations, with
+// defaults that depend on the image family.
+func newHTTPProbe(meta metav1.ObjectMeta, podSpec corev1.PodSpec) *HTTPProbe {
+	nm, ns := meta.GetName(), meta.GetNamespace()
+	probe := &HTTPProbe{
+		Path:  fmt.Sprintf("/notebook/%s/%s/api/status", ns, nm),
+		Field: DEFAULT_HTTP_PROBE_FIELD,
+	}
+	if defaultProbeName(podSpec) == PROBE_HTTP {
+		// code-server reports its last heartbeat in milliseconds
+		probe.Path = "/healthz"
+		probe.Field = "lastHeartbeat"
+	}
+
+	if path := meta.GetAnnotations()[ACTIVITY_PROBE_PATH_ANNOTATION]; path != "" && len(ValidateProbePath(path)) == 0 {
+		probe.Path = path
+	}
+	if field := meta.GetAnnotations()[ACTIVITY_PROBE_FIELD_ANNOTATION]; field != "" {
+		probe.Field = field
+	}
+	return probe
+}
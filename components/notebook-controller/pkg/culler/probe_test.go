@@ -0,0 +1,321 @@
+package culler
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func podSpecWithImage(image string) corev1.PodSpec {
+	return corev1.PodSpec{
+		Containers: []corev1.Container{{Name: "test", Image: image}},
+	}
+}
+
+func TestDefaultProbeName(t *testing.T) {
+	testCases := []struct {
+		testName string
+		podSpec  corev1.PodSpec
+		result   string
+	}{
+		{
+			testName: "No containers",
+			podSpec:  corev1.PodSpec{},
+			result:   PROBE_JUPYTER_KERNELS,
+		},
+		{
+			testName: "Jupyter image",
+			podSpec:  podSpecWithImage("kubeflownotebookswg/jupyter-scipy:v1.6.0"),
+			result:   PROBE_JUPYTER_KERNELS,
+		},
+		{
+			testName: "code-server image",
+			podSpec:  podSpecWithImage("kubeflownotebookswg/codeserver-python:v1.6.0"),
+			result:   PROBE_HTTP,
+		},
+		{
+			testName: "RStudio image",
+			podSpec:  podSpecWithImage("kubeflownotebookswg/rstudio-tidyverse:v1.6.0"),
+			result:   PROBE_NEVER_CULL,
+		},
+		{
+			testName: "Registry path is not matched",
+			podSpec:  podSpecWithImage("rstudio.example.com:5000/team/jupyter:latest"),
+			result:   PROBE_JUPYTER_KERNELS,
+		},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.testName, func(t *testing.T) {
+			if name := defaultProbeName(c.podSpec); name != c.result {
+				t.Errorf("Got %s, expected %s", name, c.result)
+			}
+		})
+	}
+}
+
+func TestGetActivityProbe(t *testing.T) {
+	testCases := []struct {
+		testName string
+		meta     metav1.ObjectMeta
+		podSpec  corev1.PodSpec
+		result   ActivityProbe
+	}{
+		{
+			testName: "Default probe",
+			meta:     metav1.ObjectMeta{Name: "nb", Namespace: "ns"},
+			podSpec:  podSpecWithImage("jupyter"),
+			result:   &JupyterKernelsProbe{Rules: DefaultActivityRules()},
+		},
+		{
+			testName: "Probe selected with the annotation",
+			meta: metav1.ObjectMeta{
+				Name:      "nb",
+				Namespace: "ns",
+				Annotations: map[string]string{
+					ACTIVITY_PROBE_ANNOTATION: PROBE_JUPYTER_SESSIONS,
+				},
+			},
+			podSpec: podSpecWithImage("jupyter"),
+			result:  &JupyterSessionsProbe{Rules: DefaultActivityRules()},
+		},
+		{
+			testName: "Unknown probe falls back to the default",
+			meta: metav1.ObjectMeta{
+				Name:      "nb",
+				Namespace: "ns",
+				Annotations: map[string]string{
+					ACTIVITY_PROBE_ANNOTATION: "unknown",
+				},
+			},
+			podSpec: podSpecWithImage("rstudio"),
+			result:  &NeverCullProbe{},
+		},
+		{
+			testName: "HTTP probe on a Jupyter image",
+			meta: metav1.ObjectMeta{
+				Name:      "nb",
+				Namespace: "ns",
+				Annotations: map[string]string{
+					ACTIVITY_PROBE_ANNOTATION: PROBE_HTTP,
+				},
+			},
+			podSpec: podSpecWithImage("jupyter"),
+			result:  &HTTPProbe{Path: "/notebook/ns/nb/api/status", Field: "last_activity"},
+		},
+		{
+			testName: "HTTP probe on a code-server image",
+			meta:     metav1.ObjectMeta{Name: "nb", Namespace: "ns"},
+			podSpec:  podSpecWithImage("codeserver"),
+			result:   &HTTPProbe{Path: "/healthz", Field: "lastHeartbeat"},
+		},
+		{
+			testName: "HTTP probe configured with annotations",
+			meta: metav1.ObjectMeta{
+				Name:      "nb",
+				Namespace: "ns",
+				Annotations: map[string]string{
+					ACTIVITY_PROBE_ANNOTATION:       PROBE_HTTP,
+					ACTIVITY_PROBE_PATH_ANNOTATION:  "/activity",
+					ACTIVITY_PROBE_FIELD_ANNOTATION: "last",
+				},
+			},
+			podSpec: podSpecWithImage("custom"),
+			result:  &HTTPProbe{Path: "/activity", Field: "last"},
+		},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.testName, func(t *testing.T) {
+			probe := GetActivityProbe(c.meta, c.podSpec, ProbeEndpoint{})
+			if fmt.Sprintf("%#v", probe) != fmt.Sprintf("%#v", c.result) {
+				t.Errorf("Got %#v, expected %#v", probe, c.result)
+			}
+		})
+	}
+}
+
+func TestParseActivityValue(t *testing.T) {
+	ref := time.Date(2022, 8, 30, 15, 37, 36, 0, time.UTC)
+
+	testCases := []struct {
+		testName string
+		value    interface{}
+		result   time.Time
+		err      bool
+	}{
+		{
+			testName: "RFC3339 string",
+			value:    "2022-08-30T15:37:36Z",
+			result:   ref,
+		},
+		{
+			testName: "Unix seconds",
+			value:    float64(ref.Unix()),
+			result:   ref,
+		},
+		{
+			testName: "Unix milliseconds",
+			value:    float64(ref.UnixMilli()),
+			result:   ref,
+		},
+		{
+			testName: "Unix seconds as string",
+			value:    fmt.Sprintf("%d", ref.Unix()),
+			result:   ref,
+		},
+		{
+			testName: "Invalid string",
+			value:    "yesterday",
+			err:      true,
+		},
+		{
+			testName: "Unsupported type",
+			value:    true,
+			err:      true,
+		},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.testName, func(t *testing.T) {
+			result, err := parseActivityValue(c.value)
+			if (err != nil) != c.err {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !result.Equal(c.result) {
+				t.Errorf("Got %v, expected %v", result, c.result)
+			}
+		})
+	}
+}
+
+// fakeNotebookServer serves fixed JSON responses, keyed by path.
+func fakeNotebookServer(t *testing.T, responses map[string]string) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := responses[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		// Like Jupyter, check the XSRF token of the POSTs without a token
+		if r.Method == http.MethodPost && r.Header.Get("Authorization") == "" {
+			cookie, err := r.Cookie(xsrfCookie)
+			if err != nil || cookie.Value == "" || cookie.Value != r.Header.Get("X-XSRFToken") {
+				http.Error(w, "'_xsrf' argument missing from POST", http.StatusForbidden)
+				return
+			}
+		}
+		fmt.Fprint(w, body)
+	}))
+	t.Cleanup(server.Close)
+
+	origURL := notebookServiceURL
+	notebookServiceURL = func(endpoint ProbeEndpoint, nm, ns, path string) string {
+		return server.URL + path
+	}
+	t.Cleanup(func() { notebookServiceURL = origURL })
+}
+
+func TestActivityProbes(t *testing.T) {
+	meta := metav1.ObjectMeta{Name: "nb", Namespace: "ns"}
+	old := "2022-08-30T15:37:36Z"
+	recent := "2022-08-30T16:37:36Z"
+	rules := ActivityRules{BusyKernels: true, KernelConnections: true, Terminals: true}
+
+	testCases := []struct {
+		testName  string
+		probe     ActivityProbe
+		responses map[string]string
+		result    string
+		err       bool
+	}{
+		{
+			testName: "Kernels probe with idle kernels",
+			probe:    &JupyterKernelsProbe{Rules: rules},
+			responses: map[string]string{
+				"/notebook/ns/nb/api/kernels": `[
+					{"id": "1", "execution_state": "idle", "last_activity": "` + old + `"},
+					{"id": "2", "execution_state": "idle", "last_activity": "` + recent + `"}]`,
+			},
+			result: recent,
+		},
+		{
+			testName: "Kernels probe with no kernels",
+			probe:    &JupyterKernelsProbe{Rules: rules},
+			responses: map[string]string{
+				"/notebook/ns/nb/api/kernels": `[]`,
+			},
+			result: "",
+		},
+		{
+			testName:  "Kernels probe with unreachable endpoint",
+			probe:     &JupyterKernelsProbe{Rules: rules},
+			responses: map[string]string{},
+			err:       true,
+		},
+		{
+			testName: "Sessions probe with a recent terminal",
+			probe:    &JupyterSessionsProbe{Rules: rules},
+			responses: map[string]string{
+				"/notebook/ns/nb/api/sessions": `[
+					{"id": "s", "kernel": {"id": "1", "execution_state": "idle", "last_activity": "` + old + `"}}]`,
+				"/notebook/ns/nb/api/terminals": `[{"name": "1", "last_activity": "` + recent + `"}]`,
+			},
+			result: recent,
+		},
+		{
+			testName: "Sessions probe without terminals",
+			probe:    &JupyterSessionsProbe{Rules: rules},
+			responses: map[string]string{
+				"/notebook/ns/nb/api/sessions": `[
+					{"id": "s", "kernel": {"id": "1", "execution_state": "idle", "last_activity": "` + old + `"}}]`,
+				"/notebook/ns/nb/api/terminals": `[]`,
+			},
+			result: old,
+		},
+		{
+			testName: "HTTP probe",
+			probe:    &HTTPProbe{Path: "/healthz", Field: "lastHeartbeat"},
+			responses: map[string]string{
+				"/healthz": `{"status": "alive", "lastHeartbeat": 1661877456000}`,
+			},
+			result: "2022-08-30T16:37:36Z",
+		},
+		{
+			testName: "HTTP probe with missing field",
+			probe:    &HTTPProbe{Path: "/healthz", Field: "lastHeartbeat"},
+			responses: map[string]string{
+				"/healthz": `{"status": "alive"}`,
+			},
+			err: true,
+		},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.testName, func(t *testing.T) {
+			fakeNotebookServer(t, c.responses)
+
+			result, err := c.probe.LastActivity(meta)
+			if (err != nil) != c.err {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if c.err {
+				return
+			}
+			if c.result == "" {
+				if !result.IsZero() {
+					t.Errorf("Got %v, expected no activity", result)
+				}
+				return
+			}
+			if result.UTC().Format(time.RFC3339) != c.result {
+				t.Errorf("Got %v, expected %s", result, c.result)
+			}
+		})
+	}
+}
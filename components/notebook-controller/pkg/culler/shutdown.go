@@ -0,0 +1,92 @@
+package culler
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// xsrfCookie is the cookie of the XSRF token of the Jupyter servers.
+const xsrfCookie = "_xsrf"
+
+// newXSRFToken returns a random XSRF token.
+func newXSRFToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("error generating an XSRF token: %v", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// postJupyter makes a POST request to a Jupyter API endpoint, which must
+// answer with a 2xx status. Jupyter checks the XSRF token of the requests
+// without a Jupyter token, e.g. to the servers started with an empty token
+// like the Kubeflow images: the xsrf token is sent both as the _xsrf cookie
+// and the X-XSRFToken header, which the server compares.
+func postJupyter(ctx context.Context, endpoint ProbeEndpoint, nm, ns, api, xsrf string) error {
+	url := jupyterURL(endpoint, nm, ns, api)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, nil)
+	if err != nil {
+		return fmt.Errorf("error creating request to %s: %v", url, err)
+	}
+	if authorization := endpoint.jupyterAuthorization(); authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	req.AddCookie(&http.Cookie{Name: xsrfCookie, Value: xsrf})
+	req.Header.Set("X-XSRFToken", xsrf)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error talking to %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("POST to %s: %d", url, resp.StatusCode)
+	}
+	return nil
+}
+
+// contentsPath escapes the path of a file for the `/api/contents` endpoint.
+func contentsPath(path string) string {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	for i := range segments {
+		segments[i] = url.PathEscape(segments[i])
+	}
+	return strings.Join(segments, "/")
+}
+
+// ShutdownJupyter gracefully shuts down a Jupyter server. It checkpoints the
+// files of the open sessions with the `/api/contents` endpoint, which copies
+// them as saved on disk, without their unsaved changes, and then stops the
+// kernels and the server with the `/api/shutdown` endpoint. It returns the
+// number of checkpointed files.
+func ShutdownJupyter(ctx context.Context, endpoint ProbeEndpoint, nm, ns string) (int, error) {
+	xsrf, err := newXSRFToken()
+	if err != nil {
+		return 0, err
+	}
+
+	var sessions []SessionStatus
+	url := jupyterURL(endpoint, nm, ns, "/api/sessions")
+	if err := getJSON(url, endpoint.jupyterAuthorization(), &sessions); err != nil {
+		return 0, err
+	}
+
+	checkpoints := 0
+	for _, session := range sessions {
+		if session.Path == "" {
+			continue
+		}
+		api := fmt.Sprintf("/api/contents/%s/checkpoints", contentsPath(session.Path))
+		if err := postJupyter(ctx, endpoint, nm, ns, api, xsrf); err != nil {
+			return checkpoints, err
+		}
+		checkpoints++
+	}
+
+	return checkpoints, postJupyter(ctx, endpoint, nm, ns, "/api/shutdown", xsrf)
+}
@@ -0,0 +1,56 @@
+package culler
+
+import (
+	"context"
+	"testing"
+)
+
+func TestShutdownJupyter(t *testing.T) {
+	prefix := "/notebook/ns/nb"
+	sessions := `[
+		{"id": "1", "path": "work/My Notebook.ipynb", "kernel": {"id": "k1"}},
+		{"id": "2", "path": "", "kernel": {"id": "k2"}}
+	]`
+
+	testCases := []struct {
+		testName    string
+		responses   map[string]string
+		checkpoints int
+		err         bool
+	}{
+		{
+			testName: "checkpoints and shutdown",
+			responses: map[string]string{
+				prefix + "/api/sessions":                                    sessions,
+				prefix + "/api/contents/work/My Notebook.ipynb/checkpoints": `{"id": "checkpoint"}`,
+				prefix + "/api/shutdown":                                    "",
+			},
+			checkpoints: 1,
+		},
+		{
+			testName: "checkpoint fails",
+			responses: map[string]string{
+				prefix + "/api/sessions": sessions,
+				prefix + "/api/shutdown": "",
+			},
+			err: true,
+		},
+		{
+			testName: "server unreachable",
+			err:      true,
+		},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.testName, func(t *testing.T) {
+			fakeNotebookServer(t, c.responses)
+			checkpoints, err := ShutdownJupyter(context.TODO(), ProbeEndpoint{}, "nb", "ns")
+			if (err != nil) != c.err {
+				t.Fatalf("Got error %v, expected an error: %t", err, c.err)
+			}
+			if checkpoints != c.checkpoints {
+				t.Errorf("Got %d checkpoints, expected %d", checkpoints, c.checkpoints)
+			}
+		})
+	}
+}
@@ -0,0 +1,262 @@
+package culler
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/kubeflow/kubeflow/components/notebook-controller/pkg/config"
+	"github.com/kubeflow/kubeflow/components/notebook-controller/pkg/metrics"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+	crclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Reasons of the failures of the probes, reported by the ProbeErrors metric.
+const (
+	probeErrorTimeout         = "timeout"
+	probeErrorConnection      = "connection"
+	probeErrorHTTPStatus      = "http_status"
+	probeErrorInvalidResponse = "invalid_response"
+)
+
+// probeTarget is a Notebook tracked by the Culler, along with the result of
+// its last probe.
+type probeTarget struct {
+	meta     metav1.ObjectMeta
+	probe    ActivityProbe
+	interval time.Duration
+
+	probed       bool
+	lastActivity time.Time
+	err          error
+	cpu          cpuHistory
+}
+
+// Culler probes the activity of the running Notebooks in the background, so
+// that the reconcile loop doesn't block on the Notebook servers. It runs as a
+// manager Runnable and caches the result of the last probe of each Notebook.
+//
+// The Culler implements ActivityProbe by serving these cached results.
+type Culler struct {
+	workers int
+	queue   workqueue.RateLimitingInterface
+	metrics *metrics.Metrics
+
+	// The CPU signal is read from the PodMetrics by the reader. Its
+	// decisions are recorded in the Notebooks' events by the recorder.
+	reader   crclient.Reader
+	recorder record.EventRecorder
+
+	mu      sync.Mutex
+	targets map[types.NamespacedName]*probeTarget
+}
+
+// NewCuller creates a Culler. The reader and the recorder are only used by the
+// CPU signal, and may be nil if it is disabled.
+func NewCuller(m *metrics.Metrics, reader crclient.Reader, recorder record.EventRecorder) *Culler {
+	// The probes run in a pool of workers. A Notebook whose probe fails is
+	// probed again with an exponential backoff, starting at the check period.
+	culling := config.Get().Culling
+	if culling.Activity.CPU.Enabled && reader == nil {
+		log.Info("No reader for the PodMetrics. Disabling the CPU signal.")
+	}
+
+	return &Culler{
+		workers: culling.ProbeWorkers,
+		queue: workqueue.NewNamedRateLimitingQueue(
+			workqueue.NewItemExponentialFailureRateLimiter(culling.CheckPeriod.Duration, culling.ProbeMaxBackoff.Duration),
+			"culler"),
+		metrics:  m,
+		reader:   reader,
+		recorder: recorder,
+		targets:  map[types.NamespacedName]*probeTarget{},
+	}
+}
+
+// cpuRules returns the rules of the CPU signal, which is disabled without a
+// reader for the PodMetrics.
+func (c *Culler) cpuRules() CPURules {
+	cpu := DefaultCPURules()
+	if c.reader == nil {
+		cpu.Enabled = false
+	}
+	return cpu
+}
+
+// Track starts probing the Notebook every interval with the given probe, or
+// updates them if the Notebook is already tracked. A Notebook seen for the
+// first time is probed right away.
+func (c *Culler) Track(meta metav1.ObjectMeta, probe ActivityProbe, interval time.Duration) {
+	key := getNamespacedNameFromMeta(meta)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	target, ok := c.targets[key]
+	if !ok {
+		target = &probeTarget{}
+		c.targets[key] = target
+		c.queue.Add(key)
+	}
+	target.meta = *meta.DeepCopy()
+	target.probe = probe
+	target.interval = interval
+}
+
+// Forget stops probing the Notebook and drops its cached result.
+func (c *Culler) Forget(key types.NamespacedName) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.targets, key)
+}
+
+// Name implements ActivityProbe.
+func (c *Culler) Name() string {
+	return "cache"
+}
+
+// LastActivity implements ActivityProbe. It returns the result of the last
+// probe of the Notebook, or a zero time if it hasn't been probed yet.
+func (c *Culler) LastActivity(meta metav1.ObjectMeta) (time.Time, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	target, ok := c.targets[getNamespacedNameFromMeta(meta)]
+	if !ok || !target.probed {
+		return time.Time{}, nil
+	}
+	return target.lastActivity, target.err
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable, since only the
+// leader reconciles the Notebooks.
+func (c *Culler) NeedLeaderElection() bool {
+	return true
+}
+
+// Start implements manager.Runnable. It runs the workers until the context
+// is done.
+func (c *Culler) Start(ctx context.Context) error {
+	log.Info("Starting the culler", "workers", c.workers)
+
+	var wg sync.WaitGroup
+	for i := 0; i < c.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for c.processNextItem() {
+			}
+		}()
+	}
+
+	<-ctx.Done()
+	log.Info("Stopping the culler")
+	c.queue.ShutDown()
+	wg.Wait()
+	return nil
+}
+
+func (c *Culler) processNextItem() bool {
+	item, shutdown := c.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer c.queue.Done(item)
+
+	key := item.(types.NamespacedName)
+	c.mu.Lock()
+	target, ok := c.targets[key]
+	if !ok {
+		// The Notebook was forgotten while waiting in the queue
+		c.mu.Unlock()
+		c.queue.Forget(item)
+		return true
+	}
+	meta, probe, interval := target.meta, target.probe, target.interval
+	c.mu.Unlock()
+
+	lastActivity, err := c.runProbe(meta, probe)
+
+	// The CPU rules are read on each probe, so that they follow the reloads
+	// of the configuration. The CPU usage is read even if the probe failed,
+	// as a server whose CPU is saturated may not answer it.
+	cpu := c.cpuRules()
+	var usage int64
+	var cpuErr error
+	if cpu.Enabled {
+		usage, cpuErr = c.podCPUUsage(meta)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if target, ok = c.targets[key]; !ok {
+		c.queue.Forget(item)
+		return true
+	}
+	target.probed = true
+
+	cpuActive := false
+	if cpu.Enabled {
+		if cpuErr != nil {
+			log.Error(cpuErr, "Could not get the CPU usage of the Notebook. Ignoring it",
+				"notebook", key)
+		} else {
+			lastActivity = c.applyCPU(target, cpu, usage, lastActivity, time.Now())
+			cpuActive = target.cpu.active
+		}
+	}
+
+	if err != nil && !cpuActive {
+		log.Error(err, "Could not probe the Notebook's activity. Backing off",
+			"notebook", key, "probe", probe.Name())
+		target.err = err
+		c.queue.AddRateLimited(item)
+		return true
+	} else if err != nil {
+		log.Info("Could not probe the Notebook's activity, but its CPU usage makes it active",
+			"notebook", key, "probe", probe.Name(), "error", err.Error())
+	}
+
+	target.lastActivity = lastActivity
+	target.err = nil
+	c.queue.Forget(item)
+	c.queue.AddAfter(item, interval)
+	return true
+}
+
+func (c *Culler) runProbe(meta metav1.ObjectMeta, probe ActivityProbe) (time.Time, error) {
+	start := time.Now()
+	lastActivity, err := probe.LastActivity(meta)
+	if c.metrics != nil {
+		c.metrics.ProbeDuration.WithLabelValues(probe.Name()).Observe(time.Since(start).Seconds())
+		if err != nil {
+			c.metrics.ProbeErrors.WithLabelValues(probe.Name(), probeErrorReason(err)).Inc()
+		}
+	}
+	return lastActivity, err
+}
+
+// probeErrorReason classifies the error of a probe in one of a few reasons,
+// to keep the cardinality of the ProbeErrors metric bounded.
+func probeErrorReason(err error) string {
+	var statusErr *statusError
+	var netErr net.Error
+	var urlErr *url.Error
+	switch {
+	case errors.As(err, &statusErr):
+		return probeErrorHTTPStatus
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return probeErrorTimeout
+	case errors.As(err, &urlErr):
+		return probeErrorConnection
+	default:
+		return probeErrorInvalidResponse
+	}
+}
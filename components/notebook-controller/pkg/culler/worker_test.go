@@ -0,0 +1,195 @@
+package culler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// fakeProbe reports a fixed last activity, or an error.
+type fakeProbe struct {
+	lastActivity time.Time
+	err          error
+}
+
+func (p *fakeProbe) Name() string {
+	return "fake"
+}
+
+func (p *fakeProbe) LastActivity(meta metav1.ObjectMeta) (time.Time, error) {
+	return p.lastActivity, p.err
+}
+
+// waitForProbe waits until the culler has a result for the Notebook.
+func waitForProbe(t *testing.T, c *Culler, meta metav1.ObjectMeta) {
+	key := getNamespacedNameFromMeta(meta)
+	for i := 0; i < 100; i++ {
+		c.mu.Lock()
+		target, ok := c.targets[key]
+		probed := ok && target.probed
+		c.mu.Unlock()
+		if probed {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Notebook %s was never probed", key)
+}
+
+func TestCuller(t *testing.T) {
+	ref := time.Date(2022, 8, 30, 15, 37, 36, 0, time.UTC)
+
+	testCases := []struct {
+		testName string
+		probe    ActivityProbe
+		result   time.Time
+		err      bool
+	}{
+		{
+			testName: "Successful probe",
+			probe:    &fakeProbe{lastActivity: ref},
+			result:   ref,
+		},
+		{
+			testName: "No activity",
+			probe:    &fakeProbe{},
+			result:   time.Time{},
+		},
+		{
+			testName: "Failed probe",
+			probe:    &fakeProbe{err: fmt.Errorf("connection refused")},
+			err:      true,
+		},
+	}
+
+	c := NewCuller(nil, nil, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- c.Start(ctx) }()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	for i, tc := range testCases {
+		t.Run(tc.testName, func(t *testing.T) {
+			meta := metav1.ObjectMeta{Name: fmt.Sprintf("nb-%d", i), Namespace: "ns"}
+
+			if result, err := c.LastActivity(meta); err != nil || !result.IsZero() {
+				t.Errorf("Expected no result before the Notebook is tracked")
+			}
+
+			c.Track(meta, tc.probe, time.Hour)
+			waitForProbe(t, c, meta)
+
+			result, err := c.LastActivity(meta)
+			if (err != nil) != tc.err {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !result.Equal(tc.result) {
+				t.Errorf("Got %v, expected %v", result, tc.result)
+			}
+
+			c.Forget(types.NamespacedName{Name: meta.Name, Namespace: meta.Namespace})
+			if result, err := c.LastActivity(meta); err != nil || !result.IsZero() {
+				t.Errorf("Expected no result after the Notebook is forgotten")
+			}
+		})
+	}
+}
+
+func TestUpdateTimestampFromActivity(t *testing.T) {
+	ref := time.Date(2022, 8, 30, 15, 37, 36, 0, time.UTC)
+	meta := &metav1.ObjectMeta{
+		Annotations: map[string]string{
+			LAST_ACTIVITY_ANNOTATION: ref.Add(-time.Hour).Format(time.RFC3339),
+		},
+	}
+
+	if !updateTimestampFromActivity(meta, ref) {
+		t.Errorf("Expected the last-activity to be updated")
+	}
+	if updateTimestampFromActivity(meta, ref) {
+		t.Errorf("Expected the last-activity to be up to date")
+	}
+	if updateTimestampFromActivity(meta, time.Time{}) {
+		t.Errorf("Expected no update without activity")
+	}
+}
+
+func TestProbeErrorReason(t *testing.T) {
+	testCases := []struct {
+		testName string
+		err      error
+		reason   string
+	}{
+		{
+			testName: "Timeout",
+			err:      fmt.Errorf("error talking to nb: %w", &url.Error{Op: "Get", URL: "nb", Err: context.DeadlineExceeded}),
+			reason:   probeErrorTimeout,
+		},
+		{
+			testName: "Connection refused",
+			err:      fmt.Errorf("error talking to nb: %w", &url.Error{Op: "Get", URL: "nb", Err: errors.New("connection refused")}),
+			reason:   probeErrorConnection,
+		},
+		{
+			testName: "Unexpected status",
+			err:      &statusError{url: "nb", code: 503},
+			reason:   probeErrorHTTPStatus,
+		},
+		{
+			testName: "Invalid response",
+			err:      fmt.Errorf("field %q not found in the response of nb", "lastActivity"),
+			reason:   probeErrorInvalidResponse,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.testName, func(t *testing.T) {
+			if reason := probeErrorReason(tc.err); reason != tc.reason {
+				t.Errorf("Expected reason %s, got %s", tc.reason, reason)
+			}
+		})
+	}
+}
+
+func TestCullerCPUWithFailedProbe(t *testing.T) {
+	t.Setenv("ACTIVITY_CPU", "true")
+	busy := metav1.ObjectMeta{Name: "busy", Namespace: "ns"}
+	idle := metav1.ObjectMeta{Name: "idle", Namespace: "ns"}
+	reader := fake.NewClientBuilder().WithScheme(runtime.NewScheme()).
+		WithObjects(newPodMetrics("busy-0", "ns", "2"), newPodMetrics("idle-0", "ns", "10m")).Build()
+
+	c := NewCuller(nil, reader, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- c.Start(ctx) }()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	// The server of the busy Notebook is too busy to answer the probe
+	probe := &fakeProbe{err: fmt.Errorf("context deadline exceeded")}
+	start := time.Now()
+	c.Track(busy, probe, time.Hour)
+	c.Track(idle, probe, time.Hour)
+	waitForProbe(t, c, busy)
+	waitForProbe(t, c, idle)
+
+	if result, err := c.LastActivity(busy); err != nil || result.Before(start) {
+		t.Errorf("Expected the CPU usage to make the Notebook active, got %v, error %v", result, err)
+	}
+	if _, err := c.LastActivity(idle); err == nil {
+		t.Errorf("Expected the failure of the probe of the idle Notebook")
+	}
+}
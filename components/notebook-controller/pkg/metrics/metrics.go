@@ -2,25 +2,71 @@ package metrics
 
 import (
 	"context"
+	"time"
 
+	"github.com/kubeflow/kubeflow/components/notebook-controller/api/v1beta1"
+	"github.com/kubeflow/kubeflow/components/notebook-controller/pkg/config"
 	"github.com/prometheus/client_golang/prometheus"
 	appsv1 "k8s.io/api/apps/v1"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/metrics"
 )
 
+// cullScheduledAnnotation is culler.CULL_SCHEDULED_ANNOTATION, which can't be
+// imported since the culler depends on this package.
+const cullScheduledAnnotation = "notebooks.kubeflow.org/cull-scheduled-at"
+
+// stopAnnotation is culler.STOP_ANNOTATION.
+const stopAnnotation = "kubeflow-resource-stopped"
+
+// lastActivityAnnotation is culler.LAST_ACTIVITY_ANNOTATION.
+const lastActivityAnnotation = "notebooks.kubeflow.org/last-activity"
+
+// Values of the start label of NotebookStartDuration.
+const (
+	// StartCreate is the start of a new Notebook, measured from its
+	// creation.
+	StartCreate = "create"
+	// StartUnstop is the start of a stopped Notebook, measured from the
+	// removal of its stop annotation.
+	StartUnstop = "unstop"
+)
+
+// RunningNotebooksFunc returns the Notebooks of a namespace that count
+// towards its limits of running Notebooks.
+type RunningNotebooksFunc func(ctx context.Context, namespace string) ([]v1beta1.Notebook, error)
+
 // Metrics includes metrics used in notebook controller
 type Metrics struct {
 	cli                      client.Client
 	runningNotebooks         *prometheus.GaugeVec
+	pendingCullingNotebooks  *prometheus.GaugeVec
+	notebookLimits           *prometheus.GaugeVec
+	notebookLimitsUsage      *prometheus.GaugeVec
+	notebookPhases           *prometheus.GaugeVec
+	lastActivityAge          *prometheus.GaugeVec
 	NotebookCreation         *prometheus.CounterVec
 	NotebookFailCreation     *prometheus.CounterVec
 	NotebookCullingCount     *prometheus.CounterVec
 	NotebookCullingTimestamp *prometheus.GaugeVec
+	ProbeDuration            *prometheus.HistogramVec
+	ProbeErrors              *prometheus.CounterVec
+	NotebookStartDuration    *prometheus.HistogramVec
+
+	// RunningNotebooks is set by the reconciler that enforces the limits of
+	// running Notebooks, so that their reported usage is the enforced one.
+	// The usage isn't reported without it.
+	RunningNotebooks RunningNotebooksFunc
 }
 
 func NewMetrics(cli client.Client) *Metrics {
-	m := &Metrics{
+	m := newMetrics(cli)
+	metrics.Registry.MustRegister(m)
+	return m
+}
+
+func newMetrics(cli client.Client) *Metrics {
+	return &Metrics{
 		cli: cli,
 		runningNotebooks: prometheus.NewGaugeVec(
 			prometheus.GaugeOpts{
@@ -29,6 +75,41 @@ func NewMetrics(cli client.Client) *Metrics {
 			},
 			[]string{"namespace"},
 		),
+		pendingCullingNotebooks: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "notebook_culling_pending",
+				Help: "Current idle notebooks in the warning period before their culling",
+			},
+			[]string{"namespace"},
+		),
+		notebookLimits: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "notebook_limit",
+				Help: "Limits of notebooks in the namespaces with notebooks",
+			},
+			[]string{"namespace", "limit"},
+		),
+		notebookLimitsUsage: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "notebook_limit_usage",
+				Help: "Notebooks counted towards the limits of notebooks of the namespaces",
+			},
+			[]string{"namespace", "limit"},
+		),
+		notebookPhases: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "notebook_phase",
+				Help: "Current notebooks in each phase",
+			},
+			[]string{"namespace", "phase"},
+		),
+		lastActivityAge: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "notebook_last_activity_age_seconds",
+				Help: "Time since the last activity of the running notebooks in seconds",
+			},
+			[]string{"namespace", "name"},
+		),
 		NotebookCreation: prometheus.NewCounterVec(
 			prometheus.CounterOpts{
 				Name: "notebook_create_total",
@@ -57,25 +138,79 @@ func NewMetrics(cli client.Client) *Metrics {
 			},
 			[]string{"namespace", "name"},
 		),
+		ProbeDuration: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "notebook_activity_probe_duration_seconds",
+				Help:    "Duration of the notebook activity probes in seconds",
+				Buckets: []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10},
+			},
+			[]string{"probe"},
+		),
+		ProbeErrors: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "notebook_activity_probe_errors_total",
+				Help: "Total failures of the notebook activity probes",
+			},
+			[]string{"probe", "reason"},
+		),
+		NotebookStartDuration: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "notebook_start_duration_seconds",
+				Help:    "Duration from the creation or the unstop of notebooks to their first ready pod in seconds",
+				Buckets: []float64{5, 10, 30, 60, 120, 300, 600, 1200, 1800, 3600},
+			},
+			[]string{"start"},
+		),
 	}
-
-	metrics.Registry.MustRegister(m)
-	return m
 }
 
 // Describe implements the prometheus.Collector interface.
 func (m *Metrics) Describe(ch chan<- *prometheus.Desc) {
 	m.runningNotebooks.Describe(ch)
+	m.pendingCullingNotebooks.Describe(ch)
+	m.notebookLimits.Describe(ch)
+	m.notebookLimitsUsage.Describe(ch)
+	m.notebookPhases.Describe(ch)
+	m.lastActivityAge.Describe(ch)
 	m.NotebookCreation.Describe(ch)
 	m.NotebookFailCreation.Describe(ch)
+	m.NotebookCullingCount.Describe(ch)
+	m.NotebookCullingTimestamp.Describe(ch)
+	m.ProbeDuration.Describe(ch)
+	m.ProbeErrors.Describe(ch)
+	m.NotebookStartDuration.Describe(ch)
 }
 
 // Collect implements the prometheus.Collector interface.
 func (m *Metrics) Collect(ch chan<- prometheus.Metric) {
 	m.scrape()
+	nbList := &v1beta1.NotebookList{}
+	if err := m.cli.List(context.TODO(), nbList); err == nil {
+		m.scrapePendingCulling(nbList.Items)
+		m.scrapeLimits(nbList.Items)
+		m.scrapePhases(nbList.Items)
+		m.scrapeLastActivity(nbList.Items, time.Now())
+	}
 	m.runningNotebooks.Collect(ch)
+	m.pendingCullingNotebooks.Collect(ch)
+	m.notebookLimits.Collect(ch)
+	m.notebookLimitsUsage.Collect(ch)
+	m.notebookPhases.Collect(ch)
+	m.lastActivityAge.Collect(ch)
 	m.NotebookCreation.Collect(ch)
 	m.NotebookFailCreation.Collect(ch)
+	m.NotebookCullingCount.Collect(ch)
+	m.NotebookCullingTimestamp.Collect(ch)
+	m.ProbeDuration.Collect(ch)
+	m.ProbeErrors.Collect(ch)
+	m.NotebookStartDuration.Collect(ch)
+}
+
+// Forget drops the series of a deleted Notebook, so that the number of series
+// of the per-Notebook metrics stays bounded by the number of Notebooks.
+func (m *Metrics) Forget(namespace, name string) {
+	m.NotebookCullingCount.DeleteLabelValues(namespace, name)
+	m.NotebookCullingTimestamp.DeleteLabelValues(namespace, name)
 }
 
 // scrape gets current running notebook statefulsets.
@@ -97,3 +232,73 @@ func (m *Metrics) scrape() {
 		m.runningNotebooks.WithLabelValues(ns).Set(v)
 	}
 }
+
+// scrapePendingCulling counts the notebooks that are scheduled for culling.
+func (m *Metrics) scrapePendingCulling(notebooks []v1beta1.Notebook) {
+	// Reset, so that namespaces without pending notebooks report nothing
+	m.pendingCullingNotebooks.Reset()
+	for _, nb := range notebooks {
+		if _, ok := nb.GetAnnotations()[cullScheduledAnnotation]; ok {
+			m.pendingCullingNotebooks.WithLabelValues(nb.Namespace).Inc()
+		}
+	}
+}
+
+// scrapeLimits reports the namespace-wide limits of notebooks and their usage.
+// The limits per creator aren't reported, to keep the number of series
+// bounded by the number of namespaces.
+func (m *Metrics) scrapeLimits(notebooks []v1beta1.Notebook) {
+	counts := map[string]int{}
+	for _, nb := range notebooks {
+		counts[nb.Namespace]++
+	}
+
+	// Reset, so that the limits that were removed report nothing
+	m.notebookLimits.Reset()
+	m.notebookLimitsUsage.Reset()
+	c := config.Get()
+	for ns, count := range counts {
+		limits := c.Limits.ForNamespace(ns)
+		if limits.Notebooks > 0 {
+			m.notebookLimits.WithLabelValues(ns, "notebooks").Set(float64(limits.Notebooks))
+			m.notebookLimitsUsage.WithLabelValues(ns, "notebooks").Set(float64(count))
+		}
+		if limits.RunningNotebooks > 0 {
+			m.notebookLimits.WithLabelValues(ns, "runningNotebooks").Set(float64(limits.RunningNotebooks))
+			if m.RunningNotebooks == nil {
+				continue
+			}
+			if running, err := m.RunningNotebooks(context.TODO(), ns); err == nil {
+				m.notebookLimitsUsage.WithLabelValues(ns, "runningNotebooks").Set(float64(len(running)))
+			}
+		}
+	}
+}
+
+// scrapePhases counts the notebooks in each phase. The phases are set by the
+// controller, so the number of series is bounded by the number of namespaces.
+func (m *Metrics) scrapePhases(notebooks []v1beta1.Notebook) {
+	m.notebookPhases.Reset()
+	for _, nb := range notebooks {
+		if nb.Status.Phase != "" {
+			m.notebookPhases.WithLabelValues(nb.Namespace, nb.Status.Phase).Inc()
+		}
+	}
+}
+
+// scrapeLastActivity reports the time since the last activity of the
+// notebooks that the culler probes. The stopped notebooks, which have no
+// last activity, report nothing.
+func (m *Metrics) scrapeLastActivity(notebooks []v1beta1.Notebook, now time.Time) {
+	m.lastActivityAge.Reset()
+	for _, nb := range notebooks {
+		if _, ok := nb.GetAnnotations()[stopAnnotation]; ok {
+			continue
+		}
+		lastActivity, err := time.Parse(time.RFC3339, nb.GetAnnotations()[lastActivityAnnotation])
+		if err != nil {
+			continue
+		}
+		m.lastActivityAge.WithLabelValues(nb.Namespace, nb.Name).Set(now.Sub(lastActivity).Seconds())
+	}
+}
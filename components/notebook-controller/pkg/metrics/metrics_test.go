@@ -0,0 +1,163 @@
+package metrics
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	nbv1beta1 "github.com/kubeflow/kubeflow/components/notebook-controller/api/v1beta1"
+	"github.com/kubeflow/kubeflow/components/notebook-controller/pkg/config"
+)
+
+func testNotebook(namespace, name, phase string, annotations map[string]string) *nbv1beta1.Notebook {
+	return &nbv1beta1.Notebook{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Annotations: annotations},
+		Status:     nbv1beta1.NotebookStatus{Phase: phase},
+	}
+}
+
+func testMetrics(objects ...client.Object) *Metrics {
+	scheme := runtime.NewScheme()
+	_ = appsv1.AddToScheme(scheme)
+	_ = nbv1beta1.AddToScheme(scheme)
+	return newMetrics(fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).Build())
+}
+
+func TestCollect(t *testing.T) {
+	m := testMetrics()
+	// The pedantic registry checks that every collected metric is described
+	registry := prometheus.NewPedanticRegistry()
+	if err := registry.Register(m); err != nil {
+		t.Fatal(err)
+	}
+
+	m.NotebookCullingCount.WithLabelValues("default", "test").Inc()
+	m.NotebookCullingTimestamp.WithLabelValues("default", "test").Set(1661877456)
+	m.ProbeErrors.WithLabelValues("http", "timeout").Inc()
+	m.NotebookStartDuration.WithLabelValues(StartCreate).Observe(42)
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := map[string]bool{}
+	for _, family := range families {
+		names[family.GetName()] = true
+	}
+	for _, name := range []string{
+		"notebook_culling_total",
+		"last_notebook_culling_timestamp_seconds",
+		"notebook_activity_probe_errors_total",
+		"notebook_start_duration_seconds",
+	} {
+		if !names[name] {
+			t.Errorf("Expected %s to be collected, got %v", name, names)
+		}
+	}
+}
+
+func TestScrapePhases(t *testing.T) {
+	m := testMetrics(
+		testNotebook("a", "running-1", nbv1beta1.NotebookPhaseRunning, nil),
+		testNotebook("a", "running-2", nbv1beta1.NotebookPhaseRunning, nil),
+		testNotebook("a", "culled", nbv1beta1.NotebookPhaseCulled, nil),
+		testNotebook("b", "running", nbv1beta1.NotebookPhaseRunning, nil),
+		testNotebook("b", "new", "", nil),
+	)
+	testutil.CollectAndCount(m)
+
+	expected := map[[2]string]float64{
+		{"a", nbv1beta1.NotebookPhaseRunning}: 2,
+		{"a", nbv1beta1.NotebookPhaseCulled}:  1,
+		{"b", nbv1beta1.NotebookPhaseRunning}: 1,
+	}
+	for labels, count := range expected {
+		if value := testutil.ToFloat64(m.notebookPhases.WithLabelValues(labels[0], labels[1])); value != count {
+			t.Errorf("Expected %v notebooks in %v, got %v", count, labels, value)
+		}
+	}
+	// The Notebooks without a phase yet are not reported
+	if series := testutil.CollectAndCount(m.notebookPhases); series != len(expected) {
+		t.Errorf("Expected %d series, got %d", len(expected), series)
+	}
+}
+
+func TestScrapeLastActivity(t *testing.T) {
+	now := time.Date(2022, 8, 30, 16, 37, 36, 0, time.UTC)
+	m := testMetrics()
+	m.scrapeLastActivity([]nbv1beta1.Notebook{
+		*testNotebook("a", "active", "", map[string]string{
+			lastActivityAnnotation: "2022-08-30T16:27:36Z",
+		}),
+		*testNotebook("a", "stopped", "", map[string]string{
+			lastActivityAnnotation: "2022-08-30T15:37:36Z",
+			stopAnnotation:         "2022-08-30T15:47:36Z",
+		}),
+		*testNotebook("a", "never-probed", "", nil),
+	}, now)
+
+	if value := testutil.ToFloat64(m.lastActivityAge.WithLabelValues("a", "active")); value != 600 {
+		t.Errorf("Expected a last activity 600s ago, got %v", value)
+	}
+	if series := testutil.CollectAndCount(m.lastActivityAge); series != 1 {
+		t.Errorf("Expected only the running Notebook with an activity to be reported, got %d series", series)
+	}
+}
+
+func TestScrapeLimits(t *testing.T) {
+	c := config.FromEnv()
+	c.Limits = config.LimitsConfig{
+		Namespaces: map[string]config.NotebookLimits{"a": {Notebooks: 5, RunningNotebooks: 2}},
+	}
+	config.Set(c)
+	defer config.Set(nil)
+
+	m := testMetrics()
+	// The reconciler doesn't count the Notebook that isn't scaled up yet
+	m.RunningNotebooks = func(_ context.Context, namespace string) ([]nbv1beta1.Notebook, error) {
+		if namespace != "a" {
+			t.Errorf("Expected only the running Notebooks of a to be listed, got %s", namespace)
+		}
+		return []nbv1beta1.Notebook{*testNotebook("a", "running", "", nil)}, nil
+	}
+	m.scrapeLimits([]nbv1beta1.Notebook{
+		*testNotebook("a", "running", "", nil),
+		*testNotebook("a", "starting", "", nil),
+		*testNotebook("a", "stopped", "", map[string]string{stopAnnotation: "2022-08-30T15:47:36Z"}),
+		*testNotebook("b", "unlimited", "", nil),
+	})
+
+	if value := testutil.ToFloat64(m.notebookLimits.WithLabelValues("a", "notebooks")); value != 5 {
+		t.Errorf("Expected the limit of notebooks, got %v", value)
+	}
+	if value := testutil.ToFloat64(m.notebookLimitsUsage.WithLabelValues("a", "notebooks")); value != 3 {
+		t.Errorf("Expected 3 notebooks, got %v", value)
+	}
+	if value := testutil.ToFloat64(m.notebookLimitsUsage.WithLabelValues("a", "runningNotebooks")); value != 1 {
+		t.Errorf("Expected 1 running notebook, got %v", value)
+	}
+	// 2 limits and their usage in a, nothing for b
+	if series := testutil.CollectAndCount(m.notebookLimits) + testutil.CollectAndCount(m.notebookLimitsUsage); series != 4 {
+		t.Errorf("Expected 4 series, got %d", series)
+	}
+}
+
+func TestForget(t *testing.T) {
+	m := testMetrics()
+	m.NotebookCullingCount.WithLabelValues("default", "deleted").Inc()
+	m.NotebookCullingTimestamp.WithLabelValues("default", "deleted").Set(1661877456)
+	m.NotebookCullingCount.WithLabelValues("default", "test").Inc()
+
+	m.Forget("default", "deleted")
+	if series := testutil.CollectAndCount(m.NotebookCullingCount) + testutil.CollectAndCount(m.NotebookCullingTimestamp); series != 1 {
+		t.Errorf("Expected only the series of the remaining Notebook, got %d series", series)
+	}
+}
@@ -0,0 +1,404 @@
+package registry
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// DockerHub is the registry of the images without a registry host.
+	DockerHub = "docker.io"
+	// dockerHubAPI is the host serving the registry API of DockerHub.
+	dockerHubAPI = "registry-1.docker.io"
+	// dockerHubAuth is the token server of DockerHub.
+	dockerHubAuth = "auth.docker.io"
+	defaultTag    = "latest"
+)
+
+// manifestTypes are the manifests the registries are asked for. The manifest
+// lists and indexes come first, so that the digest of a multi-arch image
+// doesn't depend on the platform of the controller.
+var manifestTypes = []string{
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+}
+
+var client = &http.Client{Timeout: 10 * time.Second}
+
+// Reference is a parsed image reference.
+type Reference struct {
+	// Name is the image without its tag or digest, as written in the
+	// Notebook.
+	Name string
+	// Registry is the host[:port] of the registry.
+	Registry string
+	// Repository is the path of the image in the registry.
+	Repository string
+	Tag        string
+	Digest     string
+}
+
+// ParseReference parses an image reference of the form
+// [registry/]repository[:tag][@digest].
+func ParseReference(image string) (Reference, error) {
+	ref := Reference{}
+	rest := image
+	if i := strings.Index(rest, "@"); i >= 0 {
+		rest, ref.Digest = rest[:i], rest[i+1:]
+		if !strings.Contains(ref.Digest, ":") {
+			return ref, fmt.Errorf("invalid digest in image %q", image)
+		}
+	}
+	// A colon after the last slash separates the tag, otherwise it is the
+	// port of the registry
+	if i := strings.LastIndex(rest, ":"); i > strings.LastIndex(rest, "/") {
+		rest, ref.Tag = rest[:i], rest[i+1:]
+	}
+	if rest == "" || ref.Tag == "" && strings.HasSuffix(image, ":") {
+		return ref, fmt.Errorf("invalid image %q", image)
+	}
+	ref.Name = rest
+
+	ref.Registry, ref.Repository = DockerHub, rest
+	if i := strings.Index(rest, "/"); i >= 0 {
+		// The first component is a registry if it looks like a host
+		if host := rest[:i]; strings.ContainsAny(host, ".:") || host == "localhost" {
+			ref.Registry, ref.Repository = host, rest[i+1:]
+		}
+	}
+	if ref.Registry == "index.docker.io" {
+		ref.Registry = DockerHub
+	}
+	if ref.Registry == DockerHub && !strings.Contains(ref.Repository, "/") {
+		ref.Repository = "library/" + ref.Repository
+	}
+	if ref.Tag == "" && ref.Digest == "" {
+		ref.Tag = defaultTag
+	}
+	return ref, nil
+}
+
+// apiHost returns the host serving the registry API.
+func (ref Reference) apiHost() string {
+	if ref.Registry == DockerHub {
+		return dockerHubAPI
+	}
+	return ref.Registry
+}
+
+// Credential is a username and password to pull from a registry.
+type Credential struct {
+	Username string
+	Password string
+}
+
+// Credentials are the credentials of each registry host.
+type Credentials map[string]Credential
+
+// dockerConfig is the content of the .dockerconfigjson Secrets, and its
+// "auths" the content of the older .dockercfg ones.
+type dockerConfig struct {
+	Auths map[string]dockerAuth `json:"auths"`
+}
+
+type dockerAuth struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Auth     string `json:"auth"`
+}
+
+// CredentialsFromDockerConfig reads the credentials of a Secret of type
+// kubernetes.io/dockerconfigjson or kubernetes.io/dockercfg.
+func CredentialsFromDockerConfig(data []byte) (Credentials, error) {
+	config := dockerConfig{}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, err
+	}
+	auths := config.Auths
+	if auths == nil {
+		if err := json.Unmarshal(data, &auths); err != nil {
+			return nil, err
+		}
+	}
+
+	creds := Credentials{}
+	for server, auth := range auths {
+		if auth.Auth != "" {
+			decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+			if err != nil {
+				return nil, fmt.Errorf("invalid auth of %s: %w", server, err)
+			}
+			if i := strings.Index(string(decoded), ":"); i >= 0 {
+				auth.Username, auth.Password = string(decoded[:i]), string(decoded[i+1:])
+			}
+		}
+		creds[registryHost(server)] = Credential{Username: auth.Username, Password: auth.Password}
+	}
+	return creds, nil
+}
+
+// registryHost returns the registry of a server of a docker config, which
+// may be a URL.
+func registryHost(server string) string {
+	host := server
+	if u, err := url.Parse(server); err == nil && u.Host != "" {
+		host = u.Host
+	}
+	if i := strings.Index(host, "/"); i >= 0 {
+		host = host[:i]
+	}
+	switch host {
+	case "index.docker.io", dockerHubAPI:
+		return DockerHub
+	}
+	return host
+}
+
+// Resolver resolves the tags of the images to the digests of their
+// manifests, using the registry HTTP API.
+type Resolver struct {
+	// Client defaults to a client with a 10s timeout.
+	Client *http.Client
+	// Registries are the registries whose images may be resolved, on top of
+	// the Insecure ones. The images of other registries are refused, so that
+	// the images can't make the resolver call arbitrary hosts.
+	Registries []string
+	// Insecure are the registries reached over plain HTTP.
+	Insecure []string
+	// TokenRealms are the hosts of the token servers of the registries that
+	// don't serve their tokens themselves. DockerHub's is always allowed.
+	TokenRealms []string
+}
+
+// contains returns true if the hosts contain the host.
+func contains(hosts []string, host string) bool {
+	for _, h := range hosts {
+		if h == host {
+			return true
+		}
+	}
+	return false
+}
+
+// Resolve returns the digest the tag of the image points to. The digest of
+// an image that is already pinned is returned as is.
+func (r *Resolver) Resolve(ctx context.Context, image string, creds Credentials) (string, error) {
+	ref, err := ParseReference(image)
+	if err != nil {
+		return "", err
+	}
+	if ref.Digest != "" {
+		return ref.Digest, nil
+	}
+
+	insecure := contains(r.Insecure, ref.Registry)
+	if !insecure && !contains(r.Registries, ref.Registry) {
+		return "", fmt.Errorf("registry %s is not allowed", ref.Registry)
+	}
+	scheme := "https"
+	if insecure {
+		scheme = "http"
+	}
+	manifest := fmt.Sprintf("%s://%s/v2/%s/manifests/%s", scheme, ref.apiHost(), ref.Repository, ref.Tag)
+	cred, hasCred := creds[ref.Registry]
+
+	authorization := ""
+	resp, err := r.do(ctx, http.MethodHead, manifest, authorization)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusUnauthorized {
+		authorization, err = r.authorize(ctx, resp.Header.Get("WWW-Authenticate"), ref, cred, hasCred)
+		if err != nil {
+			return "", err
+		}
+		if resp, err = r.do(ctx, http.MethodHead, manifest, authorization); err != nil {
+			return "", err
+		}
+		resp.Body.Close()
+	}
+	if digest := resp.Header.Get("Docker-Content-Digest"); resp.StatusCode == http.StatusOK && digest != "" {
+		return digest, nil
+	}
+	return r.digestOfBody(ctx, manifest, authorization)
+}
+
+// digestOfBody gets the manifest and computes its digest, for the registries
+// that don't return it in the Docker-Content-Digest header.
+func (r *Resolver) digestOfBody(ctx context.Context, manifest, authorization string) (string, error) {
+	resp, err := r.do(ctx, http.MethodGet, manifest, authorization)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("getting %s: %s", manifest, resp.Status)
+	}
+	if digest := resp.Header.Get("Docker-Content-Digest"); digest != "" {
+		return digest, nil
+	}
+	hash := sha256.New()
+	if _, err := io.Copy(hash, resp.Body); err != nil {
+		return "", fmt.Errorf("reading %s: %w", manifest, err)
+	}
+	return fmt.Sprintf("sha256:%x", hash.Sum(nil)), nil
+}
+
+func (r *Resolver) do(ctx context.Context, method, target, authorization string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", strings.Join(manifestTypes, ", "))
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	return r.client().Do(req)
+}
+
+func (r *Resolver) client() *http.Client {
+	if r.Client != nil {
+		return r.Client
+	}
+	return client
+}
+
+// authorize returns the Authorization header answering the challenge of a
+// registry.
+func (r *Resolver) authorize(ctx context.Context, challenge string, ref Reference, cred Credential, hasCred bool) (string, error) {
+	scheme, params := parseChallenge(challenge)
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if !hasCred {
+			return "", fmt.Errorf("%s requires credentials", ref.Registry)
+		}
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(cred.Username+":"+cred.Password)), nil
+	case "bearer":
+		token, err := r.token(ctx, params, ref, cred, hasCred)
+		if err != nil {
+			return "", err
+		}
+		return "Bearer " + token, nil
+	}
+	return "", fmt.Errorf("unsupported authentication challenge %q from %s", challenge, ref.Registry)
+}
+
+// token gets a pull token of the repository from the token server of the
+// registry. The token server must be served by the registry, or be one of
+// the TokenRealms, so that the credentials aren't sent to another host.
+func (r *Resolver) token(ctx context.Context, params map[string]string, ref Reference, cred Credential, hasCred bool) (string, error) {
+	realm, err := url.Parse(params["realm"])
+	if err != nil || realm.Host == "" {
+		return "", fmt.Errorf("invalid token realm %q from %s", params["realm"], ref.Registry)
+	}
+	if !r.allowedRealm(realm, ref) {
+		return "", fmt.Errorf("token realm %q of %s is not served by the registry", params["realm"], ref.Registry)
+	}
+	query := realm.Query()
+	if service := params["service"]; service != "" {
+		query.Set("service", service)
+	}
+	query.Set("scope", fmt.Sprintf("repository:%s:pull", ref.Repository))
+	realm.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	if hasCred {
+		req.SetBasicAuth(cred.Username, cred.Password)
+	}
+	resp, err := r.client().Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("getting a token from %s: %s", realm.Host, resp.Status)
+	}
+
+	body := struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("invalid token from %s: %w", realm.Host, err)
+	}
+	if body.Token != "" {
+		return body.Token, nil
+	}
+	if body.AccessToken != "" {
+		return body.AccessToken, nil
+	}
+	return "", fmt.Errorf("no token from %s", realm.Host)
+}
+
+// allowedRealm returns true if the token realm is served over HTTPS by the
+// registry, by DockerHub's token server for DockerHub, or by one of the
+// TokenRealms. The insecure registries may serve it over plain HTTP.
+func (r *Resolver) allowedRealm(realm *url.URL, ref Reference) bool {
+	switch realm.Scheme {
+	case "https":
+	case "http":
+		if !contains(r.Insecure, ref.Registry) {
+			return false
+		}
+	default:
+		return false
+	}
+	switch host := realm.Host; {
+	case host == ref.Registry, host == ref.apiHost():
+		return true
+	case ref.Registry == DockerHub && host == dockerHubAuth:
+		return true
+	default:
+		return contains(r.TokenRealms, host)
+	}
+}
+
+// parseChallenge parses a WWW-Authenticate header such as
+// `Bearer realm="https://auth.docker.io/token",service="registry.docker.io"`.
+func parseChallenge(challenge string) (string, map[string]string) {
+	params := map[string]string{}
+	challenge = strings.TrimSpace(challenge)
+	i := strings.Index(challenge, " ")
+	if i < 0 {
+		return challenge, params
+	}
+	scheme, rest := challenge[:i], challenge[i+1:]
+	for rest != "" {
+		rest = strings.TrimLeft(rest, " ,")
+		eq := strings.Index(rest, "=")
+		if eq < 0 {
+			break
+		}
+		key := strings.ToLower(strings.TrimSpace(rest[:eq]))
+		rest = rest[eq+1:]
+		value := ""
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.Index(rest[1:], `"`)
+			if end < 0 {
+				value, rest = rest[1:], ""
+			} else {
+				value, rest = rest[1:end+1], rest[end+2:]
+			}
+		} else if end := strings.Index(rest, ","); end >= 0 {
+			value, rest = rest[:end], rest[end:]
+		} else {
+			value, rest = rest, ""
+		}
+		params[key] = value
+	}
+	return scheme, params
+}
@@ -0,0 +1,203 @@
+package registry
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestParseReference(t *testing.T) {
+	tests := []struct {
+		image    string
+		expected Reference
+	}{
+		{
+			image: "jupyter",
+			expected: Reference{Name: "jupyter", Registry: "docker.io", Repository: "library/jupyter",
+				Tag: "latest"},
+		},
+		{
+			image: "kubeflownotebookswg/jupyter-scipy:v1.6.1",
+			expected: Reference{Name: "kubeflownotebookswg/jupyter-scipy", Registry: "docker.io",
+				Repository: "kubeflownotebookswg/jupyter-scipy", Tag: "v1.6.1"},
+		},
+		{
+			image: "index.docker.io/jupyter:3",
+			expected: Reference{Name: "index.docker.io/jupyter", Registry: "docker.io",
+				Repository: "library/jupyter", Tag: "3"},
+		},
+		{
+			image: "localhost:5000/team/jupyter",
+			expected: Reference{Name: "localhost:5000/team/jupyter", Registry: "localhost:5000",
+				Repository: "team/jupyter", Tag: "latest"},
+		},
+		{
+			image: "quay.io/team/jupyter:v1@sha256:abc",
+			expected: Reference{Name: "quay.io/team/jupyter", Registry: "quay.io",
+				Repository: "team/jupyter", Tag: "v1", Digest: "sha256:abc"},
+		},
+	}
+
+	for _, test := range tests {
+		ref, err := ParseReference(test.image)
+		if err != nil {
+			t.Errorf("Unexpected error parsing %q: %v", test.image, err)
+		} else if ref != test.expected {
+			t.Errorf("Expected %q to be %+v, got %+v", test.image, test.expected, ref)
+		}
+	}
+
+	for _, image := range []string{"", "jupyter:", "jupyter@abc"} {
+		if _, err := ParseReference(image); err == nil {
+			t.Errorf("Expected %q to be invalid", image)
+		}
+	}
+}
+
+func TestCredentialsFromDockerConfig(t *testing.T) {
+	// alice:secret
+	dockerConfigJSON := `{"auths": {
+		"https://index.docker.io/v1/": {"auth": "YWxpY2U6c2VjcmV0"},
+		"quay.io": {"username": "bob", "password": "hunter2"}
+	}}`
+	creds, err := CredentialsFromDockerConfig([]byte(dockerConfigJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Credentials{
+		"docker.io": {Username: "alice", Password: "secret"},
+		"quay.io":   {Username: "bob", Password: "hunter2"},
+	}
+	if fmt.Sprint(creds) != fmt.Sprint(expected) {
+		t.Errorf("Expected %v, got %v", expected, creds)
+	}
+
+	dockerCfg := `{"registry.example.com": {"auth": "YWxpY2U6c2VjcmV0"}}`
+	creds, err = CredentialsFromDockerConfig([]byte(dockerCfg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cred := creds["registry.example.com"]; cred.Username != "alice" {
+		t.Errorf("Expected the .dockercfg credentials, got %v", creds)
+	}
+}
+
+// testRegistry serves the manifests of "team/jupyter" behind a token server,
+// which only gives tokens to alice.
+func testRegistry(t *testing.T, digestHeader bool) (*httptest.Server, string) {
+	manifest := `{"schemaVersion": 2}`
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/token":
+			if user, password, _ := r.BasicAuth(); user != "alice" || password != "secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			if scope := r.URL.Query().Get("scope"); scope != "repository:team/jupyter:pull" {
+				t.Errorf("Unexpected scope %q", scope)
+			}
+			fmt.Fprint(w, `{"token": "pull-token"}`)
+		case "/v2/team/jupyter/manifests/v1":
+			if r.Header.Get("Authorization") != "Bearer pull-token" {
+				w.Header().Set("WWW-Authenticate",
+					fmt.Sprintf(`Bearer realm="%s/token",service="test"`, server.URL))
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			if digestHeader {
+				w.Header().Set("Docker-Content-Digest", "sha256:from-header")
+			}
+			if r.Method == http.MethodGet {
+				fmt.Fprint(w, manifest)
+			}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	return server, fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(manifest)))
+}
+
+func TestResolve(t *testing.T) {
+	server, bodyDigest := testRegistry(t, true)
+	defer server.Close()
+	host := server.Listener.Addr().String()
+	r := &Resolver{Insecure: []string{host}}
+	creds := Credentials{host: {Username: "alice", Password: "secret"}}
+
+	digest, err := r.Resolve(context.TODO(), host+"/team/jupyter:v1", creds)
+	if err != nil || digest != "sha256:from-header" {
+		t.Errorf("Expected the digest of the header, got %q, %v", digest, err)
+	}
+
+	if _, err := r.Resolve(context.TODO(), host+"/team/jupyter:v1", nil); err == nil {
+		t.Errorf("Expected the anonymous pull to be denied")
+	}
+	if _, err := r.Resolve(context.TODO(), host+"/team/jupyter:v2", creds); err == nil {
+		t.Errorf("Expected an unknown tag to fail")
+	}
+	if digest, _ := r.Resolve(context.TODO(), host+"/team/jupyter@sha256:pinned", nil); digest != "sha256:pinned" {
+		t.Errorf("Expected a pinned image to keep its digest, got %q", digest)
+	}
+
+	// Without the header, the digest is the one of the manifest
+	noHeader, _ := testRegistry(t, false)
+	defer noHeader.Close()
+	noHeaderHost := noHeader.Listener.Addr().String()
+	r.Insecure = []string{noHeaderHost}
+	digest, err = r.Resolve(context.TODO(), noHeaderHost+"/team/jupyter:v1",
+		Credentials{noHeaderHost: {Username: "alice", Password: "secret"}})
+	if err != nil || digest != bodyDigest {
+		t.Errorf("Expected the digest of the manifest %q, got %q, %v", bodyDigest, digest, err)
+	}
+
+	if _, err := r.Resolve(context.TODO(), "registry.internal:8080/team/jupyter:v1", nil); err == nil {
+		t.Errorf("Expected a registry that isn't allowed to be refused")
+	}
+}
+
+func TestAllowedRealm(t *testing.T) {
+	r := &Resolver{
+		Registries:  []string{DockerHub, "registry.example.com", "registry.gitlab.com"},
+		Insecure:    []string{"registry.local:5000"},
+		TokenRealms: []string{"gitlab.com"},
+	}
+	tests := []struct {
+		image   string
+		realm   string
+		allowed bool
+	}{
+		{image: "jupyter", realm: "https://auth.docker.io/token", allowed: true},
+		{image: "registry.example.com/jupyter", realm: "https://registry.example.com/token", allowed: true},
+		{image: "registry.example.com/jupyter", realm: "https://auth.docker.io/token", allowed: false},
+		{image: "registry.example.com/jupyter", realm: "https://169.254.169.254/token", allowed: false},
+		{image: "registry.example.com/jupyter", realm: "http://registry.example.com/token", allowed: false},
+		{image: "registry.gitlab.com/team/jupyter", realm: "https://gitlab.com/jwt/auth", allowed: true},
+		{image: "registry.local:5000/jupyter", realm: "http://registry.local:5000/token", allowed: true},
+	}
+	for _, test := range tests {
+		ref, err := ParseReference(test.image)
+		if err != nil {
+			t.Fatal(err)
+		}
+		realm, err := url.Parse(test.realm)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if allowed := r.allowedRealm(realm, ref); allowed != test.allowed {
+			t.Errorf("%s with realm %s: got allowed %t, expected %t", test.image, test.realm, allowed, test.allowed)
+		}
+	}
+}
+
+func TestParseChallenge(t *testing.T) {
+	scheme, params := parseChallenge(`Bearer realm="https://auth.docker.io/token",service="registry.docker.io",error=insufficient_scope`)
+	if scheme != "Bearer" || params["realm"] != "https://auth.docker.io/token" ||
+		params["service"] != "registry.docker.io" || params["error"] != "insufficient_scope" {
+		t.Errorf("Unexpected challenge %s %v", scheme, params)
+	}
+}
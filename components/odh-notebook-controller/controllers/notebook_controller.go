@@ -43,6 +43,10 @@ const (
 	AnnotationLogoutUrl               = "notebooks.opendatahub.io/oauth-logout-url"
 )
 
+// LifecycleActor is the actor of the Notebook lifecycle transitions recorded
+// by this controller.
+const LifecycleActor = "odh-notebook-controller"
+
 // OpenshiftNotebookReconciler holds the controller configuration.
 type OpenshiftNotebookReconciler struct {
 	client.Client
@@ -53,7 +57,7 @@ type OpenshiftNotebookReconciler struct {
 // ClusterRole permissions
 
 // +kubebuilder:rbac:groups=kubeflow.org,resources=notebooks,verbs=get;list;watch;patch
-// +kubebuilder:rbac:groups=kubeflow.org,resources=notebooks/status,verbs=get
+// +kubebuilder:rbac:groups=kubeflow.org,resources=notebooks/status,verbs=get;update
 // +kubebuilder:rbac:groups=kubeflow.org,resources=notebooks/finalizers,verbs=update
 // +kubebuilder:rbac:groups=route.openshift.io,resources=routes,verbs=get;list;watch;create;update;patch
 // +kubebuilder:rbac:groups="",resources=services;serviceaccounts;secrets,verbs=get;list;watch;create;update;patch
@@ -115,7 +119,21 @@ func (r *OpenshiftNotebookReconciler) RemoveReconciliationLock(notebook *nbv1.No
 	// Remove the reconciliation lock annotation
 	patch := client.RawPatch(types.MergePatchType,
 		[]byte(`{"metadata":{"annotations":{"`+culler.STOP_ANNOTATION+`":null}}}`))
-	return r.Patch(ctx, notebook, patch)
+	if err := r.Patch(ctx, notebook, patch); err != nil {
+		return err
+	}
+
+	// Record the start of the notebook in its lifecycle status
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		if err := r.Get(ctx, client.ObjectKeyFromObject(notebook), notebook); err != nil {
+			return err
+		}
+		if !notebook.Status.RecordLifecycleTransition(nbv1.LifecycleActionStart,
+			nbv1.LifecycleReasonReconciliationLockReleased, LifecycleActor, metav1.Now()) {
+			return nil
+		}
+		return r.Status().Update(ctx, notebook)
+	})
 }
 
 // Reconcile performs the reconciling of the Openshift objects for a Kubeflow
@@ -176,6 +194,12 @@ func (r *OpenshiftNotebookReconciler) Reconcile(ctx context.Context, req ctrl.Re
 		}
 	}
 
+	// Report the URLs of the route in the notebook status
+	err = r.ReconcileRoutingStatus(notebook, ctx)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
 	// Remove the reconciliation lock annotation
 	if ReconciliationLockIsEnabled(notebook.ObjectMeta) {
 		log.Info("Removing reconciliation lock")
@@ -137,6 +137,33 @@ var _ = Describe("The Openshift Notebook controller", func() {
 			Expect(CompareNotebookRoutes(*route, expectedRoute)).Should(BeTrue())
 		})
 
+		It("Should report the URL of the admitted Route in the Notebook status", func() {
+			By("By simulating the admission of the Route by a router")
+			route.Status.Ingress = []routev1.RouteIngress{{
+				Host:       "test-notebook.apps.example.com",
+				RouterName: "default",
+				Conditions: []routev1.RouteIngressCondition{{
+					Type:   routev1.RouteAdmitted,
+					Status: corev1.ConditionTrue,
+				}},
+			}}
+			Expect(cli.Update(ctx, route)).Should(Succeed())
+			time.Sleep(interval)
+
+			By("By checking that the controller has reported the URL")
+			Eventually(func() (string, error) {
+				key := types.NamespacedName{Name: Name, Namespace: Namespace}
+				if err := cli.Get(ctx, key, notebook); err != nil {
+					return "", err
+				}
+				if notebook.Status.Routing == nil {
+					return "", nil
+				}
+				return notebook.Status.Routing.URL, nil
+			}, timeout, interval).Should(Equal("https://test-notebook.apps.example.com/notebook/default/test-notebook/"))
+			Expect(notebook.Status.Routing.Backend).To(Equal(RoutingBackendRoute))
+		})
+
 		It("Should delete the Openshift Route", func() {
 			// Testenv cluster does not implement Kubernetes GC:
 			// https://book.kubebuilder.io/reference/envtest.html#testing-considerations
@@ -17,10 +17,12 @@ package controllers
 
 import (
 	"context"
+	"fmt"
 	"reflect"
 
 	nbv1 "github.com/kubeflow/kubeflow/components/notebook-controller/api/v1"
 	routev1 "github.com/openshift/api/route/v1"
+	corev1 "k8s.io/api/core/v1"
 	apierrs "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
@@ -28,8 +30,13 @@ import (
 	"k8s.io/client-go/util/retry"
 	"k8s.io/utils/pointer"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
+// RoutingBackendRoute is the routing backend of the notebooks exposed by an
+// Openshift Route, in their status.
+const RoutingBackendRoute = "route"
+
 // NewNotebookRoute defines the desired route object
 func NewNotebookRoute(notebook *nbv1.Notebook) *routev1.Route {
 	return &routev1.Route{
@@ -144,3 +151,57 @@ func (r *OpenshiftNotebookReconciler) ReconcileRoute(
 	notebook *nbv1.Notebook, ctx context.Context) error {
 	return r.reconcileRoute(notebook, ctx, NewNotebookRoute)
 }
+
+// NotebookRouteURLs returns the URLs of the notebook on the hosts of the
+// routers that admitted the route. The notebook server is served under its
+// NB_PREFIX, as the route doesn't rewrite the path.
+func NotebookRouteURLs(notebook *nbv1.Notebook, route *routev1.Route) []string {
+	urls := []string{}
+	for _, ingress := range route.Status.Ingress {
+		if ingress.Host == "" {
+			continue
+		}
+		for _, condition := range ingress.Conditions {
+			if condition.Type == routev1.RouteAdmitted && condition.Status == corev1.ConditionTrue {
+				urls = append(urls, fmt.Sprintf("https://%s/notebook/%s/%s/",
+					ingress.Host, notebook.Namespace, notebook.Name))
+				break
+			}
+		}
+	}
+	return urls
+}
+
+// ReconcileRoutingStatus reports the URLs of the route of the notebook in its
+// status, once the route is admitted. The internal URL and the endpoints are
+// left to the notebook controller, which manages the Service of the notebook.
+func (r *OpenshiftNotebookReconciler) ReconcileRoutingStatus(
+	notebook *nbv1.Notebook, ctx context.Context) error {
+	route := &routev1.Route{}
+	if err := r.Get(ctx, client.ObjectKeyFromObject(notebook), route); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	urls := NotebookRouteURLs(notebook, route)
+
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		if err := r.Get(ctx, client.ObjectKeyFromObject(notebook), notebook); err != nil {
+			return err
+		}
+		routing := &nbv1.RoutingStatus{Backend: RoutingBackendRoute}
+		if notebook.Status.Routing != nil {
+			routing.InternalURL = notebook.Status.Routing.InternalURL
+			routing.Endpoints = notebook.Status.Routing.Endpoints
+		}
+		if len(urls) > 0 {
+			routing.URL = urls[0]
+		}
+		if len(urls) > 1 {
+			routing.URLs = urls
+		}
+		if reflect.DeepEqual(routing, notebook.Status.Routing) {
+			return nil
+		}
+		notebook.Status.Routing = routing
+		return r.Status().Update(ctx, notebook)
+	})
+}
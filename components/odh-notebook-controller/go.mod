@@ -85,3 +85,6 @@ require (
 	sigs.k8s.io/structured-merge-diff/v4 v4.2.1 // indirect
 	sigs.k8s.io/yaml v1.3.0 // indirect
 )
+
+// The notebook-controller is built from the same tree, see the Dockerfile
+replace github.com/kubeflow/kubeflow/components/notebook-controller => ../notebook-controller
@@ -450,7 +450,7 @@ func (r *ProfileReconciler) getAuthorizationPolicy(profileIns *profilev1.Profile
 			},
 			{
 				// allow the notebook-controller in the kubeflow namespace to
-				// access the api/kernels endpoint of the notebook servers.
+				// access the activity endpoints of the notebook servers.
 				From: []*istioSecurity.Rule_From{
 					{
 						Source: &istioSecurity.Source{
@@ -462,7 +462,40 @@ func (r *ProfileReconciler) getAuthorizationPolicy(profileIns *profilev1.Profile
 					{
 						Operation: &istioSecurity.Operation{
 							Methods: []string{"GET"},
-							Paths:   []string{"*/api/kernels"}, // wildcard for the name of the notebook server
+							// wildcard for the name of the notebook server
+							Paths: []string{
+								"*/api/kernels",
+								"*/api/sessions",
+								"*/api/terminals",
+								"*/api/status",
+								// the default path of the http probe for
+								// code-server
+								"*/healthz",
+							},
+						},
+					},
+				},
+			},
+			{
+				// allow the notebook-controller to run the jupyter shutdown
+				// hook of the notebook servers, which checkpoints the files
+				// with `/api/contents/<path>/checkpoints` and then calls
+				// `/api/shutdown`. Istio paths only take one wildcard.
+				From: []*istioSecurity.Rule_From{
+					{
+						Source: &istioSecurity.Source{
+							Principals: []string{"cluster.local/ns/kubeflow/sa/notebook-controller-service-account"},
+						},
+					},
+				},
+				To: []*istioSecurity.Rule_To{
+					{
+						Operation: &istioSecurity.Operation{
+							Methods: []string{"POST"},
+							Paths: []string{
+								"*/checkpoints",
+								"*/api/shutdown",
+							},
 						},
 					},
 				},